@@ -0,0 +1,136 @@
+// Package bip39 implements the BIP39 mnemonic codes: entropy is written as a
+// phrase of english words with a checksum, and a phrase and an optional
+// passphrase make the seed of an HD master key.
+package bip39
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// MinEntropyBits and MaxEntropyBits bound the entropy of a mnemonic,
+	// whose size must be a multiple of 32 bits.
+	MinEntropyBits = 128
+	MaxEntropyBits = 256
+
+	// SeedLen is the length in bytes of the seed of a mnemonic.
+	SeedLen = 64
+
+	seedIterations = 2048
+	bitsPerWord    = 11
+)
+
+var (
+	ErrEntropyLen       = errors.New("entropy must be 128 to 256 bits, a multiple of 32")
+	ErrMnemonicWords    = errors.New("a mnemonic must have 12, 15, 18, 21 or 24 words")
+	ErrUnknownWord      = errors.New("mnemonic word is not in the word list")
+	ErrMnemonicChecksum = errors.New("mnemonic checksum mismatch")
+)
+
+func checkEntropyBits(bits int) error {
+	if bits < MinEntropyBits || bits > MaxEntropyBits || bits%32 != 0 {
+		return ErrEntropyLen
+	}
+	return nil
+}
+
+// NewEntropy returns bits of random entropy.
+func NewEntropy(bits int) ([]byte, error) {
+	if err := checkEntropyBits(bits); err != nil {
+		return nil, err
+	}
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+// NewMnemonic returns the phrase of entropy: its bits followed by the first
+// bits of its sha256, one word per 11 bits.
+func NewMnemonic(entropy []byte) (string, error) {
+	entropyBits := len(entropy) * 8
+	if err := checkEntropyBits(entropyBits); err != nil {
+		return "", err
+	}
+	checksumBits := entropyBits / 32
+	sum := sha256.Sum256(entropy)
+
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, uint(checksumBits))
+	data.Or(data, big.NewInt(int64(sum[0]>>(8-uint(checksumBits)))))
+
+	wordCount := (entropyBits + checksumBits) / bitsPerWord
+	words := make([]string, wordCount)
+	mask := big.NewInt(1<<bitsPerWord - 1)
+	index := new(big.Int)
+	for i := wordCount - 1; i >= 0; i-- {
+		index.And(data, mask)
+		words[i] = English[index.Int64()]
+		data.Rsh(data, bitsPerWord)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// EntropyFromMnemonic returns the entropy of a phrase, checking its words and
+// checksum.
+func EntropyFromMnemonic(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, ErrMnemonicWords
+	}
+
+	data := new(big.Int)
+	for _, word := range words {
+		i, ok := wordIndexes[word]
+		if !ok {
+			return nil, errors.Wrap(ErrUnknownWord, word)
+		}
+		data.Lsh(data, bitsPerWord)
+		data.Or(data, big.NewInt(int64(i)))
+	}
+
+	checksumBits := len(words) * bitsPerWord / 33
+	entropyBits := checksumBits * 32
+	checksum := new(big.Int).And(data, big.NewInt(1<<uint(checksumBits)-1))
+	data.Rsh(data, uint(checksumBits))
+
+	entropy := make([]byte, entropyBits/8)
+	raw := data.Bytes()
+	copy(entropy[len(entropy)-len(raw):], raw)
+	sum := sha256.Sum256(entropy)
+	if int64(sum[0]>>(8-uint(checksumBits))) != checksum.Int64() {
+		return nil, ErrMnemonicChecksum
+	}
+	return entropy, nil
+}
+
+// IsMnemonicValid returns whether a phrase has known words and a valid
+// checksum.
+func IsMnemonicValid(mnemonic string) bool {
+	_, err := EntropyFromMnemonic(mnemonic)
+	return err == nil
+}
+
+// NewSeed returns the seed of a phrase and passphrase, which is not checked:
+// PBKDF2-HMAC-SHA512 with 2048 iterations, salted with "mnemonic" and the
+// passphrase. Non-ASCII passphrases must be given in NFKD form.
+func NewSeed(mnemonic string, passphrase string) []byte {
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(mnemonic), []byte("mnemonic"+passphrase), seedIterations, SeedLen, sha512.New)
+}
+
+// NewSeedWithErrorChecking returns the seed of a phrase after checking it.
+func NewSeedWithErrorChecking(mnemonic string, passphrase string) ([]byte, error) {
+	if _, err := EntropyFromMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	return NewSeed(mnemonic, passphrase), nil
+}
@@ -0,0 +1,70 @@
+package bip39
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// TestVectors checks test vectors of the reference implementation, whose
+// passphrase is TREZOR.
+func TestVectors(t *testing.T) {
+	tests := []struct {
+		entropy  string
+		mnemonic string
+		seed     string
+	}{
+		{
+			"00000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+		},
+		{
+			"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+		},
+		{
+			strings.Repeat("ff", 32),
+			strings.Repeat("zoo ", 23) + "vote",
+			"dd48c104698c30cfe2b6142103248622fb7bb0ff692eebb00089b32d22484e1613912f0a5b694407be899ffd31ed3992c456cdf60f5d4564b8ba3f05a69890ad",
+		},
+	}
+	for _, test := range tests {
+		entropy, _ := hex.DecodeString(test.entropy)
+		mnemonic, err := NewMnemonic(entropy)
+		if err != nil || mnemonic != test.mnemonic {
+			t.Errorf("%s: expected %q, got %q (%v)", test.entropy, test.mnemonic, mnemonic, err)
+		}
+		decoded, err := EntropyFromMnemonic(test.mnemonic)
+		if err != nil || !bytes.Equal(decoded, entropy) {
+			t.Errorf("%s: got entropy %x (%v)", test.entropy, decoded, err)
+		}
+		seed, err := NewSeedWithErrorChecking(test.mnemonic, "TREZOR")
+		if err != nil || hex.EncodeToString(seed) != test.seed {
+			t.Errorf("%s: expected seed %s, got %x (%v)", test.entropy, test.seed, seed, err)
+		}
+	}
+}
+
+func TestInvalidMnemonics(t *testing.T) {
+	tests := map[string]error{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon": ErrMnemonicChecksum,
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about":           ErrMnemonicWords,
+		strings.Repeat("zoo ", 23) + "voted": ErrUnknownWord,
+	}
+	for mnemonic, expected := range tests {
+		if _, err := EntropyFromMnemonic(mnemonic); errors.Cause(err) != expected {
+			t.Errorf("%q: expected %v, got %v", mnemonic, expected, err)
+		}
+		if IsMnemonicValid(mnemonic) {
+			t.Errorf("%q should not be valid", mnemonic)
+		}
+	}
+	if _, err := NewEntropy(129); err != ErrEntropyLen {
+		t.Errorf("expected %v, got %v", ErrEntropyLen, err)
+	}
+}
@@ -0,0 +1,188 @@
+package blockchain
+
+import (
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+	"github.com/pkg/errors"
+)
+
+// ErrAddressIndexDisabled is returned by the address index queries when the
+// node runs without -addressindex.
+var ErrAddressIndexDisabled = errors.New("address index not enabled")
+
+// AddressDelta is a change of the balance of a script: an output paying to
+// it, or an input spending one of its outputs.
+type AddressDelta struct {
+	TxID utils.Hash
+	// Index is the output index when funding, the input index when spending.
+	Index    uint32
+	Height   int
+	TxPos    uint32
+	Spending bool
+	// Value is negative when spending.
+	Value int64
+}
+
+// AddressUnspent is an unspent output paying to a script.
+type AddressUnspent struct {
+	OutPoint core.OutPoint
+	Script   []byte
+	Value    int64
+	Height   int
+}
+
+// addressDelta and addressUnspent are the records of the index, keyed by the
+// hash of their script.
+type addressDelta struct {
+	scriptHash utils.Hash
+	AddressDelta
+}
+
+type addressUnspent struct {
+	scriptHash utils.Hash
+	AddressUnspent
+}
+
+// addressIndexChanges are the records a block adds to the address index, and
+// the unspent outputs it removes.
+type addressIndexChanges struct {
+	deltas  []*addressDelta
+	created []*addressUnspent
+	spent   []*addressUnspent
+}
+
+// ScriptHash returns the key of the outputs paying to scriptPubKey in the
+// address index.
+func ScriptHash(scriptPubKey []byte) utils.Hash {
+	return crypto.Sha256Hash(scriptPubKey)
+}
+
+// addressIndexChangesOf returns the changes of the address index made by the
+// block at pindex, whose transaction i spends the coins spent[i].
+func addressIndexChangesOf(block *core.Block, pindex *core.BlockIndex, spent [][]*utxo.Coin) *addressIndexChanges {
+	changes := new(addressIndexChanges)
+	for i, tx := range block.Txs {
+		txid := tx.TxHash()
+		if i < len(spent) {
+			for j, coin := range spent[i] {
+				if coin.TxOut == nil {
+					continue
+				}
+				script := coin.TxOut.Script.GetScriptByte()
+				scriptHash := ScriptHash(script)
+				changes.deltas = append(changes.deltas, &addressDelta{scriptHash, AddressDelta{
+					TxID:     txid,
+					Index:    uint32(j),
+					Height:   pindex.Height,
+					TxPos:    uint32(i),
+					Spending: true,
+					Value:    -coin.TxOut.Value,
+				}})
+				changes.spent = append(changes.spent, &addressUnspent{scriptHash, AddressUnspent{
+					OutPoint: *tx.Ins[j].PreviousOutPoint,
+					Script:   script,
+					Value:    coin.TxOut.Value,
+					Height:   int(coin.GetHeight()),
+				}})
+			}
+		}
+		for j, out := range tx.Outs {
+			if out.Script.IsUnspendable() {
+				continue
+			}
+			script := out.Script.GetScriptByte()
+			scriptHash := ScriptHash(script)
+			changes.deltas = append(changes.deltas, &addressDelta{scriptHash, AddressDelta{
+				TxID:   txid,
+				Index:  uint32(j),
+				Height: pindex.Height,
+				TxPos:  uint32(i),
+				Value:  out.Value,
+			}})
+			changes.created = append(changes.created, &addressUnspent{scriptHash, AddressUnspent{
+				OutPoint: core.OutPoint{Hash: txid, Index: uint32(j)},
+				Script:   script,
+				Value:    out.Value,
+				Height:   pindex.Height,
+			}})
+		}
+	}
+	return changes
+}
+
+// undoSpentCoins returns the coins spent by each transaction of block, once
+// view was rolled back to before it. Coins created and spent in the block
+// are taken from the block itself.
+func undoSpentCoins(block *core.Block, pindex *core.BlockIndex, view *utxo.CoinsViewCache) [][]*utxo.Coin {
+	outputs := make(map[utils.Hash]*core.Tx, len(block.Txs))
+	for _, tx := range block.Txs {
+		outputs[tx.TxHash()] = tx
+	}
+	spent := make([][]*utxo.Coin, len(block.Txs))
+	for i, tx := range block.Txs {
+		if tx.IsCoinBase() {
+			continue
+		}
+		spent[i] = make([]*utxo.Coin, 0, len(tx.Ins))
+		for _, in := range tx.Ins {
+			outPoint := in.PreviousOutPoint
+			if prev, ok := outputs[outPoint.Hash]; ok && int(outPoint.Index) < len(prev.Outs) {
+				spent[i] = append(spent[i], utxo.NewCoin(prev.Outs[outPoint.Index], uint32(pindex.Height),
+					prev.IsCoinBase()))
+				continue
+			}
+			spent[i] = append(spent[i], view.AccessCoin(outPoint))
+		}
+	}
+	return spent
+}
+
+// readIndexFlag returns whether the block tree maintains the index name. An
+// index only covers the blocks connected while it was enabled, so -name has
+// to agree with it until the chain is reindexed.
+func readIndexFlag(name string, defaultValue bool) (bool, error) {
+	enabled := GBlockTree.ReadFlag(name)
+	if enabled != utils.GetBoolArg("-"+name, defaultValue) {
+		return enabled, errors.Errorf("You need to rebuild the database using -reindex to change -%s", name)
+	}
+	return enabled, nil
+}
+
+// connectAddressIndex records the balance changes made by the block at
+// pindex, which spends the coins spent.
+func connectAddressIndex(block *core.Block, pindex *core.BlockIndex, spent [][]*utxo.Coin) error {
+	if GBlockTree == nil {
+		return errors.New("the block tree database is not open")
+	}
+	return GBlockTree.WriteAddressIndex(addressIndexChangesOf(block, pindex, spent))
+}
+
+// disconnectAddressIndex removes the balance changes made by the block at
+// pindex, and restores the outputs it spent.
+func disconnectAddressIndex(block *core.Block, pindex *core.BlockIndex, spent [][]*utxo.Coin) error {
+	if GBlockTree == nil {
+		return errors.New("the block tree database is not open")
+	}
+	return GBlockTree.EraseAddressIndex(addressIndexChangesOf(block, pindex, spent))
+}
+
+// GetAddressDeltas returns the balance changes of the script whose hash is
+// scriptHash in the blocks from start to end, by height and position in the
+// block. An end of zero stands for the tip.
+func GetAddressDeltas(scriptHash *utils.Hash, start, end int) ([]*AddressDelta, error) {
+	if !GAddressIndex || GBlockTree == nil {
+		return nil, ErrAddressIndexDisabled
+	}
+	return GBlockTree.ReadAddressDeltas(scriptHash, start, end)
+}
+
+// GetAddressUnspent returns the unspent outputs paying to the script whose
+// hash is scriptHash.
+func GetAddressUnspent(scriptHash *utils.Hash) ([]*AddressUnspent, error) {
+	if !GAddressIndex || GBlockTree == nil {
+		return nil, ErrAddressIndexDisabled
+	}
+	return GBlockTree.ReadAddressUnspent(scriptHash)
+}
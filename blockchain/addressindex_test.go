@@ -0,0 +1,231 @@
+package blockchain
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/database"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+)
+
+func newTestTx(prevOuts []*core.OutPoint, values []int64, scripts [][]byte) *core.Tx {
+	tx := core.NewTx()
+	for _, prevOut := range prevOuts {
+		tx.AddTxIn(core.NewTxIn(prevOut, []byte{0x51}))
+	}
+	for i, value := range values {
+		tx.AddTxOut(core.NewTxOut(value, scripts[i]))
+	}
+	return tx
+}
+
+func TestAddressIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "addressindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbw, err := database.NewDBWrapper(&database.DBOption{FilePath: dir, CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbw.Close()
+
+	savedBlockTree, savedAddressIndex := GBlockTree, GAddressIndex
+	defer func() {
+		GBlockTree, GAddressIndex = savedBlockTree, savedAddressIndex
+	}()
+	GBlockTree = &BlockTreeDB{dbw: dbw}
+	GAddressIndex = true
+
+	scriptX, scriptY := []byte{0x51, 0x87}, []byte{0x52, 0x87}
+	hashX, hashY := ScriptHash(scriptX), ScriptHash(scriptY)
+
+	// Block 1 pays X and Y.
+	fund := newTestTx([]*core.OutPoint{nil}, []int64{50, 20}, [][]byte{scriptX, scriptY})
+	block1 := &core.Block{Txs: []*core.Tx{fund}}
+	index1 := &core.BlockIndex{Height: 1}
+	if err := connectAddressIndex(block1, index1, make([][]*utxo.Coin, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Block 2 spends X to Y and back to X, then spends the change to Y.
+	coinbase := newTestTx([]*core.OutPoint{nil}, []int64{1}, [][]byte{{0x53}})
+	spend := newTestTx([]*core.OutPoint{core.NewOutPoint(fund.TxHash(), 0)}, []int64{30, 15},
+		[][]byte{scriptY, scriptX})
+	change := newTestTx([]*core.OutPoint{core.NewOutPoint(spend.TxHash(), 1)}, []int64{10}, [][]byte{scriptY})
+	block2 := &core.Block{Txs: []*core.Tx{coinbase, spend, change}}
+	index2 := &core.BlockIndex{Height: 2, Prev: index1}
+	spent2 := [][]*utxo.Coin{nil,
+		{utxo.NewCoin(fund.Outs[0], 1, true)},
+		{utxo.NewCoin(spend.Outs[1], 2, false)},
+	}
+	if err := connectAddressIndex(block2, index2, spent2); err != nil {
+		t.Fatal(err)
+	}
+
+	checkDeltas := func(scriptHash utils.Hash, start int, expected ...int64) {
+		deltas, err := GetAddressDeltas(&scriptHash, start, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deltas) != len(expected) {
+			t.Fatalf("expected %d deltas, got %d", len(expected), len(deltas))
+		}
+		for i, delta := range deltas {
+			if delta.Value != expected[i] || delta.Spending != (delta.Value < 0) {
+				t.Errorf("delta %d: expected %d, got %+v", i, expected[i], delta)
+			}
+		}
+	}
+	checkUnspent := func(scriptHash utils.Hash, expected ...int64) {
+		unspents, err := GetAddressUnspent(&scriptHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var values []int64
+		for _, unspent := range unspents {
+			values = append(values, unspent.Value)
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+		if fmt.Sprint(values) != fmt.Sprint(expected) {
+			t.Fatalf("expected unspent outputs of %v, got %v", expected, values)
+		}
+	}
+
+	checkDeltas(hashX, 0, 50, -50, 15, -15)
+	checkDeltas(hashX, 2, -50, 15, -15)
+	checkDeltas(hashY, 0, 20, 30, 10)
+	checkUnspent(hashX)
+	checkUnspent(hashY, 20, 30, 10)
+	if deltas, _ := GetAddressDeltas(&hashY, 0, 1); len(deltas) != 1 {
+		t.Errorf("the deltas should stop at the end height, got %d", len(deltas))
+	}
+
+	// Disconnecting block 2 restores the output it spent.
+	if err := disconnectAddressIndex(block2, index2, spent2); err != nil {
+		t.Fatal(err)
+	}
+	checkDeltas(hashX, 0, 50)
+	checkDeltas(hashY, 0, 20)
+	checkUnspent(hashX, 50)
+	checkUnspent(hashY, 20)
+
+	GAddressIndex = false
+	if _, err := GetAddressUnspent(&hashX); err != ErrAddressIndexDisabled {
+		t.Errorf("queries should fail without -addressindex, got %v", err)
+	}
+}
+
+func TestAddressIndexConnectBlock(t *testing.T) {
+	defer useTestBlockTree(t)()
+	savedData, savedAddressIndex := GChainState.MapBlockIndex.Data, GAddressIndex
+	defer func() { GChainState.MapBlockIndex.Data, GAddressIndex = savedData, savedAddressIndex }()
+	GChainState.MapBlockIndex.Data = make(map[utils.Hash]*core.BlockIndex)
+	GAddressIndex = true
+
+	// The spent coins come from the parent view, so connecting the block
+	// clears their cache entries.
+	scriptX, scriptY := []byte{0x51, 0x87}, []byte{0x75, 0x51}
+	spent := []*core.OutPoint{core.NewOutPoint(utils.Hash{1}, 0), core.NewOutPoint(utils.Hash{2}, 3)}
+	funding := map[core.OutPoint]*core.TxOut{
+		*spent[0]: core.NewTxOut(4000, scriptX),
+		*spent[1]: core.NewTxOut(6000, scriptY),
+	}
+	spend := newTestTx(spent, []int64{9000}, [][]byte{scriptY})
+	block, index, view := newTestConnectBlock(funding, spend)
+	state := core.NewValidationState()
+	blockUndo, ok := connectBlock(newTestConnectParams(), block, state, index, view, true)
+	if !ok {
+		t.Fatalf("connecting the block failed: %s", state.GetRejectReason())
+	}
+	if err := connectAddressIndex(block, index, blockUndo.spentCoins()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[utils.Hash][]int64{
+		ScriptHash(scriptX): {-4000},
+		ScriptHash(scriptY): {9000, -6000},
+	}
+	for scriptHash, values := range expected {
+		hash := scriptHash
+		deltas, err := GetAddressDeltas(&hash, index.Height, index.Height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deltas) != len(values) {
+			t.Fatalf("expected %d deltas, got %d", len(values), len(deltas))
+		}
+		for i, delta := range deltas {
+			if delta.TxID != spend.TxHash() || delta.Value != values[i] || delta.Spending != (values[i] < 0) {
+				t.Errorf("delta %d: expected %d, got %+v", i, values[i], delta)
+			}
+		}
+	}
+}
+
+func TestReadIndexFlag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexflag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbw, err := database.NewDBWrapper(&database.DBOption{FilePath: dir, CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbw.Close()
+
+	savedBlockTree := GBlockTree
+	defer func() {
+		GBlockTree = savedBlockTree
+	}()
+	GBlockTree = &BlockTreeDB{dbw: dbw}
+	defer utils.ParseParameters(0, nil)
+
+	tests := []struct {
+		write   bool
+		stored  bool
+		args    []string
+		enabled bool
+		ok      bool
+	}{
+		// A chain connected before the flag existed has no index.
+		{false, false, nil, false, true},
+		{false, false, []string{"-addressindex"}, false, false},
+		{true, false, []string{"-addressindex=0"}, false, true},
+		{true, false, []string{"-addressindex=1"}, false, false},
+		{true, true, []string{"-addressindex"}, true, true},
+		{true, true, nil, true, false},
+	}
+	for i, test := range tests {
+		if test.write {
+			if err := GBlockTree.WriteFlag("addressindex", test.stored); err != nil {
+				t.Fatal(err)
+			}
+		}
+		utils.ParseParameters(len(test.args), test.args)
+		enabled, err := readIndexFlag("addressindex", false)
+		if enabled != test.enabled || (err == nil) != test.ok {
+			t.Errorf("test %d: expected %v %v, got %v %v", i, test.enabled, test.ok, enabled, err)
+		}
+	}
+
+	// Each index has its own flag.
+	utils.ParseParameters(1, []string{"-addressindex"})
+	if enabled, err := readIndexFlag("spentindex", false); enabled || err != nil {
+		t.Errorf("expected the spent index disabled, got %v %v", enabled, err)
+	}
+	if err := GBlockTree.WriteFlag("spentindex", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readIndexFlag("spentindex", false); err == nil {
+		t.Errorf("disabling the spent index should require -reindex")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/btcboost/copernicus/net/msg"
 	"github.com/btcboost/copernicus/utils"
 	"github.com/btcboost/copernicus/utxo"
+	"github.com/pkg/errors"
 )
 
 type BlockTreeDB struct {
@@ -40,27 +41,258 @@ type writeTxIndex struct {
 	pos  core.DiskTxPos
 }
 
-func (blockTreeDB *BlockTreeDB) WriteTxIndex(ect []*writeTxIndex) error {
-	var batch *database.BatchWrapper
-	for _, v := range ect {
-		key := make([]byte, 0, 100)
-		key = append(key, utxo.DbTxIndex)
-		key = append(key, v.hash[:]...)
+func txIndexKey(txid *utils.Hash) []byte {
+	key := make([]byte, 0, 1+utils.Hash256Size)
+	key = append(key, utxo.DbTxIndex)
+	return append(key, txid[:]...)
+}
 
+// WriteTxIndex records the positions of transactions, and best as the last
+// block of the index.
+func (blockTreeDB *BlockTreeDB) WriteTxIndex(ect []*writeTxIndex, best *utils.Hash) error {
+	batch := database.NewBatchWrapper(blockTreeDB.dbw)
+	for _, v := range ect {
 		buf := bytes.NewBuffer(nil)
 		if err := v.pos.SerializeDiskTxPos(buf); err != nil {
 			return err
 		}
-		batch.Write(key, buf.Bytes())
+		batch.Write(txIndexKey(&v.hash), buf.Bytes())
 	}
+	batch.Write([]byte{utxo.DbTxIndexBestBlock}, best[:])
 	return blockTreeDB.dbw.WriteBatch(batch, false)
 }
 
-func (blockTreeDB *BlockTreeDB) ReadTxIndex(txid *utils.Hash) ([]byte, error) {
-	tmp := make([]byte, 0, 100)
-	tmp = append(tmp, utxo.DbTxIndex)
-	tmp = append(tmp, txid[:]...)
-	return blockTreeDB.dbw.Read(tmp)
+// EraseTxIndex removes the positions of transactions, and records best as
+// the last block of the index.
+func (blockTreeDB *BlockTreeDB) EraseTxIndex(txids []utils.Hash, best *utils.Hash) error {
+	batch := database.NewBatchWrapper(blockTreeDB.dbw)
+	for i := range txids {
+		batch.Erase(txIndexKey(&txids[i]))
+	}
+	batch.Write([]byte{utxo.DbTxIndexBestBlock}, best[:])
+	return blockTreeDB.dbw.WriteBatch(batch, false)
+}
+
+// ReadTxIndex returns the position of the transaction txid.
+func (blockTreeDB *BlockTreeDB) ReadTxIndex(txid *utils.Hash) (*core.DiskTxPos, error) {
+	buf, err := blockTreeDB.dbw.Read(txIndexKey(txid))
+	if err != nil {
+		return nil, err
+	}
+	return core.DeserializeDiskTxPos(bytes.NewReader(buf))
+}
+
+// ReadTxIndexBestBlock returns the last block of the transaction index, or
+// false when the index is empty.
+func (blockTreeDB *BlockTreeDB) ReadTxIndexBestBlock() (utils.Hash, bool) {
+	var hash utils.Hash
+	buf, err := blockTreeDB.dbw.Read([]byte{utxo.DbTxIndexBestBlock})
+	if err != nil || len(buf) != len(hash) {
+		return hash, false
+	}
+	copy(hash[:], buf)
+	return hash, true
+}
+
+// addressDeltaKey returns the key of delta: its script hash, then height,
+// position in the block, txid, index and direction, in an order which sorts
+// the history of a script by height.
+func addressDeltaKey(delta *addressDelta) []byte {
+	key := make([]byte, 1+utils.Hash256Size+8, 1+2*utils.Hash256Size+13)
+	copy(key, addressDeltaPrefix(&delta.scriptHash, delta.Height))
+	binary.BigEndian.PutUint32(key[1+utils.Hash256Size+4:], delta.TxPos)
+	key = append(key, delta.TxID[:]...)
+	key = append(key, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(key[len(key)-5:], delta.Index)
+	if delta.Spending {
+		key[len(key)-1] = 1
+	}
+	return key
+}
+
+func addressDeltaPrefix(scriptHash *utils.Hash, height int) []byte {
+	key := make([]byte, 1+utils.Hash256Size+4)
+	key[0] = utxo.DbAddressIndex
+	copy(key[1:], scriptHash[:])
+	binary.BigEndian.PutUint32(key[1+utils.Hash256Size:], uint32(height))
+	return key
+}
+
+func addressUnspentKey(scriptHash *utils.Hash, outPoint *core.OutPoint) []byte {
+	key := make([]byte, 1+2*utils.Hash256Size+4)
+	key[0] = utxo.DbAddressUnspentIndex
+	copy(key[1:], scriptHash[:])
+	copy(key[1+utils.Hash256Size:], outPoint.Hash[:])
+	binary.BigEndian.PutUint32(key[1+2*utils.Hash256Size:], outPoint.Index)
+	return key
+}
+
+func addressUnspentValue(unspent *addressUnspent) []byte {
+	value := make([]byte, 12, 12+len(unspent.Script))
+	binary.LittleEndian.PutUint64(value, uint64(unspent.Value))
+	binary.LittleEndian.PutUint32(value[8:], uint32(unspent.Height))
+	return append(value, unspent.Script...)
+}
+
+func addressDeltaValue(delta *addressDelta) []byte {
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, uint64(delta.Value))
+	return value
+}
+
+// WriteAddressIndex records the balance changes of a connected block, and
+// replaces the outputs it spent with those it created.
+func (blockTreeDB *BlockTreeDB) WriteAddressIndex(changes *addressIndexChanges) error {
+	batch := database.NewBatchWrapper(blockTreeDB.dbw)
+	for _, delta := range changes.deltas {
+		batch.Write(addressDeltaKey(delta), addressDeltaValue(delta))
+	}
+	// Outputs created and spent in the block are erased after being written.
+	for _, unspent := range changes.created {
+		batch.Write(addressUnspentKey(&unspent.scriptHash, &unspent.OutPoint), addressUnspentValue(unspent))
+	}
+	for _, unspent := range changes.spent {
+		batch.Erase(addressUnspentKey(&unspent.scriptHash, &unspent.OutPoint))
+	}
+	return blockTreeDB.dbw.WriteBatch(batch, false)
+}
+
+// EraseAddressIndex removes the balance changes of a disconnected block, and
+// restores the outputs it spent in place of those it created.
+func (blockTreeDB *BlockTreeDB) EraseAddressIndex(changes *addressIndexChanges) error {
+	batch := database.NewBatchWrapper(blockTreeDB.dbw)
+	for _, delta := range changes.deltas {
+		batch.Erase(addressDeltaKey(delta))
+	}
+	// Outputs created and spent in the block are erased after being restored.
+	for _, unspent := range changes.spent {
+		batch.Write(addressUnspentKey(&unspent.scriptHash, &unspent.OutPoint), addressUnspentValue(unspent))
+	}
+	for _, unspent := range changes.created {
+		batch.Erase(addressUnspentKey(&unspent.scriptHash, &unspent.OutPoint))
+	}
+	return blockTreeDB.dbw.WriteBatch(batch, false)
+}
+
+// ReadAddressDeltas returns the balance changes of the script hash in the
+// blocks from start to end, or up to the tip when end is zero.
+func (blockTreeDB *BlockTreeDB) ReadAddressDeltas(scriptHash *utils.Hash, start, end int) ([]*AddressDelta, error) {
+	prefix := addressDeltaPrefix(scriptHash, 0)[:1+utils.Hash256Size]
+	cursor := blockTreeDB.dbw.Iterator()
+	defer cursor.Close()
+
+	var deltas []*AddressDelta
+	for cursor.Seek(addressDeltaPrefix(scriptHash, start)); cursor.Valid(); cursor.Next() {
+		key := cursor.GetKey()
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		rest := key[len(prefix):]
+		value := cursor.GetVal()
+		if len(rest) != 4+4+utils.Hash256Size+4+1 || len(value) != 8 {
+			return nil, errors.Errorf("malformed address index entry %x", key)
+		}
+		delta := &AddressDelta{
+			Height:   int(binary.BigEndian.Uint32(rest)),
+			TxPos:    binary.BigEndian.Uint32(rest[4:]),
+			Index:    binary.BigEndian.Uint32(rest[8+utils.Hash256Size:]),
+			Spending: rest[len(rest)-1] != 0,
+			Value:    int64(binary.LittleEndian.Uint64(value)),
+		}
+		if end > 0 && delta.Height > end {
+			break
+		}
+		copy(delta.TxID[:], rest[8:])
+		deltas = append(deltas, delta)
+	}
+	return deltas, nil
+}
+
+// ReadAddressUnspent returns the unspent outputs of the script hash.
+func (blockTreeDB *BlockTreeDB) ReadAddressUnspent(scriptHash *utils.Hash) ([]*AddressUnspent, error) {
+	prefix := make([]byte, 0, 1+utils.Hash256Size)
+	prefix = append(prefix, utxo.DbAddressUnspentIndex)
+	prefix = append(prefix, scriptHash[:]...)
+	cursor := blockTreeDB.dbw.Iterator()
+	defer cursor.Close()
+
+	var unspents []*AddressUnspent
+	for cursor.Seek(prefix); cursor.Valid(); cursor.Next() {
+		key := cursor.GetKey()
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		rest := key[len(prefix):]
+		value := cursor.GetVal()
+		if len(rest) != utils.Hash256Size+4 || len(value) < 12 {
+			return nil, errors.Errorf("malformed address index entry %x", key)
+		}
+		unspent := &AddressUnspent{
+			Value:  int64(binary.LittleEndian.Uint64(value)),
+			Height: int(binary.LittleEndian.Uint32(value[8:])),
+			Script: value[12:],
+		}
+		copy(unspent.OutPoint.Hash[:], rest)
+		unspent.OutPoint.Index = binary.BigEndian.Uint32(rest[utils.Hash256Size:])
+		unspents = append(unspents, unspent)
+	}
+	return unspents, nil
+}
+
+func spentIndexKey(outPoint *core.OutPoint) []byte {
+	key := make([]byte, 1+utils.Hash256Size+4)
+	key[0] = utxo.DbSpentIndex
+	copy(key[1:], outPoint.Hash[:])
+	binary.BigEndian.PutUint32(key[1+utils.Hash256Size:], outPoint.Index)
+	return key
+}
+
+// spentIndexValueSize is the size of an entry of the spent index before the
+// script of the spent output: the txid, input index and height of the
+// spender, then the value of the output.
+const spentIndexValueSize = utils.Hash256Size + 16
+
+// WriteSpentIndex records the inputs spending outpoints.
+func (blockTreeDB *BlockTreeDB) WriteSpentIndex(entries []*spentIndexEntry) error {
+	batch := database.NewBatchWrapper(blockTreeDB.dbw)
+	for _, entry := range entries {
+		value := make([]byte, spentIndexValueSize, spentIndexValueSize+len(entry.Script))
+		copy(value, entry.TxID[:])
+		binary.LittleEndian.PutUint32(value[utils.Hash256Size:], entry.Index)
+		binary.LittleEndian.PutUint32(value[utils.Hash256Size+4:], uint32(entry.Height))
+		binary.LittleEndian.PutUint64(value[utils.Hash256Size+8:], uint64(entry.Value))
+		value = append(value, entry.Script...)
+		batch.Write(spentIndexKey(&entry.outPoint), value)
+	}
+	return blockTreeDB.dbw.WriteBatch(batch, false)
+}
+
+// EraseSpentIndex removes the inputs spending outPoints.
+func (blockTreeDB *BlockTreeDB) EraseSpentIndex(outPoints []*core.OutPoint) error {
+	batch := database.NewBatchWrapper(blockTreeDB.dbw)
+	for _, outPoint := range outPoints {
+		batch.Erase(spentIndexKey(outPoint))
+	}
+	return blockTreeDB.dbw.WriteBatch(batch, false)
+}
+
+// ReadSpentIndex returns the input spending outPoint.
+func (blockTreeDB *BlockTreeDB) ReadSpentIndex(outPoint *core.OutPoint) (*SpentInfo, error) {
+	value, err := blockTreeDB.dbw.Read(spentIndexKey(outPoint))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) < spentIndexValueSize {
+		return nil, errors.Errorf("malformed spent index entry for %s", outPoint.String())
+	}
+	info := &SpentInfo{
+		Index:  binary.LittleEndian.Uint32(value[utils.Hash256Size:]),
+		Height: int(binary.LittleEndian.Uint32(value[utils.Hash256Size+4:])),
+		Value:  int64(binary.LittleEndian.Uint64(value[utils.Hash256Size+8:])),
+		Script: append([]byte{}, value[spentIndexValueSize:]...),
+	}
+	copy(info.TxID[:], value)
+	return info, nil
 }
 
 func (blockTreeDB *BlockTreeDB) WriteReindexing(reindexing bool) error {
@@ -179,10 +411,10 @@ func (blockTreeDB *BlockTreeDB) WriteFlag(name string, value bool) error {
 	tmp := make([]byte, 0, 100)
 	tmp = append(tmp, utxo.DbFlag)
 	tmp = append(tmp, name...)
-	if !value {
-		return blockTreeDB.dbw.Write(tmp, []byte{'1'}, value)
+	if value {
+		return blockTreeDB.dbw.Write(tmp, []byte{'1'}, false)
 	}
-	return blockTreeDB.dbw.Write(tmp, []byte{'0'}, value)
+	return blockTreeDB.dbw.Write(tmp, []byte{'0'}, false)
 }
 
 func (blockTreeDB *BlockTreeDB) ReadFlag(name string) bool {
@@ -191,10 +423,11 @@ func (blockTreeDB *BlockTreeDB) ReadFlag(name string) bool {
 	tmp = append(tmp, name...)
 	b, err := blockTreeDB.dbw.Read(tmp)
 
-	if b[0] == '1' && err == nil {
-		return true
+	// A flag never written reads as unset.
+	if err != nil || len(b) == 0 {
+		return false
 	}
-	return false
+	return b[0] == '1'
 }
 
 func NewBlockTreeDB(do *database.DBOption) *BlockTreeDB {
@@ -1,12 +1,14 @@
 package blockchain
 
 import (
+	"sync"
 	"sync/atomic"
 
 	"github.com/btcboost/copernicus/consensus"
 	"github.com/btcboost/copernicus/container"
 	"github.com/btcboost/copernicus/core"
 	"github.com/btcboost/copernicus/mempool"
+	"github.com/btcboost/copernicus/policy"
 	"github.com/btcboost/copernicus/utils"
 	"github.com/btcboost/copernicus/utxo"
 )
@@ -17,6 +19,10 @@ type BlockMap struct {
 
 // ChainState store the blockChain global state
 type ChainState struct {
+	// The lock of the chain state, held by the validation entry points and
+	// by the RPC commands, which run on their own goroutines.
+	sync.Mutex
+
 	ChainActive      core.Chain
 	MapBlockIndex    BlockMap
 	IndexBestInvalid *core.BlockIndex
@@ -38,6 +44,7 @@ var (
 	GImporting       atomic.Value
 	GMaxTipAge       int64
 	GMemPool         *mempool.TxMempool
+	GFeeEstimator    *policy.FeeEstimator
 	GCoinsTip        *utxo.CoinsViewCache
 	GBlockTree       *BlockTreeDB
 	GMinRelayTxFee   utils.FeeRate
@@ -52,6 +59,10 @@ var (
 	GHavePruned = false
 	GPruneMode  = false
 	GTxIndex    = false
+	// GAddressIndex is set when the history of every script is indexed.
+	GAddressIndex = false
+	// GSpentIndex is set when the input spending each output is indexed.
+	GSpentIndex = false
 
 	//GIndexBestHeader Best header we've seen so far (used for getHeaders queries' starting points)
 	GIndexBestHeader *core.BlockIndex
@@ -98,5 +109,7 @@ func init() {
 	GMaxTipAge = consensus.DefaultMaxTipAge
 	GMinRelayTxFee.SataoshisPerK = int64(DefaultMinRelayTxFee)
 	GMemPool = mempool.NewTxMempool()
+	GFeeEstimator = policy.NewFeeEstimator()
+	GMemPool.FeeEstimator = GFeeEstimator
 	GWarningCache = NewWarnBitsCache(VersionBitsNumBits)
 }
@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/utils"
+)
+
+const (
+	// DefaultScriptCheckThreads default for -par, 0 = auto
+	DefaultScriptCheckThreads = 0
+	// MaxScriptCheckThreads maximum number of script checking threads allowed
+	MaxScriptCheckThreads = 64
+
+	// scriptCheckQueueSize is the number of pending checks the queue buffers
+	// before CheckQueueControl.Add blocks.
+	scriptCheckQueueSize = 1024
+)
+
+// gScriptCheckQueue verifies the scripts of connected blocks. It is nil when
+// -par leaves no threads for script checking, in which case checks run inline.
+var gScriptCheckQueue *CheckQueue
+
+type queuedCheck struct {
+	check   *ScriptCheck
+	control *CheckQueueControl
+}
+
+// CheckQueue is a pool of goroutines verifying ScriptChecks. Batches of
+// checks are submitted and waited for through a CheckQueueControl.
+type CheckQueue struct {
+	checks  chan *queuedCheck
+	workers int
+}
+
+// NewCheckQueue starts workers goroutines pulling checks off a new queue.
+func NewCheckQueue(workers int) *CheckQueue {
+	queue := &CheckQueue{
+		checks:  make(chan *queuedCheck, scriptCheckQueueSize),
+		workers: workers,
+	}
+	for i := 0; i < workers; i++ {
+		go ThreadScriptCheck(queue)
+	}
+	return queue
+}
+
+// Workers returns the number of goroutines of the queue.
+func (q *CheckQueue) Workers() int {
+	if q == nil {
+		return 0
+	}
+	return q.workers
+}
+
+// Stop makes the workers exit once the checks already queued are done.
+func (q *CheckQueue) Stop() {
+	close(q.checks)
+}
+
+// NewControl returns a control to submit the checks of one block to q. A nil
+// queue is allowed: the checks then run inline as they are added.
+func (q *CheckQueue) NewControl() *CheckQueueControl {
+	return &CheckQueueControl{queue: q}
+}
+
+// ThreadScriptCheck is the loop of a script checking goroutine.
+func ThreadScriptCheck(queue *CheckQueue) {
+	for item := range queue.checks {
+		item.control.run(item.check)
+	}
+}
+
+// CheckQueueControl collects the result of a batch of checks. The first
+// failing check cancels the ones which have not started yet.
+type CheckQueueControl struct {
+	queue     *CheckQueue
+	pending   sync.WaitGroup
+	failed    int32
+	cancelled int32
+	once      sync.Once
+	err       crypto.ScriptError
+}
+
+func (c *CheckQueueControl) run(check *ScriptCheck) {
+	defer c.pending.Done()
+	if atomic.LoadInt32(&c.failed) != 0 || atomic.LoadInt32(&c.cancelled) != 0 {
+		return
+	}
+	if !check.check() {
+		c.fail(check.GetScriptError())
+	}
+}
+
+func (c *CheckQueueControl) fail(err crypto.ScriptError) {
+	c.once.Do(func() {
+		c.err = err
+		atomic.StoreInt32(&c.failed, 1)
+	})
+}
+
+// Add submits checks for verification. Nothing more is queued once a check
+// has failed.
+func (c *CheckQueueControl) Add(checks []*ScriptCheck) {
+	for _, check := range checks {
+		if atomic.LoadInt32(&c.failed) != 0 || atomic.LoadInt32(&c.cancelled) != 0 {
+			return
+		}
+		c.pending.Add(1)
+		if c.queue.Workers() == 0 {
+			c.run(check)
+			continue
+		}
+		c.queue.checks <- &queuedCheck{check: check, control: c}
+	}
+}
+
+// Wait blocks until every check added is done or cancelled, and returns false
+// with the error of the first failing check if any failed.
+func (c *CheckQueueControl) Wait() (bool, crypto.ScriptError) {
+	c.pending.Wait()
+	if atomic.LoadInt32(&c.failed) != 0 {
+		return false, c.err
+	}
+	return true, crypto.ScriptErrOK
+}
+
+// Cancel gives up the checks which have not started yet and waits for the
+// others, so that none outlives the block they were added for. It does
+// nothing once Wait returned.
+func (c *CheckQueueControl) Cancel() {
+	atomic.StoreInt32(&c.cancelled, 1)
+	c.pending.Wait()
+}
+
+// InitScriptCheckQueue starts the script checking goroutines as configured by
+// -par: a positive value is the number of threads, zero or a negative value
+// leaves that many cores free. With a single thread checks run inline.
+func InitScriptCheckQueue() {
+	threads := utils.GetArg("-par", DefaultScriptCheckThreads)
+	if threads <= 0 {
+		threads += int64(runtime.NumCPU())
+	}
+	if threads > MaxScriptCheckThreads {
+		threads = MaxScriptCheckThreads
+	}
+	if threads <= 1 {
+		gScriptCheckQueue = nil
+		return
+	}
+	gScriptCheckQueue = NewCheckQueue(int(threads))
+}
@@ -0,0 +1,65 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/utils"
+)
+
+func newTestScriptCheck(scriptPubKey []byte) *ScriptCheck {
+	tx := core.NewTx()
+	tx.AddTxIn(core.NewTxIn(core.NewOutPoint(utils.HashOne, 0), []byte{}))
+	ctx := core.NewScriptExecutionContext(tx, 0, nil)
+	return NewScriptCheck(core.NewScriptRaw(scriptPubKey), 0, ctx, crypto.ScriptVerifyNone, false, nil)
+}
+
+func TestCheckQueue(t *testing.T) {
+	// <1>
+	valid := []byte{0x01, 0x01}
+	// <1> <2> OP_EQUAL
+	invalid := []byte{0x01, 0x01, 0x01, 0x02, core.OP_EQUAL}
+
+	for _, queue := range []*CheckQueue{nil, NewCheckQueue(4)} {
+		checks := make([]*ScriptCheck, 0, 100)
+		for i := 0; i < 100; i++ {
+			checks = append(checks, newTestScriptCheck(valid))
+		}
+		control := queue.NewControl()
+		control.Add(checks[:50])
+		control.Add(checks[50:])
+		if ok, err := control.Wait(); !ok {
+			t.Errorf("valid checks failed with %s on %d workers", crypto.ScriptErrorString(err), queue.Workers())
+		}
+
+		checks[60] = newTestScriptCheck(invalid)
+		control = queue.NewControl()
+		control.Add(checks)
+		ok, err := control.Wait()
+		if ok || err != crypto.ScriptErrEvalFalse {
+			t.Errorf("invalid check not reported on %d workers: %v %s", queue.Workers(), ok,
+				crypto.ScriptErrorString(err))
+		}
+
+		// Nothing is queued once the batch has failed.
+		control.Add(checks[:1])
+		if ok, _ := control.Wait(); ok {
+			t.Errorf("failed control should stay failed on %d workers", queue.Workers())
+		}
+
+		// A cancelled batch is drained: no check runs once Cancel returned.
+		control = queue.NewControl()
+		control.Add(checks[:10])
+		control.Cancel()
+		control.Add(checks[10:])
+		if ok, err := control.Wait(); !ok {
+			t.Errorf("cancelled checks should not fail on %d workers: %s", queue.Workers(),
+				crypto.ScriptErrorString(err))
+		}
+
+		if queue != nil {
+			queue.Stop()
+		}
+	}
+}
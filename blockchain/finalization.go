@@ -0,0 +1,119 @@
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+)
+
+// DefaultMaxReorgDepth default for -maxreorgdepth, a negative value disables
+// the automatic finalization.
+const DefaultMaxReorgDepth = 10
+
+// gIndexFinalized is the last block which can not be reorged anymore. It is
+// always on the active chain.
+var gIndexFinalized *core.BlockIndex
+
+// GetFinalizedBlock returns the last finalized block, or nil if none.
+func GetFinalizedBlock() *core.BlockIndex {
+	return gIndexFinalized
+}
+
+// IsBlockFinalized returns true if index is the finalized block or one of its
+// ancestors.
+func IsBlockFinalized(index *core.BlockIndex) bool {
+	return gIndexFinalized != nil && index != nil &&
+		gIndexFinalized.GetAncestor(index.Height) == index
+}
+
+// findBlockToFinalize returns the block to finalize once indexNew is the tip,
+// -maxreorgdepth blocks behind it.
+func findBlockToFinalize(indexNew *core.BlockIndex) *core.BlockIndex {
+	maxReorgDepth := utils.GetArg("-maxreorgdepth", DefaultMaxReorgDepth)
+	if maxReorgDepth < 0 || int64(indexNew.Height) < maxReorgDepth {
+		return nil
+	}
+	return indexNew.GetAncestor(indexNew.Height - int(maxReorgDepth))
+}
+
+// FinalizeBlockInternal finalizes index, which must neither be invalid nor
+// conflict with the block already finalized.
+func FinalizeBlockInternal(state *core.ValidationState, index *core.BlockIndex) bool {
+	hash := index.GetBlockHash()
+	if index.Status&core.BlockFailedMask != 0 {
+		return state.Dos(100, false, core.RejectInvalid, "finalize-invalid-block", false,
+			fmt.Sprintf("trying to finalize invalid block %s", hash.ToString()))
+	}
+
+	// Check that the request is consistent with current finalization.
+	if gIndexFinalized != nil && !IsBlockFinalized(index) &&
+		index.GetAncestor(gIndexFinalized.Height) != gIndexFinalized {
+		return state.Dos(20, false, core.RejectAgainstFinalized, "bad-fork-prior-finalization", false,
+			fmt.Sprintf("trying to finalize block %s which conflicts with already finalized block", hash.ToString()))
+	}
+
+	if IsBlockFinalized(index) {
+		// The block is already finalized.
+		return true
+	}
+
+	gIndexFinalized = index
+	return true
+}
+
+// FinalizeBlockAndInvalidate finalizes index on behalf of the operator. A
+// parked index is unparked, and the active chain is rewound away from any
+// block conflicting with it.
+func FinalizeBlockAndInvalidate(params *msg.BitcoinParams, state *core.ValidationState,
+	index *core.BlockIndex) bool {
+	if index.Status&core.BlockParkedMask != 0 {
+		UnparkBlock(index)
+	}
+
+	if !FinalizeBlockInternal(state, index) {
+		return false
+	}
+
+	// The finalized block must end up on the active chain: invalidate the
+	// first block of the active chain which is not one of its ancestors.
+	if !GChainState.ChainActive.Contains(index) {
+		forkHeight := -1
+		if fork := GChainState.ChainActive.FindFork(index); fork != nil {
+			forkHeight = fork.Height
+		}
+		if next := GChainState.ChainActive.GetSpecIndex(forkHeight + 1); next != nil {
+			return InvalidateBlock(params, state, next)
+		}
+	}
+	return true
+}
+
+// ParkBlock holds index and its descendants out of the active chain, without
+// marking them invalid.
+func ParkBlock(params *msg.BitcoinParams, state *core.ValidationState, index *core.BlockIndex) bool {
+	return unwindBlock(params, state, index, false)
+}
+
+// UnparkBlock clears the parked flags of index, its descendants and its
+// ancestors, and makes them candidates for the active chain again.
+func UnparkBlock(index *core.BlockIndex) {
+	// todo AssertLockHeld(cs_main)
+	height := index.Height
+	for _, bl := range GChainState.MapBlockIndex.Data {
+		if bl.Status&core.BlockParkedMask != 0 && bl.GetAncestor(height) == index {
+			bl.Status &= ^core.BlockParkedMask
+			gSetDirtyBlockIndex.AddItem(bl)
+		}
+	}
+
+	for bl := index; bl != nil; bl = bl.Prev {
+		if bl.Status&core.BlockParkedMask != 0 {
+			bl.Status &= ^core.BlockParkedMask
+			gSetDirtyBlockIndex.AddItem(bl)
+		}
+	}
+
+	addBlockIndexCandidates()
+}
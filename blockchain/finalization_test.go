@@ -0,0 +1,104 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/btcboost/copernicus/container"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+)
+
+// newTestFork returns length blocks built on prev, usable as chain candidates.
+func newTestFork(prev *core.BlockIndex, length int, bits uint32) []*core.BlockIndex {
+	blocks := make([]*core.BlockIndex, length)
+	for i := range blocks {
+		blocks[i] = getBlockIndex(prev, 300, bits)
+		blocks[i].Header.HashPrevBlock = prev.BlockHash
+		blocks[i].BlockHash, _ = blocks[i].Header.GetHash()
+		blocks[i].Status = core.BlockValidTransactions | core.BlockHaveData
+		blocks[i].ChainTxCount = prev.ChainTxCount + 1
+		GChainState.MapBlockIndex.Data[blocks[i].BlockHash] = blocks[i]
+		prev = blocks[i]
+	}
+	return blocks
+}
+
+func TestFinalizationAndParking(t *testing.T) {
+	bits := BigToCompact(new(big.Int).Rsh(msg.MainNetParams.PowLimit, 4))
+
+	savedData, savedChain := GChainState.MapBlockIndex.Data, GChainState.ChainActive
+	savedCandidates, savedFinalized, savedInvalid := GChainState.setBlockIndexCandidates, gIndexFinalized, gIndexBestInvalid
+	defer func() {
+		GChainState.MapBlockIndex.Data, GChainState.ChainActive = savedData, savedChain
+		GChainState.setBlockIndexCandidates, gIndexFinalized, gIndexBestInvalid = savedCandidates, savedFinalized, savedInvalid
+	}()
+	GChainState.MapBlockIndex.Data = make(map[utils.Hash]*core.BlockIndex)
+	GChainState.setBlockIndexCandidates = container.NewCustomSet(BlockIndexWorkComparator)
+	GChainState.ChainActive = core.Chain{}
+	gIndexFinalized = nil
+
+	blocks := newTestHeaderChain(20, bits)
+	for i, block := range blocks {
+		block.Status = core.BlockValidTransactions | core.BlockHaveData
+		block.ChainTxCount = i + 1
+	}
+	GChainState.ChainActive.SetTip(blocks[19])
+	GChainState.setBlockIndexCandidates.AddInterm(blocks[19])
+
+	toFinalize := findBlockToFinalize(blocks[19])
+	if toFinalize != blocks[19-DefaultMaxReorgDepth] {
+		t.Fatalf("expected block %d to be finalized, got %v", 19-DefaultMaxReorgDepth, toFinalize)
+	}
+	state := core.NewValidationState()
+	if !FinalizeBlockInternal(state, toFinalize) {
+		t.Fatalf("finalizing the active chain failed: %s", state.GetRejectReason())
+	}
+	if !IsBlockFinalized(blocks[3]) || IsBlockFinalized(blocks[10]) {
+		t.Errorf("only the finalized block and its ancestors should be final")
+	}
+
+	// A fork below the finalized block is refused whatever its work.
+	deepFork := newTestFork(blocks[5], 20, bits)
+	GChainState.setBlockIndexCandidates.AddInterm(deepFork[19])
+	if index := FindMostWorkChain(); index != blocks[19] {
+		t.Errorf("a fork reorging the finalized block should be refused")
+	}
+	conflicting := deepFork[toFinalize.Height-deepFork[0].Height]
+	if conflicting.Status&core.BlockFailedValid == 0 || deepFork[19].Status&core.BlockFailedChild == 0 {
+		t.Errorf("a fork reorging the finalized block should be marked invalid")
+	}
+
+	state = core.NewValidationState()
+	if FinalizeBlockInternal(state, conflicting) || state.GetRejectReason() != "finalize-invalid-block" {
+		t.Errorf("finalizing an invalid block should fail, got %q", state.GetRejectReason())
+	}
+	conflict := newTestFork(blocks[7], 1, bits)
+	state = core.NewValidationState()
+	if FinalizeBlockInternal(state, conflict[0]) || state.GetRejectReason() != "bad-fork-prior-finalization" {
+		t.Errorf("finalizing a conflicting block should fail, got %q", state.GetRejectReason())
+	}
+
+	// A parked fork is held out of the active chain until unparked.
+	fork := newTestFork(blocks[15], 10, bits)
+	GChainState.setBlockIndexCandidates.AddInterm(fork[9])
+	state = core.NewValidationState()
+	if !ParkBlock(msg.ActiveNetParams, state, fork[0]) {
+		t.Fatalf("parking a fork failed: %s", state.GetRejectReason())
+	}
+	if index := FindMostWorkChain(); index != blocks[19] {
+		t.Errorf("a parked fork should not be selected")
+	}
+	if fork[9].Status&core.BlockParkedParent == 0 || fork[9].Status&core.BlockFailedMask != 0 {
+		t.Errorf("the descendants of a parked block should be parked, not invalid")
+	}
+
+	UnparkBlock(fork[0])
+	if fork[0].Status&core.BlockParkedMask != 0 || fork[9].Status&core.BlockParkedMask != 0 {
+		t.Errorf("unparking should clear the parked flags")
+	}
+	if index := FindMostWorkChain(); index != fork[9] {
+		t.Errorf("an unparked fork with more work should be selected")
+	}
+}
@@ -0,0 +1,80 @@
+package blockchain
+
+import (
+	"sync"
+
+	"github.com/btcboost/copernicus/core"
+)
+
+// ValidationInterface is implemented by subsystems, such as the wallet,
+// which follow the active chain and the mempool. Notifications are sent
+// synchronously, in the order of the events.
+type ValidationInterface interface {
+	// BlockConnected is called once block becomes the tip, at index.
+	BlockConnected(block *core.Block, index *core.BlockIndex)
+	// BlockDisconnected is called once block, at index, was disconnected
+	// from the tip.
+	BlockDisconnected(block *core.Block, index *core.BlockIndex)
+	// TransactionAddedToMempool is called once tx was accepted to the
+	// mempool.
+	TransactionAddedToMempool(tx *core.Tx)
+}
+
+var (
+	validationInterfacesLock sync.RWMutex
+	validationInterfaces     []ValidationInterface
+)
+
+// RegisterValidationInterface subscribes v to the notifications.
+func RegisterValidationInterface(v ValidationInterface) {
+	validationInterfacesLock.Lock()
+	defer validationInterfacesLock.Unlock()
+	validationInterfaces = append(validationInterfaces, v)
+}
+
+// UnregisterValidationInterface unsubscribes v from the notifications.
+func UnregisterValidationInterface(v ValidationInterface) {
+	validationInterfacesLock.Lock()
+	defer validationInterfacesLock.Unlock()
+	for i, registered := range validationInterfaces {
+		if registered == v {
+			validationInterfaces = append(validationInterfaces[:i], validationInterfaces[i+1:]...)
+			return
+		}
+	}
+}
+
+// UnregisterAllValidationInterfaces unsubscribes everyone, on shutdown.
+func UnregisterAllValidationInterfaces() {
+	validationInterfacesLock.Lock()
+	defer validationInterfacesLock.Unlock()
+	validationInterfaces = nil
+}
+
+func notifyValidationInterfaces(notify func(v ValidationInterface)) {
+	validationInterfacesLock.RLock()
+	registered := make([]ValidationInterface, len(validationInterfaces))
+	copy(registered, validationInterfaces)
+	validationInterfacesLock.RUnlock()
+	for _, v := range registered {
+		notify(v)
+	}
+}
+
+func notifyBlockConnected(block *core.Block, index *core.BlockIndex) {
+	notifyValidationInterfaces(func(v ValidationInterface) {
+		v.BlockConnected(block, index)
+	})
+}
+
+func notifyBlockDisconnected(block *core.Block, index *core.BlockIndex) {
+	notifyValidationInterfaces(func(v ValidationInterface) {
+		v.BlockDisconnected(block, index)
+	})
+}
+
+func notifyTransactionAddedToMempool(tx *core.Tx) {
+	notifyValidationInterfaces(func(v ValidationInterface) {
+		v.TransactionAddedToMempool(tx)
+	})
+}
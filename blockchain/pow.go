@@ -2,7 +2,9 @@ package blockchain
 
 import (
 	"math/big"
+	"sync"
 
+	"github.com/btcboost/copernicus/consensus"
 	"github.com/btcboost/copernicus/core"
 	"github.com/btcboost/copernicus/net/msg"
 	"github.com/btcboost/copernicus/utils"
@@ -21,7 +23,11 @@ func (pow *Pow) GetNextWorkRequired(indexPrev *core.BlockIndex, blHeader *core.B
 		return indexPrev.Header.Bits
 	}
 
-	if indexPrev.GetMedianTimePast() >= params.CashHardForkActivationTime {
+	if IsRuleActive(params, consensus.RuleASERT, indexPrev) {
+		return pow.getNextASERTWorkRequired(indexPrev, blHeader, params)
+	}
+
+	if IsRuleActive(params, consensus.RuleCashDAA, indexPrev) {
 		return pow.getNextCashWorkRequired(indexPrev, blHeader, params)
 	}
 
@@ -247,3 +253,136 @@ func (pow *Pow) CheckProofOfWork(hash *utils.Hash, bits uint32, params *msg.Bitc
 
 	return true
 }
+
+var (
+	asertAnchorLock   sync.Mutex
+	cachedASERTAnchor *core.BlockIndex
+)
+
+// getASERTAnchorBlock returns the anchor block of the ASERT algorithm for the
+// chain ending at indexPrev: the first block whose median time past reached
+// the activation time. The last anchor found is cached, as it is the same for
+// every block built on it.
+func getASERTAnchorBlock(indexPrev *core.BlockIndex, params *msg.BitcoinParams) *core.BlockIndex {
+	asertAnchorLock.Lock()
+	defer asertAnchorLock.Unlock()
+
+	if cachedASERTAnchor != nil && indexPrev.GetAncestor(cachedASERTAnchor.Height) == cachedASERTAnchor {
+		return cachedASERTAnchor
+	}
+
+	anchor := indexPrev
+	for anchor.Prev != nil {
+		// The median time past never decreases along a chain, so skip back
+		// as long as ASERT is already enabled there.
+		if anchor.Skip != nil && IsRuleActive(params, consensus.RuleASERT, anchor.Skip) {
+			anchor = anchor.Skip
+			continue
+		}
+		if !IsRuleActive(params, consensus.RuleASERT, anchor.Prev) {
+			break
+		}
+		anchor = anchor.Prev
+	}
+
+	cachedASERTAnchor = anchor
+	return anchor
+}
+
+// getNextASERTWorkRequired Compute the next required proof of work using the
+// ASERT (aserti3-2d) algorithm: the target of the anchor block is scaled by
+// 2^((actual time - ideal time) / half-life) where the ideal time is the time
+// elapsed since the parent of the anchor block at one block every target
+// spacing.
+func (pow *Pow) getNextASERTWorkRequired(indexPrev *core.BlockIndex, blHeader *core.BlockHeader,
+	params *msg.BitcoinParams) uint32 {
+	if indexPrev == nil {
+		panic("This cannot handle the genesis block.")
+	}
+
+	// Special difficulty rule for testnet:
+	// If the new block's timestamp is more than 2* 10 minutes then allow
+	// mining of a min-difficulty block.
+	if params.FPowAllowMinDifficultyBlocks && (blHeader.GetBlockTime() > indexPrev.GetBlockTime()+uint32(2*params.TargetTimePerBlock)) {
+		return BigToCompact(params.PowLimit)
+	}
+
+	var anchorHeight int
+	var anchorBits uint32
+	var anchorPrevTime int64
+	if params.ASERTAnchor != nil {
+		anchorHeight = params.ASERTAnchor.Height
+		anchorBits = params.ASERTAnchor.Bits
+		anchorPrevTime = params.ASERTAnchor.PrevBlockTime
+	} else {
+		anchor := getASERTAnchorBlock(indexPrev, params)
+		anchorHeight = anchor.Height
+		anchorBits = anchor.Header.Bits
+		// The time of the parent of the anchor is the reference of the
+		// absolute formulation of ASERT.
+		if anchor.Prev != nil {
+			anchorPrevTime = int64(anchor.Prev.GetBlockTime())
+		} else {
+			anchorPrevTime = int64(anchor.GetBlockTime())
+		}
+	}
+
+	timeDiff := int64(indexPrev.GetBlockTime()) - anchorPrevTime
+	heightDiff := int64(indexPrev.Height - anchorHeight)
+	nextTarget := CalculateASERT(CompactToBig(anchorBits), int64(params.TargetTimePerBlock), timeDiff,
+		heightDiff, params.PowLimit, params.ASERTHalfLife)
+
+	return BigToCompact(nextTarget)
+}
+
+// CalculateASERT returns the target of the block heightDiff+1 blocks after the
+// reference block, timeDiff seconds after the parent of the reference block.
+//
+// The exponent is computed in 16.16 fixed point and 2^frac is approximated by
+// a cubic polynomial so that every node gets the exact same result.
+func CalculateASERT(refTarget *big.Int, targetSpacing int64, timeDiff int64, heightDiff int64,
+	powLimit *big.Int, halfLife int64) *big.Int {
+	if refTarget.Sign() <= 0 || refTarget.Cmp(powLimit) > 0 {
+		panic("the reference target is out of range")
+	}
+	if heightDiff < 0 {
+		panic("the height difference should not be negative")
+	}
+	if halfLife <= 0 {
+		panic("the half-life should be positive")
+	}
+
+	exponent := ((timeDiff - targetSpacing*(heightDiff+1)) * 65536) / halfLife
+
+	// Arithmetic shift, which rounds towards negative infinity, so that frac
+	// is always positive.
+	shifts := exponent >> 16
+	frac := uint64(uint16(exponent))
+
+	// 2^frac ~= 1 + 0.695502049*frac + 0.2262698*frac^2 + 0.0782318*frac^3
+	// for 0 <= frac < 1, scaled by 2^16. The uint64 arithmetic cannot
+	// overflow for any 16 bit frac.
+	factor := 65536 + ((195766423245049*frac + 971821376*frac*frac +
+		5127*frac*frac*frac + 1<<47) >> 48)
+
+	nextTarget := new(big.Int).Mul(refTarget, new(big.Int).SetUint64(factor))
+
+	// The factor was scaled by 2^16, undo it along with the shifts.
+	shifts -= 16
+	if shifts <= 0 {
+		nextTarget.Rsh(nextTarget, uint(-shifts))
+	} else if shifts > int64(powLimit.BitLen()) {
+		return new(big.Int).Set(powLimit)
+	} else {
+		nextTarget.Lsh(nextTarget, uint(shifts))
+	}
+
+	if nextTarget.Sign() == 0 {
+		// The target can't be zero, as no hash would be below it.
+		return big.NewInt(1)
+	}
+	if nextTarget.Cmp(powLimit) > 0 {
+		return new(big.Int).Set(powLimit)
+	}
+	return nextTarget
+}
@@ -1,9 +1,11 @@
 package blockchain
 
 import (
+	"math"
 	"math/big"
 	"testing"
 
+	"github.com/btcboost/copernicus/consensus"
 	"github.com/btcboost/copernicus/core"
 	"github.com/btcboost/copernicus/net/msg"
 )
@@ -399,3 +401,176 @@ func TestPowGetNextCashWorkRequired(t *testing.T) {
 	}
 
 }
+
+func TestCalculateASERT(t *testing.T) {
+	powLimit := msg.MainNetParams.PowLimit
+	halfLife := msg.MainNetParams.ASERTHalfLife
+	initialTarget := new(big.Int).Rsh(powLimit, 4)
+
+	// The time difference is counted from the parent of the reference block,
+	// which is assumed to be ideally spaced before it.
+	const parentTimeDiff = 600
+
+	// Steady
+	target := CalculateASERT(initialTarget, 600, parentTimeDiff+600, 1, powLimit, halfLife)
+	if target.Cmp(initialTarget) != 0 {
+		t.Errorf("a block on schedule should keep the target, got %x", target)
+	}
+
+	// A block that arrives in half the expected time
+	target = CalculateASERT(initialTarget, 600, parentTimeDiff+600+300, 2, powLimit, halfLife)
+	if target.Cmp(initialTarget) >= 0 {
+		t.Errorf("a fast block should lower the target, got %x", target)
+	}
+
+	// A block that makes up for the shortfall of the previous one restores
+	// the initial target.
+	prevTarget := target
+	target = CalculateASERT(initialTarget, 600, parentTimeDiff+600+300+900, 3, powLimit, halfLife)
+	if target.Cmp(prevTarget) <= 0 || target.Cmp(initialTarget) != 0 {
+		t.Errorf("the target should be restored, got %x", target)
+	}
+
+	// Two days behind schedule doubles the target.
+	prevTarget = target
+	target = CalculateASERT(prevTarget, 600, parentTimeDiff+288*1200, 288, powLimit, halfLife)
+	if target.Cmp(new(big.Int).Lsh(prevTarget, 1)) != 0 {
+		t.Errorf("a half-life behind schedule should double the target, got %x", target)
+	}
+
+	// Two days ahead of schedule halves it.
+	prevTarget = target
+	target = CalculateASERT(prevTarget, 600, parentTimeDiff+288*0, 288, powLimit, halfLife)
+	if target.Cmp(new(big.Int).Rsh(prevTarget, 1)) != 0 || target.Cmp(initialTarget) != 0 {
+		t.Errorf("a half-life ahead of schedule should halve the target, got %x", target)
+	}
+
+	tests := []struct {
+		refTarget      *big.Int
+		timeDiff       int64
+		heightDiff     int64
+		expectedTarget *big.Int
+		expectedBits   uint32
+	}{
+		{powLimit, 0, 2 * 144, new(big.Int).Rsh(powLimit, 1), 0x1c7fffff},
+		{powLimit, 0, 4 * 144, new(big.Int).Rsh(powLimit, 2), 0x1c3fffff},
+		{new(big.Int).Rsh(powLimit, 1), 0, 2 * 144, new(big.Int).Rsh(powLimit, 2), 0x1c3fffff},
+		{new(big.Int).Rsh(powLimit, 2), 0, 2 * 144, new(big.Int).Rsh(powLimit, 3), 0x1c1fffff},
+		{new(big.Int).Rsh(powLimit, 3), 0, 2 * 144, new(big.Int).Rsh(powLimit, 4), 0x1c0fffff},
+		{powLimit, 0, 2 * (256 - 34) * 144, big.NewInt(3), 0x01030000},
+		{powLimit, 0, 2*(256-34)*144 + 119, big.NewInt(3), 0x01030000},
+		{powLimit, 0, 2*(256-34)*144 + 120, big.NewInt(2), 0x01020000},
+		{powLimit, 0, 2*(256-33)*144 - 1, big.NewInt(2), 0x01020000},
+		{powLimit, 0, 2 * (256 - 33) * 144, big.NewInt(1), 0x01010000},
+		// The target can't go below 1.
+		{powLimit, 0, 2 * (256 - 32) * 144, big.NewInt(1), 0x01010000},
+		{powLimit, 0, 2*(256-32)*144 + 2*144, big.NewInt(1), 0x01010000},
+		{big.NewInt(1), 0, 0, big.NewInt(1), 0x01010000},
+		// Nor above the proof of work limit.
+		{powLimit, 2 * 144 * 600, 0, powLimit, 0x1d00ffff},
+		{new(big.Int).Rsh(powLimit, 1), 2 * 2 * 144 * 600, 0, powLimit, 0x1d00ffff},
+		{big.NewInt(1), 600 * 2 * 144 * 256, 0, powLimit, 0x1d00ffff},
+		{big.NewInt(1), 1 << 40, 0, powLimit, 0x1d00ffff},
+	}
+	for i, test := range tests {
+		target := CalculateASERT(test.refTarget, 600, parentTimeDiff+test.timeDiff, test.heightDiff,
+			powLimit, halfLife)
+		if target.Cmp(test.expectedTarget) != 0 {
+			t.Errorf("case %d: expected target %x, got %x", i, test.expectedTarget, target)
+		}
+		if bits := BigToCompact(target); bits != test.expectedBits {
+			t.Errorf("case %d: expected bits %#08x, got %#08x", i, test.expectedBits, bits)
+		}
+	}
+
+	// Vectors from the mainnet anchor whose exponents are whole numbers of
+	// half-lives, so that the target is the anchor target shifted exactly.
+	anchorBits := msg.MainNetParams.ASERTAnchor.Bits
+	anchorTarget := CompactToBig(anchorBits)
+	vectors := []struct {
+		heightDiff   int64
+		timeDiff     int64
+		expectedBits uint32
+	}{
+		{0, 600, 0x1804dafe},
+		{1000, 1001 * 600, 0x1804dafe},
+		{1000, 1001*600 + halfLife, 0x1809b5fc},
+		{1000, 1001*600 + 2*halfLife, 0x18136bf8},
+		{1000, 1001*600 - halfLife, 0x18026d7f},
+		{1000, 1001*600 - 2*halfLife, 0x180136bf},
+		{1000, 1001*600 - 16*halfLife, 0x1604dafe},
+	}
+	for i, vector := range vectors {
+		target := CalculateASERT(anchorTarget, 600, vector.timeDiff, vector.heightDiff, powLimit, halfLife)
+		if bits := BigToCompact(target); bits != vector.expectedBits {
+			t.Errorf("vector %d: expected bits %#08x, got %#08x", i, vector.expectedBits, bits)
+		}
+	}
+
+	// Blocks on schedule from an anchor at the proof of work limit keep it.
+	for height := int64(1); height <= 10; height++ {
+		target := CalculateASERT(powLimit, 600, (height+1)*600, height, powLimit, halfLife)
+		if bits := BigToCompact(target); bits != 0x1d00ffff {
+			t.Errorf("height %d: expected bits 0x1d00ffff, got %#08x", height, bits)
+		}
+	}
+
+	// Between whole half-lives the cubic approximation of the specification
+	// stays within 0.013% of the exact exponential.
+	refTarget := new(big.Int).Rsh(powLimit, 8)
+	for offset := int64(0); offset < halfLife; offset += 997 {
+		target := CalculateASERT(refTarget, 600, 600+offset, 0, powLimit, halfLife)
+		ratio, _ := new(big.Float).Quo(new(big.Float).SetInt(target), new(big.Float).SetInt(refTarget)).Float64()
+		exact := math.Exp2(float64(offset*65536/halfLife) / 65536)
+		if math.Abs(ratio-exact)/exact > 0.00013 {
+			t.Errorf("offset %d: expected a factor of %f, got %f", offset, exact, ratio)
+		}
+	}
+}
+
+func TestPowGetNextASERTWorkRequired(t *testing.T) {
+	params := msg.RegressionNetParams
+	params.PowLimit = msg.MainNetParams.PowLimit
+	params.FPowNoRetargeting = false
+	params.ASERTAnchor = nil
+	initialBits := BigToCompact(new(big.Int).Rsh(params.PowLimit, 4))
+
+	blocks := make([]*core.BlockIndex, 300)
+	blocks[0] = new(core.BlockIndex)
+	blocks[0].SetNull()
+	blocks[0].Header.Time = 1269211443
+	blocks[0].Header.Bits = initialBits
+	blocks[0].ChainWork = *GetBlockProof(blocks[0])
+	for i := 1; i < 200; i++ {
+		blocks[i] = getBlockIndex(blocks[i-1], 600, initialBits)
+	}
+
+	// Activate ASERT so that block 150 is the anchor.
+	params.Upgrades = append([]consensus.Upgrade(nil), params.Upgrades...)
+	params.GetUpgrade(consensus.UpgradeAxion).Time = blocks[150].GetMedianTimePast()
+	if anchor := getASERTAnchorBlock(blocks[199], &params); anchor != blocks[150] {
+		t.Fatalf("expected anchor at height 150, got %d", anchor.Height)
+	}
+
+	pow := Pow{}
+	blkHeaderDummy := core.BlockHeader{}
+	bits := pow.GetNextWorkRequired(blocks[199], &blkHeaderDummy, &params)
+	if bits != initialBits {
+		t.Errorf("blocks on schedule should keep the anchor bits %#08x, got %#08x", initialBits, bits)
+	}
+
+	// Two days worth of blocks, each at the same time as the previous one,
+	// halve the target.
+	i := 200
+	for ; i < 200+288; i++ {
+		if i == len(blocks) {
+			blocks = append(blocks, nil)
+		}
+		blocks[i] = getBlockIndex(blocks[i-1], 0, bits)
+	}
+	bits = pow.GetNextWorkRequired(blocks[i-1], &blkHeaderDummy, &params)
+	expected := BigToCompact(new(big.Int).Rsh(params.PowLimit, 5))
+	if bits != expected {
+		t.Errorf("expected bits %#08x, got %#08x", expected, bits)
+	}
+}
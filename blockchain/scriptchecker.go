@@ -13,19 +13,22 @@ type ScriptCheck struct {
 	amount       utils.Amount
 	txTo         *core.Tx
 	ins          int
+	context      *core.ScriptExecutionContext
 	flags        uint32
 	cacheStore   bool
 	err          crypto.ScriptError
 	txData       *core.PrecomputedTransactionData
 }
 
-func NewScriptCheck(script *core.Script, amount utils.Amount, tx *core.Tx, ins int, flags uint32,
+func NewScriptCheck(script *core.Script, amount utils.Amount, context *core.ScriptExecutionContext, flags uint32,
 	cacheStore bool, txData *core.PrecomputedTransactionData) *ScriptCheck {
+	context.TxData = txData
 	return &ScriptCheck{
 		scriptPubKey: script,
 		amount:       amount,
-		txTo:         tx,
-		ins:          ins,
+		txTo:         context.Tx,
+		ins:          context.InputIndex,
+		context:      context,
 		flags:        flags,
 		cacheStore:   cacheStore,
 		txData:       txData,
@@ -33,10 +36,21 @@ func NewScriptCheck(script *core.Script, amount utils.Amount, tx *core.Tx, ins i
 }
 
 func (sc *ScriptCheck) check() bool {
-	// scriptSig := sc.txTo.Ins[sc.ins].Script
-	// if !core.VerifyScript(scriptSig, sc.scriptPubKey, sc.flags,, sc.err) { // todo new a CachingTransactionSignatureChecker
-	// 	return false
-	// }
+	scriptSig := sc.txTo.Ins[sc.ins].Script
+	ret, err := core.NewCachingInterpreter(sc.cacheStore).VerifyWithContext(sc.context, scriptSig, sc.scriptPubKey, sc.flags)
+	if err != nil {
+		if e, ok := err.(*crypto.ErrDesc); ok {
+			sc.err = e.Code
+		} else {
+			sc.err = crypto.ScriptErrUnknownError
+		}
+		return false
+	}
+	if !ret {
+		sc.err = crypto.ScriptErrEvalFalse
+		return false
+	}
+	sc.err = crypto.ScriptErrOK
 	return true
 }
 
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+	"github.com/pkg/errors"
+)
+
+// MempoolHeight is the height of the spending transactions of the mempool
+// in the spent index.
+const MempoolHeight = -1
+
+// ErrSpentIndexDisabled is returned by GetSpentInfo for an output which is
+// not spent in the mempool when the node runs without -spentindex.
+var ErrSpentIndexDisabled = errors.New("spent index not enabled")
+
+// SpentInfo is the input spending an output, with the value and script of
+// the output.
+type SpentInfo struct {
+	TxID   utils.Hash
+	Index  uint32
+	Height int
+	Value  int64
+	Script []byte
+}
+
+// spentIndexEntry records the input spending an outpoint.
+type spentIndexEntry struct {
+	outPoint core.OutPoint
+	SpentInfo
+}
+
+// connectSpentIndex records the inputs of the block at pindex, whose
+// transaction i spends the coins spent[i] of its undo data.
+func connectSpentIndex(block *core.Block, pindex *core.BlockIndex, spent [][]*utxo.Coin) error {
+	if GBlockTree == nil {
+		return errors.New("the block tree database is not open")
+	}
+	var entries []*spentIndexEntry
+	for i, tx := range block.Txs {
+		if tx.IsCoinBase() {
+			continue
+		}
+		if i >= len(spent) || len(spent[i]) != len(tx.Ins) {
+			return errors.Errorf("the undo data of transaction %d does not match its inputs", i)
+		}
+		txid := tx.TxHash()
+		for j, in := range tx.Ins {
+			out := spent[i][j].TxOut
+			entries = append(entries, &spentIndexEntry{*in.PreviousOutPoint, SpentInfo{
+				TxID:   txid,
+				Index:  uint32(j),
+				Height: pindex.Height,
+				Value:  out.Value,
+				Script: out.Script.GetScriptByte(),
+			}})
+		}
+	}
+	return GBlockTree.WriteSpentIndex(entries)
+}
+
+// disconnectSpentIndex removes the inputs of block from the index.
+func disconnectSpentIndex(block *core.Block) error {
+	if GBlockTree == nil {
+		return errors.New("the block tree database is not open")
+	}
+	var outPoints []*core.OutPoint
+	for _, tx := range block.Txs {
+		if tx.IsCoinBase() {
+			continue
+		}
+		for _, in := range tx.Ins {
+			outPoints = append(outPoints, in.PreviousOutPoint)
+		}
+	}
+	return GBlockTree.EraseSpentIndex(outPoints)
+}
+
+// GetSpentInfo returns the input spending outPoint in the mempool or, with
+// -spentindex, in the active chain.
+func GetSpentInfo(outPoint *core.OutPoint) (*SpentInfo, error) {
+	if tx, index := GMemPool.GetSpender(outPoint); tx != nil {
+		info := &SpentInfo{TxID: tx.TxHash(), Index: uint32(index), Height: MempoolHeight}
+		if out := mempoolSpentOutput(outPoint); out != nil {
+			info.Value, info.Script = out.Value, out.Script.GetScriptByte()
+		}
+		return info, nil
+	}
+	if !GSpentIndex || GBlockTree == nil {
+		return nil, ErrSpentIndexDisabled
+	}
+	return GBlockTree.ReadSpentIndex(outPoint)
+}
+
+// mempoolSpentOutput returns the output spent in the mempool at outPoint,
+// created either in the mempool or in the active chain.
+func mempoolSpentOutput(outPoint *core.OutPoint) *core.TxOut {
+	if tx := GMemPool.FindTx(outPoint.Hash); tx != nil {
+		if int(outPoint.Index) < len(tx.Outs) {
+			return tx.Outs[outPoint.Index]
+		}
+		return nil
+	}
+	if GCoinsTip == nil {
+		return nil
+	}
+	if coin := GCoinsTip.AccessCoin(outPoint); !coin.IsSpent() {
+		return coin.TxOut
+	}
+	return nil
+}
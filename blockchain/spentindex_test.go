@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/database"
+	"github.com/btcboost/copernicus/mempool"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+)
+
+func TestSpentIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spentindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbw, err := database.NewDBWrapper(&database.DBOption{FilePath: dir, CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbw.Close()
+
+	savedBlockTree, savedSpentIndex, savedMemPool := GBlockTree, GSpentIndex, GMemPool
+	defer func() {
+		GBlockTree, GSpentIndex, GMemPool = savedBlockTree, savedSpentIndex, savedMemPool
+	}()
+	GBlockTree = &BlockTreeDB{dbw: dbw}
+	GMemPool = mempool.NewTxMempool()
+
+	spent := []*core.OutPoint{core.NewOutPoint(utils.Hash{1}, 0), core.NewOutPoint(utils.Hash{2}, 3)}
+	coinbase := newTestTx([]*core.OutPoint{nil}, []int64{1}, [][]byte{{0x51}})
+	spend := newTestTx(spent, []int64{1}, [][]byte{{0x51}})
+	block := &core.Block{Txs: []*core.Tx{coinbase, spend}}
+	undo := [][]*utxo.Coin{nil, {
+		utxo.NewCoin(core.NewTxOut(40, []byte{0x52}), 3, false),
+		utxo.NewCoin(core.NewTxOut(60, []byte{0x53, 0x87}), 5, true),
+	}}
+	if err := connectSpentIndex(block, &core.BlockIndex{Height: 7}, undo[:1]); err == nil {
+		t.Errorf("spends without undo data should not be indexed")
+	}
+	if err := connectSpentIndex(block, &core.BlockIndex{Height: 7}, undo); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetSpentInfo(spent[1]); err != ErrSpentIndexDisabled {
+		t.Errorf("block spends should not be found without -spentindex, got %v", err)
+	}
+	GSpentIndex = true
+	for i, outPoint := range spent {
+		info, err := GetSpentInfo(outPoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := undo[1][i].TxOut
+		if info.TxID != spend.TxHash() || info.Index != uint32(i) || info.Height != 7 ||
+			info.Value != out.Value || !bytes.Equal(info.Script, out.Script.GetScriptByte()) {
+			t.Errorf("unexpected spender %+v of %s", info, outPoint.String())
+		}
+	}
+	if _, err := GetSpentInfo(core.NewOutPoint(utils.Hash{1}, 1)); err == nil {
+		t.Errorf("unspent outputs should not be found")
+	}
+
+	if err := disconnectSpentIndex(block); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetSpentInfo(spent[0]); err == nil {
+		t.Errorf("the spends of a disconnected block should be removed")
+	}
+
+	// Spends of the mempool are found without the index, with the outputs
+	// of the mempool they spend.
+	GSpentIndex = false
+	parent := newTestTx([]*core.OutPoint{spent[1]}, []int64{25}, [][]byte{{0x54}})
+	GMemPool.PoolData[parent.TxHash()] = &mempool.TxEntry{Tx: parent}
+	parentOut := core.NewOutPoint(parent.TxHash(), 0)
+	pending := newTestTx([]*core.OutPoint{spent[0], parentOut}, []int64{1}, [][]byte{{0x51}})
+	GMemPool.NextTx[*spent[0]] = &mempool.TxEntry{Tx: pending}
+	GMemPool.NextTx[*parentOut] = &mempool.TxEntry{Tx: pending}
+	info, err := GetSpentInfo(parentOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.TxID != pending.TxHash() || info.Index != 1 || info.Height != MempoolHeight ||
+		info.Value != 25 || !bytes.Equal(info.Script, []byte{0x54}) {
+		t.Errorf("unexpected mempool spender %+v", info)
+	}
+}
+
+// useTestBlockTree makes GBlockTree a database in a temporary directory until
+// the returned function is called.
+func useTestBlockTree(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "blocktree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbw, err := database.NewDBWrapper(&database.DBOption{FilePath: dir, CacheSize: 1 << 20})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	savedBlockTree := GBlockTree
+	GBlockTree = &BlockTreeDB{dbw: dbw}
+	return func() {
+		GBlockTree = savedBlockTree
+		dbw.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSpentIndexConnectBlock(t *testing.T) {
+	defer useTestBlockTree(t)()
+	savedData, savedSpentIndex, savedMemPool := GChainState.MapBlockIndex.Data, GSpentIndex, GMemPool
+	defer func() {
+		GChainState.MapBlockIndex.Data, GSpentIndex, GMemPool = savedData, savedSpentIndex, savedMemPool
+	}()
+	GChainState.MapBlockIndex.Data = make(map[utils.Hash]*core.BlockIndex)
+	GMemPool = mempool.NewTxMempool()
+	GSpentIndex = true
+
+	// The spent coins come from the parent view, so connecting the block
+	// clears their cache entries.
+	spent := []*core.OutPoint{core.NewOutPoint(utils.Hash{1}, 0), core.NewOutPoint(utils.Hash{2}, 3)}
+	funding := map[core.OutPoint]*core.TxOut{
+		*spent[0]: core.NewTxOut(4000, []byte{0x51}),
+		*spent[1]: core.NewTxOut(6000, []byte{0x75, 0x51}),
+	}
+	spend := newTestTx(spent, []int64{9000}, [][]byte{{0x51}})
+	block, index, view := newTestConnectBlock(funding, spend)
+	state := core.NewValidationState()
+	blockUndo, ok := connectBlock(newTestConnectParams(), block, state, index, view, true)
+	if !ok {
+		t.Fatalf("connecting the block failed: %s", state.GetRejectReason())
+	}
+	if err := connectSpentIndex(block, index, blockUndo.spentCoins()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, outPoint := range spent {
+		info, err := GetSpentInfo(outPoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := funding[*outPoint]
+		if info.TxID != spend.TxHash() || info.Index != uint32(i) || info.Height != index.Height ||
+			info.Value != out.Value || !bytes.Equal(info.Script, out.Script.GetScriptByte()) {
+			t.Errorf("unexpected spender %+v of %s", info, outPoint.String())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package blockchain
+
+import (
+	"github.com/btcboost/copernicus/consensus"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utxo"
+)
+
+// clearTokenPrefixes folds the token prefixes of the outputs of block back
+// into their scripts unless the block, built on indexPrev, enforces upgrade9:
+// until then a token prefix is plain script bytes.
+func clearTokenPrefixes(params *msg.BitcoinParams, block *core.Block, indexPrev *core.BlockIndex) {
+	if IsUpgradeActive(params, consensus.UpgradeUpgrade9, indexPrev) {
+		return
+	}
+	for _, tx := range block.Txs {
+		tx.ClearTokenPrefixes()
+	}
+}
+
+// tokensEnabledAt returns whether the block at height enforces upgrade9.
+// Heights above the tip are those of the block being connected and of the
+// mempool, which follow the tip.
+func tokensEnabledAt(params *msg.BitcoinParams, height int) bool {
+	tip := GChainState.ChainActive.Tip()
+	if tip == nil || height > tip.Height {
+		return IsUpgradeActive(params, consensus.UpgradeUpgrade9, tip)
+	}
+	return IsUpgradeActive(params, consensus.UpgradeUpgrade9, GChainState.ChainActive.GetSpecIndex(height).Prev)
+}
+
+// checkInputTokenPrefixes rejects tx if it spends an output starting with a
+// token prefix which was created before upgrade9. Such outputs are
+// unspendable, even though the UTXO set parses their prefix again.
+func checkInputTokenPrefixes(params *msg.BitcoinParams, tx *core.Tx, state *core.ValidationState,
+	view *utxo.CoinsViewCache) bool {
+
+	for _, in := range tx.Ins {
+		coin := view.AccessCoin(in.PreviousOutPoint)
+		if coin.TxOut.TokenData == nil && !coin.TxOut.HasInvalidTokenPrefix() {
+			continue
+		}
+		// Outputs with an invalid prefix are rejected once tokens are enabled.
+		if coin.TxOut.TokenData == nil || !tokensEnabledAt(params, int(coin.GetHeight())) {
+			return state.Dos(100, false, core.RejectInvalid,
+				"bad-txns-input-token-before-activation", false, "")
+		}
+	}
+	return true
+}
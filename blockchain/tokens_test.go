@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcboost/copernicus/consensus"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/mempool"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+)
+
+func TestTokenPrefixesBeforeActivation(t *testing.T) {
+	params := &msg.MainNetParams
+	upgrade9 := uint32(params.GetUpgrade(consensus.UpgradeUpgrade9).Time)
+
+	// The block at height 1 is the last one before upgrade9, the block at
+	// height 2 the first one enforcing it.
+	indexes := make([]*core.BlockIndex, 2)
+	for i := range indexes {
+		indexes[i] = new(core.BlockIndex)
+		indexes[i].Height = i
+		indexes[i].Header.Time = upgrade9 - 1 + uint32(i)
+		if i > 0 {
+			indexes[i].Prev = indexes[i-1]
+		}
+	}
+	savedChain := GChainState.ChainActive
+	defer func() { GChainState.ChainActive = savedChain }()
+	GChainState.ChainActive = core.Chain{}
+	GChainState.ChainActive.SetTip(indexes[1])
+
+	newTokenOut := func() *core.TxOut {
+		out := core.NewTxOut(1000, []byte{core.OP_TRUE})
+		out.TokenData = &core.TokenData{Category: utils.Hash{1}, Bitfield: core.TokenHasAmount, Amount: 1}
+		return out
+	}
+
+	block := core.NewBlock()
+	tx := core.NewTx()
+	tx.AddTxOut(newTokenOut())
+	block.Txs = append(block.Txs, tx)
+	var before bytes.Buffer
+	tx.Outs[0].Serialize(&before)
+	clearTokenPrefixes(params, block, indexes[1])
+	if tx.Outs[0].TokenData == nil {
+		t.Errorf("the token prefix should be kept once upgrade9 is enforced")
+	}
+	clearTokenPrefixes(params, block, indexes[0])
+	var after bytes.Buffer
+	tx.Outs[0].Serialize(&after)
+	if tx.Outs[0].TokenData != nil || !tx.Outs[0].HasInvalidTokenPrefix() ||
+		!bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Errorf("the token prefix should be folded into the script before upgrade9")
+	}
+
+	invalidPrefix := core.NewTxOut(1000, []byte{core.PrefixToken, core.OP_TRUE})
+	tests := []struct {
+		name   string
+		out    *core.TxOut
+		height uint32
+		ok     bool
+	}{
+		{"plain output", core.NewTxOut(1000, []byte{core.OP_TRUE}), 1, true},
+		{"tokens before activation", newTokenOut(), 1, false},
+		{"tokens after activation", newTokenOut(), 2, true},
+		{"mempool tokens", newTokenOut(), mempool.MEMPOOL_HEIGHT, true},
+		{"invalid prefix", invalidPrefix, 2, false},
+	}
+	for i, test := range tests {
+		view := utxo.CoinsViewCache{Base: newCoinsViewTest(), CacheCoins: make(utxo.CacheCoins)}
+		outPoint := core.NewOutPoint(utils.Hash{byte(i)}, 0)
+		view.AddCoin(outPoint, *utxo.NewCoin(test.out, test.height, false), false)
+		spend := core.NewTx()
+		spend.AddTxIn(core.NewTxIn(outPoint, []byte{}))
+
+		state := core.NewValidationState()
+		if ok := checkInputTokenPrefixes(params, spend, state, &view); ok != test.ok {
+			t.Errorf("%s: expected %v, got %v (%s)", test.name, test.ok, ok, state.GetRejectReason())
+		}
+	}
+}
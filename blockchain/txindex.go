@@ -0,0 +1,200 @@
+package blockchain
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/pkg/errors"
+)
+
+// txIndexState tracks the last block of the active chain whose transactions
+// are in the transaction index. Blocks connected once the index reached the
+// tip are indexed by ConnectBlock; before, the builder indexes them.
+type txIndexState struct {
+	mtx     sync.Mutex
+	started bool
+	best    *core.BlockIndex
+}
+
+var gTxIndex txIndexState
+
+// readTxFromDisk reads the transaction at pos and returns it with the hash
+// of its block.
+func readTxFromDisk(pos *core.DiskTxPos) (*core.Tx, utils.Hash, error) {
+	file := OpenBlockFile(pos.BlockIn, true)
+	if file == nil {
+		return nil, utils.Hash{}, errors.Errorf("OpenBlockFile failed for %s", pos.BlockIn.ToString())
+	}
+	defer file.Close()
+
+	var header core.BlockHeader
+	if err := header.Deserialize(file); err != nil {
+		return nil, utils.Hash{}, errors.Wrap(err, "Deserialize or I/O error")
+	}
+	if _, err := file.Seek(int64(pos.TxOffsetIn), io.SeekCurrent); err != nil {
+		return nil, utils.Hash{}, errors.Wrap(err, "Deserialize or I/O error")
+	}
+	tx, err := core.DeserializeTx(file)
+	if err != nil {
+		return nil, utils.Hash{}, errors.Wrap(err, "Deserialize or I/O error")
+	}
+	hash, err := header.GetHash()
+	if err != nil {
+		return nil, utils.Hash{}, err
+	}
+	return tx, hash, nil
+}
+
+// txPositions returns the positions in the block file of the transactions
+// of block.
+func txPositions(block *core.Block, pindex *core.BlockIndex) []*writeTxIndex {
+	blockPos := pindex.GetBlockPos()
+	offset := utils.VarIntSerializeSize(uint64(len(block.Txs)))
+	positions := make([]*writeTxIndex, 0, len(block.Txs))
+	for _, tx := range block.Txs {
+		positions = append(positions, &writeTxIndex{hash: tx.TxHash(), pos: *core.NewDiskTxPos(&blockPos, offset)})
+		offset += tx.SerializeSize()
+	}
+	return positions
+}
+
+// txids returns the hashes of the transactions of block.
+func txids(block *core.Block) []utils.Hash {
+	hashes := make([]utils.Hash, 0, len(block.Txs))
+	for _, tx := range block.Txs {
+		hashes = append(hashes, tx.TxHash())
+	}
+	return hashes
+}
+
+// connectTxIndex records the positions of the transactions of the block at
+// pindex, once the index reached its parent.
+func connectTxIndex(pindex *core.BlockIndex, positions []*writeTxIndex) error {
+	gTxIndex.mtx.Lock()
+	defer gTxIndex.mtx.Unlock()
+	if !gTxIndex.started || gTxIndex.best != pindex.Prev {
+		return nil
+	}
+	if err := GBlockTree.WriteTxIndex(positions, pindex.GetBlockHash()); err != nil {
+		return err
+	}
+	gTxIndex.best = pindex
+	return nil
+}
+
+// disconnectTxIndex removes the transactions of the block at pindex from
+// the index, if they are in it.
+func disconnectTxIndex(block *core.Block, pindex *core.BlockIndex) error {
+	gTxIndex.mtx.Lock()
+	defer gTxIndex.mtx.Unlock()
+	if !gTxIndex.started || gTxIndex.best != pindex {
+		return nil
+	}
+	return unindexBlock(block, pindex)
+}
+
+// unindexBlock removes the transactions of the last block of the index.
+func unindexBlock(block *core.Block, pindex *core.BlockIndex) error {
+	var best utils.Hash
+	if pindex.Prev != nil {
+		best = *pindex.Prev.GetBlockHash()
+	}
+	if err := GBlockTree.EraseTxIndex(txids(block), &best); err != nil {
+		return err
+	}
+	gTxIndex.best = pindex.Prev
+	return nil
+}
+
+// StartTxIndex resumes the transaction index from its last block, after
+// removing the blocks which left the active chain, and builds it up to the
+// tip in the background.
+func StartTxIndex(param *msg.BitcoinParams) error {
+	if !GTxIndex {
+		return nil
+	}
+	if GBlockTree == nil {
+		return errors.New("the block tree database is not open")
+	}
+
+	gTxIndex.mtx.Lock()
+	defer gTxIndex.mtx.Unlock()
+	if gTxIndex.started {
+		return nil
+	}
+	if err := resumeTxIndex(param); err != nil {
+		return err
+	}
+	gTxIndex.started = true
+	go buildTxIndex(param)
+	return nil
+}
+
+// resumeTxIndex loads the last block of the transaction index and removes
+// the blocks which left the active chain.
+func resumeTxIndex(param *msg.BitcoinParams) error {
+	if hash, ok := GBlockTree.ReadTxIndexBestBlock(); ok && hash != (utils.Hash{}) {
+		gTxIndex.best = GChainState.MapBlockIndex.Data[hash]
+		if gTxIndex.best == nil {
+			return errors.Errorf("the last block %s of the transaction index is unknown", hash.ToString())
+		}
+	}
+	for gTxIndex.best != nil && !GChainState.ChainActive.Contains(gTxIndex.best) {
+		var block core.Block
+		if !ReadBlockFromDisk(&block, gTxIndex.best, param) {
+			return errors.Errorf("failed to read block %s", gTxIndex.best.GetBlockHash().ToString())
+		}
+		if err := unindexBlock(&block, gTxIndex.best); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildTxIndex indexes the blocks of the active chain after the last one of
+// the index, until it reaches the tip.
+func buildTxIndex(param *msg.BitcoinParams) {
+	lastLog := time.Now()
+	for {
+		gTxIndex.mtx.Lock()
+		next := GChainState.ChainActive.Genesis()
+		if gTxIndex.best != nil {
+			next = GChainState.ChainActive.Next(gTxIndex.best)
+		}
+		gTxIndex.mtx.Unlock()
+		if next == nil {
+			logs.Info("transaction index is synced with the tip")
+			return
+		}
+
+		var block core.Block
+		if !ReadBlockFromDisk(&block, next, param) {
+			logs.Error(fmt.Sprintf("buildTxIndex(): failed to read block %s", next.GetBlockHash().ToString()))
+			return
+		}
+
+		gTxIndex.mtx.Lock()
+		// The chain may have been reorganized meanwhile.
+		if gTxIndex.best == next.Prev && GChainState.ChainActive.Contains(next) {
+			if err := GBlockTree.WriteTxIndex(txPositions(&block, next), next.GetBlockHash()); err != nil {
+				gTxIndex.mtx.Unlock()
+				logs.Error(fmt.Sprintf("buildTxIndex(): failed to write the transaction index: %s", err.Error()))
+				return
+			}
+			gTxIndex.best = next
+		}
+		gTxIndex.mtx.Unlock()
+
+		if time.Since(lastLog) > 10*time.Second {
+			logs.Info(fmt.Sprintf("building the transaction index, at height %d of %d", next.Height,
+				GChainState.ChainActive.Height()))
+			lastLog = time.Now()
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/database"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+)
+
+// newTestDiskBlock mines a block of two transactions on prev, writes it to
+// its own block file and returns it with its index.
+func newTestDiskBlock(t *testing.T, prev *core.BlockIndex, param *msg.BitcoinParams) (*core.Block, *core.BlockIndex) {
+	height := 0
+	block := core.NewBlock()
+	block.BlockHeader.Version = 1
+	block.BlockHeader.Time = 1296688602
+	block.BlockHeader.Bits = BigToCompact(param.PowLimit)
+	if prev != nil {
+		height = prev.Height + 1
+		block.BlockHeader.HashPrevBlock = prev.BlockHash
+		block.BlockHeader.Time = prev.Header.Time + 600
+	}
+	for i := 0; i < 2; i++ {
+		tx := core.NewTx()
+		tx.AddTxIn(core.NewTxIn(core.NewOutPoint(utils.Hash{byte(height)}, uint32(i)), []byte{0x51}))
+		tx.AddTxOut(core.NewTxOut(int64(1000*(height+1)), []byte{0x51}))
+		block.Txs = append(block.Txs, tx)
+	}
+
+	pow := Pow{}
+	for {
+		hash, _ := block.BlockHeader.GetHash()
+		if pow.CheckProofOfWork(&hash, block.BlockHeader.Bits, param) {
+			break
+		}
+		block.BlockHeader.Nonce++
+	}
+
+	pos := core.DiskBlockPos{File: height}
+	if !WriteBlockToDisk(block, &pos, param.BitcoinNet) {
+		t.Fatalf("failed to write block %d", height)
+	}
+	index := core.NewBlockIndex(&block.BlockHeader)
+	index.Prev = prev
+	index.Height = height
+	index.File = pos.File
+	index.DataPos = pos.Pos
+	index.Status = core.BlockValidTransactions | core.BlockHaveData
+	index.BlockHash, _ = block.BlockHeader.GetHash()
+	GChainState.MapBlockIndex.Data[index.BlockHash] = index
+	return block, index
+}
+
+func TestTxIndex(t *testing.T) {
+	param := &msg.RegressionNetParams
+	dir, err := ioutil.TempDir("", "txindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedRoot, savedBlockTree, savedTxIndex := utils.AppRoot, GBlockTree, GTxIndex
+	savedChain, savedData := GChainState.ChainActive, GChainState.MapBlockIndex.Data
+	defer func() {
+		utils.AppRoot, GBlockTree, GTxIndex = savedRoot, savedBlockTree, savedTxIndex
+		GChainState.ChainActive, GChainState.MapBlockIndex.Data = savedChain, savedData
+		gTxIndex.started, gTxIndex.best = false, nil
+	}()
+	utils.AppRoot = dir
+	GChainState.MapBlockIndex.Data = make(map[utils.Hash]*core.BlockIndex)
+	GChainState.ChainActive = core.Chain{}
+
+	dbw, err := database.NewDBWrapper(&database.DBOption{FilePath: filepath.Join(dir, "index"), CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbw.Close()
+	GBlockTree = &BlockTreeDB{dbw: dbw}
+	GTxIndex = true
+
+	blocks := make([]*core.Block, 3)
+	indexes := make([]*core.BlockIndex, 3)
+	var prev *core.BlockIndex
+	for i := range blocks {
+		blocks[i], indexes[i] = newTestDiskBlock(t, prev, param)
+		prev = indexes[i]
+	}
+	indexed := func(block *core.Block, index *core.BlockIndex) bool {
+		for _, tx := range block.Txs {
+			txid := tx.TxHash()
+			found, hashBlock, ok := GetTransaction(param, &txid, false)
+			if !ok {
+				return false
+			}
+			if found.TxHash() != txid || hashBlock != index.BlockHash {
+				t.Fatalf("unexpected transaction %s in block %s", txid.ToString(), hashBlock.ToString())
+			}
+		}
+		return true
+	}
+	checkBest := func(index *core.BlockIndex) {
+		if gTxIndex.best != index {
+			t.Fatalf("the index should end at %v, got %v", index, gTxIndex.best)
+		}
+		if hash, ok := GBlockTree.ReadTxIndexBestBlock(); !ok || hash != index.BlockHash {
+			t.Fatalf("the stored best block should be %s, got %s", index.BlockHash.ToString(), hash.ToString())
+		}
+	}
+
+	// The builder indexes the existing chain.
+	GChainState.ChainActive.SetTip(indexes[1])
+	gTxIndex.started = true
+	buildTxIndex(param)
+	checkBest(indexes[1])
+	if !indexed(blocks[0], indexes[0]) || !indexed(blocks[1], indexes[1]) || indexed(blocks[2], indexes[2]) {
+		t.Fatal("the first two blocks should be indexed")
+	}
+
+	// New blocks are indexed when they are connected.
+	GChainState.ChainActive.SetTip(indexes[2])
+	if err := connectTxIndex(indexes[2], txPositions(blocks[2], indexes[2])); err != nil {
+		t.Fatal(err)
+	}
+	checkBest(indexes[2])
+	if !indexed(blocks[2], indexes[2]) {
+		t.Fatal("the connected block should be indexed")
+	}
+
+	// Disconnected blocks are removed.
+	GChainState.ChainActive.SetTip(indexes[1])
+	if err := disconnectTxIndex(blocks[2], indexes[2]); err != nil {
+		t.Fatal(err)
+	}
+	checkBest(indexes[1])
+	if indexed(blocks[2], indexes[2]) {
+		t.Fatal("the disconnected block should not be indexed")
+	}
+
+	// Blocks which left the chain while the node was down are removed on
+	// restart.
+	GChainState.ChainActive.SetTip(indexes[0])
+	gTxIndex.started, gTxIndex.best = false, nil
+	if err := resumeTxIndex(param); err != nil {
+		t.Fatal(err)
+	}
+	checkBest(indexes[0])
+	if !indexed(blocks[0], indexes[0]) || indexed(blocks[1], indexes[1]) {
+		t.Fatal("only the first block should remain indexed")
+	}
+}
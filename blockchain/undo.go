@@ -105,6 +105,16 @@ func NewBlockUndo() *BlockUndo {
 	}
 }
 
+// spentCoins returns the coins spent by each transaction of the block, by
+// position in the block. The coinbase spends none.
+func (bu *BlockUndo) spentCoins() [][]*utxo.Coin {
+	spent := make([][]*utxo.Coin, len(bu.txundo)+1)
+	for i, txundo := range bu.txundo {
+		spent[i+1] = txundo.PrevOut
+	}
+	return spent
+}
+
 func (bu *BlockUndo) Serialize(w io.Writer) error {
 	var err error
 	for _, txundo := range bu.txundo {
@@ -141,15 +151,32 @@ func ApplyBlockUndo(undo *BlockUndo, block *core.Block, index *core.BlockIndex,
 		return DisconnectFailed
 	}
 
-	// Undo transactions in reverse order.
-	i := len(block.Txs)
-	for i > 0 {
-		i--
+	// Restore the inputs first: with canonical transaction ordering an input
+	// may spend an output created by a later transaction of the block, which
+	// must be removed again below.
+	for i := len(block.Txs) - 1; i > 0; i-- {
 		tx := block.Txs[i]
-		txid := tx.Hash
+		txundo := undo.txundo[i-1]
+		if len(txundo.PrevOut) != len(tx.Ins) {
+			fmt.Println("DisconnectBlock(): transaction and undo data inconsistent")
+			return DisconnectFailed
+		}
+
+		for k := len(tx.Ins) - 1; k >= 0; k-- {
+			outpoint := tx.Ins[k].PreviousOutPoint
+			c := txundo.PrevOut[k]
+			res := UndoCoinSpend(c, cache, outpoint)
+			if res == DisconnectFailed {
+				return DisconnectFailed
+			}
+			clean = clean && (res != DisconnectUnclean)
+		}
+	}
 
-		// Check that all outputs are available and match the outputs in the
-		// block itself exactly.
+	// Then check that all outputs are available and match the outputs in the
+	// block itself exactly, and remove them.
+	for _, tx := range block.Txs {
+		txid := tx.Hash
 		for j := 0; j < len(tx.Outs); j++ {
 			if tx.Outs[j].Script.IsUnspendable() {
 				continue
@@ -158,33 +185,10 @@ func ApplyBlockUndo(undo *BlockUndo, block *core.Block, index *core.BlockIndex,
 			out := core.NewOutPoint(txid, uint32(j))
 			coin := utxo.NewEmptyCoin()
 			isSpent := cache.SpendCoin(out, coin)
-			if !isSpent || tx.Outs[0] != coin.TxOut {
+			if !isSpent || !tx.Outs[j].IsEqual(coin.TxOut) {
 				// transaction output mismatch
 				clean = false
 			}
-
-			// Restore inputs
-			if i < 1 {
-				// Skip the coinbase
-				continue
-			}
-
-			txundo := undo.txundo[i-1]
-			if len(txundo.PrevOut) != len(tx.Ins) {
-				fmt.Println("DisconnectBlock(): transaction and undo data inconsistent")
-				return DisconnectFailed
-			}
-
-			for k := len(tx.Ins); k > 0; {
-				k--
-				outpoint := tx.Ins[k].PreviousOutPoint
-				c := txundo.PrevOut[k]
-				res := UndoCoinSpend(c, cache, outpoint)
-				if res == DisconnectFailed {
-					return DisconnectFailed
-				}
-				clean = clean && (res != DisconnectUnclean)
-			}
 		}
 	}
 
@@ -0,0 +1,58 @@
+package blockchain
+
+import (
+	"github.com/btcboost/copernicus/consensus"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/net/msg"
+)
+
+// upgradeContext returns the height and the median time past deciding which
+// upgrades the block built on indexPrev enforces.
+func upgradeContext(indexPrev *core.BlockIndex) (int, int64) {
+	if indexPrev == nil {
+		return 0, 0
+	}
+	return indexPrev.Height + 1, indexPrev.GetMedianTimePast()
+}
+
+// IsUpgradeActive returns whether the upgrade called name is enforced by the
+// block built on indexPrev. Upgrades unknown to the network are never active.
+func IsUpgradeActive(params *msg.BitcoinParams, name string, indexPrev *core.BlockIndex) bool {
+	height, medianTimePast := upgradeContext(indexPrev)
+	return isUpgradeActiveAt(params, name, height, medianTimePast)
+}
+
+func isUpgradeActiveAt(params *msg.BitcoinParams, name string, height int, medianTimePast int64) bool {
+	upgrade := params.GetUpgrade(name)
+	return upgrade != nil && upgrade.IsActive(height, medianTimePast)
+}
+
+// IsRuleActive returns whether one of the upgrades enforced by the block
+// built on indexPrev enables rule.
+func IsRuleActive(params *msg.BitcoinParams, rule consensus.UpgradeRules, indexPrev *core.BlockIndex) bool {
+	height, medianTimePast := upgradeContext(indexPrev)
+	return isRuleActiveAt(params, rule, height, medianTimePast)
+}
+
+func isRuleActiveAt(params *msg.BitcoinParams, rule consensus.UpgradeRules, height int, medianTimePast int64) bool {
+	for i := range params.Upgrades {
+		upgrade := &params.Upgrades[i]
+		if upgrade.Rules&rule != 0 && upgrade.IsActive(height, medianTimePast) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUpgradeScriptFlags returns the script flags enabled by the upgrades the
+// block built on indexPrev enforces.
+func GetUpgradeScriptFlags(params *msg.BitcoinParams, indexPrev *core.BlockIndex) uint32 {
+	height, medianTimePast := upgradeContext(indexPrev)
+	var flags uint32
+	for i := range params.Upgrades {
+		if params.Upgrades[i].IsActive(height, medianTimePast) {
+			flags |= params.Upgrades[i].ScriptFlags
+		}
+	}
+	return flags
+}
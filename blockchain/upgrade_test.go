@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/btcboost/copernicus/consensus"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/mempool"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/sign"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+)
+
+func TestIsUpgradeActive(t *testing.T) {
+	params := &msg.MainNetParams
+	newIndex := func(height int, time uint32) *core.BlockIndex {
+		index := new(core.BlockIndex)
+		index.Height = height
+		index.Header.Time = time
+		return index
+	}
+
+	uahf := params.GetUpgrade(consensus.UpgradeUAHF).Height
+	if IsUpgradeActive(params, consensus.UpgradeUAHF, newIndex(uahf-2, 0)) ||
+		!IsUpgradeActive(params, consensus.UpgradeUAHF, newIndex(uahf-1, 0)) {
+		t.Errorf("the uahf should be enforced from height %d", uahf)
+	}
+
+	upgrade8 := uint32(params.GetUpgrade(consensus.UpgradeUpgrade8).Time)
+	before, after := newIndex(uahf, upgrade8-1), newIndex(uahf, upgrade8)
+	if IsUpgradeActive(params, consensus.UpgradeUpgrade8, before) ||
+		!IsUpgradeActive(params, consensus.UpgradeUpgrade8, after) {
+		t.Errorf("upgrade8 should be enforced once the median time past reaches %d", upgrade8)
+	}
+	introspection := uint32(crypto.ScriptEnableNativeIntrospection | crypto.ScriptEnable64BitIntegers)
+	if GetUpgradeScriptFlags(params, before)&introspection != 0 ||
+		GetUpgradeScriptFlags(params, after)&introspection != introspection {
+		t.Errorf("upgrade8 should enable native introspection and 64-bit integers")
+	}
+	if !IsRuleActive(params, consensus.RuleASERT, after) || IsRuleActive(params, consensus.RuleASERT, nil) {
+		t.Errorf("ASERT should be selected after axion only")
+	}
+
+	if IsUpgradeActive(params, "nosuchupgrade", after) {
+		t.Errorf("unknown upgrades should never be active")
+	}
+	if !IsUpgradeActive(&msg.RegressionNetParams, consensus.UpgradeUpgrade9, nil) {
+		t.Errorf("regtest upgrades should be enforced from the genesis block")
+	}
+}
+
+func TestAcceptToMemoryPoolUpgradeBoundary(t *testing.T) {
+	savedChain, savedData := GChainState.ChainActive, GChainState.MapBlockIndex.Data
+	savedCoinsTip, savedMemPool := GCoinsTip, GMemPool
+	defer func() {
+		GChainState.ChainActive, GChainState.MapBlockIndex.Data = savedChain, savedData
+		GCoinsTip, GMemPool = savedCoinsTip, savedMemPool
+	}()
+
+	keyStore, script := newTestKeyStore()
+	outPoint := core.NewOutPoint(utils.Hash{1}, 0)
+	tx := core.NewTx()
+	tx.AddTxIn(core.NewTxIn(outPoint, nil))
+	tx.AddTxOut(core.NewTxOut(utils.COIN-10000, script.GetScriptByte()))
+	coins := map[core.OutPoint]*core.TxOut{*outPoint: core.NewTxOut(utils.COIN, script.GetScriptByte())}
+	if errs := sign.SignTransaction(tx, coins, keyStore, crypto.SigHashAll|crypto.SigHashForkID); len(errs) != 0 {
+		t.Fatalf("failed to sign: %v", errs[0].Err)
+	}
+
+	// The tip is at height 2. Replay protected signatures are accepted once
+	// the next block, rather than the tip, enforces the UAHF.
+	for _, uahfHeight := range []int{3, 4} {
+		params := newTestConnectParams()
+		params.Upgrades = append([]consensus.Upgrade(nil), params.Upgrades...)
+		params.GetUpgrade(consensus.UpgradeUAHF).Height = uahfHeight
+
+		var tip *core.BlockIndex
+		for height := 0; height <= 2; height++ {
+			index := new(core.BlockIndex)
+			index.Height = height
+			index.Header.Time = uint32(1500000000 + 600*height)
+			index.Prev = tip
+			tip = index
+		}
+		tip.BlockHash = *utils.GetRandHash()
+		GChainState.ChainActive = core.Chain{}
+		GChainState.ChainActive.SetTip(tip)
+		GChainState.MapBlockIndex.Data = map[utils.Hash]*core.BlockIndex{tip.BlockHash: tip}
+		GCoinsTip = &utxo.CoinsViewCache{Base: utxo.CoinsViewDummy{}, CacheCoins: make(utxo.CacheCoins)}
+		GCoinsTip.SetBestBlock(tip.BlockHash)
+		GCoinsTip.AddCoin(outPoint, *utxo.NewCoin(coins[*outPoint], 1, false), false)
+		GMemPool = mempool.NewTxMempool()
+
+		state := core.NewValidationState()
+		ok := AcceptToMemoryPool(params, GMemPool, state, tx, false, nil, nil, false, 0)
+		if expected := uahfHeight == tip.Height+1; ok != expected {
+			t.Errorf("UAHF from height %d: expected %v, got %v (%s)", uahfHeight, expected, ok,
+				state.GetRejectReason())
+		}
+	}
+}
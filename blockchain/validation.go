@@ -156,15 +156,6 @@ func FormatStateMessage(state *core.ValidationState) string {
 	return fmt.Sprintf("%s%s (code %c)", state.GetRejectReason(), state.GetDebugMessage(), state.GetRejectCode())
 }
 
-// IsUAHFEnabled Check is UAHF has activated.
-func IsUAHFEnabled(params *msg.BitcoinParams, height int) bool {
-	return height >= params.UAHFHeight
-}
-
-func IsCashHFEnabled(params *msg.BitcoinParams, medianTimePast int64) bool {
-	return params.CashHardForkActivationTime <= medianTimePast
-}
-
 func ContextualCheckTransaction(params *msg.BitcoinParams, tx *core.Tx, state *core.ValidationState,
 	height int, lockTimeCutoff int64) bool {
 
@@ -173,7 +164,10 @@ func ContextualCheckTransaction(params *msg.BitcoinParams, tx *core.Tx, state *c
 			false, "non-final transaction")
 	}
 
-	if IsUAHFEnabled(params, height) && height <= params.AntiReplayOpReturnSunsetHeight {
+	// The lock time cutoff is the median time past of the previous block once
+	// it is used for lock times, which is what upgrades are activated on.
+	if isRuleActiveAt(params, consensus.RuleAntiReplay, height, lockTimeCutoff) &&
+		height <= params.AntiReplayOpReturnSunsetHeight {
 		for _, txo := range tx.Outs {
 			if txo.Script.IsCommitment(params.AntiReplayOpReturnCommitment) {
 				return state.Dos(10, false, core.RejectInvalid, "bad-txn-replay",
@@ -215,6 +209,32 @@ func ContextualCheckBlock(params *msg.BitcoinParams, block *core.Block, state *c
 		}
 	}
 
+	// Once canonical transaction ordering is enabled the transactions after
+	// the coinbase must be sorted by txid.
+	if IsRuleActive(params, consensus.RuleCanonicalTxOrder, indexPrev) {
+		for i := 2; i < len(block.Txs); i++ {
+			prevHash := block.Txs[i-1].TxHash()
+			hash := block.Txs[i].TxHash()
+			switch cmp := bytes.Compare(prevHash[:], hash[:]); {
+			case cmp == 0:
+				return state.Dos(100, false, core.RejectInvalid, "tx-duplicate",
+					false, fmt.Sprintf("duplicated transaction %s", hash.ToString()))
+			case cmp > 0:
+				return state.Dos(100, false, core.RejectInvalid, "tx-ordering",
+					false, fmt.Sprintf("transaction order is invalid (%s < %s)", hash.ToString(), prevHash.ToString()))
+			}
+		}
+	}
+
+	// A coinbase has no genesis input, so it can't create tokens
+	if IsUpgradeActive(params, consensus.UpgradeUpgrade9, indexPrev) {
+		for _, out := range block.Txs[0].Outs {
+			if out.TokenData != nil || out.HasInvalidTokenPrefix() {
+				return state.Dos(100, false, core.RejectInvalid, "bad-cb-token-outputs", false, "")
+			}
+		}
+	}
+
 	// Enforce rule that the coinBase starts with serialized block height
 	expect := core.Script{}
 	if height >= params.BIP34Height {
@@ -284,7 +304,7 @@ func CheckBlock(params *msg.BitcoinParams, block *core.Block, state *core.Valida
 	}
 
 	// size limits
-	nMaxBlockSize := policy.DefaultMaxBlockSize
+	nMaxBlockSize := conf.GlobalValueInstance.GetMaxBlockSize()
 
 	// Bail early if there is no way this block is of reasonable size.
 	minTransactionSize := core.NewTx().SerializeSize()
@@ -772,23 +792,21 @@ func OpenDiskFile(pos core.DiskBlockPos, prefix string, fReadOnly bool) *os.File
 	if pos.IsNull() {
 		return nil
 	}
-	path := GetBlockPosParentFilename()
-	utils.MakePath(path)
+	path := GetBlockPosFilename(pos, prefix)
+	utils.MakePath(GetBlockPosParentFilename())
 
-	file, err := os.Open(path + "rb+")
-	if file == nil && !fReadOnly || err != nil {
-		file, err = os.Open(path + "wb+")
-		if err == nil {
-			panic("open wb+ file failed ")
-		}
+	flag := os.O_RDWR | os.O_CREATE
+	if fReadOnly {
+		flag = os.O_RDONLY
 	}
-	if file == nil {
+	file, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
 		logs.Info("Unable to open file %s\n", path)
 		return nil
 	}
 	if pos.Pos > 0 {
-		if _, err := file.Seek(0, 1); err != nil {
-			logs.Info("Unable to seek to position %u of %s\n", pos.Pos, path)
+		if _, err := file.Seek(int64(pos.Pos), io.SeekStart); err != nil {
+			logs.Info(fmt.Sprintf("Unable to seek to position %d of %s", pos.Pos, path))
 			file.Close()
 			return nil
 		}
@@ -1193,11 +1211,11 @@ func blockIndexWorkComparator(pa, pb *core.BlockIndex) bool {
 
 	// Use pointer address as tie breaker (should only happen with blocks
 	// loaded from disk, as those all have id 0).
-	a, err := strconv.ParseUint(fmt.Sprintf("%p", pa), 16, 0)
+	a, err := strconv.ParseUint(fmt.Sprintf("%p", pa), 0, 0)
 	if err != nil {
 		panic("convert hex string to uint failed")
 	}
-	b, err := strconv.ParseUint(fmt.Sprintf("%p", pb), 16, 0)
+	b, err := strconv.ParseUint(fmt.Sprintf("%p", pb), 0, 0)
 	if err != nil {
 		panic("convert hex string to uint failed")
 	}
@@ -1681,7 +1699,7 @@ func ConnectTip(param *msg.BitcoinParams, state *core.ValidationState, indexNew
 	nTime2 := utils.GetMicrosTime()
 	gTimeReadFromDisk += nTime2 - nTime1
 	view := utxo.NewCoinViewCacheByCoinview(GCoinsTip)
-	rv := ConnectBlock(param, &blockConnecting, state, indexNew, view, false)
+	blockundo, rv := connectBlock(param, &blockConnecting, state, indexNew, view, false)
 	// todo etMainSignals().BlockChecked(blockConnecting, state)
 	if !rv {
 		if state.IsInvalid() {
@@ -1691,6 +1709,15 @@ func ConnectTip(param *msg.BitcoinParams, state *core.ValidationState, indexNew
 		logs.Error(fmt.Sprintf("ConnectTip(): ConnectBlock %s failed", hash.ToString()))
 		return false
 	}
+	// Blocks deep enough behind the new tip can no longer be reorged. This is
+	// checked before anything of the block is committed, so a failure leaves
+	// the chain state untouched.
+	if indexToFinalize := findBlockToFinalize(indexNew); indexToFinalize != nil &&
+		!FinalizeBlockInternal(state, indexToFinalize) {
+		logs.Error(fmt.Sprintf("ConnectTip(): failed to finalize block %s: %s",
+			indexToFinalize.BlockHash.ToString(), state.FormatStateMessage()))
+		return false
+	}
 	nTime3 := utils.GetMicrosTime()
 	gTimeConnectTotal += nTime3 - nTime2
 	log.Print("bench", "debug", " - Connect total: %.2fms [%.2fs]\n",
@@ -1707,14 +1734,32 @@ func ConnectTip(param *msg.BitcoinParams, state *core.ValidationState, indexNew
 	if !FlushStateToDisk(state, FlushStateIfNeeded, 0) {
 		return false
 	}
+	// The block is committed, so the indexes may now refer to it.
+	if GTxIndex {
+		if err := connectTxIndex(indexNew, txPositions(&blockConnecting, indexNew)); err != nil {
+			return AbortNode(state, "Failed to write transaction index", err.Error())
+		}
+	}
+	if GAddressIndex && blockundo != nil {
+		if err := connectAddressIndex(&blockConnecting, indexNew, blockundo.spentCoins()); err != nil {
+			return AbortNode(state, "Failed to write address index", err.Error())
+		}
+	}
+	if GSpentIndex && blockundo != nil {
+		if err := connectSpentIndex(&blockConnecting, indexNew, blockundo.spentCoins()); err != nil {
+			return AbortNode(state, "Failed to write spent index", err.Error())
+		}
+	}
 	nTime5 := utils.GetMicrosTime()
 	gTimeChainState += nTime5 - nTime4
 	log.Print("bench", "debug", " - Writing chainstate: %.2fms [%.2fs]\n",
 		float64(nTime5-nTime4)*0.001, float64(gTimeChainState)*0.000001)
 	// Remove conflicting transactions from the mempool.;
+	GFeeEstimator.ProcessBlock(indexNew.Height, blockConnecting.Txs)
 	GMemPool.RemoveTxSelf(blockConnecting.Txs)
 	// Update chainActive & related variables.
 	UpdateTip(param, indexNew)
+	notifyBlockConnected(&blockConnecting, indexNew)
 	nTime6 := utils.GetMicrosTime()
 	gTimePostConnect += nTime6 - nTime1
 	gTimeTotal += nTime6 - nTime1
@@ -1774,13 +1819,88 @@ func FindUndoPos(state *core.ValidationState, nFile int, pos *core.DiskBlockPos,
 	return true
 }
 
-func ThreadScriptCheck() {
-	// todo: RenameThread("bitcoin-scriptch")
-	//		scriptcheckqueue.Thread()
+// scriptChecksRequired reports whether the scripts of pindex have to be
+// verified, which is not the case when it is an ancestor of the assumed valid
+// block on a best header chain with enough work.
+func scriptChecksRequired(pindex *core.BlockIndex, param *msg.BitcoinParams) bool {
+	// We've been configured with the hash of a block which has been
+	// externally verified to have a valid history. A suitable default value
+	// is included with the software and updated from time to time. Because
+	// validity relative to a piece of software is an objective fact these
+	// defaults can be easily reviewed. This setting doesn't force the
+	// selection of any particular chain but makes validating some faster by
+	// effectively caching the result of part of the verification.
+	if HashAssumeValid.IsNull() || GIndexBestHeader == nil {
+		return true
+	}
+	it, ok := GChainState.MapBlockIndex.Data[HashAssumeValid]
+	if !ok {
+		return true
+	}
+	if it.GetAncestor(pindex.Height) != pindex || GIndexBestHeader.GetAncestor(pindex.Height) != pindex ||
+		GIndexBestHeader.ChainWork.Cmp(&param.MinimumChainWork) < 0 {
+		return true
+	}
+
+	// This block is a member of the assumed verified chain and an ancestor
+	// of the best header. The equivalent time check discourages hashpower
+	// from extorting the network via DOS attack into accepting an invalid
+	// block through telling users they must manually set assumevalid.
+	// Requiring a software change or burying the invalid block, regardless
+	// of the setting, makes it hard to hide the implication of the demand.
+	// This also avoids having release candidates that are hardly doing any
+	// signature verification at all in testing without having to
+	// artificially set the default assumed verified block further back. The
+	// test against MinimumChainWork prevents the skipping when denied access
+	// to any chain at least as good as the expected chain.
+	return GetBlockProofEquivalentTime(GIndexBestHeader, pindex, GIndexBestHeader, param) <= 60*60*24*7*2
+}
+
+// InitAssumeValid sets HashAssumeValid from -assumevalid, which defaults to
+// the assumed valid block of the network. -assumevalid=0 verifies the scripts
+// of every block.
+func InitAssumeValid(params *msg.BitcoinParams) error {
+	hashStr := utils.GetArgString("-assumevalid", params.DefaultAssumeValid.ToString())
+	hash, err := utils.GetHashFromStr(hashStr)
+	if err != nil {
+		return fmt.Errorf("invalid -assumevalid %s: %v", hashStr, err)
+	}
+	HashAssumeValid = *hash
+	if HashAssumeValid.IsNull() {
+		logs.Info("Validating signatures for all blocks.")
+	} else {
+		logs.Info(fmt.Sprintf("Assuming ancestors of block %s have valid signatures.", HashAssumeValid.ToString()))
+	}
+	return nil
+}
+
+// InitBlockSizeLimits sets the excessive block size from -excessiveblocksize
+// and checks that -blockmaxsize does not exceed it, as blocks mined above it
+// would be rejected.
+func InitBlockSizeLimits() error {
+	excessiveBlockSize := utils.GetArg("-excessiveblocksize", consensus.DefaultMaxBlockSize)
+	if excessiveBlockSize < 0 || !conf.GlobalValueInstance.SetMaxBlockSize(uint64(excessiveBlockSize)) {
+		return fmt.Errorf("excessive block size must be > %d bytes (1MB)", consensus.LegacyMaxBlockSize)
+	}
+	maxGeneratedBlockSize := utils.GetArg("-blockmaxsize", int64(policy.DefaultMaxGeneratedBlockSize))
+	if uint64(maxGeneratedBlockSize) > conf.GlobalValueInstance.GetMaxBlockSize() {
+		return fmt.Errorf("max generated block size (blockmaxsize) cannot exceed the excessive block size " +
+			"(excessiveblocksize)")
+	}
+	logs.Info(fmt.Sprintf("Excessive block size set to %d bytes.", excessiveBlockSize))
+	return nil
 }
 
 func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.ValidationState,
 	pindex *core.BlockIndex, view *utxo.CoinsViewCache, fJustCheck bool) bool {
+	_, ok := connectBlock(param, pblock, state, pindex, view, fJustCheck)
+	return ok
+}
+
+// connectBlock connects pblock to view and returns the coins it spent, which
+// are nil for the genesis block.
+func connectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.ValidationState,
+	pindex *core.BlockIndex, view *utxo.CoinsViewCache, fJustCheck bool) (*BlockUndo, bool) {
 
 	// TODO: AssertLockHeld(cs_main);
 	// var sc sync.RWMutex
@@ -1792,7 +1912,7 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 	// Check it again in case a previous version let a bad block in
 	if !CheckBlock(param, pblock, state, !fJustCheck, !fJustCheck) {
 		logs.Error(fmt.Sprintf("CheckBlock: %s", FormatStateMessage(state)))
-		return false
+		return nil, false
 	}
 
 	// Verify that the view's current state corresponds to the previous block
@@ -1801,6 +1921,7 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 	if hashPrevBlock != view.GetBestBlock() {
 		panic("error: hashPrevBlock not equal view.GetBestBlock()")
 	}
+	clearTokenPrefixes(param, pblock, pindex.Prev)
 
 	// Special case for the genesis block, skipping connection of its
 	// transactions (its coinbase is unspendable)
@@ -1808,38 +1929,10 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 		if !fJustCheck {
 			view.SetBestBlock(*pindex.GetBlockHash())
 		}
-		return true
+		return nil, true
 	}
 
-	fScriptChecks := true
-	if HashAssumeValid != utils.HashZero {
-		// We've been configured with the hash of a block which has been
-		// externally verified to have a valid history. A suitable default value
-		// is included with the software and updated from time to time. Because
-		// validity relative to a piece of software is an objective fact these
-		// defaults can be easily reviewed. This setting doesn't force the
-		// selection of any particular chain but makes validating some faster by
-		// effectively caching the result of part of the verification.
-		if it, ok := MapBlockIndex.Data[HashAssumeValid]; ok {
-			if it.GetAncestor(pindex.Height) == pindex && gIndexBestHeader.GetAncestor(pindex.Height) == pindex &&
-				gIndexBestHeader.ChainWork.Cmp(&param.MinimumChainWork) > 0 {
-				// This block is a member of the assumed verified chain and an
-				// ancestor of the best header. The equivalent time check
-				// discourages hashpower from extorting the network via DOS
-				// attack into accepting an invalid block through telling users
-				// they must manually set assumevalid. Requiring a software
-				// change or burying the invalid block, regardless of the
-				// setting, makes it hard to hide the implication of the demand.
-				// This also avoids having release candidates that are hardly
-				// doing any signature verification at all in testing without
-				// having to artificially set the default assumed verified block
-				// further back. The test against nMinimumChainWork prevents the
-				// skipping when denied access to any chain at least as good as
-				// the expected chain.
-				fScriptChecks = (GetBlockProofEquivalentTime(gIndexBestHeader, pindex, gIndexBestHeader, param)) <= 60*60*24*7*2
-			}
-		}
-	}
+	fScriptChecks := scriptChecksRequired(pindex, param)
 
 	nTime1 := utils.GetMicrosTime()
 	gTimeCheck += nTime1 - nTimeStart
@@ -1875,7 +1968,7 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 	pindexBIP34height := pindex.Prev.GetAncestor(param.BIP34Height)
 	// Only continue to enforce if we're below BIP34 activation height or the
 	// block hash at that height doesn't correspond.
-	fEnforceBIP30 = fEnforceBIP30 && (&pindexBIP34height == nil ||
+	fEnforceBIP30 = fEnforceBIP30 && (pindexBIP34height == nil ||
 		!(*pindexBIP34height.GetBlockHash() == param.BIP34Hash))
 
 	if fEnforceBIP30 {
@@ -1886,7 +1979,7 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 					Index: uint32(o),
 				}
 				if view.HaveCoin(outPoint) {
-					return state.Dos(100, false, core.RejectInvalid, "bad-txns-BIP30",
+					return nil, state.Dos(100, false, core.RejectInvalid, "bad-txns-BIP30",
 						false, "")
 				}
 			}
@@ -1905,11 +1998,14 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 	log.Print("bench", "debug", " - Fork checks: %.2fms [%.2fs]\n",
 		0.001*float64(nTime2-nTime1), float64(gTimeForks)*0.000001)
 
-	var blockundo *BlockUndo
+	blockundo := NewBlockUndo()
 	// TODO:not finish
-	// CCheckQueueControl<CScriptCheck> control(fScriptChecks ? &scriptCheckQueue : nullptr);
+	// The scripts of every input are verified by the check queue while the
+	// block is being connected, and waited for before its undo data is written.
+	// Returning early drains the checks queued so far.
+	control := gScriptCheckQueue.NewControl()
+	defer control.Cancel()
 
-	prevheights := make([]int, 0)
 	var nFees utils.Amount
 	nInputs := 0
 
@@ -1918,33 +2014,37 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 	currentBlockSize := pblock.SerializeSize()
 	nMaxSigOpsCount := consensus.GetMaxBlockSigOpsCount(uint64(currentBlockSize))
 
-	tmpBlockPos := pindex.GetBlockPos()
-	txPos := &core.DiskTxPos{
-		BlockIn:    &tmpBlockPos,
-		TxOffsetIn: len(pblock.Txs),
+	// With canonical transaction ordering a transaction may spend the outputs
+	// of one sorted after it, so the outputs of the whole block are added
+	// before any input is spent.
+	fCanonicalOrder := IsRuleActive(param, consensus.RuleCanonicalTxOrder, pindex.Prev)
+	if fCanonicalOrder {
+		for _, tx := range pblock.Txs {
+			utxo.AddCoins(*view, *tx, pindex.Height)
+		}
 	}
 
-	var vPos map[utils.Hash]core.DiskTxPos
 	for i := 0; i < len(pblock.Txs); i++ {
 		tx := pblock.Txs[i]
 		nInputs += len(tx.Ins)
 		if !tx.IsCoinBase() {
 			if !view.HaveInputs(tx) {
 				logs.Error("ConnectBlock(): inputs missing/spent")
-				return state.Dos(100, false, core.RejectInvalid,
+				return nil, state.Dos(100, false, core.RejectInvalid,
 					"bad-txns-inputs-missIngorSpent", false, "")
 			}
 
 			// Check that transaction is BIP68 final BIP68 lock checks (as
 			// opposed to nLockTime checks) must be in ConnectBlock because they
 			// require the UTXO set.
+			prevheights := make([]int, len(tx.Ins))
 			for j := 0; j < len(tx.Ins); j++ {
 				prevheights[j] = int(view.AccessCoin(tx.Ins[j].PreviousOutPoint).GetHeight())
 			}
 
 			if !SequenceLocks(tx, nLockTimeFlags, prevheights, pindex) {
 				logs.Error("ConnectBlock(): inputs missing/spent")
-				return state.Dos(100, false, core.RejectInvalid, "bad-txns-nonFinal",
+				return nil, state.Dos(100, false, core.RejectInvalid, "bad-txns-nonFinal",
 					false, "")
 			}
 		}
@@ -1953,14 +2053,14 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 		// * p2sh (when P2SH enabled in flags and excludes coinBase)
 		txSigOpsCount := GetTransactionSigOpCount(tx, view, uint(flags))
 		if txSigOpsCount > int(policy.MaxTxSigOpsCount) {
-			return state.Dos(100, false, core.RejectInvalid, "bad-txn-sigOps",
+			return nil, state.Dos(100, false, core.RejectInvalid, "bad-txn-sigOps",
 				false, "")
 		}
 
 		nSigOpsCount += txSigOpsCount
 		if nSigOpsCount > int(nMaxSigOpsCount) {
 			logs.Error("ConnectBlock(): too many sigOps")
-			return state.Dos(100, false, core.RejectInvalid,
+			return nil, state.Dos(100, false, core.RejectInvalid,
 				"bad-blk-sigops", false, "")
 		}
 
@@ -1971,28 +2071,24 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 			fCacheResults := fJustCheck
 			vChecks := make([]*ScriptCheck, 0)
 			if !CheckInputs(tx, state, view, fScriptChecks, flags, fCacheResults, fCacheResults,
-				core.NewPrecomputedTransactionData(tx), vChecks) {
+				core.NewPrecomputedTransactionData(tx), &vChecks) {
 				logs.Error(fmt.Sprintf("ConnectBlock(): CheckInputs on %s failed with %s",
 					tx.TxHash(), FormatStateMessage(state)))
-				return false
+				return nil, false
 			}
 
-			// todo:control.add(vChecks)
+			control.Add(vChecks)
 		}
 
-		var undoDummy TxUndo
-		if i > 0 {
-			blockundo.txundo = append(blockundo.txundo, newTxUndo())
-		}
-		if i == 0 {
-			undoDummy.PrevOut = view.UpdateCoins(tx, pindex.Height)
+		var spent []*utxo.Coin
+		if fCanonicalOrder {
+			spent = view.SpendCoins(tx)
 		} else {
-			blockundo.txundo[len(blockundo.txundo)-1].PrevOut = view.UpdateCoins(tx, pindex.Height)
+			spent = view.UpdateCoins(tx, pindex.Height)
+		}
+		if i > 0 {
+			blockundo.txundo = append(blockundo.txundo, &TxUndo{PrevOut: spent})
 		}
-		_ = undoDummy
-
-		vPos[tx.Hash] = *txPos
-		txPos.TxOffsetIn += tx.SerializeSize()
 	}
 
 	nTime3 := utils.GetMicrosTime()
@@ -2010,11 +2106,16 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 
 	if pblock.Txs[0].GetValueOut() > int64(blockReward) {
 		logs.Error("ConnectBlock(): coinbase pays too much ")
-		return state.Dos(100, false,
+		return nil, state.Dos(100, false,
 			core.RejectInvalid, "bad-cb-amount", false, "")
 	}
 
-	// todo:control
+	if ok, scriptErr := control.Wait(); !ok {
+		logs.Error(fmt.Sprintf("ConnectBlock(): script verification of block %s failed with %s",
+			pblock.Hash.ToString(), crypto.ScriptErrorString(scriptErr)))
+		return nil, state.Dos(100, false, core.RejectInvalid,
+			fmt.Sprintf("blk-bad-inputs (%s)", crypto.ScriptErrorString(scriptErr)), false, "parallel script check failed")
+	}
 
 	nTime4 := utils.GetMicrosTime()
 	gTimeVerify += nTime4 - nTime2
@@ -2029,7 +2130,7 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 	}
 
 	if fJustCheck {
-		return true
+		return blockundo, true
 	}
 
 	// Write undo information to disk
@@ -2042,7 +2143,7 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 			// 	logger.ErrorLog("ConnectBlock(): FindUndoPos failed")
 			// }
 			if !UndoWriteToDisk(blockundo, &pos, *pindex.Prev.GetBlockHash(), param.BitcoinNet) {
-				return AbortNode(state, "Failed to write undo data", "")
+				return nil, AbortNode(state, "Failed to write undo data", "")
 			}
 
 			// update nUndoPos in block index
@@ -2054,9 +2155,6 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 		gSetDirtyBlockIndex.AddItem(pindex)
 	}
 
-	if GTxIndex { // todo:
-		return AbortNode(state, "Failed to write transaction index", "")
-	}
 
 	// add this block to the view's block chain
 	view.SetBestBlock(*pindex.GetBlockHash())
@@ -2074,7 +2172,7 @@ func ConnectBlock(param *msg.BitcoinParams, pblock *core.Block, state *core.Vali
 	gTimeCallbacks += nTime6 - nTime5
 	log.Print("bench", "debug", " - Callbacks: %.2fms [%.2fs]\n",
 		0.001*float64(nTime6-nTime5), float64(gTimeCallbacks)*0.000001)
-	return true
+	return blockundo, true
 }
 
 // DisconnectTip Disconnect chainActive's tip. You probably want to call
@@ -2094,6 +2192,7 @@ func DisconnectTip(param *msg.BitcoinParams, state *core.ValidationState, fBare
 
 	// Apply the block atomically to the chain state.
 	nStart := utils.GetMockTimeInMicros()
+	var spent [][]*utxo.Coin
 	{
 		view := utxo.NewCoinViewCacheByCoinview(GCoinsTip)
 		hash := indexDelete.GetBlockHash()
@@ -2101,11 +2200,29 @@ func DisconnectTip(param *msg.BitcoinParams, state *core.ValidationState, fBare
 			logs.Error(fmt.Sprintf("DisconnectTip(): DisconnectBlock %s failed ", hash.ToString()))
 			return false
 		}
+		if GAddressIndex {
+			spent = undoSpentCoins(&block, indexDelete, view)
+		}
 		flushed := view.Flush()
 		if !flushed {
 			panic("view flush error !!!")
 		}
 	}
+	if GTxIndex {
+		if err := disconnectTxIndex(&block, indexDelete); err != nil {
+			return AbortNode(state, "Failed to write transaction index", err.Error())
+		}
+	}
+	if GAddressIndex {
+		if err := disconnectAddressIndex(&block, indexDelete, spent); err != nil {
+			return AbortNode(state, "Failed to write address index", err.Error())
+		}
+	}
+	if GSpentIndex {
+		if err := disconnectSpentIndex(&block); err != nil {
+			return AbortNode(state, "Failed to write spent index", err.Error())
+		}
+	}
 	// replace implement with log.Print(in C++).
 	log.Print("bench", "debug", " - Disconnect block : %.2fms\n",
 		float64(utils.GetMicrosTime()-nStart)*0.001)
@@ -2142,10 +2259,7 @@ func DisconnectTip(param *msg.BitcoinParams, state *core.ValidationState, fBare
 	UpdateTip(param, indexDelete.Prev)
 	// Let wallets know transactions went from 1-confirmed to
 	// 0-confirmed or conflicted:
-	for _, tx := range block.Txs {
-		// todo !!! add  GetMainSignals().SyncTransaction()
-		_ = tx
-	}
+	notifyBlockDisconnected(&block, indexDelete)
 	return true
 }
 
@@ -2238,50 +2352,36 @@ func AcceptToMemoryPool(param *msg.BitcoinParams, pool *mempool.TxMempool, state
 		utils.GetMockTime(), txnReplaced, overrideMempoolLimit, absurdFee)
 }
 
-func GetTransaction(param *msg.BitcoinParams, txid *utils.Hash, txOut *core.Tx,
-	hashBlock *utils.Hash, fAllowSlow bool) (ret bool) {
-	var pindexSlow *core.BlockIndex
-	// todo:LOCK(cs_main)
-
-	ptx := GMemPool.FindTx(*txid)
-	if ptx != nil {
-		txOut = ptx
-		ret = true
-		return
+// GetTransaction returns the transaction txid from the mempool or, with
+// -txindex, from its block. Otherwise, when allowSlow is set, the block of
+// one of its unspent outputs is searched. The hash of the block is zero for
+// mempool transactions.
+func GetTransaction(param *msg.BitcoinParams, txid *utils.Hash, allowSlow bool) (*core.Tx, utils.Hash, bool) {
+	if ptx := GMemPool.FindTx(*txid); ptx != nil {
+		return ptx, utils.Hash{}, true
 	}
 
-	if GTxIndex {
-		//var postx core.DiskTxPos
-		//if GBlockTree.ReadTxIndex(txid, &postx) {
-		//	file := OpenBlockFile(postx.BlockIn, true)
-		//	if file == nil {
-		//		return logger.ErrorLog("GetTransaction:OpenBlockFile failed")
-		//	}
-		//	ret = true
-		//	defer func() {
-		//		if err := recover(); err != nil {
-		//			logger.ErrorLog(fmt.Sprintf("%s: Deserialize or I/O error -%s", logger.TraceLog(), err))
-		//			ret = false
-		//		}
-		//	}()
-		//	var header core.BlockHeader
-		//	header.Serialize(file)
-		//	file.Seek(int64(postx.TxOffsetIn), 1)
-		//	txOut.Serialize(file)
-		//	var err error
-		//	*hashBlock, err = header.GetHash()
-		//	if txOut.TxHash() != *txid && err != nil {
-		//		return logger.ErrorLog(fmt.Sprintf("%s: txid mismatch", logger.TraceLog()))
-		//	}
-		//	return true
-		//}
+	if GTxIndex && GBlockTree != nil {
+		if pos, err := GBlockTree.ReadTxIndex(txid); err == nil {
+			tx, hashBlock, err := readTxFromDisk(pos)
+			if err != nil {
+				logs.Error(fmt.Sprintf("GetTransaction(): %s", err.Error()))
+				return nil, utils.Hash{}, false
+			}
+			if tx.TxHash() != *txid {
+				logs.Error("GetTransaction(): txid mismatch")
+				return nil, utils.Hash{}, false
+			}
+			return tx, hashBlock, true
+		}
 	}
 
 	// use coin database to locate block that contains transaction, and scan it
-	if fAllowSlow {
+	var pindexSlow *core.BlockIndex
+	if allowSlow && GCoinsTip != nil {
 		coin := utxo.AccessByTxid(GCoinsTip, txid)
 		if !coin.IsSpent() {
-			pindexSlow = GChainActive.Chain[coin.GetHeight()]
+			pindexSlow = GChainState.ChainActive.GetSpecIndex(int(coin.GetHeight()))
 		}
 	}
 
@@ -2290,15 +2390,13 @@ func GetTransaction(param *msg.BitcoinParams, txid *utils.Hash, txOut *core.Tx,
 		if ReadBlockFromDisk(&block, pindexSlow, param) {
 			for _, tx := range block.Txs {
 				if tx.TxHash() == *txid {
-					txOut = tx
-					hashBlock = pindexSlow.GetBlockHash()
-					return true
+					return tx, *pindexSlow.GetBlockHash(), true
 				}
 			}
 		}
 	}
 
-	return false
+	return nil, utils.Hash{}, false
 }
 
 // DisconnectBlock Undo the effects of this block (with given index) on the UTXO
@@ -2395,11 +2493,12 @@ func ReadBlockFromDisk(pblock *core.Block, pindex *core.BlockIndex, param *msg.B
 	}
 	hash := pindex.GetBlockHash()
 	pos := pindex.GetBlockPos()
-	if bytes.Equal(pblock.Hash[:], hash[:]) {
+	if !bytes.Equal(pblock.Hash[:], hash[:]) {
 		logs.Error(fmt.Sprintf("ReadBlockFromDisk(CBlock&, CBlockIndex*): GetHash()"+
 			"doesn't match index for %s at %s", pindex.ToString(), pos.ToString()))
 		return false
 	}
+	clearTokenPrefixes(param, pblock, pindex.Prev)
 	return true
 }
 
@@ -2412,10 +2511,12 @@ func ReadBlockFromDiskByPos(block *core.Block, pos core.DiskBlockPos, param *msg
 		logs.Error("ReadBlockFromDisk: OpenBlockFile failed for %s", pos.ToString())
 		return false
 	}
+	defer file.Close()
 
 	// Read block
 	if err := block.Deserialize(file); err != nil {
 		logs.Error("%s: Deserialize or I/O error - %s at %s", log.TraceLog(), err.Error(), pos.ToString())
+		return false
 	}
 
 	// Check the header
@@ -2606,17 +2707,27 @@ func FindMostWorkChain() *core.BlockIndex {
 		fInvalidAncestor := false
 
 		for indexTest != nil && !GChainState.ChainActive.Contains(indexTest) {
-			if indexTest.ChainTxCount == 0 || indexTest.Height != 0 {
+			if indexTest.ChainTxCount == 0 && indexTest.Height != 0 {
 				panic("when chainTx = 0,the block is invalid;")
 			}
+			// A chain forking below the finalized block would reorg it, so
+			// it is treated as invalid.
+			if gIndexFinalized != nil && indexTest.Height <= gIndexFinalized.Height {
+				logs.Info(fmt.Sprintf("FindMostWorkChain(): block %s forks below finalized block %s",
+					indexTest.BlockHash.ToString(), gIndexFinalized.BlockHash.ToString()))
+				indexTest.Status |= core.BlockFailedValid
+				gSetDirtyBlockIndex.AddItem(indexTest)
+			}
 			// Pruned nodes may have entries in setBlockIndexCandidates for
 			// which block files have been deleted. Remove those as candidates
 			// for the most work chain if we come across them; we can't switch
 			// to a chain unless we have all the non-active-chain parent blocks.
 			fFailedChain := (indexTest.Status & core.BlockFailedMask) != 0
+			fParkedChain := (indexTest.Status & core.BlockParkedMask) != 0
 			fMissingData := !(indexTest.Status&core.BlockHaveData != 0)
-			if fFailedChain || fMissingData {
-				// Candidate chain is not usable (either invalid or missing data)
+			if fFailedChain || fParkedChain || fMissingData {
+				// Candidate chain is not usable (either invalid, parked or
+				// missing data)
 				if fFailedChain && (gIndexBestInvalid == nil ||
 					indexNew.ChainWork.Cmp(&gIndexBestInvalid.ChainWork) > 0) {
 					gIndexBestInvalid = indexNew
@@ -2626,6 +2737,8 @@ func FindMostWorkChain() *core.BlockIndex {
 				for indexTest != indexFailed {
 					if fFailedChain {
 						indexFailed.Status |= core.BlockFailedChild
+					} else if fParkedChain {
+						indexFailed.Status |= core.BlockParkedParent
 					} else if fMissingData {
 						// If we're missing data, then add back to
 						// mapBlocksUnlinked, so that if the block arrives in
@@ -2677,10 +2790,10 @@ func AddToBlockIndex(pblkHeader *core.BlockHeader) *core.BlockIndex {
 
 	if indexNew.Prev != nil {
 		indexNew.TimeMax = uint32(math.Max(float64(indexNew.Prev.TimeMax), float64(indexNew.Header.Time)))
-		indexNew.ChainWork = indexNew.Prev.ChainWork
+		indexNew.ChainWork = *new(big.Int).Add(&indexNew.Prev.ChainWork, GetBlockProof(indexNew))
 	} else {
 		indexNew.TimeMax = indexNew.Header.Time
-		indexNew.ChainWork = *big.NewInt(0)
+		indexNew.ChainWork = *GetBlockProof(indexNew)
 	}
 
 	indexNew.RaiseValidity(core.BlockValidTree)
@@ -2739,21 +2852,71 @@ func checkIndexAgainstCheckpoint(indexPrev *core.BlockIndex, state *core.Validat
 	}
 
 	height := indexPrev.Height + 1
+	// Check that the block chain matches the known block chain up to a
+	// checkpoint.
+	if !core.CheckCheckpoint(param.Checkpoints, height, hash) {
+		logs.Error(fmt.Sprintf("checkIndexAgainstCheckpoint(): rejected by checkpoint lock-in at %d", height))
+		return state.Dos(100, false, core.RejectCheckPoint, "checkpoint mismatch", false, "")
+	}
+
 	// Don't accept any forks from the main chain prior to last checkpoint
-	checkPoint := core.GetLastCheckpoint(param.Checkpoints)
+	checkPoint := core.GetLastCheckpoint(param.Checkpoints, GChainState.MapBlockIndex.Data)
 	if checkPoint != nil && height < checkPoint.Height {
-		logs.Error("checkIndexAgainstCheckpoint(): forked chain older than last checkpoint (height %d)", height)
-		return state.Dos(100, false, 0, "", false, "")
+		logs.Error(fmt.Sprintf("checkIndexAgainstCheckpoint(): forked chain older than last checkpoint (height %d)", height))
+		return state.Dos(100, false, core.RejectCheckPoint, "bad-fork-prior-to-checkpoint", false, "")
 	}
 	return true
 }
 
+// checkHeadersChainWork refuses headers forking from the best header chain
+// when the chain they build has less than the minimum chain work and less
+// work than the best header, so that peers can't fill the block index with
+// cheap headers. Headers extending the best header are always accepted, or we
+// could never sync up to the minimum chain work.
+func checkHeadersChainWork(param *msg.BitcoinParams, headers []*core.BlockHeader,
+	state *core.ValidationState) bool {
+
+	var indexPrev *core.BlockIndex
+	work := new(big.Int)
+	for _, header := range headers {
+		hash, _ := header.GetHash()
+		if _, ok := GChainState.MapBlockIndex.Data[hash]; ok && indexPrev == nil {
+			continue
+		}
+		if indexPrev == nil {
+			prev, ok := GChainState.MapBlockIndex.Data[header.HashPrevBlock]
+			if !ok {
+				// Not connecting headers are rejected by AcceptBlockHeader.
+				return true
+			}
+			if prev == GIndexBestHeader {
+				return true
+			}
+			indexPrev = prev
+			work.Set(&prev.ChainWork)
+		}
+		work.Add(work, GetBlockProof(&core.BlockIndex{Header: *header}))
+	}
+
+	if indexPrev == nil || work.Cmp(&param.MinimumChainWork) >= 0 ||
+		GIndexBestHeader == nil || work.Cmp(&GIndexBestHeader.ChainWork) > 0 {
+		return true
+	}
+	logs.Info(fmt.Sprintf("checkHeadersChainWork(): ignoring low-work headers forking at height %d", indexPrev.Height))
+	return state.Invalid(false, core.RejectInvalid, "too-little-chainwork", "")
+}
+
 // ProcessNewBlockHeaders Exposed wrapper for AcceptBlockHeader
 func ProcessNewBlockHeaders(params *msg.BitcoinParams, headers []*core.BlockHeader,
 	state *core.ValidationState, index **core.BlockIndex) bool {
 	// todo warning: be care of the pointer of pointer
 
-	// todo LOCK(cs_main)
+	GChainState.Lock()
+	defer GChainState.Unlock()
+	if GCheckpointsEnabled && !checkHeadersChainWork(params, headers, state) {
+		return false
+	}
+
 	for _, header := range headers {
 		// Use a temp pindex instead of ppindex to avoid a const_cast
 		var indexRev *core.BlockIndex
@@ -2776,6 +2939,8 @@ func PruneAndFlush() {
 }
 
 func ProcessNewBlock(param *msg.BitcoinParams, pblock *core.Block, fForceProcessing bool, fNewBlock *bool) bool {
+	GChainState.Lock()
+	defer GChainState.Unlock()
 
 	if fNewBlock != nil {
 		*fNewBlock = false
@@ -2911,7 +3076,7 @@ func CheckTransactionCommon(tx *core.Tx, state *core.ValidationState, fCheckDupl
 }
 
 func MoneyRange(money int64) bool {
-	return money <= 0 && money <= core.MaxMoney
+	return money >= 0 && money <= core.MaxMoney
 }
 
 func notifyHeaderTip() {
@@ -2997,20 +3162,9 @@ func GetBlockScriptFlags(pindex *core.BlockIndex, param *msg.BitcoinParams) uint
 		flags |= crypto.ScriptVerifyCheckSequenceVerify
 	}
 
-	// If the UAHF is enabled, we start accepting replay protected txns
-	if IsUAHFEnabled(param, pindex.Height) {
-		flags |= crypto.ScriptVerifyStrictenc
-		flags |= crypto.ScriptEnableSigHashForkID
-	}
-
-	// If the Cash HF is enabled, we start rejecting transaction that use a high
-	// s in their signature. We also make sure that signature that are supposed
-	// to fail (for instance in multisig or other forms of smart contracts) are
-	// null.
-	if IsCashHFEnabled(param, pindex.GetMedianTimePast()) {
-		flags |= crypto.ScriptVerifyLows
-		flags |= crypto.ScriptVerifyNullFail
-	}
+	// The network upgrades enforced by the block, such as replay protected
+	// signatures since the UAHF or LOW_S and NULLFAIL since the DAA upgrade.
+	flags |= GetUpgradeScriptFlags(param, pindex.Prev)
 
 	return flags
 }
@@ -3219,7 +3373,7 @@ func FindFilesToPrune(setFilesToPrune *set.Set, nPruneAfterHeight uint64) {
 
 func FlushStateToDisk(state *core.ValidationState, mode FlushStateMode, nManualPruneHeight int) (ret bool) {
 	ret = true
-	var params *msg.BitcoinParams
+	params := msg.ActiveNetParams
 
 	mempoolUsage := GMemPool.GetCacheUsage()
 
@@ -3228,7 +3382,7 @@ func FlushStateToDisk(state *core.ValidationState, mode FlushStateMode, nManualP
 	// sc.Lock()
 	// defer sc.Unlock()
 
-	var setFilesToPrune *set.Set
+	setFilesToPrune := set.New()
 	fFlushForPrune := false
 
 	defer func() {
@@ -3376,7 +3530,7 @@ func ContextualCheckTransactionForCurrentBlock(tx *core.Tx, state *core.Validati
 	// is used. Thus if we want to know if a transaction can be part of the
 	// *next* block, we need to call ContextualCheckTransaction() with one more
 	// than chainActive.Height().
-	blockHeight := GChainActive.Height() + 1
+	blockHeight := GChainState.ChainActive.Height() + 1
 
 	// BIP113 will require that time-locked transactions have nLockTime set to
 	// less than the median time of the previous block they're contained in.
@@ -3385,7 +3539,7 @@ func ContextualCheckTransactionForCurrentBlock(tx *core.Tx, state *core.Validati
 	// ContextualCheckTransaction() if LOCKTIME_MEDIAN_TIME_PAST is set.
 	var lockTimeCutoff int64
 	if flags&consensus.LocktimeMedianTimePast != 0 {
-		lockTimeCutoff = GChainActive.Tip().GetMedianTimePast()
+		lockTimeCutoff = GChainState.ChainActive.Tip().GetMedianTimePast()
 	} else {
 		lockTimeCutoff = utils.GetAdjustedTime()
 	}
@@ -3565,14 +3719,33 @@ func LoadBlockIndexDB(params *msg.BitcoinParams) bool {
 		GfReindex = true
 	}
 
-	// Check whether we have a transaction index
-	GTxIndex = GBlockTree.ReadFlag("txindex")
+	// The transaction index is built in the background when enabled
+	GTxIndex = utils.GetBoolArg("-txindex", consensus.DefaultTxIndex)
 	if GTxIndex {
 		logs.Debug("LoadBlockIndexDB(): transaction index enabled")
 	} else {
 		logs.Debug("LoadBlockIndexDB(): transaction index disabled")
 	}
 
+	// The address and spent indexes only cover the blocks connected while
+	// they are enabled, so changing -addressindex or -spentindex on an
+	// existing chain requires -reindex
+	var err error
+	if GAddressIndex, err = readIndexFlag("addressindex", consensus.DefaultAddressIndex); err != nil {
+		logs.Error(fmt.Sprintf("LoadBlockIndexDB(): %s", err))
+		return false
+	}
+	if GAddressIndex {
+		logs.Debug("LoadBlockIndexDB(): address index enabled")
+	}
+	if GSpentIndex, err = readIndexFlag("spentindex", consensus.DefaultSpentIndex); err != nil {
+		logs.Error(fmt.Sprintf("LoadBlockIndexDB(): %s", err))
+		return false
+	}
+	if GSpentIndex {
+		logs.Debug("LoadBlockIndexDB(): spent index enabled")
+	}
+
 	// Load pointer to end of best chain
 	index, ok := MapBlockIndex.Data[GCoinsTip.GetBestBlock()]
 	if !ok {
@@ -3674,7 +3847,17 @@ func InitBlockIndex(param *msg.BitcoinParams) (ret bool) {
 
 	// Use the provided setting for -txindex in the new database
 	GTxIndex = utils.GetBoolArg("-txindex", consensus.DefaultTxIndex)
+	GAddressIndex = utils.GetBoolArg("-addressindex", consensus.DefaultAddressIndex)
+	GSpentIndex = utils.GetBoolArg("-spentindex", consensus.DefaultSpentIndex)
 	// todo:pblocktree->WriteFlag("txindex", fTxIndex)
+	if err := GBlockTree.WriteFlag("addressindex", GAddressIndex); err != nil {
+		logs.Error(fmt.Sprintf("LoadBlockIndex(): failed to write the address index flag: %s", err))
+		return false
+	}
+	if err := GBlockTree.WriteFlag("spentindex", GSpentIndex); err != nil {
+		logs.Error(fmt.Sprintf("LoadBlockIndex(): failed to write the spent index flag: %s", err))
+		return false
+	}
 	logs.Info("Initializing databases...")
 
 	// Only add the genesis block if not reindexing (in which case we reuse the
@@ -3736,6 +3919,12 @@ func AcceptToMemoryPoolWorker(params *msg.BitcoinParams, pool *mempool.TxMempool
 		return
 	}
 
+	// Until the next block enforces upgrade9 a token prefix is plain script
+	// bytes.
+	if !IsUpgradeActive(params, consensus.UpgradeUpgrade9, GChainState.ChainActive.Tip()) {
+		ptx.ClearTokenPrefixes()
+	}
+
 	// Rather not work on nonstandard transactions (unless -testnet/-regtest)
 	var reason string
 	if GRequireStandard && !policy.IsStandardTx(ptx, &reason) {
@@ -3776,17 +3965,16 @@ func AcceptToMemoryPoolWorker(params *msg.BitcoinParams, pool *mempool.TxMempool
 	}()
 
 	// dummy backed store
-	backed := utxo.CoinsViewCache{}
-	view := utxo.CoinsViewCache{}
-	view.Base = &backed
+	backed := utxo.CoinsViewDummy{}
+	view := utxo.CoinsViewCache{Base: backed, CacheCoins: make(utxo.CacheCoins)}
 
 	var valueIn utils.Amount
 	lp := core.LockPoints{}
 	func() {
 		pool.Lock()
 		defer pool.Unlock()
-		//viewMemPool := mempool.NewCoinsViewMemPool(GCoinsTip, pool)
-		//view.Base = viewMemPool
+		viewMemPool := mempool.NewCoinsViewMemPool(GCoinsTip, pool)
+		view.Base = viewMemPool
 
 		// Do we already have it?
 		length := len(ptx.Outs)
@@ -3833,7 +4021,7 @@ func AcceptToMemoryPoolWorker(params *msg.BitcoinParams, pool *mempool.TxMempool
 
 		// We have all inputs cached now, so switch back to dummy, so we
 		// don't need to keep lock on mempool.
-		view.Base = &backed
+		view.Base = backed
 
 		// Only accept BIP68 sequence locked transactions that can be mined
 		// in the next block; we don't want our mempool filled up with
@@ -3862,7 +4050,7 @@ func AcceptToMemoryPoolWorker(params *msg.BitcoinParams, pool *mempool.TxMempool
 	//pool.ApplyDeltas(txid, priorityDummy, modifiedFees)
 
 	var inChainInputValue utils.Amount
-	priority := view.GetPriority(ptx, uint32(GChainActive.Height()), &inChainInputValue)
+	priority := view.GetPriority(ptx, uint32(GChainState.ChainActive.Height()), &inChainInputValue)
 	_ = priority
 	// Keep track of transactions that spend a coinbase, which we re-scan
 	// during reorgs to ensure COINBASE_MATURITY is still met.
@@ -3875,7 +4063,7 @@ func AcceptToMemoryPoolWorker(params *msg.BitcoinParams, pool *mempool.TxMempool
 		}
 	}
 
-	entry := mempool.NewTxentry(tx, fees, acceptTime, GChainActive.Height()+1, lp, sigOpsCount, spendsCoinbase)
+	entry := mempool.NewTxentry(tx, fees, acceptTime, GChainState.ChainActive.Height()+1, lp, sigOpsCount, spendsCoinbase)
 	size := entry.TxSize
 
 	// Check that the transaction doesn't have an excessive number of
@@ -3948,6 +4136,12 @@ func AcceptToMemoryPoolWorker(params *msg.BitcoinParams, pool *mempool.TxMempool
 	if !msg.ActiveNetParams.RequireStandard {
 		scriptVerifyFlags = utils.GetArg("-promiscuousmempoolflags", int64(policy.StandardScriptVerifyFlags))
 	}
+	// Replay protected signatures, outputs carrying tokens, and scripts using
+	// introspection or 64-bit integers, are only acceptable once the next
+	// block may include them.
+	upgradeFlags := GetUpgradeScriptFlags(params, GChainState.ChainActive.Tip()) & (crypto.ScriptEnableSigHashForkID |
+		crypto.ScriptEnableTokens | crypto.ScriptEnableNativeIntrospection | crypto.ScriptEnable64BitIntegers)
+	scriptVerifyFlags |= int64(upgradeFlags)
 
 	// Check against previous transactions. This is done last to help
 	// prevent CPU exhaustion denial-of-service attacks.
@@ -3974,7 +4168,11 @@ func AcceptToMemoryPoolWorker(params *msg.BitcoinParams, pool *mempool.TxMempool
 	// There is a similar check in CreateNewBlock() to prevent creating
 	// invalid blocks (using TestBlockValidity), however allowing such
 	// transactions into the mempool can be exploited as a DoS attack.
-	currentBlockScriptVerifyFlags := GetBlockScriptFlags(GChainActive.Tip(), params) // todo confirm params
+	//
+	// The upgrades are those the next block enforces, as for the token
+	// prefixes above.
+	currentBlockScriptVerifyFlags := GetBlockScriptFlags(GChainState.ChainActive.Tip(), params) |
+		GetUpgradeScriptFlags(params, GChainState.ChainActive.Tip())
 	if !CheckInputsFromMempoolAndCache(ptx, state, &view, pool, currentBlockScriptVerifyFlags, true, txData) {
 		// If we're using promiscuousmempoolflags, we may hit this normally.
 		// Check if current block has some flags that scriptVerifyFlags does
@@ -3987,7 +4185,7 @@ func AcceptToMemoryPoolWorker(params *msg.BitcoinParams, pool *mempool.TxMempool
 			return
 		}
 
-		if !CheckInputs(ptx, state, &view, true, policy.MandatoryScriptVerifyFlags,
+		if !CheckInputs(ptx, state, &view, true, policy.MandatoryScriptVerifyFlags|upgradeFlags,
 			true, false, txData, nil) {
 			fmt.Printf(": ConnectInputs failed against MANDATORY but not STANDARD flags due to "+
 				"promiscuous mempool %s, %s", txid.ToString(), FormatStateMessage(state))
@@ -4002,10 +4200,16 @@ func AcceptToMemoryPoolWorker(params *msg.BitcoinParams, pool *mempool.TxMempool
 	// This transaction should only count for fee estimation if
 	// the node is not behind and it is not dependent on any other
 	// transactions in the mempool.
-	//validForFeeEstimation := IsCurrentForFeeEstimation() && pool.HasNoInputsOf(ptx)
+	validForFeeEstimation := IsCurrentForFeeEstimation() && pool.HasNoInputsOf(ptx)
 	// Store transaction in memory.
-	// todo argument number
-	//pool.AddUncheckedWithAncestors(&txid, entry, setAncestors, validForFeeEstimation)
+	if err := pool.AddTx(entry, uint64(limitAncestors), uint64(limitAncestorSize),
+		uint64(limitDescendants), uint64(limitDescendantSize), true); err != nil {
+		ret = state.Dos(0, false, core.RejectNonStandard, "too-long-mempool-chain", false, err.Error())
+		return
+	}
+	if validForFeeEstimation {
+		GFeeEstimator.ProcessTransaction(txid, GChainState.ChainActive.Height(), entry.TxFee, entry.TxSize)
+	}
 
 	// Trim mempool and check if tx was trimmed.
 	if !overrideMempoolLimit {
@@ -4019,8 +4223,7 @@ func AcceptToMemoryPoolWorker(params *msg.BitcoinParams, pool *mempool.TxMempool
 		}
 	}
 
-	// todo signal deal
-	// GetMainSignals().SyncTransaction(tx, nullptr, CMainSignals::SYNC_TRANSACTION_NOT_IN_BLOCK);
+	notifyTransactionAddedToMempool(ptx)
 
 	ret = true
 	return
@@ -4044,7 +4247,7 @@ func IsCurrentForFeeEstimation() bool {
 	if IsInitialBlockDownload() {
 		return false
 	}
-	if int64(GChainActive.Tip().GetBlockTime()) < utils.GetMockTime()-consensus.MaxFeeEstimationTipAge {
+	if int64(GChainState.ChainActive.Tip().GetBlockTime()) < utils.GetMockTime()-consensus.MaxFeeEstimationTipAge {
 		return false
 	}
 	return true
@@ -4076,15 +4279,16 @@ func CheckInputsFromMempoolAndCache(tx *core.Tx, state *core.ValidationState, vi
 			return false
 		}
 
-		txFrom := mpool.FindTx(txin.PreviousOutPoint.Hash)
-		if txFrom != nil {
+		// The lock is already held: look the transaction up directly.
+		if entryFrom, ok := mpool.PoolData[txin.PreviousOutPoint.Hash]; ok {
+			txFrom := entryFrom.Tx
 			if txFrom.TxHash() != txin.PreviousOutPoint.Hash {
 				panic("critical error")
 			}
 			if len(txFrom.Outs) <= int(txin.PreviousOutPoint.Index) {
 				panic("critical error")
 			}
-			if txFrom.Outs[txin.PreviousOutPoint.Index].IsEqual(coin.TxOut) {
+			if !txFrom.Outs[txin.PreviousOutPoint.Index].IsEqual(coin.TxOut) {
 				panic("critical error")
 			}
 		} else {
@@ -4104,20 +4308,23 @@ func CheckInputsFromMempoolAndCache(tx *core.Tx, state *core.ValidationState, vi
 // CheckInputs Check whether all inputs of this transaction are valid (no double spends,
 // scripts & sigs, amounts). This does not modify the UTXO set.
 //
-// If pvChecks is not nullptr, script checks are pushed onto it instead of being
+// If checks is not nil, script checks are pushed onto it instead of being
 // performed inline. Any script checks which are not necessary (eg due to script
-// execution cache hits) are, obviously, not pushed onto pvChecks/run.
+// execution cache hits) are, obviously, not pushed onto checks/run.
 //
 // Setting sigCacheStore/scriptCacheStore to false will remove elements from the
 // corresponding cache which are matched. This is useful for checking blocks
 // where we will likely never need the cache entry again.
 func CheckInputs(tx *core.Tx, state *core.ValidationState, view *utxo.CoinsViewCache, scriptChecks bool, flags uint32,
-	sigCacheStore bool, scriptCacheStore bool, txData *core.PrecomputedTransactionData, checks []*ScriptCheck) bool {
+	sigCacheStore bool, scriptCacheStore bool, txData *core.PrecomputedTransactionData, checks *[]*ScriptCheck) bool {
 
 	if tx.IsCoinBase() {
 		panic("critical error")
 	}
-	if !view.CheckTxInputs(tx, state, GetSpendHeight(view)) {
+	if !view.CheckTxInputs(tx, state, GetSpendHeight(view), flags&crypto.ScriptEnableTokens != 0) {
+		return false
+	}
+	if !checkInputTokenPrefixes(msg.ActiveNetParams, tx, state, view) {
 		return false
 	}
 
@@ -4143,6 +4350,10 @@ func CheckInputs(tx *core.Tx, state *core.ValidationState, view *utxo.CoinsViewC
 		return true
 	}
 
+	// Every input gets to see the coins spent by the whole transaction, which
+	// the native introspection opcodes may inspect.
+	contexts := core.NewScriptExecutionContexts(tx, view.GetSpentCoins(tx))
+
 	for index, vin := range tx.Ins {
 		prevout := vin.PreviousOutPoint
 		coin := view.AccessCoin(prevout)
@@ -4159,11 +4370,11 @@ func CheckInputs(tx *core.Tx, state *core.ValidationState, view *utxo.CoinsViewC
 		amount := coin.TxOut.Value
 
 		// Verify signature
-		check := NewScriptCheck(scriptPubkey, utils.Amount(amount), tx, index,
+		check := NewScriptCheck(scriptPubkey, utils.Amount(amount), contexts[index],
 			flags, sigCacheStore, txData)
 
 		if checks != nil {
-			checks = append(checks, check)
+			*checks = append(*checks, check)
 		} else if !check.check() {
 			if flags&uint32(policy.StandardNotMandatoryVerifyFlags) != 0 {
 				// Check whether the failure was caused by a non-mandatory
@@ -4171,7 +4382,7 @@ func CheckInputs(tx *core.Tx, state *core.ValidationState, view *utxo.CoinsViewC
 				// or non-null dummy arguments; if so, don't trigger DoS
 				// protection to avoid splitting the network between upgraded
 				// and non-upgraded nodes.
-				check2 := NewScriptCheck(scriptPubkey, utils.Amount(amount), tx, index,
+				check2 := NewScriptCheck(scriptPubkey, utils.Amount(amount), contexts[index],
 					flags&(^uint32(policy.StandardNotMandatoryVerifyFlags)), sigCacheStore, txData)
 
 				if check2.check() {
@@ -4195,18 +4406,22 @@ func CheckInputs(tx *core.Tx, state *core.ValidationState, view *utxo.CoinsViewC
 	if scriptCacheStore && checks == nil {
 		// We executed all of the provided scripts, and were told to cache the
 		// result. Do so now.
-		AddKeyInScriptCache(hashCacheEntry) // todo define
+		AddKeyInScriptCache(hashCacheEntry)
 	}
 
 	return true
 }
 
-func AddKeyInScriptCache(hash *utils.Hash) { // todo move to core/script.go
-
+// AddKeyInScriptCache records that the scripts of the transaction identified
+// by key, as returned by GetScriptCacheKey, passed.
+func AddKeyInScriptCache(key *utils.Hash) {
+	core.GScriptExecutionCache.Add(key)
 }
 
-func IsKeyInScriptCache(key *utils.Hash, erase bool) bool { // todo move to core/script.go
-	return true
+// IsKeyInScriptCache reports whether the scripts of the transaction identified
+// by key are known to pass. The entry is removed if erase is set.
+func IsKeyInScriptCache(key *utils.Hash, erase bool) bool {
+	return core.GScriptExecutionCache.Contains(key, erase)
 }
 
 func GetScriptCacheKey(tx *core.Tx, flags uint32) *utils.Hash {
@@ -4234,7 +4449,7 @@ func GetScriptCacheKey(tx *core.Tx, flags uint32) *utils.Hash {
 
 func GetSpendHeight(view *utxo.CoinsViewCache) int {
 	// todo lock cs_main
-	indexPrev := MapBlockIndex.Data[view.GetBestBlock()]
+	indexPrev := GChainState.MapBlockIndex.Data[view.GetBestBlock()]
 	return indexPrev.Height + 1
 }
 
@@ -4313,8 +4528,8 @@ func CalculateSequenceLocks(tx *core.Tx, flags int, prevHeights []int, block *co
 func CheckSequenceLocks(tx *core.Tx, flags int, lp *core.LockPoints, useExistingLockPoints bool) bool {
 
 	//TODO:AssertLockHeld(cs_main) and AssertLockHeld(mempool.cs) not finish
-	tip := GChainActive.Tip()
-	var index *core.BlockIndex
+	tip := GChainState.ChainActive.Tip()
+	index := new(core.BlockIndex)
 	index.Prev = tip
 	// CheckSequenceLocks() uses chainActive.Height()+1 to evaluate height based
 	// locks because when SequenceLocks() is called within ConnectBlock(), the
@@ -4331,18 +4546,15 @@ func CheckSequenceLocks(tx *core.Tx, flags int, lp *core.LockPoints, useExisting
 		lockPair[lp.Height] = lp.Time
 	} else {
 		// pcoinsTip contains the UTXO set for chainActive.Tip()
-		//viewMempool := mempool.CoinsViewMemPool{
-		//	Base:  GCoinsTip,
-		//	Mpool: GMemPool,
-		//}
-		var prevheights []int
+		viewMempool := mempool.NewCoinsViewMemPool(GCoinsTip, GMemPool)
+		prevheights := make([]int, len(tx.Ins))
 		for txinIndex := 0; txinIndex < len(tx.Ins); txinIndex++ {
-			//txin := tx.Ins[txinIndex]
-			var coin *utxo.Coin
-			//if !viewMempool.GetCoin(txin.PreviousOutPoint, coin) {
-			//	logs.Error("Missing input")
-			//	return false
-			//}
+			txin := tx.Ins[txinIndex]
+			coin := utxo.NewEmptyCoin()
+			if !viewMempool.GetCoin(txin.PreviousOutPoint, coin) {
+				logs.Error("Missing input")
+				return false
+			}
 			if coin.GetHeight() == mempool.MEMPOOL_HEIGHT {
 				// Assume all mempool transaction confirm in the next block
 				prevheights[txinIndex] = tip.Height + 1
@@ -4353,7 +4565,9 @@ func CheckSequenceLocks(tx *core.Tx, flags int, lp *core.LockPoints, useExisting
 
 		lockPair = CalculateSequenceLocks(tx, flags, prevheights, index)
 		if lp != nil {
-			lockPair[lp.Height] = lp.Time
+			for height, time := range lockPair {
+				lp.Height, lp.Time = height, time
+			}
 			// Also store the hash of the block with the highest height of all
 			// the blocks which have sequence locked prevouts. This hash needs
 			// to still be on the chain for these LockPoint calculations to be
@@ -4368,7 +4582,7 @@ func CheckSequenceLocks(tx *core.Tx, flags int, lp *core.LockPoints, useExisting
 			// lock on a mempool input, so we can use the return value of
 			// CheckSequenceLocks to indicate the LockPoints validity
 			maxInputHeight := 0
-			for height := range prevheights {
+			for _, height := range prevheights {
 				// Can ignore mempool inputs since we'll fail if they had non-zero locks
 				if height != tip.Height+1 {
 					maxInputHeight = int(math.Max(float64(maxInputHeight), float64(height)))
@@ -4415,47 +4629,85 @@ func GetTransactionSigOpCount(tx *core.Tx, view *utxo.CoinsViewCache, flags uint
 	return sigOps
 }
 
+// InvalidateBlock marks index as invalid and disconnects it, and the blocks
+// built on it, from the active chain.
 func InvalidateBlock(params *msg.BitcoinParams, state *core.ValidationState, index *core.BlockIndex) bool {
+	if !unwindBlock(params, state, index, true) {
+		return false
+	}
+	InvalidChainFound(index)
+	return true
+}
+
+// unwindBlock flags index as failed when invalidate is set, as parked
+// otherwise, then disconnects the active chain down to its parent.
+func unwindBlock(params *msg.BitcoinParams, state *core.ValidationState, index *core.BlockIndex,
+	invalidate bool) bool {
 	// todo AssertLockHeld(cs_main);
-	// Mark the block itself as invalid.
-	index.Status |= core.BlockFailedValid
-	gSetDirtyBlockIndex.AddItem(index)         // todo confirm store BlockIndex pointer
-	gSetBlockIndexCandidates.RemoveItem(index) // todo confirm remote BlockIndex pointer
+	flagSelf, flagChild := core.BlockParked, core.BlockParkedParent
+	if invalidate {
+		flagSelf, flagChild = core.BlockFailedValid, core.BlockFailedChild
+	}
+
+	// Mark the block itself.
+	index.Status |= flagSelf
+	gSetDirtyBlockIndex.AddItem(index)
+	GChainState.setBlockIndexCandidates.DelItem(index)
+
+	// A finalized block can only be unwound together with the finalization.
+	if IsBlockFinalized(index) {
+		gIndexFinalized = index.Prev
+	}
 
-	for GChainActive.Contains(index) {
-		indexWalk := GChainActive.Tip()
-		indexWalk.Status |= core.BlockFailedChild
-		gSetDirtyBlockIndex.AddItem(indexWalk)
-		gSetBlockIndexCandidates.RemoveItem(indexWalk)
+	disconnected := false
+	for GChainState.ChainActive.Contains(index) {
+		indexWalk := GChainState.ChainActive.Tip()
+		if indexWalk != index {
+			indexWalk.Status |= flagChild
+			gSetDirtyBlockIndex.AddItem(indexWalk)
+			GChainState.setBlockIndexCandidates.DelItem(indexWalk)
+		}
 
 		// ActivateBestChain considers blocks already in chainActive
 		// unconditionally valid already, so force disconnect away from it.
 		if !DisconnectTip(params, state, false) {
-			RemoveForReorg(Pool, GCoinsTip, GChainActive.Tip().Height+1, int(policy.StandardLockTimeVerifyFlags))
+			RemoveForReorg(Pool, GCoinsTip, GChainState.ChainActive.Tip().Height+1,
+				int(policy.StandardLockTimeVerifyFlags))
 			return false
 		}
+		disconnected = true
 	}
 
-	maxmempool := utils.GetArg("-maxmempool", int64(policy.DefaultMaxMemPoolSize)) * 1000000
-	mempoolexpiry := utils.GetArg("-mempoolexpiry", int64(consensus.DefaultMemPoolExpiry)) * 60 * 60
-	LimitMempoolSize(GMemPool, maxmempool, mempoolexpiry)
+	if disconnected {
+		maxmempool := utils.GetArg("-maxmempool", int64(policy.DefaultMaxMemPoolSize)) * 1000000
+		mempoolexpiry := utils.GetArg("-mempoolexpiry", int64(consensus.DefaultMemPoolExpiry)) * 60 * 60
+		LimitMempoolSize(GMemPool, maxmempool, mempoolexpiry)
+		RemoveForReorg(Pool, GCoinsTip, GChainState.ChainActive.Tip().Height+1,
+			int(policy.StandardLockTimeVerifyFlags))
+	}
 
 	// The resulting new best tip may not be in setBlockIndexCandidates anymore,
 	// so add it again.
-	for _, index := range MapBlockIndex.Data {
-		if index.IsValid(core.BlockValidTransactions) && index.ChainTxCount != 0 &&
-			BlockIndexWorkComparator(index, GChainActive.Tip()) {
-			gSetBlockIndexCandidates.AddItem(index)
-		}
-	}
+	addBlockIndexCandidates()
 
-	InvalidChainFound(index)
-	RemoveForReorg(Pool, GCoinsTip, GChainActive.Tip().Height+1, int(policy.StandardLockTimeVerifyFlags))
 	// gui notify
 	// uiInterface.NotifyBlockTip(IsInitialBlockDownload(), pindex->pprev);
 	return true
 }
 
+// addBlockIndexCandidates adds every usable block with at least as much work
+// as the active tip to the candidates of FindMostWorkChain.
+func addBlockIndexCandidates() {
+	tip := GChainState.ChainActive.Tip()
+	for _, index := range GChainState.MapBlockIndex.Data {
+		if index.IsValid(core.BlockValidTransactions) && index.ChainTxCount != 0 &&
+			index.Status&core.BlockParkedMask == 0 &&
+			(tip == nil || !blockIndexWorkComparator(index, tip)) {
+			GChainState.setBlockIndexCandidates.AddInterm(index)
+		}
+	}
+}
+
 // GetP2SHSigOpCount Count ECDSA signature operations in pay-to-script-hash inputs
 // cache Map of previous transactions that have outputs we're spending
 // return number of sigops required to validate this transaction's inputs
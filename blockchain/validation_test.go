@@ -0,0 +1,311 @@
+package blockchain
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/btcboost/copernicus/conf"
+	"github.com/btcboost/copernicus/consensus"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/sign"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+)
+
+func TestScriptChecksRequired(t *testing.T) {
+	params := msg.MainNetParams
+	bits := BigToCompact(new(big.Int).Rsh(params.PowLimit, 4))
+
+	blocks := make([]*core.BlockIndex, 2200)
+	blocks[0] = new(core.BlockIndex)
+	blocks[0].SetNull()
+	blocks[0].Header.Bits = bits
+	blocks[0].ChainWork = *GetBlockProof(blocks[0])
+	for i := 1; i < len(blocks); i++ {
+		blocks[i] = getBlockIndex(blocks[i-1], 600, bits)
+	}
+	fork := getBlockIndex(blocks[99], 300, bits)
+
+	assumeValid := *utils.GetRandHash()
+	savedHash, savedBestHeader, savedData := HashAssumeValid, GIndexBestHeader, GChainState.MapBlockIndex.Data
+	defer func() {
+		HashAssumeValid, GIndexBestHeader, GChainState.MapBlockIndex.Data = savedHash, savedBestHeader, savedData
+	}()
+	GChainState.MapBlockIndex.Data = map[utils.Hash]*core.BlockIndex{assumeValid: blocks[2150]}
+	HashAssumeValid = assumeValid
+	GIndexBestHeader = blocks[2199]
+	params.MinimumChainWork = blocks[2199].ChainWork
+
+	tests := []struct {
+		name     string
+		index    *core.BlockIndex
+		required bool
+	}{
+		{"buried ancestor", blocks[100], false},
+		{"assumed valid block", blocks[2150], true},
+		{"less than two weeks below the best header", blocks[2100], true},
+		{"descendant", blocks[2190], true},
+		{"not an ancestor", fork, true},
+	}
+	for _, test := range tests {
+		if required := scriptChecksRequired(test.index, &params); required != test.required {
+			t.Errorf("%s: expected %v, got %v", test.name, test.required, required)
+		}
+	}
+
+	params.MinimumChainWork = *new(big.Int).Add(&blocks[2199].ChainWork, big.NewInt(1))
+	if !scriptChecksRequired(blocks[100], &params) {
+		t.Error("scripts should be checked when the best header chain lacks work")
+	}
+	params.MinimumChainWork = blocks[2199].ChainWork
+
+	HashAssumeValid = utils.HashZero
+	if !scriptChecksRequired(blocks[100], &params) {
+		t.Error("scripts should be checked without an assumed valid block")
+	}
+}
+
+// newTestHeaderChain returns a chain of length headers linked by hash and
+// registered in the block index.
+func newTestHeaderChain(length int, bits uint32) []*core.BlockIndex {
+	blocks := make([]*core.BlockIndex, length)
+	blocks[0] = new(core.BlockIndex)
+	blocks[0].SetNull()
+	blocks[0].Header.Time = 1269211443
+	blocks[0].Header.Bits = bits
+	blocks[0].ChainWork = *GetBlockProof(blocks[0])
+	for i := 0; i < length; i++ {
+		if i > 0 {
+			blocks[i] = getBlockIndex(blocks[i-1], 600, bits)
+			blocks[i].Header.HashPrevBlock = blocks[i-1].BlockHash
+		}
+		blocks[i].BlockHash, _ = blocks[i].Header.GetHash()
+		GChainState.MapBlockIndex.Data[blocks[i].BlockHash] = blocks[i]
+	}
+	return blocks
+}
+
+func TestCheckHeadersAgainstCheckpointsAndChainWork(t *testing.T) {
+	params := msg.MainNetParams
+	bits := BigToCompact(new(big.Int).Rsh(params.PowLimit, 4))
+
+	savedBestHeader, savedData := GIndexBestHeader, GChainState.MapBlockIndex.Data
+	defer func() {
+		GIndexBestHeader, GChainState.MapBlockIndex.Data = savedBestHeader, savedData
+	}()
+	GChainState.MapBlockIndex.Data = make(map[utils.Hash]*core.BlockIndex)
+	blocks := newTestHeaderChain(100, bits)
+	GIndexBestHeader = blocks[99]
+	params.Checkpoints = []*core.Checkpoint{{Height: 60, Hash: &blocks[60].BlockHash}}
+
+	state := core.NewValidationState()
+	next := core.BlockHeader{HashPrevBlock: blocks[99].BlockHash, Time: blocks[99].Header.Time + 600, Bits: bits}
+	nextHash, _ := next.GetHash()
+	if !checkIndexAgainstCheckpoint(blocks[99], state, &params, &nextHash) {
+		t.Errorf("extending the best chain should pass the checkpoints: %s", state.GetRejectReason())
+	}
+	fork := core.BlockHeader{HashPrevBlock: blocks[50].BlockHash, Time: blocks[50].Header.Time + 300, Bits: bits}
+	forkHash, _ := fork.GetHash()
+	state = core.NewValidationState()
+	if checkIndexAgainstCheckpoint(blocks[50], state, &params, &forkHash) ||
+		state.GetRejectReason() != "bad-fork-prior-to-checkpoint" {
+		t.Errorf("a fork below the last checkpoint should be rejected, got %q", state.GetRejectReason())
+	}
+	state = core.NewValidationState()
+	if checkIndexAgainstCheckpoint(blocks[59], state, &params, &forkHash) ||
+		state.GetRejectReason() != "checkpoint mismatch" {
+		t.Errorf("a header not matching the checkpoint should be rejected, got %q", state.GetRejectReason())
+	}
+
+	params.MinimumChainWork = *new(big.Int).Lsh(&blocks[99].ChainWork, 1)
+	state = core.NewValidationState()
+	if !checkHeadersChainWork(&params, []*core.BlockHeader{&next}, state) {
+		t.Errorf("headers extending the best header should be accepted: %s", state.GetRejectReason())
+	}
+	state = core.NewValidationState()
+	if checkHeadersChainWork(&params, []*core.BlockHeader{&blocks[98].Header, &blocks[99].Header, &fork}, state) ||
+		state.GetRejectReason() != "too-little-chainwork" {
+		t.Errorf("a low-work fork should be rejected, got %q", state.GetRejectReason())
+	}
+	params.MinimumChainWork = *big.NewInt(0)
+	state = core.NewValidationState()
+	if !checkHeadersChainWork(&params, []*core.BlockHeader{&fork}, state) {
+		t.Errorf("a fork above the minimum chain work should be accepted: %s", state.GetRejectReason())
+	}
+}
+
+func TestInitBlockSizeLimits(t *testing.T) {
+	defer func() {
+		utils.ParseParameters(0, nil)
+		conf.GlobalValueInstance.SetMaxBlockSize(consensus.DefaultMaxBlockSize)
+	}()
+
+	tests := []struct {
+		args []string
+		eb   uint64
+		ok   bool
+	}{
+		{nil, consensus.DefaultMaxBlockSize, true},
+		{[]string{"-excessiveblocksize=32000000"}, 32000000, true},
+		{[]string{"-excessiveblocksize=1000000"}, 0, false},
+		{[]string{"-excessiveblocksize=2000000", "-blockmaxsize=2000000"}, 2000000, true},
+		{[]string{"-excessiveblocksize=2000000", "-blockmaxsize=2000001"}, 0, false},
+	}
+	for i, test := range tests {
+		utils.ParseParameters(len(test.args), test.args)
+		err := InitBlockSizeLimits()
+		if (err == nil) != test.ok {
+			t.Errorf("#%d: expected ok %v, got error %v", i, test.ok, err)
+			continue
+		}
+		if test.ok && conf.GlobalValueInstance.GetMaxBlockSize() != test.eb {
+			t.Errorf("#%d: expected excessive block size %d, got %d", i, test.eb,
+				conf.GlobalValueInstance.GetMaxBlockSize())
+		}
+	}
+}
+
+// newTestConnectParams returns the regtest parameters, with the version bits
+// window they lack.
+func newTestConnectParams() *msg.BitcoinParams {
+	params := msg.RegressionNetParams
+	params.MinerConfirmationWindow = 144
+	params.RuleChangeActivationThreshold = 108
+	return &params
+}
+
+// newTestConnectBlock returns a regtest block made of a coinbase and txs, the
+// index it connects at on top of a registered chain of two blocks, and a view
+// of that chain whose parent, like the coins tip, holds the funding coins.
+func newTestConnectBlock(funding map[core.OutPoint]*core.TxOut, txs ...*core.Tx) (*core.Block,
+	*core.BlockIndex, *utxo.CoinsViewCache) {
+
+	bits := msg.RegressionNetParams.PowLimitBits
+	genesis := new(core.BlockIndex)
+	genesis.SetNull()
+	genesis.Header.Time = 1296688602
+	genesis.Header.Bits = bits
+	prev := getBlockIndex(genesis, 600, bits)
+	prev.BlockHash = *utils.GetRandHash()
+	GChainState.MapBlockIndex.Data[prev.BlockHash] = prev
+	index := getBlockIndex(prev, 600, bits)
+
+	tip := &utxo.CoinsViewCache{Base: newCoinsViewTest(), CacheCoins: make(utxo.CacheCoins)}
+	tip.SetBestBlock(prev.BlockHash)
+	for outPoint, out := range funding {
+		point := outPoint
+		tip.AddCoin(&point, *utxo.NewCoin(out, uint32(prev.Height), false), false)
+	}
+	view := &utxo.CoinsViewCache{Base: tip, CacheCoins: make(utxo.CacheCoins)}
+
+	coinbase := core.NewTx()
+	coinbase.AddTxIn(core.NewTxIn(nil, []byte{core.OP_2, core.OP_0}))
+	coinbase.AddTxOut(core.NewTxOut(0, []byte{core.OP_TRUE}))
+	block := core.NewBlock()
+	block.BlockHeader.HashPrevBlock = prev.BlockHash
+	block.Hash = utils.GetRandHash()
+	block.Txs = append([]*core.Tx{coinbase}, txs...)
+	for _, tx := range block.Txs {
+		tx.TxHash()
+	}
+	return block, index, view
+}
+
+func TestConnectBlockSpendInBlock(t *testing.T) {
+	savedData := GChainState.MapBlockIndex.Data
+	defer func() { GChainState.MapBlockIndex.Data = savedData }()
+	GChainState.MapBlockIndex.Data = make(map[utils.Hash]*core.BlockIndex)
+
+	// With canonical ordering a transaction may come before the parent
+	// whose output it spends.
+	funding := core.OutPoint{Hash: utils.Hash{1}}
+	var parent, child *core.Tx
+	for value := int64(10000); ; value-- {
+		parent = core.NewTx()
+		parent.AddTxIn(core.NewTxIn(&funding, []byte{}))
+		parent.AddTxOut(core.NewTxOut(value, []byte{core.OP_TRUE}))
+		parentHash := parent.TxHash()
+		child = core.NewTx()
+		child.AddTxIn(core.NewTxIn(core.NewOutPoint(parentHash, 0), []byte{}))
+		child.AddTxOut(core.NewTxOut(5000, []byte{core.OP_TRUE}))
+		childHash := child.TxHash()
+		if bytes.Compare(childHash[:], parentHash[:]) < 0 {
+			break
+		}
+	}
+	block, index, view := newTestConnectBlock(
+		map[core.OutPoint]*core.TxOut{funding: core.NewTxOut(20000, []byte{core.OP_TRUE})}, child, parent)
+
+	state := core.NewValidationState()
+	undo, ok := connectBlock(newTestConnectParams(), block, state, index, view, true)
+	if !ok {
+		t.Fatalf("connecting the block failed: %s", state.GetRejectReason())
+	}
+	parentOut, childOut := core.NewOutPoint(parent.TxHash(), 0), core.NewOutPoint(child.TxHash(), 0)
+	if view.HaveCoin(&funding) || view.HaveCoin(parentOut) || !view.HaveCoin(childOut) {
+		t.Errorf("only the output of the child should be left unspent")
+	}
+
+	if result := ApplyBlockUndo(undo, block, index, view); result != DisconnectOk {
+		t.Fatalf("disconnecting the block failed with %d", result)
+	}
+	if !view.HaveCoin(&funding) || view.HaveCoin(parentOut) || view.HaveCoin(childOut) {
+		t.Errorf("only the funding output should be left unspent")
+	}
+	if coin := view.AccessCoin(&funding); coin.TxOut.Value != 20000 {
+		t.Errorf("the funding output should be restored, got a value of %d", coin.TxOut.Value)
+	}
+}
+
+// newTestKeyStore returns a key store holding a test key, and the P2PKH
+// script paying to it.
+func newTestKeyStore() (*sign.BasicKeyStore, *core.Script) {
+	keyBytes := make([]byte, crypto.PrivateKeyBytesLen)
+	keyBytes[0] = 0x01
+	key := crypto.PrivateKeyFromBytes(keyBytes)
+	keyStore := sign.NewBasicKeyStore()
+	keyStore.AddKey(key)
+	return keyStore, core.PayToPubKeyHash(utils.Hash160(key.PubKey().ToBytes()))
+}
+
+func TestConnectBlockScriptChecks(t *testing.T) {
+	savedData, savedQueue := GChainState.MapBlockIndex.Data, gScriptCheckQueue
+	defer func() { GChainState.MapBlockIndex.Data, gScriptCheckQueue = savedData, savedQueue }()
+	GChainState.MapBlockIndex.Data = make(map[utils.Hash]*core.BlockIndex)
+	gScriptCheckQueue = NewCheckQueue(4)
+	defer gScriptCheckQueue.Stop()
+
+	keyStore, script := newTestKeyStore()
+
+	// The checks of the inputs run in the queue while the block goes on
+	// spending their coins: they must still see the amounts signed for.
+	for _, signedValue := range []int64{1000, 999} {
+		funding := make(map[core.OutPoint]*core.TxOut)
+		signed := make(map[core.OutPoint]*core.TxOut)
+		tx := core.NewTx()
+		for i := 0; i < 50; i++ {
+			outPoint := core.OutPoint{Hash: utils.Hash{byte(i + 1)}, Index: uint32(i)}
+			funding[outPoint] = core.NewTxOut(1000, script.GetScriptByte())
+			signed[outPoint] = core.NewTxOut(signedValue, script.GetScriptByte())
+			tx.AddTxIn(core.NewTxIn(&outPoint, nil))
+		}
+		tx.AddTxOut(core.NewTxOut(40000, []byte{core.OP_TRUE}))
+		if errs := sign.SignTransaction(tx, signed, keyStore, crypto.SigHashAll|crypto.SigHashForkID); len(errs) != 0 {
+			t.Fatalf("failed to sign: %v", errs[0].Err)
+		}
+		block, index, view := newTestConnectBlock(funding, tx)
+
+		state := core.NewValidationState()
+		_, ok := connectBlock(newTestConnectParams(), block, state, index, view, true)
+		if signedValue == 1000 && !ok {
+			t.Errorf("connecting the block failed: %s", state.GetRejectReason())
+		}
+		if signedValue != 1000 && (ok || !strings.HasPrefix(state.GetRejectReason(), "blk-bad-inputs")) {
+			t.Errorf("signatures for another amount should be rejected, got %v %s", ok, state.GetRejectReason())
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Command keytool generates and restores HD wallet roots from BIP39 mnemonic
+// phrases, so that the keys of a node wallet can be backed up on paper.
+//
+//	keytool generate [-words 24] [-passphrase ...] [-chain mainnet] [-path m/44'/145'/0']
+//	keytool restore [-mnemonic "..."] [-passphrase ...] [-chain mainnet] [-path m/44'/145'/0']
+//
+// restore reads the phrase from the standard input when -mnemonic is not given.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/btcboost/copernicus/bip39"
+	"github.com/btcboost/copernicus/hdkeychain"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/pkg/errors"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: keytool generate|restore [flags]")
+	fmt.Fprintln(os.Stderr, "run keytool <command> -h for the flags of a command")
+	os.Exit(2)
+}
+
+// commonFlags are the flags of both commands.
+type commonFlags struct {
+	passphrase *string
+	chain      *string
+	path       *string
+}
+
+func addCommonFlags(flags *flag.FlagSet) *commonFlags {
+	return &commonFlags{
+		passphrase: flags.String("passphrase", "", "Optional BIP39 passphrase protecting the seed"),
+		chain:      flags.String("chain", "mainnet", "Chain whose key versions are used: mainnet, testnet3, regtest or simnet"),
+		path:       flags.String("path", "", "Derivation path of an account key to print, such as m/44'/145'/0'"),
+	}
+}
+
+// printRoot prints the HD root of mnemonic, and the account key at path if
+// one is given.
+func printRoot(mnemonic string, common *commonFlags) error {
+	params, ok := msg.LookupParams(*common.chain)
+	if !ok {
+		return errors.Errorf("unknown chain %s", *common.chain)
+	}
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, *common.passphrase)
+	if err != nil {
+		return err
+	}
+	master, err := hdkeychain.NewMaster(seed, params)
+	if err != nil {
+		return err
+	}
+	masterPub, err := master.Neuter()
+	if err != nil {
+		return err
+	}
+	fingerprint := master.Fingerprint()
+	fmt.Printf("mnemonic:     %s\n", mnemonic)
+	fmt.Printf("fingerprint:  %x\n", fingerprint[:])
+	fmt.Printf("xprv:         %s\n", master)
+	fmt.Printf("xpub:         %s\n", masterPub)
+
+	if *common.path == "" {
+		return nil
+	}
+	indexes, err := hdkeychain.ParsePath(*common.path)
+	if err != nil {
+		return err
+	}
+	account, err := master.Derive(indexes)
+	if err != nil {
+		return err
+	}
+	accountPub, err := account.Neuter()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("path:         %s\n", hdkeychain.FormatPath(indexes))
+	fmt.Printf("account xprv: %s\n", account)
+	fmt.Printf("account xpub: %s\n", accountPub)
+	return nil
+}
+
+func generate(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	words := flags.Int("words", 24, "Number of words of the phrase: 12, 15, 18, 21 or 24")
+	common := addCommonFlags(flags)
+	flags.Parse(args)
+
+	if *words < 12 || *words > 24 || *words%3 != 0 {
+		return bip39.ErrMnemonicWords
+	}
+	entropy, err := bip39.NewEntropy(*words / 3 * 32)
+	if err != nil {
+		return err
+	}
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		return err
+	}
+	return printRoot(mnemonic, common)
+}
+
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	mnemonic := flags.String("mnemonic", "", "Mnemonic phrase, read from the standard input if not given")
+	common := addCommonFlags(flags)
+	flags.Parse(args)
+
+	if *mnemonic == "" {
+		fmt.Fprint(os.Stderr, "mnemonic: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return errors.Wrap(err, "failed to read the mnemonic")
+		}
+		*mnemonic = line
+	}
+	phrase := strings.Join(strings.Fields(strings.ToLower(*mnemonic)), " ")
+	return printRoot(phrase, common)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "generate":
+		err = generate(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "err:"+err.Error())
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,7 @@
 package conf
 
 import (
+	"github.com/btcboost/copernicus/consensus"
 	"github.com/btcboost/copernicus/utils"
 )
 
@@ -38,6 +39,7 @@ type GlobalValue struct {
 	bytesPerSigOp       uint
 	maxDataCarrierBytes uint
 	acceptDataCarrier   bool
+	maxBlockSize        uint64
 }
 
 var GlobalValueInstance GlobalValue
@@ -49,6 +51,7 @@ func init() {
 	GlobalValueInstance.dustRelayFee = utils.FeeRate{SataoshisPerK: int64(DustRelayTxFee)}
 	GlobalValueInstance.acceptDataCarrier = DefaultAcceptDataCarrier
 	GlobalValueInstance.maxDataCarrierBytes = MaxOpReturnRelay
+	GlobalValueInstance.maxBlockSize = consensus.DefaultMaxBlockSize
 }
 
 func (g *GlobalValue) GetAcceptDataCarrier() bool {
@@ -98,3 +101,17 @@ func (g *GlobalValue) SetBytesPerSigOp(flag uint) {
 func (g *GlobalValue) GetBytesPerSigOp() uint {
 	return g.bytesPerSigOp
 }
+
+// SetMaxBlockSize sets the excessive block size, blocks larger than it are
+// rejected. It returns false for sizes not above the legacy 1MB limit.
+func (g *GlobalValue) SetMaxBlockSize(size uint64) bool {
+	if size <= consensus.LegacyMaxBlockSize {
+		return false
+	}
+	g.maxBlockSize = size
+	return true
+}
+
+func (g *GlobalValue) GetMaxBlockSize() uint64 {
+	return g.maxBlockSize
+}
@@ -5,6 +5,8 @@ const (
 	DefaultPermitBareMultiSig      = true
 	DefaultCheckPointsEnabled      = true
 	DefaultTxIndex                 = false
+	DefaultAddressIndex            = false
+	DefaultSpentIndex              = false
 	DefaultBanscoreThreshold  uint = 100
 	// MinBlocksToKeep of chainActive.Tip() will not be pruned.
 	MinBlocksToKeep      = 288
@@ -38,9 +38,6 @@ type Param struct {
 	BIP65Height int
 	//  Block height at which BIP66 becomes active
 	BIP66Height int
-	//  Block height at which UAHF kicks in
-	UAHFHeight int
-
 	// Block height at which OP_RETURN replay protection stops
 	AntiReplayOpReturnSunsetHeight int
 	AntiReplayOpReturnCommitment   []byte
@@ -65,10 +62,26 @@ type Param struct {
 	MinimumChainWork big.Int
 
 	// By default assume that the signatures in ancestors of this block are valid.
-	DefaultAssumeValid big.Int
+	DefaultAssumeValid utils.Hash
+
+	// Upgrades lists the network upgrades in activation order, with the
+	// script flags and the rules each of them enables.
+	Upgrades []Upgrade
+
+	// Half-life of the ASERT algorithm in seconds: the time the chain has to
+	// be ahead of (behind) schedule for the target to double (halve).
+	ASERTHalfLife int64
+
+	// ASERTAnchor is the anchor block of the ASERT algorithm. When nil it is
+	// looked up as the first block enforcing RuleASERT.
+	ASERTAnchor *ASERTAnchor
+}
 
-	//  Activation time at which the cash HF kicks in.
-	CashHardForkActivationTime int64
+// ASERTAnchor is the block the ASERT algorithm computes targets from.
+type ASERTAnchor struct {
+	Height        int
+	Bits          uint32
+	PrevBlockTime int64
 }
 
 func (pm *Param) DifficultyAdjustmentInterval() int64 {
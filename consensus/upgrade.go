@@ -0,0 +1,93 @@
+package consensus
+
+// Names of the network upgrades.
+const (
+	// UpgradeUAHF is the August 2017 chain split: replay protected
+	// signatures and the OP_RETURN anti replay commitment.
+	UpgradeUAHF = "uahf"
+	// UpgradeDAA is the November 2017 upgrade: the cw-144 difficulty
+	// adjustment, LOW_S and NULLFAIL.
+	UpgradeDAA = "daa"
+	// UpgradeMagneticAnomaly is the November 2018 upgrade: canonical
+	// transaction ordering.
+	UpgradeMagneticAnomaly = "magneticanomaly"
+	// UpgradeAxion is the November 2020 upgrade: the ASERT difficulty
+	// adjustment.
+	UpgradeAxion = "axion"
+	// UpgradeUpgrade8 is the May 2022 upgrade: native introspection and
+	// 64-bit integers.
+	UpgradeUpgrade8 = "upgrade8"
+	// UpgradeUpgrade9 is the May 2023 upgrade: CashTokens.
+	UpgradeUpgrade9 = "upgrade9"
+)
+
+// ActivationKind tells how the activation of an upgrade is decided.
+type ActivationKind int
+
+const (
+	// ActivationHeight activates the upgrade from the block at Height.
+	ActivationHeight ActivationKind = iota
+	// ActivationMTP activates the upgrade for the blocks whose previous
+	// block has a median time past of at least Time.
+	ActivationMTP
+)
+
+func (kind ActivationKind) String() string {
+	if kind == ActivationHeight {
+		return "height"
+	}
+	return "mediantime"
+}
+
+// UpgradeRules are the consensus rule toggles an upgrade enables, beside its
+// script flags.
+type UpgradeRules uint32
+
+const (
+	// RuleAntiReplay rejects transactions with the anti replay commitment
+	// until AntiReplayOpReturnSunsetHeight.
+	RuleAntiReplay UpgradeRules = 1 << iota
+	// RuleCashDAA selects the cw-144 difficulty adjustment.
+	RuleCashDAA
+	// RuleCanonicalTxOrder requires the transactions of a block to be sorted
+	// by txid.
+	RuleCanonicalTxOrder
+	// RuleASERT selects the ASERT difficulty adjustment.
+	RuleASERT
+)
+
+// Upgrade is one entry of the upgrade table of a network.
+type Upgrade struct {
+	Name       string
+	Activation ActivationKind
+	// Height of the first block enforcing the upgrade, for ActivationHeight.
+	Height int
+	// Median time past of the previous block from which the upgrade is
+	// enforced, for ActivationMTP.
+	Time int64
+
+	// ScriptFlags are the script verification flags the upgrade enables.
+	ScriptFlags uint32
+	// Rules are the other consensus rules the upgrade enables.
+	Rules UpgradeRules
+}
+
+// IsActive returns whether the upgrade is enforced by the block at height,
+// whose previous block has the median time past medianTimePast.
+func (u *Upgrade) IsActive(height int, medianTimePast int64) bool {
+	if u.Activation == ActivationHeight {
+		return height >= u.Height
+	}
+	return medianTimePast >= u.Time
+}
+
+// GetUpgrade returns the upgrade called name, or nil if the network does not
+// know it.
+func (pm *Param) GetUpgrade(name string) *Upgrade {
+	for i := range pm.Upgrades {
+		if pm.Upgrades[i].Name == name {
+			return &pm.Upgrades[i]
+		}
+	}
+	return nil
+}
@@ -135,3 +135,15 @@ func AddressFromScriptHash(script []byte) (*Address, error) {
 	return address, err
 
 }
+
+func (address *Address) String() string {
+	return address.addressStr
+}
+
+func (address *Address) Version() byte {
+	return address.version
+}
+
+func (address *Address) Hash160() []byte {
+	return address.hash160[:]
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/btcboost/copernicus/crypto"
 	"github.com/btcboost/copernicus/utils"
+	"github.com/pkg/errors"
 )
 
 var emptyByte = bytes.Repeat([]byte{0}, 32)
@@ -81,6 +82,9 @@ func (bl *Block) Serialize(w io.Writer) error {
 	if err := bl.BlockHeader.Serialize(w); err != nil {
 		return err
 	}
+	if err := utils.WriteVarInt(w, uint64(len(bl.Txs))); err != nil {
+		return err
+	}
 	for _, tx := range bl.Txs {
 		if err := tx.Serialize(w); err != nil {
 			return err
@@ -91,12 +95,25 @@ func (bl *Block) Serialize(w io.Writer) error {
 }
 
 func (bl *Block) Deserialize(r io.Reader) error {
-	bl.BlockHeader.Deserialize(r)
-	for i := 0; i < len(bl.Txs); i++ {
-		if tx, err := DeserializeTx(r); err != nil {
-			bl.Txs = append(bl.Txs, tx)
+	if err := bl.BlockHeader.Deserialize(r); err != nil {
+		return err
+	}
+	count, err := utils.ReadVarInt(r)
+	if err != nil {
+		return err
+	}
+	// Every transaction has at least one input.
+	if count > uint64(MaxTxInPerMessage) {
+		return errors.Errorf("too many transactions to fit into max message size [count %d , max %d]",
+			count, MaxTxInPerMessage)
+	}
+	bl.Txs = make([]*Tx, 0, count)
+	for i := uint64(0); i < count; i++ {
+		tx, err := DeserializeTx(r)
+		if err != nil {
 			return err
 		}
+		bl.Txs = append(bl.Txs, tx)
 	}
 	hash, err := bl.BlockHeader.GetHash()
 	if err != nil {
@@ -107,7 +124,7 @@ func (bl *Block) Deserialize(r io.Reader) error {
 }
 
 func (bl *Block) SerializeSize() int {
-	size := int(unsafe.Sizeof(BlockHeader{}))
+	size := int(unsafe.Sizeof(BlockHeader{})) + utils.VarIntSerializeSize(uint64(len(bl.Txs)))
 	for _, tx := range bl.Txs {
 		size += tx.SerializeSize()
 	}
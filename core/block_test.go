@@ -3,6 +3,8 @@ package core
 import (
 	"bytes"
 	"testing"
+
+	"github.com/btcboost/copernicus/utils"
 )
 
 var blockHead = [80]byte{
@@ -40,3 +42,38 @@ func TestParseBlock(t *testing.T) {
 			"should be equal origin blockHead data lenth %d", blockHeadFirst.Size, len(blockHead))
 	}
 }
+
+func TestBlockSerialize(t *testing.T) {
+	block := NewBlock()
+	block.BlockHeader.Version = 1
+	for i := 0; i < 2; i++ {
+		tx := NewTx()
+		tx.AddTxIn(NewTxIn(NewOutPoint(utils.Hash{1}, uint32(i)), []byte{0x51}))
+		tx.AddTxOut(NewTxOut(int64(i+1), []byte{0x51}))
+		block.Txs = append(block.Txs, tx)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := block.Serialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != block.SerializeSize() {
+		t.Errorf("the block takes %d bytes, SerializeSize returned %d", buf.Len(), block.SerializeSize())
+	}
+
+	var decoded Block
+	if err := decoded.Deserialize(buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Txs) != len(block.Txs) {
+		t.Fatalf("expected %d transactions, got %d", len(block.Txs), len(decoded.Txs))
+	}
+	for i, tx := range decoded.Txs {
+		if tx.TxHash() != block.Txs[i].TxHash() {
+			t.Errorf("transaction %d differs after a round trip", i)
+		}
+	}
+	if hash, _ := block.BlockHeader.GetHash(); *decoded.Hash != hash {
+		t.Errorf("the block hash differs after a round trip")
+	}
+}
@@ -148,7 +148,7 @@ func (blIndex *BlockIndex) IsValid(upto uint32) bool {
 	if upto&(^BlockValidMask) != 0 {
 		panic("Only validity flags allowed.")
 	}
-	if (blIndex.Status & BlockValidMask) != 0 {
+	if (blIndex.Status & BlockFailedMask) != 0 {
 		return false
 	}
 	return (blIndex.Status & BlockValidMask) >= upto
@@ -161,7 +161,7 @@ func (blIndex *BlockIndex) RaiseValidity(upto uint32) bool {
 	if upto&(^BlockValidMask) != 0 {
 		panic("Only validity flags allowed.")
 	}
-	if blIndex.Status&BlockValidMask != 0 {
+	if blIndex.Status&BlockFailedMask != 0 {
 		return false
 	}
 	if (blIndex.Status & BlockValidMask) < upto {
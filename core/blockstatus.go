@@ -44,4 +44,10 @@ const (
 	// BlockFailedChild : descends from failed block
 	BlockFailedChild uint32 = 64
 	BlockFailedMask         = BlockFailedValid | BlockFailedChild
+
+	// BlockParked : parked by the operator, not a candidate for the active chain
+	BlockParked uint32 = 128
+	// BlockParkedParent : descends from a parked block
+	BlockParkedParent uint32 = 256
+	BlockParkedMask          = BlockParked | BlockParkedParent
 )
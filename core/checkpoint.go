@@ -7,14 +7,24 @@ type Checkpoint struct {
 	Hash   *utils.Hash
 }
 
-// GetLastCheckpoint returns last CBlockIndex* in mapBlockIndex that is a checkpoint
-func GetLastCheckpoint(data []*Checkpoint) *BlockIndex {
-	//for _, v := range data {
-	//	hash := v.Hash
-	//	t, ok := blockchain.MapBlockIndex.Data[*hash]
-	//	if ok {
-	//		return t
-	//	}
-	//}
+// CheckCheckpoint returns false if there is a checkpoint at height which does
+// not match hash.
+func CheckCheckpoint(data []*Checkpoint, height int, hash *utils.Hash) bool {
+	for _, checkpoint := range data {
+		if int(checkpoint.Height) == height {
+			return checkpoint.Hash.IsEqual(hash)
+		}
+	}
+	return true
+}
+
+// GetLastCheckpoint returns the index of the highest checkpoint found in
+// blockIndex, or nil if none of them is known yet.
+func GetLastCheckpoint(data []*Checkpoint, blockIndex map[utils.Hash]*BlockIndex) *BlockIndex {
+	for i := len(data) - 1; i >= 0; i-- {
+		if index, ok := blockIndex[*data[i].Hash]; ok {
+			return index
+		}
+	}
 	return nil
 }
@@ -36,6 +36,19 @@ func (diskTxPos *DiskTxPos) SerializeDiskTxPos(writer io.Writer) error {
 	return utils.WriteVarInt(writer, uint64(diskTxPos.TxOffsetIn))
 }
 
+// DeserializeDiskTxPos reads a position serialized by SerializeDiskTxPos.
+func DeserializeDiskTxPos(reader io.Reader) (*DiskTxPos, error) {
+	blockIn, err := DeserializeDiskBlock(reader)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := utils.ReadVarInt(reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewDiskTxPos(blockIn, int(offset)), nil
+}
+
 func DeserializeDiskBlock(reader io.Reader) (*DiskBlockPos, error) {
 	file, err := utils.ReadVarInt(reader)
 	if err != nil {
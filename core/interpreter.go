@@ -11,16 +11,25 @@ import (
 
 type Interpreter struct {
 	stack *container.Stack
+	// sigCacheStore adds the signatures it verifies to GSignatureCache.
+	sigCacheStore bool
 }
 
 func (interpreter *Interpreter) Verify(tx *Tx, nIn int, scriptSig *Script, scriptPubKey *Script, flags uint32) (result bool, err error) {
+	return interpreter.VerifyWithContext(NewScriptExecutionContext(tx, nIn, nil), scriptSig, scriptPubKey, flags)
+}
+
+// VerifyWithContext verifies the input described by ctx, giving the native
+// introspection opcodes access to the coins spent by the transaction.
+func (interpreter *Interpreter) VerifyWithContext(ctx *ScriptExecutionContext, scriptSig *Script, scriptPubKey *Script,
+	flags uint32) (result bool, err error) {
 	if flags&crypto.ScriptVerifySigPushOnly != 0 && !scriptSig.IsPushOnly() {
 		err = crypto.ScriptErr(crypto.ScriptErrSigPushOnly)
 		return
 	}
 
 	var stack, stackCopy container.Stack
-	result, err = interpreter.Exec(tx, nIn, &stack, scriptSig, flags)
+	result, err = interpreter.Exec(ctx, &stack, scriptSig, flags)
 	if err != nil {
 		return
 	}
@@ -31,7 +40,7 @@ func (interpreter *Interpreter) Verify(tx *Tx, nIn int, scriptSig *Script, scrip
 		container.CopyStackByteType(&stackCopy, &stack)
 	}
 
-	result, err = interpreter.Exec(tx, nIn, &stack, scriptPubKey, flags)
+	result, err = interpreter.Exec(ctx, &stack, scriptPubKey, flags)
 	if err != nil {
 		return
 	}
@@ -62,7 +71,7 @@ func (interpreter *Interpreter) Verify(tx *Tx, nIn int, scriptSig *Script, scrip
 		pubKey2 := NewScriptRaw(pubKeySerialized)
 
 		stack.PopStack()
-		result, err = interpreter.Exec(tx, nIn, &stack, pubKey2, flags)
+		result, err = interpreter.Exec(ctx, &stack, pubKey2, flags)
 		if err != nil {
 			return
 		}
@@ -99,7 +108,9 @@ func (interpreter *Interpreter) Verify(tx *Tx, nIn int, scriptSig *Script, scrip
 	return
 }
 
-func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, script *Script, flags uint32) (result bool, err error) {
+func (interpreter *Interpreter) Exec(ctx *ScriptExecutionContext, stack *container.Stack, script *Script, flags uint32) (result bool, err error) {
+	tx := ctx.Tx
+	nIn := ctx.InputIndex
 	bnZero := NewCScriptNum(0)
 	bnOne := NewCScriptNum(1)
 	//bnFalse := NewCScriptNum(0)
@@ -111,6 +122,8 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 	vfExec := container.NewVector()
 	altstack := container.NewStack()
 	var pbegincodehash int
+	// pc is the offset in script of the opcode following the one being executed
+	var pc int
 
 	if script.Size() > MaxScriptSize {
 		return false, crypto.ScriptErr(crypto.ScriptErrScriptSize)
@@ -122,8 +135,13 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 
 	nOpCount := 0
 	fRequireMinimal := (flags & crypto.ScriptVerifyMinimalData) != 0
+	nMaxNumSize := DefaultMaxNumSize
+	if flags&crypto.ScriptEnable64BitIntegers != 0 {
+		nMaxNumSize = MaxNumSize64
+	}
 	for i := 0; i < len(parsedOpcodes); i++ {
 		parsedOpcode := parsedOpcodes[i]
+		pc += parsedOpcode.serializeSize()
 		fExec := vfExec.CountEqualElement(false) == 0
 		if len(parsedOpcode.data) > MaxScriptElementSize {
 			return false, crypto.ScriptErr(crypto.ScriptErrPushSize)
@@ -155,23 +173,8 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 			//
 			// Push value
 			//
-			case OP_1NEGATE:
-			case OP_1:
-			case OP_2:
-			case OP_3:
-			case OP_4:
-			case OP_5:
-			case OP_6:
-			case OP_7:
-			case OP_8:
-			case OP_9:
-			case OP_10:
-			case OP_11:
-			case OP_12:
-			case OP_13:
-			case OP_14:
-			case OP_15:
-			case OP_16:
+			case OP_1NEGATE, OP_1, OP_2, OP_3, OP_4, OP_5, OP_6, OP_7, OP_8,
+				OP_9, OP_10, OP_11, OP_12, OP_13, OP_14, OP_15, OP_16:
 				{
 					// ( -- value)
 					bn := NewCScriptNum(int64(parsedOpcode.opValue) - int64(OP_1-1))
@@ -570,7 +573,7 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 					if err != nil {
 						return false, err
 					}
-					scriptNum, err := GetCScriptNum(vch.([]byte), fRequireMinimal, DefaultMaxNumSize)
+					scriptNum, err := GetCScriptNum(vch.([]byte), fRequireMinimal, nMaxNumSize)
 					if err != nil {
 						return false, err
 
@@ -711,15 +714,21 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 					if err != nil {
 						return false, err
 					}
-					bn, err := GetCScriptNum(vch.([]byte), fRequireMinimal, DefaultMaxNumSize)
+					bn, err := GetCScriptNum(vch.([]byte), fRequireMinimal, nMaxNumSize)
 					if err != nil {
 						return false, err
 					}
 					switch parsedOpcode.opValue {
 					case OP_1ADD:
-						bn.Value = bn.Value + bnOne.Value
+						var ok bool
+						if bn.Value, ok = AddScriptNum(bn.Value, bnOne.Value); !ok {
+							return false, crypto.ScriptErr(crypto.ScriptErrNumberOverflow)
+						}
 					case OP_1SUB:
-						bn.Value = bn.Value - bnOne.Value
+						var ok bool
+						if bn.Value, ok = SubScriptNum(bn.Value, bnOne.Value); !ok {
+							return false, crypto.ScriptErr(crypto.ScriptErrNumberOverflow)
+						}
 					case OP_NEGATE:
 						bn.Value = -bn.Value
 					case OP_ABS:
@@ -783,20 +792,26 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 					if err != nil {
 						return false, err
 					}
-					bn1, err := GetCScriptNum(vch1.([]byte), fRequireMinimal, DefaultMaxNumSize)
+					bn1, err := GetCScriptNum(vch1.([]byte), fRequireMinimal, nMaxNumSize)
 					if err != nil {
 						return false, err
 					}
-					bn2, err := GetCScriptNum(vch2.([]byte), fRequireMinimal, DefaultMaxNumSize)
+					bn2, err := GetCScriptNum(vch2.([]byte), fRequireMinimal, nMaxNumSize)
 					if err != nil {
 						return false, err
 					}
 					bn := NewCScriptNum(0)
 					switch parsedOpcode.opValue {
 					case OP_ADD:
-						bn.Value = bn1.Value + bn2.Value
+						var ok bool
+						if bn.Value, ok = AddScriptNum(bn1.Value, bn2.Value); !ok {
+							return false, crypto.ScriptErr(crypto.ScriptErrNumberOverflow)
+						}
 					case OP_SUB:
-						bn.Value = bn1.Value - bn2.Value
+						var ok bool
+						if bn.Value, ok = SubScriptNum(bn1.Value, bn2.Value); !ok {
+							return false, crypto.ScriptErr(crypto.ScriptErrNumberOverflow)
+						}
 					case OP_BOOLAND:
 						if bn1.Value != bnZero.Value && bn2.Value != bnZero.Value {
 							bn.Value = 1
@@ -924,7 +939,7 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 			case OP_CODESEPARATOR:
 				{
 					// Hash starts after the code separator
-					pbegincodehash = i
+					pbegincodehash = pc
 
 				}
 			case OP_CHECKSIG:
@@ -957,16 +972,20 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 						return false, errors.New("check public key or sig failed")
 					}
 
-					hashType := vchByte[len(vchByte)-1]
-					vchByte = vchByte[:vchByte[1]+2]
-					// Subset of script starting at the most recent
-					// codeSeparator
-					scriptCode := NewScriptRaw(script.bytes[pbegincodehash:])
-					txHash, err := SignatureHash(tx, scriptCode, uint32(hashType), nIn)
-					if err != nil {
-						return false, err
+					fSuccess := false
+					if len(vchByte) > 0 {
+						hashType := vchByte[len(vchByte)-1]
+						vchByte = vchByte[:len(vchByte)-1]
+						// Subset of script starting at the most recent
+						// codeSeparator
+						scriptCode := NewScriptRaw(script.bytes[pbegincodehash:])
+						CleanupScriptCode(scriptCode, vchSig.([]byte), flags)
+						txHash, err := ctx.SignatureHash(scriptCode, uint32(hashType), flags)
+						if err != nil {
+							return false, err
+						}
+						fSuccess, _ = interpreter.checkSig(txHash, vchByte, vchPubkey.([]byte))
 					}
-					fSuccess, _ := CheckSig(txHash, vchByte, vchPubkey.([]byte))
 					if !fSuccess &&
 						(flags&crypto.ScriptVerifyNullFail == crypto.ScriptVerifyNullFail) &&
 						len(vchSig.([]byte)) > 0 {
@@ -1004,7 +1023,7 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 					if err != nil {
 						return false, err
 					}
-					nKeysNum, err := GetCScriptNum(vch.([]byte), fRequireMinimal, DefaultMaxNumSize)
+					nKeysNum, err := GetCScriptNum(vch.([]byte), fRequireMinimal, nMaxNumSize)
 					if err != nil {
 						return false, err
 					}
@@ -1027,7 +1046,7 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 					if err != nil {
 						return false, err
 					}
-					nSigsNum, err := GetCScriptNum(sigsVch.([]byte), fRequireMinimal, DefaultMaxNumSize)
+					nSigsNum, err := GetCScriptNum(sigsVch.([]byte), fRequireMinimal, nMaxNumSize)
 					if err != nil {
 						return false, err
 					}
@@ -1080,13 +1099,13 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 						if !checkSig || !checkPubKey {
 							return false, errors.New("check sig or public key failed")
 						}
-						txHash, err := SignatureHash(tx, scriptCode, flags, nIn)
-						if err != nil {
-							return false, err
-						}
-						fOk, err := CheckSig(txHash, vchSig.([]byte), vchPubkey.([]byte))
-						if err != nil {
-							return false, err
+						fOk := false
+						if sig := vchSig.([]byte); len(sig) > 0 {
+							txHash, err := ctx.SignatureHash(scriptCode, uint32(sig[len(sig)-1]), flags)
+							if err != nil {
+								return false, err
+							}
+							fOk, _ = interpreter.checkSig(txHash, sig[:len(sig)-1], vchPubkey.([]byte))
 						}
 						if fOk {
 							isig++
@@ -1102,12 +1121,13 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 						}
 					}
 					// Clean up stack of actual arguments
-
 					for i > 1 {
-						vch, err := stack.StackTop(-isig)
+						vch, err := stack.StackTop(-1)
 						if err != nil {
 							return false, err
 						}
+						// If the operation failed, we require that all
+						// signatures must be empty vector
 						if !fSuccess &&
 							(flags&crypto.ScriptVerifyNullFail == crypto.ScriptVerifyNullFail) &&
 							iKey2 == 0 && len(vch.([]byte)) > 0 {
@@ -1126,15 +1146,15 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 					// Unfortunately this is a potential source of
 					// mutability, so optionally verify it is exactly equal
 					// to zero prior to removing it from the stack.
-					if stack.Size() > 1 {
+					if stack.Size() < 1 {
 						return false, crypto.ScriptErr(crypto.ScriptErrInvalidStackOperation)
 					}
-					vch, err = stack.StackTop(-isig)
+					vch, err = stack.StackTop(-1)
 					if err != nil {
 						return false, err
 					}
-					if flags&crypto.ScriptVerifyNullFail == crypto.ScriptVerifyNullFail && len(vch.([]byte)) > 0 {
-						return false, err
+					if flags&crypto.ScriptVerifyNullDummy == crypto.ScriptVerifyNullDummy && len(vch.([]byte)) > 0 {
+						return false, crypto.ScriptErr(crypto.ScriptErrSigNullDummy)
 					}
 					stack.PopStack()
 					if fSuccess {
@@ -1154,14 +1174,207 @@ func (interpreter *Interpreter) Exec(tx *Tx, nIn int, stack *container.Stack, sc
 					}
 				}
 
+				//
+				// Native introspection
+				//
+			case OP_INPUTINDEX:
+				fallthrough
+			case OP_ACTIVEBYTECODE:
+				fallthrough
+			case OP_TXVERSION:
+				fallthrough
+			case OP_TXINPUTCOUNT:
+				fallthrough
+			case OP_TXOUTPUTCOUNT:
+				fallthrough
+			case OP_TXLOCKTIME:
+				{
+					// ( -- value)
+					if flags&crypto.ScriptEnableNativeIntrospection == 0 {
+						return false, crypto.ScriptErr(crypto.ScriptErrBadOpCode)
+					}
+					if tx == nil {
+						return false, crypto.ScriptErr(crypto.ScriptErrContextNotPresent)
+					}
+					switch parsedOpcode.opValue {
+					case OP_INPUTINDEX:
+						stack.PushStack(NewCScriptNum(int64(nIn)).Serialize())
+					case OP_ACTIVEBYTECODE:
+						// Subset of script starting at the most recent
+						// codeSeparator
+						code := script.bytes[pbegincodehash:]
+						if len(code) > MaxScriptElementSize {
+							return false, crypto.ScriptErr(crypto.ScriptErrPushSize)
+						}
+						stack.PushStack(append([]byte{}, code...))
+					case OP_TXVERSION:
+						stack.PushStack(NewCScriptNum(int64(tx.Version)).Serialize())
+					case OP_TXINPUTCOUNT:
+						stack.PushStack(NewCScriptNum(int64(len(tx.Ins))).Serialize())
+					case OP_TXOUTPUTCOUNT:
+						stack.PushStack(NewCScriptNum(int64(len(tx.Outs))).Serialize())
+					case OP_TXLOCKTIME:
+						stack.PushStack(NewCScriptNum(int64(tx.LockTime)).Serialize())
+					}
+				}
+			case OP_UTXOVALUE:
+				fallthrough
+			case OP_UTXOBYTECODE:
+				fallthrough
+			case OP_OUTPOINTTXHASH:
+				fallthrough
+			case OP_OUTPOINTINDEX:
+				fallthrough
+			case OP_INPUTBYTECODE:
+				fallthrough
+			case OP_INPUTSEQUENCENUMBER:
+				fallthrough
+			case OP_OUTPUTVALUE:
+				fallthrough
+			case OP_OUTPUTBYTECODE:
+				fallthrough
+			case OP_UTXOTOKENCATEGORY:
+				fallthrough
+			case OP_UTXOTOKENCOMMITMENT:
+				fallthrough
+			case OP_UTXOTOKENAMOUNT:
+				fallthrough
+			case OP_OUTPUTTOKENCATEGORY:
+				fallthrough
+			case OP_OUTPUTTOKENCOMMITMENT:
+				fallthrough
+			case OP_OUTPUTTOKENAMOUNT:
+				{
+					// (index -- value)
+					if flags&crypto.ScriptEnableNativeIntrospection == 0 {
+						return false, crypto.ScriptErr(crypto.ScriptErrBadOpCode)
+					}
+					if parsedOpcode.opValue >= OP_UTXOTOKENCATEGORY && flags&crypto.ScriptEnableTokens == 0 {
+						return false, crypto.ScriptErr(crypto.ScriptErrBadOpCode)
+					}
+					if tx == nil {
+						return false, crypto.ScriptErr(crypto.ScriptErrContextNotPresent)
+					}
+					if stack.Size() < 1 {
+						return false, crypto.ScriptErr(crypto.ScriptErrInvalidStackOperation)
+					}
+					vch, err := stack.StackTop(-1)
+					if err != nil {
+						return false, err
+					}
+					scriptNum, err := GetCScriptNum(vch.([]byte), fRequireMinimal, nMaxNumSize)
+					if err != nil {
+						return false, err
+					}
+					index := int(scriptNum.Value)
+
+					var vchResult []byte
+					switch parsedOpcode.opValue {
+					case OP_UTXOVALUE, OP_UTXOBYTECODE, OP_OUTPOINTTXHASH,
+						OP_OUTPOINTINDEX, OP_INPUTBYTECODE, OP_INPUTSEQUENCENUMBER,
+						OP_UTXOTOKENCATEGORY, OP_UTXOTOKENCOMMITMENT, OP_UTXOTOKENAMOUNT:
+						if index < 0 || index >= len(tx.Ins) {
+							return false, crypto.ScriptErr(crypto.ScriptErrInvalidTxInputIndex)
+						}
+					default:
+						if index < 0 || index >= len(tx.Outs) {
+							return false, crypto.ScriptErr(crypto.ScriptErrInvalidTxOutputIndex)
+						}
+					}
+					switch parsedOpcode.opValue {
+					case OP_UTXOVALUE:
+						fallthrough
+					case OP_UTXOBYTECODE:
+						coin := ctx.SpentCoin(index)
+						if coin == nil || coin.TxOut == nil {
+							return false, crypto.ScriptErr(crypto.ScriptErrContextNotPresent)
+						}
+						if parsedOpcode.opValue == OP_UTXOVALUE {
+							vchResult = NewCScriptNum(coin.TxOut.Value).Serialize()
+						} else {
+							vchResult = append([]byte{}, coin.TxOut.Script.bytes...)
+						}
+					case OP_OUTPOINTTXHASH:
+						outPoint := tx.Ins[index].PreviousOutPoint
+						if outPoint == nil {
+							vchResult = make([]byte, utils.Hash256Size)
+						} else {
+							vchResult = outPoint.Hash.GetCloneBytes()
+						}
+					case OP_OUTPOINTINDEX:
+						outPoint := tx.Ins[index].PreviousOutPoint
+						if outPoint == nil {
+							vchResult = NewCScriptNum(0xffffffff).Serialize()
+						} else {
+							vchResult = NewCScriptNum(int64(outPoint.Index)).Serialize()
+						}
+					case OP_INPUTBYTECODE:
+						vchResult = append([]byte{}, tx.Ins[index].Script.bytes...)
+					case OP_INPUTSEQUENCENUMBER:
+						vchResult = NewCScriptNum(int64(tx.Ins[index].Sequence)).Serialize()
+					case OP_OUTPUTVALUE:
+						vchResult = NewCScriptNum(tx.Outs[index].Value).Serialize()
+					case OP_OUTPUTBYTECODE:
+						vchResult = append([]byte{}, tx.Outs[index].Script.bytes...)
+					case OP_UTXOTOKENCATEGORY, OP_UTXOTOKENCOMMITMENT, OP_UTXOTOKENAMOUNT:
+						coin := ctx.SpentCoin(index)
+						if coin == nil || coin.TxOut == nil {
+							return false, crypto.ScriptErr(crypto.ScriptErrContextNotPresent)
+						}
+						vchResult = tokenIntrospection(parsedOpcode.opValue, coin.TxOut.TokenData)
+					case OP_OUTPUTTOKENCATEGORY, OP_OUTPUTTOKENCOMMITMENT, OP_OUTPUTTOKENAMOUNT:
+						vchResult = tokenIntrospection(parsedOpcode.opValue, tx.Outs[index].TokenData)
+					}
+					if len(vchResult) > MaxScriptElementSize {
+						return false, crypto.ScriptErr(crypto.ScriptErrPushSize)
+					}
+					stack.PopStack()
+					stack.PushStack(vchResult)
+				}
 			}
 		}
 	}
 	return true, nil
 }
 
+// tokenIntrospection returns what the token introspection opcode pushes for an
+// output carrying tokenData, which may be nil.
+func tokenIntrospection(opcode byte, tokenData *TokenData) []byte {
+	switch opcode {
+	case OP_UTXOTOKENCATEGORY, OP_OUTPUTTOKENCATEGORY:
+		if tokenData == nil {
+			return nil
+		}
+		// The category is followed by the capability of mutable and minting
+		// NFTs, so scripts can tell them apart.
+		category := tokenData.Category.GetCloneBytes()
+		if tokenData.HasNFT() && tokenData.Capability() != TokenCapabilityNone {
+			category = append(category, byte(tokenData.Capability()))
+		}
+		return category
+	case OP_UTXOTOKENCOMMITMENT, OP_OUTPUTTOKENCOMMITMENT:
+		if tokenData == nil {
+			return nil
+		}
+		return append([]byte{}, tokenData.Commitment...)
+	default:
+		if tokenData == nil {
+			return NewCScriptNum(0).Serialize()
+		}
+		return NewCScriptNum(tokenData.Amount).Serialize()
+	}
+}
+
+// CleanupScriptCode removes the pushes of vchSig from scriptCode, as legacy
+// signatures can not sign themselves. FORKID digests never cover them.
 func CleanupScriptCode(scriptCode *Script, vchSig []byte, flags uint32) {
-	scriptCode.FindAndDelete(scriptCode)
+	if len(vchSig) > 0 && vchSig[len(vchSig)-1]&crypto.SigHashForkID != 0 &&
+		flags&crypto.ScriptEnableSigHashForkID != 0 {
+		return
+	}
+	sigScript := NewScriptRaw(nil)
+	sigScript.PushData(vchSig)
+	scriptCode.FindAndDelete(sigScript)
 }
 
 func CastToBool(vch []byte) bool {
@@ -1185,8 +1398,33 @@ func (interpreter *Interpreter) List() []interface{} {
 	return interpreter.stack.List()
 }
 
+// checkSig is CheckSig consulting GSignatureCache first. Signatures found in
+// the cache are erased from it unless the interpreter stores new entries,
+// since a signature is not expected to be checked again once it was checked
+// while connecting a block.
+func (interpreter *Interpreter) checkSig(signHash utils.Hash, vchSig []byte, vchPubKey []byte) (bool, error) {
+	key := GetSignatureCacheKey(&signHash, vchSig, vchPubKey)
+	if GSignatureCache.Contains(key, !interpreter.sigCacheStore) {
+		return true, nil
+	}
+	ret, err := CheckSig(signHash, vchSig, vchPubKey)
+	if ret && interpreter.sigCacheStore {
+		GSignatureCache.Add(key)
+	}
+	return ret, err
+}
+
 func NewInterpreter() *Interpreter {
 	return &Interpreter{
 		stack: container.NewStack(),
 	}
 }
+
+// NewCachingInterpreter returns an interpreter whose verified signatures are
+// added to GSignatureCache if store is set.
+func NewCachingInterpreter(store bool) *Interpreter {
+	return &Interpreter{
+		stack:         container.NewStack(),
+		sigCacheStore: store,
+	}
+}
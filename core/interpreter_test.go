@@ -209,3 +209,183 @@ func TestMultiSigScript(t *testing.T) {
 	}
 
 }
+
+func TestNativeIntrospection(t *testing.T) {
+	testTx := testsTx[1].tx
+	spentCoins := []*SpentCoin{{TxOut: NewTxOut(100000, testsTx[0].tx.Outs[1].Script.bytes), Height: 100}}
+	value := NewCScriptNum(100000).Serialize()
+	scriptSig := NewScriptRaw([]byte{})
+
+	// <0> OP_UTXOVALUE <100000> OP_EQUAL
+	scriptPubKey := []byte{OP_0, OP_UTXOVALUE, byte(len(value))}
+	scriptPubKey = append(scriptPubKey, value...)
+	scriptPubKey = append(scriptPubKey, OP_EQUAL)
+
+	interpreter := NewInterpreter()
+	ctx := NewScriptExecutionContext(&testTx, 0, spentCoins)
+	_, err := interpreter.VerifyWithContext(ctx, scriptSig, NewScriptRaw(scriptPubKey), crypto.ScriptVerifyNone)
+	if e, ok := err.(*crypto.ErrDesc); !ok || e.Code != crypto.ScriptErrBadOpCode {
+		t.Errorf("introspection opcode should be rejected before activation, got %v", err)
+	}
+
+	flags := uint32(crypto.ScriptEnableNativeIntrospection)
+	ret, err := interpreter.VerifyWithContext(ctx, scriptSig, NewScriptRaw(scriptPubKey), flags)
+	if err != nil || !ret {
+		t.Errorf("OP_UTXOVALUE verify fail: %v", err)
+	}
+
+	ctx = NewScriptExecutionContext(&testTx, 0, nil)
+	_, err = interpreter.VerifyWithContext(ctx, scriptSig, NewScriptRaw(scriptPubKey), flags)
+	if e, ok := err.(*crypto.ErrDesc); !ok || e.Code != crypto.ScriptErrContextNotPresent {
+		t.Errorf("OP_UTXOVALUE without spent coins should fail, got %v", err)
+	}
+
+	// <1> OP_OUTPOINTINDEX
+	ctx = NewScriptExecutionContext(&testTx, 0, spentCoins)
+	_, err = interpreter.VerifyWithContext(ctx, scriptSig, NewScriptRaw([]byte{0x01, 0x01, OP_OUTPOINTINDEX}), flags)
+	if e, ok := err.(*crypto.ErrDesc); !ok || e.Code != crypto.ScriptErrInvalidTxInputIndex {
+		t.Errorf("out of range input index should fail, got %v", err)
+	}
+
+	// OP_TXINPUTCOUNT <1> OP_EQUAL
+	ret, err = interpreter.VerifyWithContext(ctx, scriptSig, NewScriptRaw([]byte{OP_TXINPUTCOUNT, 0x01, 0x01, OP_EQUAL}), flags)
+	if err != nil || !ret {
+		t.Errorf("OP_TXINPUTCOUNT verify fail: %v", err)
+	}
+}
+
+func TestInterpreter64BitIntegers(t *testing.T) {
+	testTx := testsTx[1].tx
+	interpreter := NewInterpreter()
+	ctx := NewScriptExecutionContext(&testTx, 0, nil)
+	scriptSig := NewScriptRaw([]byte{})
+
+	// <2^32> OP_1ADD <2^32 + 1> OP_EQUAL
+	operand := NewCScriptNum(1 << 32).Serialize()
+	expect := NewCScriptNum(1<<32 + 1).Serialize()
+	script := append([]byte{byte(len(operand))}, operand...)
+	script = append(script, OP_1ADD, byte(len(expect)))
+	script = append(script, expect...)
+	script = append(script, OP_EQUAL)
+
+	_, err := interpreter.VerifyWithContext(ctx, scriptSig, NewScriptRaw(script), crypto.ScriptVerifyNone)
+	if err == nil {
+		t.Errorf("5-byte operand should be rejected without 64-bit integers")
+	}
+	ret, err := interpreter.VerifyWithContext(ctx, scriptSig, NewScriptRaw(script), crypto.ScriptEnable64BitIntegers)
+	if err != nil || !ret {
+		t.Errorf("5-byte operand verify fail: %v", err)
+	}
+
+	// <2^63 - 1> OP_1ADD
+	operand = NewCScriptNum(MaxScriptNum64).Serialize()
+	script = append([]byte{byte(len(operand))}, operand...)
+	script = append(script, OP_1ADD)
+	_, err = interpreter.VerifyWithContext(ctx, scriptSig, NewScriptRaw(script), crypto.ScriptEnable64BitIntegers)
+	if e, ok := err.(*crypto.ErrDesc); !ok || e.Code != crypto.ScriptErrNumberOverflow {
+		t.Errorf("OP_1ADD overflow should fail with ScriptErrNumberOverflow, got %v", err)
+	}
+}
+
+func TestCheckSigForkID(t *testing.T) {
+	keys := make([]*crypto.PrivateKey, 2)
+	for i := range keys {
+		keyBytes := make([]byte, crypto.PrivateKeyBytesLen)
+		keyBytes[0], keyBytes[crypto.PrivateKeyBytesLen-1] = 0x01, byte(i+1)
+		keys[i] = crypto.PrivateKeyFromBytes(keyBytes)
+	}
+	p2pkh := NewScriptRaw(nil)
+	p2pkh.PushOpCode(OP_DUP)
+	p2pkh.PushOpCode(OP_HASH160)
+	p2pkh.PushData(utils.Hash160(keys[0].PubKey().ToBytes()))
+	p2pkh.PushOpCode(OP_EQUALVERIFY)
+	p2pkh.PushOpCode(OP_CHECKSIG)
+	multisig := NewScriptRaw(nil)
+	multisig.PushInt64(1)
+	multisig.PushData(keys[0].PubKey().ToBytes())
+	multisig.PushData(keys[1].PubKey().ToBytes())
+	multisig.PushInt64(2)
+	multisig.PushOpCode(OP_CHECKMULTISIG)
+	p2pkh, multisig = NewScriptRaw(p2pkh.GetScriptByte()), NewScriptRaw(multisig.GetScriptByte())
+
+	tx := NewTx()
+	tx.AddTxIn(NewTxIn(NewOutPoint(utils.Hash{1}, 0), nil))
+	tx.AddTxOut(NewTxOut(utils.COIN-1000, p2pkh.GetScriptByte()))
+	hashType := uint32(crypto.SigHashAll | crypto.SigHashForkID)
+	flags := uint32(crypto.ScriptVerifyStrictenc | crypto.ScriptEnableSigHashForkID)
+	sign := func(key *crypto.PrivateKey, scriptPubKey *Script) []byte {
+		spent := NewTxOut(utils.COIN, scriptPubKey.GetScriptByte())
+		hash, err := GetSignatureHash(tx, scriptPubKey, hashType, 0, spent, nil, flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := crypto.Sign(key, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(sig, byte(hashType))
+	}
+	verify := func(scriptSig, scriptPubKey *Script, amount int64) (bool, error) {
+		spentCoins := []*SpentCoin{{TxOut: NewTxOut(amount, scriptPubKey.GetScriptByte())}}
+		ctx := NewScriptExecutionContext(tx, 0, spentCoins)
+		return NewInterpreter().VerifyWithContext(ctx, scriptSig, scriptPubKey, flags)
+	}
+
+	scriptSig := NewScriptRaw(nil)
+	scriptSig.PushData(sign(keys[0], p2pkh))
+	scriptSig.PushData(keys[0].PubKey().ToBytes())
+	scriptSig = NewScriptRaw(scriptSig.GetScriptByte())
+	if ret, err := verify(scriptSig, p2pkh, utils.COIN); err != nil || !ret {
+		t.Errorf("a FORKID signature should verify: %v", err)
+	}
+	if ret, err := verify(scriptSig, p2pkh, utils.COIN+1); err == nil && ret {
+		t.Errorf("a FORKID signature should commit to the spent amount")
+	}
+	ctx := NewScriptExecutionContext(tx, 0, nil)
+	_, err := NewInterpreter().VerifyWithContext(ctx, scriptSig, p2pkh, flags)
+	if e, ok := err.(*crypto.ErrDesc); !ok || e.Code != crypto.ScriptErrContextNotPresent {
+		t.Errorf("a FORKID signature can not be checked without the spent output, got %v", err)
+	}
+
+	// The signature matches the second key only, with its own hash type.
+	scriptSig = NewScriptRaw(nil)
+	scriptSig.PushInt64(0)
+	scriptSig.PushData(sign(keys[1], multisig))
+	scriptSig = NewScriptRaw(scriptSig.GetScriptByte())
+	if ret, err := verify(scriptSig, multisig, utils.COIN); err != nil || !ret {
+		t.Errorf("a FORKID multisig signature should verify: %v", err)
+	}
+}
+
+func TestCheckSigWithoutEncodingFlags(t *testing.T) {
+	keyBytes := make([]byte, crypto.PrivateKeyBytesLen)
+	keyBytes[0] = 0x01
+	key := crypto.PrivateKeyFromBytes(keyBytes)
+	scriptPubKey := NewScriptRaw(nil)
+	scriptPubKey.PushData(key.PubKey().ToBytes())
+	scriptPubKey.PushOpCode(OP_CHECKSIG)
+	scriptPubKey.PushOpCode(OP_NOT)
+	scriptPubKey = NewScriptRaw(scriptPubKey.GetScriptByte())
+
+	tx := NewTx()
+	tx.AddTxIn(NewTxIn(NewOutPoint(utils.Hash{1}, 0), nil))
+	tx.AddTxOut(NewTxOut(utils.COIN, []byte{OP_TRUE}))
+
+	// Without the DER flags these signatures are not rejected for their
+	// encoding: they just fail to verify.
+	sigs := [][]byte{
+		{crypto.SigHashAll},
+		{0x30, crypto.SigHashAll},
+		{0x30, 0xff, 0x02, crypto.SigHashAll},
+	}
+	for i, sig := range sigs {
+		scriptSig := NewScriptRaw(nil)
+		scriptSig.PushData(sig)
+		scriptSig = NewScriptRaw(scriptSig.GetScriptByte())
+		spentCoins := []*SpentCoin{{TxOut: NewTxOut(utils.COIN, scriptPubKey.GetScriptByte())}}
+		ctx := NewScriptExecutionContext(tx, 0, spentCoins)
+		if ret, err := NewInterpreter().VerifyWithContext(ctx, scriptSig, scriptPubKey, 0); err != nil || !ret {
+			t.Errorf("signature %d: expected a failed check, got %v %v", i, ret, err)
+		}
+	}
+}
@@ -169,3 +169,17 @@ func (parsedOpCode *ParsedOpCode) bytes() ([]byte, error) {
 	}
 	return retBytes, nil
 }
+
+// serializeSize returns the number of bytes the opcode occupied in the script
+// it was parsed from.
+func (parsedOpCode *ParsedOpCode) serializeSize() int {
+	switch parsedOpCode.opValue {
+	case OP_PUSHDATA1:
+		return 2 + len(parsedOpCode.data)
+	case OP_PUSHDATA2:
+		return 3 + len(parsedOpCode.data)
+	case OP_PUSHDATA4:
+		return 5 + len(parsedOpCode.data)
+	}
+	return 1 + len(parsedOpCode.data)
+}
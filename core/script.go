@@ -155,7 +155,7 @@ func (script *Script) GetOp(index *int, opCode *byte, data *[]byte) bool {
 			if script.Size()-tmpIndex < 1 {
 				return false
 			}
-			nSize = int(script.bytes[*index])
+			nSize = int(script.bytes[tmpIndex])
 			tmpIndex++
 		} else if opcode == OP_PUSHDATA2 {
 			if script.Size()-tmpIndex < 2 {
@@ -238,40 +238,39 @@ func (script *Script) ParseScript() (stk []ParsedOpCode, err error) {
 		opcode := script.bytes[i]
 		parsedopCode := ParsedOpCode{opValue: opcode}
 
+		header := 0
 		if opcode < OP_PUSHDATA1 {
 			nSize = int(opcode)
-			parsedopCode.data = script.bytes[i+1 : i+1+nSize]
-
 		} else if opcode == OP_PUSHDATA1 {
-			if scriptLen-i < 1 {
+			if scriptLen-i < 2 {
 				err = errors.New("OP_PUSHDATA1 has no enough data")
 				return
 			}
 			nSize = int(script.bytes[i+1])
-			parsedopCode.data = script.bytes[i+2 : i+2+nSize]
-			i++
-
+			header = 1
 		} else if opcode == OP_PUSHDATA2 {
-			if scriptLen-i < 2 {
+			if scriptLen-i < 3 {
 				err = errors.New("OP_PUSHDATA2 has no enough data")
 				return
 			}
 			nSize = int(binary.LittleEndian.Uint16(script.bytes[i+1 : i+3]))
-			parsedopCode.data = script.bytes[i+3 : i+3+nSize]
-			i += 2
+			header = 2
 		} else if opcode == OP_PUSHDATA4 {
-			if scriptLen-i < 4 {
+			if scriptLen-i < 5 {
 				err = errors.New("OP_PUSHDATA4 has no enough data")
 				return
 			}
 			nSize = int(binary.LittleEndian.Uint32(script.bytes[i+1 : i+5]))
-			parsedopCode.data = script.bytes[i+5 : i+5+nSize]
-			i += 4
+			header = 4
 		}
-		if scriptLen-i < 0 || (scriptLen-i) < nSize {
+		i += header
+		if nSize < 0 || scriptLen-i-1 < nSize {
 			err = errors.New("size is wrong")
 			return
 		}
+		if opcode <= OP_PUSHDATA4 {
+			parsedopCode.data = script.bytes[i+1 : i+1+nSize]
+		}
 
 		stk = append(stk, parsedopCode)
 		i += nSize
@@ -280,33 +279,33 @@ func (script *Script) ParseScript() (stk []ParsedOpCode, err error) {
 	return
 }
 
+// FindAndDelete removes the occurrences of b starting on an opcode boundary
+// of script, and reports whether any was found.
 func (script *Script) FindAndDelete(b *Script) (bool, error) {
-	orginalParseCodes, err := script.ParseScript()
-	if err != nil {
-		return false, err
-	}
-	paramScript, err := b.ParseScript()
-	if err != nil {
-		return false, err
-	}
-	script.bytes = make([]byte, 0)
-
-	for i := 0; i < len(orginalParseCodes); i++ {
-		isDelete := false
-		parseCode := orginalParseCodes[i]
-		for j := 0; j < len(paramScript); j++ {
-			parseCodeOther := paramScript[j]
-			if parseCode.opValue == parseCodeOther.opValue {
-				isDelete = true
-			}
+	if len(b.bytes) == 0 {
+		return false, nil
+	}
+	result := make([]byte, 0, len(script.bytes))
+	found := false
+	pc, last := 0, 0
+	var opcode byte
+	var data []byte
+	for {
+		result = append(result, script.bytes[last:pc]...)
+		for len(script.bytes)-pc >= len(b.bytes) && bytes.Equal(script.bytes[pc:pc+len(b.bytes)], b.bytes) {
+			pc += len(b.bytes)
+			found = true
 		}
-		if !isDelete {
-			script.bytes = append(script.bytes, parseCode.opValue)
-			script.bytes = append(script.bytes, parseCode.data...)
+		last = pc
+		if !script.GetOp(&pc, &opcode, &data) {
+			break
 		}
 	}
-
-	return true, nil
+	if !found {
+		return false, nil
+	}
+	script.bytes = append(result, script.bytes[last:]...)
+	return true, script.ConvertOPS()
 }
 
 func (script *Script) Find(opcode int) bool {
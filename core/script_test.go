@@ -2,6 +2,7 @@ package core
 
 import (
 	"bytes"
+	"encoding/hex"
 	"testing"
 )
 
@@ -157,3 +158,31 @@ func TestScriptPushInt64(t *testing.T) {
 		t.Errorf("func PushInt64() error: the element should be 235 instead of : %d", script.bytes[0])
 	}
 }
+
+func TestScriptFindAndDelete(t *testing.T) {
+	tests := []struct {
+		script, b, expected string
+		found               bool
+	}{
+		{"5152", "52", "51", true},
+		{"0302ff03", "0302ff03", "", true},
+		{"0302ff030302ff03", "0302ff03", "", true},
+		{"0302ff030302ff03", "02", "0302ff030302ff03", false},
+		{"0302ff030302ff03", "ff", "0302ff030302ff03", false},
+		{"0302ff030302ff03", "03", "02ff0302ff03", true},
+		{"4c0301020351", "4c03010203", "51", true},
+	}
+	for _, test := range tests {
+		raw, _ := hex.DecodeString(test.script)
+		b, _ := hex.DecodeString(test.b)
+		script := NewScriptRaw(raw)
+		found, err := script.FindAndDelete(&Script{bytes: b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != test.found || hex.EncodeToString(script.bytes) != test.expected {
+			t.Errorf("deleting %s from %s: expected %s (%v), got %x (%v)", test.b, test.script,
+				test.expected, test.found, script.bytes, found)
+		}
+	}
+}
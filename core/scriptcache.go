@@ -1,5 +1,160 @@
 package core
 
-import "github.com/btcboost/copernicus/utils"
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
 
-var ScriptExecutionCacheNonce = utils.GetRandHash()
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/utils"
+)
+
+const (
+	// DefaultMaxSigCacheSize default for -maxsigcachesize, the size in MiB
+	// shared by the signature cache and the script execution cache.
+	DefaultMaxSigCacheSize = 32
+	// MaxMaxSigCacheSize maximum for -maxsigcachesize
+	MaxMaxSigCacheSize = 16384
+
+	// scriptCacheEntrySize is the memory an entry is assumed to use: the key
+	// plus the overhead of the map bucket holding it.
+	scriptCacheEntrySize = utils.Hash256Size + 32
+)
+
+var (
+	ScriptExecutionCacheNonce = utils.GetRandHash()
+	SignatureCacheNonce       = utils.GetRandHash()
+
+	// GSignatureCache holds the keys of (sighash, pubkey, signature) triples
+	// known to be valid.
+	GSignatureCache = NewScriptCache(DefaultMaxSigCacheSize / 2 << 20 / scriptCacheEntrySize)
+	// GScriptExecutionCache holds the keys of (tx, flags) pairs whose scripts
+	// are known to pass.
+	GScriptExecutionCache = NewScriptCache(DefaultMaxSigCacheSize / 2 << 20 / scriptCacheEntrySize)
+)
+
+// ScriptCache is a bounded set of salted hashes. When it is full a random
+// entry is evicted to make room for a new one, so an attacker can't predict
+// which entries survive.
+type ScriptCache struct {
+	lock       sync.RWMutex
+	entries    map[utils.Hash]struct{}
+	maxEntries int
+
+	hits   uint64
+	misses uint64
+}
+
+// ScriptCacheStats is a snapshot of the usage of a ScriptCache.
+type ScriptCacheStats struct {
+	Entries    int
+	MaxEntries int
+	Hits       uint64
+	Misses     uint64
+}
+
+func NewScriptCache(maxEntries int) *ScriptCache {
+	return &ScriptCache{
+		entries:    make(map[utils.Hash]struct{}),
+		maxEntries: maxEntries,
+	}
+}
+
+// Contains reports whether key is in the cache, removing it if erase is set.
+// Entries are erased once a block including them is connected as they are
+// unlikely to be needed again.
+func (sc *ScriptCache) Contains(key *utils.Hash, erase bool) bool {
+	var ok bool
+	if erase {
+		sc.lock.Lock()
+		_, ok = sc.entries[*key]
+		delete(sc.entries, *key)
+		sc.lock.Unlock()
+	} else {
+		sc.lock.RLock()
+		_, ok = sc.entries[*key]
+		sc.lock.RUnlock()
+	}
+	if ok {
+		atomic.AddUint64(&sc.hits, 1)
+	} else {
+		atomic.AddUint64(&sc.misses, 1)
+	}
+	return ok
+}
+
+func (sc *ScriptCache) Add(key *utils.Hash) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	if sc.maxEntries <= 0 {
+		return
+	}
+	if _, ok := sc.entries[*key]; ok {
+		return
+	}
+	if len(sc.entries) >= sc.maxEntries {
+		// Map iteration order is randomized, so this evicts a random entry.
+		for k := range sc.entries {
+			delete(sc.entries, k)
+			break
+		}
+	}
+	sc.entries[*key] = struct{}{}
+}
+
+// Resize changes the capacity of the cache, evicting entries if needed.
+func (sc *ScriptCache) Resize(maxEntries int) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	sc.maxEntries = maxEntries
+	for k := range sc.entries {
+		if len(sc.entries) <= maxEntries {
+			break
+		}
+		delete(sc.entries, k)
+	}
+}
+
+func (sc *ScriptCache) Stats() ScriptCacheStats {
+	sc.lock.RLock()
+	defer sc.lock.RUnlock()
+	return ScriptCacheStats{
+		Entries:    len(sc.entries),
+		MaxEntries: sc.maxEntries,
+		Hits:       atomic.LoadUint64(&sc.hits),
+		Misses:     atomic.LoadUint64(&sc.misses),
+	}
+}
+
+// InitScriptCaches sizes the signature cache and the script execution cache
+// from -maxsigcachesize, each of them getting half of it.
+func InitScriptCaches() {
+	size := utils.GetArg("-maxsigcachesize", DefaultMaxSigCacheSize)
+	if size < 0 {
+		size = 0
+	}
+	if size > MaxMaxSigCacheSize {
+		size = MaxMaxSigCacheSize
+	}
+	maxEntries := int(size / 2 << 20 / scriptCacheEntrySize)
+	GSignatureCache.Resize(maxEntries)
+	GScriptExecutionCache.Resize(maxEntries)
+}
+
+// GetSignatureCacheKey returns the salted key of a signature check.
+func GetSignatureCacheKey(sigHash *utils.Hash, vchSig []byte, vchPubKey []byte) *utils.Hash {
+	b := make([]byte, 0, utils.Hash256Size*2+len(vchSig)+len(vchPubKey)+8)
+	b = append(b, SignatureCacheNonce[:]...)
+	b = append(b, sigHash[:]...)
+	// Length-prefix the variable size fields so that no two distinct
+	// (pubkey, sig) pairs serialize to the same bytes.
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(len(vchPubKey)))
+	b = append(b, buf...)
+	b = append(b, vchPubKey...)
+	binary.LittleEndian.PutUint32(buf, uint32(len(vchSig)))
+	b = append(b, buf...)
+	b = append(b, vchSig...)
+	hash := crypto.Sha256Hash(b)
+	return &hash
+}
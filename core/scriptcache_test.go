@@ -0,0 +1,55 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/btcboost/copernicus/utils"
+)
+
+func TestScriptCache(t *testing.T) {
+	cache := NewScriptCache(4)
+	keys := make([]utils.Hash, 8)
+	for i := range keys {
+		keys[i] = *utils.GetRandHash()
+	}
+
+	cache.Add(&keys[0])
+	if !cache.Contains(&keys[0], false) {
+		t.Error("key should be in the cache")
+	}
+	if cache.Contains(&keys[1], false) {
+		t.Error("key should not be in the cache")
+	}
+	if !cache.Contains(&keys[0], true) || cache.Contains(&keys[0], false) {
+		t.Error("key should be erased after a hit with erase")
+	}
+
+	for i := range keys {
+		cache.Add(&keys[i])
+	}
+	stats := cache.Stats()
+	if stats.Entries != 4 || stats.MaxEntries != 4 {
+		t.Errorf("cache should be bounded to 4 entries, got %d", stats.Entries)
+	}
+	if stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("got %d hits and %d misses, want 2 and 2", stats.Hits, stats.Misses)
+	}
+
+	cache.Resize(2)
+	if cache.Stats().Entries != 2 {
+		t.Errorf("cache should shrink to 2 entries, got %d", cache.Stats().Entries)
+	}
+}
+
+func TestSignatureCacheKey(t *testing.T) {
+	hash := utils.GetRandHash()
+	key1 := GetSignatureCacheKey(hash, []byte{0x30, 0x01}, []byte{0x02, 0x03})
+	key2 := GetSignatureCacheKey(hash, []byte{0x30}, []byte{0x01, 0x02, 0x03})
+	if key1.IsEqual(key2) {
+		t.Error("different signatures and public keys should have different keys")
+	}
+	key3 := GetSignatureCacheKey(hash, []byte{0x30, 0x01}, []byte{0x02, 0x03})
+	if !key1.IsEqual(key3) {
+		t.Error("the same signature check should have the same key")
+	}
+}
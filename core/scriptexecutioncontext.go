@@ -0,0 +1,76 @@
+package core
+
+import "github.com/btcboost/copernicus/utils"
+
+// SpentCoin is the output consumed by one input of the transaction being
+// validated, together with the metadata of the coin it was stored in.
+type SpentCoin struct {
+	TxOut      *TxOut
+	Height     uint32
+	IsCoinBase bool
+}
+
+// ScriptExecutionContext holds everything a script may introspect while it is
+// being evaluated: the spending transaction, the index of the input whose
+// script is running and the coins spent by every input of the transaction.
+type ScriptExecutionContext struct {
+	Tx         *Tx
+	InputIndex int
+	SpentCoins []*SpentCoin
+	// TxData caches the hashes of the transaction shared by the FORKID
+	// digests of its inputs. It is computed for each signature when nil.
+	TxData *PrecomputedTransactionData
+}
+
+// NewScriptExecutionContext returns the context of input nIn. spentCoins may be
+// nil when the spent outputs are unknown, in which case the opcodes that need
+// them fail with ScriptErrContextNotPresent.
+func NewScriptExecutionContext(tx *Tx, nIn int, spentCoins []*SpentCoin) *ScriptExecutionContext {
+	return &ScriptExecutionContext{
+		Tx:         tx,
+		InputIndex: nIn,
+		SpentCoins: spentCoins,
+	}
+}
+
+// NewScriptExecutionContexts returns one context per input of tx, all of them
+// sharing the same spent coins.
+func NewScriptExecutionContexts(tx *Tx, spentCoins []*SpentCoin) []*ScriptExecutionContext {
+	contexts := make([]*ScriptExecutionContext, len(tx.Ins))
+	for i := range tx.Ins {
+		contexts[i] = NewScriptExecutionContext(tx, i, spentCoins)
+	}
+	return contexts
+}
+
+// HasSpentCoins reports whether the coins spent by every input are available.
+func (ctx *ScriptExecutionContext) HasSpentCoins() bool {
+	if ctx.SpentCoins == nil || len(ctx.SpentCoins) != len(ctx.Tx.Ins) {
+		return false
+	}
+	for _, coin := range ctx.SpentCoins {
+		if coin == nil || coin.TxOut == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// SpentCoin returns the coin spent by input index, or nil if it is unknown.
+func (ctx *ScriptExecutionContext) SpentCoin(index int) *SpentCoin {
+	if index < 0 || index >= len(ctx.SpentCoins) {
+		return nil
+	}
+	return ctx.SpentCoins[index]
+}
+
+// SignatureHash returns the digest signed with hashType by the input of the
+// context over scriptCode, committing to the output it spends when the
+// signature uses SigHashForkID.
+func (ctx *ScriptExecutionContext) SignatureHash(scriptCode *Script, hashType uint32, flags uint32) (utils.Hash, error) {
+	var spent *TxOut
+	if coin := ctx.SpentCoin(ctx.InputIndex); coin != nil {
+		spent = coin.TxOut
+	}
+	return GetSignatureHash(ctx.Tx, scriptCode, hashType, ctx.InputIndex, spent, ctx.TxData, flags)
+}
@@ -1,11 +1,22 @@
 package core
 
-import "github.com/pkg/errors"
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
 
 const (
 	DefaultMaxNumSize = 4
 	MaxInt32          = 1<<31 - 1
 	MinInt32          = -1 << 31
+
+	// MaxNumSize64 is the operand size once 64-bit script integers are enabled.
+	MaxNumSize64 = 8
+	// MaxScriptNum64 and MinScriptNum64 bound the values an 8-byte script
+	// number can encode; math.MinInt64 has no 8-byte encoding.
+	MaxScriptNum64 = math.MaxInt64
+	MinScriptNum64 = -math.MaxInt64
 )
 
 type CScriptNum struct {
@@ -109,6 +120,25 @@ func (scriptNum *CScriptNum) Serialize() (bytes []byte) {
 	}
 	return
 }
+
+// AddScriptNum returns a + b, and false if the result is outside of the range
+// [MinScriptNum64, MaxScriptNum64]. Operands of at most 4 bytes never overflow.
+func AddScriptNum(a, b int64) (int64, bool) {
+	if (b > 0 && a > MaxScriptNum64-b) || (b < 0 && a < MinScriptNum64-b) {
+		return 0, false
+	}
+	return a + b, true
+}
+
+// SubScriptNum returns a - b, and false if the result is outside of the range
+// [MinScriptNum64, MaxScriptNum64].
+func SubScriptNum(a, b int64) (int64, bool) {
+	if (b > 0 && a < MinScriptNum64+b) || (b < 0 && a > MaxScriptNum64+b) {
+		return 0, false
+	}
+	return a - b, true
+}
+
 func NewCScriptNum(v int64) *CScriptNum {
 	return &CScriptNum{Value: v}
 }
@@ -257,3 +257,36 @@ func TestScriptNumInt32(t *testing.T) {
 		}
 	}
 }
+
+func TestScriptNumArithmeticOverflow(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		a, b   int64
+		sum    int64
+		sumOk  bool
+		diff   int64
+		diffOk bool
+	}{
+		{1, 2, 3, true, -1, true},
+		{MaxInt32, 1, MaxInt32 + 1, true, MaxInt32 - 1, true},
+		{MinInt32, MinInt32, 2 * MinInt32, true, 0, true},
+		{MaxScriptNum64, 1, 0, false, MaxScriptNum64 - 1, true},
+		{MaxScriptNum64, -1, MaxScriptNum64 - 1, true, 0, false},
+		{MinScriptNum64, -1, 0, false, MinScriptNum64 + 1, true},
+		{MinScriptNum64, 1, MinScriptNum64 + 1, true, 0, false},
+		{MaxScriptNum64, MinScriptNum64, 0, true, 0, false},
+	}
+	for _, test := range tests {
+		sum, ok := AddScriptNum(test.a, test.b)
+		if ok != test.sumOk || (ok && sum != test.sum) {
+			t.Errorf("AddScriptNum(%d, %d): got (%d, %v), want (%d, %v)",
+				test.a, test.b, sum, ok, test.sum, test.sumOk)
+		}
+		diff, ok := SubScriptNum(test.a, test.b)
+		if ok != test.diffOk || (ok && diff != test.diff) {
+			t.Errorf("SubScriptNum(%d, %d): got (%d, %v), want (%d, %v)",
+				test.a, test.b, diff, ok, test.diff, test.diffOk)
+		}
+	}
+}
@@ -50,9 +50,7 @@ func Solver(scriptPubKey *Script, typeRet *int, vSolutionsRet *container.Vector)
 	scriptByte := scriptPubKey.GetScriptByte()
 	if scriptPubKey.IsPayToScriptHash() {
 		*typeRet = TxScriptHash
-		hashBytes := container.NewVector()
-		hashBytes.PushBack(scriptByte[2:22])
-		vSolutionsRet.PushBack(hashBytes)
+		vSolutionsRet.PushBack(scriptByte[2:22])
 		return true
 	}
 
@@ -69,7 +67,7 @@ func Solver(scriptPubKey *Script, typeRet *int, vSolutionsRet *container.Vector)
 
 	// Scan templates
 	script1 := scriptPubKey
-	for _, tmpScript := range mTemplates {
+	for tmplType, tmpScript := range mTemplates {
 
 		vSolutionsRet.Clear()
 
@@ -85,7 +83,7 @@ func Solver(scriptPubKey *Script, typeRet *int, vSolutionsRet *container.Vector)
 		for {
 			if pc1 == script1.Size() && pc2 == tmpScript.Size() {
 				// Found a match
-				*typeRet = int(tmpScript.GetScriptByte()[0])
+				*typeRet = tmplType
 				if *typeRet == TxMultiSig {
 					// Additional checks for TxMultiSig:
 					front := vSolutionsRet.Array[0].([]byte)
@@ -138,7 +136,7 @@ func Solver(scriptPubKey *Script, typeRet *int, vSolutionsRet *container.Vector)
 					if err != nil {
 						return false
 					}
-					valType := []byte{1, byte(n)}
+					valType := []byte{byte(n)}
 					vSolutionsRet.PushBack(valType)
 				} else {
 					break
@@ -156,3 +154,31 @@ func Solver(scriptPubKey *Script, typeRet *int, vSolutionsRet *container.Vector)
 	return false
 
 }
+
+// PayToPubKey returns the P2PK scriptPubKey paying to the serialized pubKey.
+func PayToPubKey(pubKey []byte) *Script {
+	script := NewScriptRaw(nil)
+	script.PushData(pubKey)
+	script.PushOpCode(OP_CHECKSIG)
+	return NewScriptRaw(script.bytes)
+}
+
+// PayToPubKeyHash returns the P2PKH scriptPubKey paying to hash160.
+func PayToPubKeyHash(hash160 []byte) *Script {
+	script := NewScriptRaw(nil)
+	script.PushOpCode(OP_DUP)
+	script.PushOpCode(OP_HASH160)
+	script.PushData(hash160)
+	script.PushOpCode(OP_EQUALVERIFY)
+	script.PushOpCode(OP_CHECKSIG)
+	return NewScriptRaw(script.bytes)
+}
+
+// PayToScriptHash returns the P2SH scriptPubKey paying to hash160.
+func PayToScriptHash(hash160 []byte) *Script {
+	script := NewScriptRaw(nil)
+	script.PushOpCode(OP_HASH160)
+	script.PushData(hash160)
+	script.PushOpCode(OP_EQUAL)
+	return NewScriptRaw(script.bytes)
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/btcboost/copernicus/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PrefixToken marks the start of the token prefix inside the locking
+	// bytecode field of an output (CashTokens).
+	PrefixToken = 0xef
+
+	// MaxTokenCommitmentLength is the maximum size of an NFT commitment.
+	MaxTokenCommitmentLength = 40
+
+	// MaxTokenAmount is the maximum fungible token amount of one output.
+	MaxTokenAmount = math.MaxInt64
+)
+
+// Token bitfield flags, the high nibble of the token bitfield byte. The low
+// nibble holds the NFT capability.
+const (
+	TokenReserved            = 0x80
+	TokenHasCommitmentLength = 0x40
+	TokenHasNFT              = 0x20
+	TokenHasAmount           = 0x10
+
+	tokenCapabilityMask = 0x0f
+)
+
+// TokenCapability is the capability of a non-fungible token.
+type TokenCapability byte
+
+const (
+	// TokenCapabilityNone the NFT is immutable.
+	TokenCapabilityNone TokenCapability = iota
+	// TokenCapabilityMutable the NFT may be spent to create one new NFT of the
+	// same category with any commitment.
+	TokenCapabilityMutable
+	// TokenCapabilityMinting the NFT may be spent to create any number of new
+	// NFTs of the same category.
+	TokenCapabilityMinting
+)
+
+// TokenData is the token prefix of an output: a category and, optionally, a
+// non-fungible token and an amount of fungible tokens of that category.
+type TokenData struct {
+	Category   utils.Hash
+	Bitfield   byte
+	Commitment []byte
+	Amount     int64
+}
+
+func (td *TokenData) HasAmount() bool {
+	return td.Bitfield&TokenHasAmount != 0
+}
+
+func (td *TokenData) HasNFT() bool {
+	return td.Bitfield&TokenHasNFT != 0
+}
+
+func (td *TokenData) HasCommitment() bool {
+	return td.Bitfield&TokenHasCommitmentLength != 0
+}
+
+func (td *TokenData) Capability() TokenCapability {
+	return TokenCapability(td.Bitfield & tokenCapabilityMask)
+}
+
+func (td *TokenData) IsMutableNFT() bool {
+	return td.HasNFT() && td.Capability() == TokenCapabilityMutable
+}
+
+func (td *TokenData) IsMintingNFT() bool {
+	return td.HasNFT() && td.Capability() == TokenCapabilityMinting
+}
+
+func (td *TokenData) IsImmutableNFT() bool {
+	return td.HasNFT() && td.Capability() == TokenCapabilityNone
+}
+
+// CheckSanity checks the bitfield and the ranges of the prefix fields.
+func (td *TokenData) CheckSanity() error {
+	if td.Bitfield&TokenReserved != 0 {
+		return errors.New("token bitfield has the reserved bit set")
+	}
+	if td.Capability() > TokenCapabilityMinting {
+		return errors.Errorf("invalid token capability %d", td.Capability())
+	}
+	if !td.HasNFT() && !td.HasAmount() {
+		return errors.New("token prefix encodes no tokens")
+	}
+	if !td.HasNFT() && (td.HasCommitment() || td.Capability() != TokenCapabilityNone) {
+		return errors.New("token commitment or capability without NFT")
+	}
+	if td.HasCommitment() {
+		if len(td.Commitment) == 0 {
+			return errors.New("token commitment length is zero")
+		}
+		if len(td.Commitment) > MaxTokenCommitmentLength {
+			return errors.Errorf("token commitment length %d exceeds %d", len(td.Commitment), MaxTokenCommitmentLength)
+		}
+	} else if len(td.Commitment) != 0 {
+		return errors.New("token commitment present without commitment flag")
+	}
+	if td.HasAmount() {
+		if td.Amount < 1 || td.Amount > MaxTokenAmount {
+			return errors.Errorf("token amount %d out of range", td.Amount)
+		}
+	} else if td.Amount != 0 {
+		return errors.New("token amount present without amount flag")
+	}
+	return nil
+}
+
+// SerializeSize returns the size of the token prefix, including PrefixToken.
+func (td *TokenData) SerializeSize() int {
+	size := 1 + utils.Hash256Size + 1
+	if td.HasCommitment() {
+		size += utils.VarIntSerializeSize(uint64(len(td.Commitment))) + len(td.Commitment)
+	}
+	if td.HasAmount() {
+		size += utils.VarIntSerializeSize(uint64(td.Amount))
+	}
+	return size
+}
+
+// Serialize writes the token prefix, including PrefixToken.
+func (td *TokenData) Serialize(writer io.Writer) error {
+	if _, err := writer.Write([]byte{PrefixToken}); err != nil {
+		return err
+	}
+	if _, err := writer.Write(td.Category[:]); err != nil {
+		return err
+	}
+	if _, err := writer.Write([]byte{td.Bitfield}); err != nil {
+		return err
+	}
+	if td.HasCommitment() {
+		if err := utils.WriteVarBytes(writer, td.Commitment); err != nil {
+			return err
+		}
+	}
+	if td.HasAmount() {
+		return utils.WriteVarInt(writer, uint64(td.Amount))
+	}
+	return nil
+}
+
+func (td *TokenData) IsEqual(other *TokenData) bool {
+	if td == nil || other == nil {
+		return td == other
+	}
+	return td.Category == other.Category && td.Bitfield == other.Bitfield &&
+		td.Amount == other.Amount && bytes.Equal(td.Commitment, other.Commitment)
+}
+
+func (td *TokenData) Copy() *TokenData {
+	if td == nil {
+		return nil
+	}
+	dst := *td
+	if td.Commitment != nil {
+		dst.Commitment = make([]byte, len(td.Commitment))
+		copy(dst.Commitment, td.Commitment)
+	}
+	return &dst
+}
+
+func (td *TokenData) String() string {
+	return fmt.Sprintf("Category:%s Bitfield:%#x Commitment:%s Amount:%d", td.Category.ToString(),
+		td.Bitfield, hex.EncodeToString(td.Commitment), td.Amount)
+}
+
+// SplitTokenPrefix separates the token prefix from the locking bytecode field
+// of an output. It returns nil token data and the field unchanged if the field
+// does not start with PrefixToken, and an error if it does but the prefix is
+// malformed.
+func SplitTokenPrefix(field []byte) (*TokenData, []byte, error) {
+	if len(field) == 0 || field[0] != PrefixToken {
+		return nil, field, nil
+	}
+	reader := bytes.NewReader(field[1:])
+	td := new(TokenData)
+	if _, err := io.ReadFull(reader, td.Category[:]); err != nil {
+		return nil, field, errors.New("token prefix is truncated")
+	}
+	bitfield, err := reader.ReadByte()
+	if err != nil {
+		return nil, field, errors.New("token prefix is truncated")
+	}
+	td.Bitfield = bitfield
+	if td.HasCommitment() {
+		length, err := utils.ReadVarInt(reader)
+		if err != nil {
+			return nil, field, errors.Wrap(err, "token commitment length")
+		}
+		if length > MaxTokenCommitmentLength {
+			return nil, field, errors.Errorf("token commitment length %d exceeds %d", length, MaxTokenCommitmentLength)
+		}
+		td.Commitment = make([]byte, length)
+		if _, err := io.ReadFull(reader, td.Commitment); err != nil {
+			return nil, field, errors.New("token commitment is truncated")
+		}
+	}
+	if td.HasAmount() {
+		amount, err := utils.ReadVarInt(reader)
+		if err != nil {
+			return nil, field, errors.Wrap(err, "token amount")
+		}
+		if amount > MaxTokenAmount {
+			return nil, field, errors.Errorf("token amount %d out of range", amount)
+		}
+		td.Amount = int64(amount)
+	}
+	if err := td.CheckSanity(); err != nil {
+		return nil, field, err
+	}
+	script := make([]byte, reader.Len())
+	copy(script, field[len(field)-reader.Len():])
+	return td, script, nil
+}
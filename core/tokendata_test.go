@@ -0,0 +1,93 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcboost/copernicus/utils"
+)
+
+func TestTokenDataSerialize(t *testing.T) {
+	category := utils.HashFromString("0000000069e244f73d78e8fd29ba2fd2ed618bd6fa2ee92559f542fdb26e7c1d")
+	script := []byte{OP_DUP, OP_HASH160, 0x14, 0x69, 0xe1, 0x2a, 0x40, 0xd4, 0xa2, 0x21, 0x8d, 0x33, 0xf2,
+		0x08, 0xb9, 0xa0, 0x44, 0x78, 0x94, 0xdc, 0x9b, 0xea, 0x31, OP_EQUALVERIFY, OP_CHECKSIG}
+
+	tests := []*TokenData{
+		{Category: *category, Bitfield: TokenHasAmount, Amount: 1},
+		{Category: *category, Bitfield: TokenHasAmount, Amount: MaxTokenAmount},
+		{Category: *category, Bitfield: TokenHasNFT},
+		{Category: *category, Bitfield: TokenHasNFT | byte(TokenCapabilityMinting)},
+		{Category: *category, Bitfield: TokenHasNFT | TokenHasCommitmentLength | byte(TokenCapabilityMutable),
+			Commitment: []byte{0xca, 0xfe}},
+		{Category: *category, Bitfield: TokenHasNFT | TokenHasCommitmentLength | TokenHasAmount,
+			Commitment: bytes.Repeat([]byte{0x01}, MaxTokenCommitmentLength), Amount: 100000},
+	}
+	for i, tokenData := range tests {
+		if err := tokenData.CheckSanity(); err != nil {
+			t.Errorf("test %d: CheckSanity() = %v", i, err)
+			continue
+		}
+		txOut := NewTxOut(1000, script)
+		txOut.TokenData = tokenData
+
+		buf := bytes.NewBuffer(nil)
+		if err := txOut.Serialize(buf); err != nil {
+			t.Error(err)
+			continue
+		}
+		if buf.Len() != txOut.SerializeSize() {
+			t.Errorf("test %d: serialized %d bytes, SerializeSize() = %d", i, buf.Len(), txOut.SerializeSize())
+		}
+
+		txOutRead := &TxOut{}
+		if err := txOutRead.Deserialize(buf); err != nil {
+			t.Error(err)
+			continue
+		}
+		if !txOutRead.IsEqual(txOut) {
+			t.Errorf("test %d: got %s, want %s", i, txOutRead.String(), txOut.String())
+		}
+		if !bytes.Equal(txOutRead.Script.bytes, script) {
+			t.Errorf("test %d: script should not include the token prefix", i)
+		}
+	}
+}
+
+func TestSplitTokenPrefixInvalid(t *testing.T) {
+	category := make([]byte, utils.Hash256Size)
+	prefix := func(rest ...byte) []byte {
+		field := append([]byte{PrefixToken}, category...)
+		return append(field, rest...)
+	}
+
+	tests := []struct {
+		name  string
+		field []byte
+	}{
+		{"truncated category", []byte{PrefixToken, 0x01, 0x02}},
+		{"missing bitfield", prefix()},
+		{"no tokens", prefix(0x00)},
+		{"reserved bit", prefix(TokenReserved | TokenHasNFT)},
+		{"capability without NFT", prefix(TokenHasAmount|byte(TokenCapabilityMinting), 0x01)},
+		{"invalid capability", prefix(TokenHasNFT | 0x03)},
+		{"zero amount", prefix(TokenHasAmount, 0x00)},
+		{"non-minimal amount", prefix(TokenHasAmount, 0xfd, 0x01, 0x00)},
+		{"empty commitment", prefix(TokenHasNFT|TokenHasCommitmentLength, 0x00)},
+		{"commitment too long", prefix(TokenHasNFT|TokenHasCommitmentLength, MaxTokenCommitmentLength+1)},
+		{"truncated commitment", prefix(TokenHasNFT|TokenHasCommitmentLength, 0x02, 0x01)},
+	}
+	for _, test := range tests {
+		tokenData, script, err := SplitTokenPrefix(test.field)
+		if err == nil || tokenData != nil {
+			t.Errorf("%s: SplitTokenPrefix() should fail", test.name)
+		}
+		if !bytes.Equal(script, test.field) {
+			t.Errorf("%s: the field should be returned unchanged", test.name)
+		}
+	}
+
+	tokenData, script, err := SplitTokenPrefix([]byte{OP_TRUE})
+	if err != nil || tokenData != nil || !bytes.Equal(script, []byte{OP_TRUE}) {
+		t.Errorf("a field without token prefix should be returned unchanged")
+	}
+}
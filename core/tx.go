@@ -212,6 +212,14 @@ func (tx *Tx) IsCoinBase() bool {
 	return len(tx.Ins) == 1 && tx.Ins[0].PreviousOutPoint == nil
 }
 
+// ClearTokenPrefixes folds the token prefixes of the outputs back into their
+// scripts, for a transaction in a block which does not enable tokens.
+func (tx *Tx) ClearTokenPrefixes() {
+	for _, out := range tx.Outs {
+		out.ClearTokenPrefix()
+	}
+}
+
 func (tx *Tx) GetSigOpCountWithoutP2SH() int {
 	n := 0
 	for _, in := range tx.Ins {
@@ -389,8 +397,9 @@ func (tx *Tx) Copy() *Tx {
 		copy(newOutScript, txOut.Script.bytes[:scriptLen])
 
 		newTxOut := TxOut{
-			Value:  txOut.Value,
-			Script: NewScriptRaw(newOutScript),
+			Value:     txOut.Value,
+			Script:    NewScriptRaw(newOutScript),
+			TokenData: txOut.TokenData.Copy(),
 		}
 		newTx.Outs = append(newTx.Outs, &newTxOut)
 	}
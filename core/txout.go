@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
@@ -14,13 +15,47 @@ type TxOut struct {
 	Value      int64
 	SigOpCount int
 	Script     *Script
+	// TokenData is the CashTokens prefix of the output, nil if it carries no
+	// tokens. Script never includes the prefix.
+	TokenData *TokenData
+}
+
+// scriptFieldSize returns the size of the locking bytecode field, which holds
+// the token prefix followed by the script.
+func (txOut *TxOut) scriptFieldSize() int {
+	size := txOut.Script.Size()
+	if txOut.TokenData != nil {
+		size += txOut.TokenData.SerializeSize()
+	}
+	return size
 }
 
 func (txOut *TxOut) SerializeSize() int {
 	if txOut.Script == nil {
 		return 8
 	}
-	return 8 + utils.VarIntSerializeSize(uint64(txOut.Script.Size())) + txOut.Script.Size()
+	size := txOut.scriptFieldSize()
+	return 8 + utils.VarIntSerializeSize(uint64(size)) + size
+}
+
+// HasInvalidTokenPrefix reports whether the locking bytecode field starts with
+// PrefixToken but could not be parsed as a token prefix.
+func (txOut *TxOut) HasInvalidTokenPrefix() bool {
+	return txOut.TokenData == nil && txOut.Script != nil && txOut.Script.Size() > 0 &&
+		txOut.Script.bytes[0] == PrefixToken
+}
+
+// ClearTokenPrefix folds the token prefix back into the script, as the plain
+// script bytes it is until tokens are enabled. The serialization is unchanged.
+func (txOut *TxOut) ClearTokenPrefix() {
+	if txOut.TokenData == nil {
+		return
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, txOut.scriptFieldSize()))
+	txOut.TokenData.Serialize(buf)
+	buf.Write(txOut.Script.bytes)
+	txOut.Script = NewScriptRaw(buf.Bytes())
+	txOut.TokenData = nil
 }
 
 func (txOut *TxOut) IsDust(minRelayTxFee utils.FeeRate) bool {
@@ -51,6 +86,14 @@ func (txOut *TxOut) Deserialize(reader io.Reader) error {
 		return err
 	}
 	bytes, err := ReadScript(reader, MaxMessagePayload, "tx output script")
+	if err == nil {
+		// A malformed token prefix is kept as part of the script, validation
+		// rejects such outputs once tokens are enabled.
+		if tokenData, script, e := SplitTokenPrefix(bytes); e == nil {
+			txOut.TokenData = tokenData
+			bytes = script
+		}
+	}
 	txOut.Script = NewScriptRaw(bytes)
 	return err
 }
@@ -63,7 +106,19 @@ func (txOut *TxOut) Serialize(writer io.Writer) error {
 	if err != nil {
 		return err
 	}
-	return utils.WriteVarBytes(writer, txOut.Script.bytes)
+	if txOut.TokenData == nil {
+		return utils.WriteVarBytes(writer, txOut.Script.bytes)
+	}
+	err = utils.WriteVarInt(writer, uint64(txOut.scriptFieldSize()))
+	if err != nil {
+		return err
+	}
+	err = txOut.TokenData.Serialize(writer)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(txOut.Script.bytes)
+	return err
 }
 
 func (txOut *TxOut) Check() bool {
@@ -73,6 +128,7 @@ func (txOut *TxOut) Check() bool {
 func (txOut *TxOut) SetNull() {
 	txOut.Value = -1
 	txOut.Script = nil
+	txOut.TokenData = nil
 }
 
 func (txOut *TxOut) IsNull() bool {
@@ -80,6 +136,10 @@ func (txOut *TxOut) IsNull() bool {
 }
 
 func (txOut *TxOut) String() string {
+	if txOut.TokenData != nil {
+		return fmt.Sprintf("Value :%d Script:%s Token:%s", txOut.Value, hex.EncodeToString(txOut.Script.bytes),
+			txOut.TokenData.String())
+	}
 	return fmt.Sprintf("Value :%d Script:%s", txOut.Value, hex.EncodeToString(txOut.Script.bytes))
 }
 
@@ -87,6 +147,9 @@ func (txOut *TxOut) IsEqual(out *TxOut) bool {
 	if txOut.Value != out.Value {
 		return false
 	}
+	if !txOut.TokenData.IsEqual(out.TokenData) {
+		return false
+	}
 
 	return txOut.Script.IsEqual(out.Script)
 }
@@ -45,7 +45,7 @@ func GetOutputsHash(tx *Tx) (utils.Hash, error) {
 		size += tx.Outs[i].SerializeSize()
 	}
 	buf := bytes.NewBuffer(make([]byte, 0, size))
-	for i := 0; i < len(tx.Ins); i++ {
+	for i := 0; i < len(tx.Outs); i++ {
 		tx.Outs[i].Serialize(buf)
 	}
 	return crypto.DoubleSha256Hash(buf.Bytes()), nil
@@ -120,3 +120,67 @@ func SignatureHash(tx *Tx, script *Script, hashType uint32, nIn int) (result uti
 	result.SetBytes(sha256)
 	return
 }
+
+// GetSignatureHash returns the digest signed by input nIn with hashType under
+// the script flags: the digest of SignatureHashForkID when hashType has
+// SigHashForkID set and flags enable it, the legacy one otherwise. spent is
+// the output spent by the input, required by the former. cache may be nil.
+func GetSignatureHash(tx *Tx, script *Script, hashType uint32, nIn int, spent *TxOut,
+	cache *PrecomputedTransactionData, flags uint32) (utils.Hash, error) {
+	if hashType&crypto.SigHashForkID != 0 && flags&crypto.ScriptEnableSigHashForkID != 0 {
+		if spent == nil {
+			return utils.Hash{}, crypto.ScriptErr(crypto.ScriptErrContextNotPresent)
+		}
+		return SignatureHashForkID(tx, script, hashType, nIn, spent, cache)
+	}
+	return SignatureHash(tx, script, hashType, nIn)
+}
+
+// SignatureHashForkID returns the BIP143 style digest signed by inputs whose
+// hash type has SigHashForkID set. spent is the output spent by input nIn: its
+// value, and its token prefix if any, are committed to. cache may be nil.
+func SignatureHashForkID(tx *Tx, script *Script, hashType uint32, nIn int, spent *TxOut,
+	cache *PrecomputedTransactionData) (utils.Hash, error) {
+	if cache == nil {
+		cache = NewPrecomputedTransactionData(tx)
+	}
+	baseType := hashType & 0x1f
+	var hashPrevouts, hashSequence, hashOutputs utils.Hash
+	if hashType&crypto.SigHashAnyoneCanpay == 0 {
+		hashPrevouts = *cache.HashPrevout
+		if baseType != crypto.SigHashSingle && baseType != crypto.SigHashNone {
+			hashSequence = *cache.HashSequence
+		}
+	}
+	if baseType != crypto.SigHashSingle && baseType != crypto.SigHashNone {
+		hashOutputs = *cache.HashOutputs
+	} else if baseType == crypto.SigHashSingle && nIn < len(tx.Outs) {
+		buf := bytes.NewBuffer(make([]byte, 0, tx.Outs[nIn].SerializeSize()))
+		if err := tx.Outs[nIn].Serialize(buf); err != nil {
+			return utils.Hash{}, err
+		}
+		hashOutputs = crypto.DoubleSha256Hash(buf.Bytes())
+	}
+
+	txIn := tx.Ins[nIn]
+	buf := bytes.NewBuffer(make([]byte, 0, 156+script.Size()))
+	utils.BinarySerializer.PutUint32(buf, binary.LittleEndian, uint32(tx.Version))
+	buf.Write(hashPrevouts[:])
+	buf.Write(hashSequence[:])
+	buf.Write(txIn.PreviousOutPoint.Hash[:])
+	utils.BinarySerializer.PutUint32(buf, binary.LittleEndian, txIn.PreviousOutPoint.Index)
+	if spent.TokenData != nil {
+		if err := spent.TokenData.Serialize(buf); err != nil {
+			return utils.Hash{}, err
+		}
+	}
+	if err := utils.WriteVarBytes(buf, script.bytes); err != nil {
+		return utils.Hash{}, err
+	}
+	utils.BinarySerializer.PutUint64(buf, binary.LittleEndian, uint64(spent.Value))
+	utils.BinarySerializer.PutUint32(buf, binary.LittleEndian, txIn.Sequence)
+	buf.Write(hashOutputs[:])
+	utils.BinarySerializer.PutUint32(buf, binary.LittleEndian, tx.LockTime)
+	utils.BinarySerializer.PutUint32(buf, binary.LittleEndian, hashType)
+	return crypto.DoubleSha256Hash(buf.Bytes()), nil
+}
@@ -11,6 +11,8 @@ const (
 	RejectDust                 = 0x41
 	RejectInsufficientFee      = 0x42
 	RejectCheckPoint           = 0x43
+	// RejectAgainstFinalized is internal only, it is never sent to peers
+	RejectAgainstFinalized = 0x103
 )
 
 const (
@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"bytes"
+
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/secp256k1-go/secp256k1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// CompactSignatureLen is the size of a compact recoverable signature: a
+	// header byte followed by R and S.
+	CompactSignatureLen = 65
+
+	// compactHeaderBase is added to the recovery id in the header byte, and
+	// compactHeaderCompressed when the public key is compressed.
+	compactHeaderBase       = 27
+	compactHeaderCompressed = 4
+)
+
+// MessageMagic is prefixed to signed messages, so that a signature of a
+// message can not be mistaken for the one of a transaction.
+const MessageMagic = "Bitcoin Signed Message:\n"
+
+var errInvalidCompactSignature = errors.New("invalid compact signature")
+
+// SignCompact signs hash with privKey, and returns a compact signature from
+// which the public key can be recovered.
+func SignCompact(privKey *PrivateKey, hash []byte) ([]byte, error) {
+	_, signature, err := secp256k1.EcdsaSignRecoverable(secp256k1Context, hash, privKey.bytes)
+	if err != nil {
+		return nil, err
+	}
+	_, serialized, recid, err := secp256k1.EcdsaRecoverableSignatureSerializeCompact(secp256k1Context, signature)
+	if err != nil {
+		return nil, err
+	}
+	header := byte(compactHeaderBase + recid)
+	if privKey.compressed {
+		header += compactHeaderCompressed
+	}
+	return append([]byte{header}, serialized...), nil
+}
+
+// RecoverCompact returns the public key whose private key signed hash with
+// the compact signature sig. The key is compressed if it was when signing.
+func RecoverCompact(sig []byte, hash []byte) (*PublicKey, error) {
+	if len(sig) != CompactSignatureLen {
+		return nil, errInvalidCompactSignature
+	}
+	header := int(sig[0]) - compactHeaderBase
+	if header < 0 || header > 7 {
+		return nil, errInvalidCompactSignature
+	}
+	compressed := header&compactHeaderCompressed != 0
+	recid := header &^ compactHeaderCompressed
+	_, signature, err := secp256k1.EcdsaRecoverableSignatureParseCompact(secp256k1Context, sig[1:], recid)
+	if err != nil {
+		return nil, errInvalidCompactSignature
+	}
+	_, pubKey, err := secp256k1.EcdsaRecover(secp256k1Context, signature, hash)
+	if err != nil || pubKey == nil {
+		return nil, errInvalidCompactSignature
+	}
+	return &PublicKey{SecpPubKey: pubKey, Compressed: compressed}, nil
+}
+
+// MessageHash returns the hash signed for message: the double SHA256 of the
+// message magic and the message, both prefixed with their length.
+func MessageHash(message string) []byte {
+	var buf bytes.Buffer
+	utils.WriteVarString(&buf, MessageMagic)
+	utils.WriteVarString(&buf, message)
+	return DoubleSha256Bytes(buf.Bytes())
+}
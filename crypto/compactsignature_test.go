@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utils/base58"
+)
+
+func TestSignCompact(t *testing.T) {
+	// The testnet key and address of the signmessage functional test.
+	secret, _, err := base58.CheckDecode("cUeKHd5orzT3mz8P9pxyREHfsWtVfgsfDjiZZBcjUBAaGk1BTj7N")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := NewPrivateKey(secret[:PrivateKeyBytesLen], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, _, err := base58.CheckDecode("mpLQjfK79b7CCV4VMJWEWAj5Mpx8Up5zxB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := MessageHash("This is just a test message")
+
+	sig, err := SignCompact(privKey, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "INbVnW4e6PeRmsv2Qgu8NuopvrVjkcxob+sX8OcZG0SALhWybUjzMLPdAsXI46YZGb0KQTRii+wWIQzRpG/U+S0="
+	if encoded := base64.StdEncoding.EncodeToString(sig); encoded != expected {
+		t.Errorf("expected the signature %s, got %s", expected, encoded)
+	}
+
+	pubKey, err := RecoverCompact(sig, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pubKey.Compressed || !bytes.Equal(utils.Hash160(pubKey.ToBytes()), keyID) {
+		t.Errorf("the recovered key should be the signing one")
+	}
+	if pubKey, err := RecoverCompact(sig, MessageHash("another message")); err == nil &&
+		bytes.Equal(utils.Hash160(pubKey.ToBytes()), keyID) {
+		t.Errorf("the signature should not recover the key for another message")
+	}
+
+	// The header records whether the key is compressed.
+	uncompressed, err := NewPrivateKey(secret[:PrivateKeyBytesLen], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err = SignCompact(uncompressed, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pubKey, err := RecoverCompact(sig, hash); err != nil || pubKey.Compressed {
+		t.Errorf("the recovered key should be uncompressed")
+	}
+
+	if _, err := RecoverCompact(sig[1:], hash); err == nil {
+		t.Errorf("short signatures should be refused")
+	}
+	sig[0] = 26
+	if _, err := RecoverCompact(sig, hash); err == nil {
+		t.Errorf("invalid headers should be refused")
+	}
+}
@@ -9,6 +9,7 @@ const (
 	SigHashAll          = 1
 	SigHashNone         = 2
 	SigHashSingle       = 3
+	SigHashForkID       = 0x40
 	SigHashAnyoneCanpay = 128
 )
 
@@ -100,6 +101,20 @@ const (
 	// Do we accept signature using SigHashForkID
 	//
 	ScriptEnableSigHashForkID = 1 << 16
+
+	// Enable the native introspection opcodes (OP_INPUTINDEX ... OP_OUTPUTBYTECODE)
+	//
+	ScriptEnableNativeIntrospection = 1 << 17
+
+	// Script numbers may be up to 8 bytes long and arithmetic is checked for
+	// overflow
+	//
+	ScriptEnable64BitIntegers = 1 << 18
+
+	// Outputs may carry CashTokens and the token introspection opcodes are
+	// enabled
+	//
+	ScriptEnableTokens = 1 << 19
 )
 
 type Signature secp256k1.EcdsaSignature
@@ -228,8 +243,9 @@ func IsLowDERSignature(vchSig []byte) (bool, error) {
 	if !IsValidSignatureEncoding(vchSig) {
 		return false, ScriptErr(ScriptErrSigDer)
 	}
+	// The hashtype byte is not part of the DER encoding.
 	var vchCopy []byte
-	vchCopy = append(vchCopy, vchSig[:]...)
+	vchCopy = append(vchCopy, vchSig[:len(vchSig)-1]...)
 	ret := CheckLowS(vchCopy)
 	if !ret {
 		return false, ScriptErr(ScriptErrSigHighs)
@@ -238,13 +254,16 @@ func IsLowDERSignature(vchSig []byte) (bool, error) {
 
 }
 
+// CheckLowS reports whether the DER encoded signature vchSig has an S value
+// no higher than half the curve order.
 func CheckLowS(vchSig []byte) bool {
-	ret, sig, err := secp256k1.EcdsaSignatureParseCompact(secp256k1Context, vchSig)
+	ret, sig, err := secp256k1.EcdsaSignatureParseDer(secp256k1Context, vchSig)
 	if ret != 1 || err != nil {
 		return false
 	}
+	// Normalize returns 1 only when it had to lower S.
 	ret, err = secp256k1.EcdsaSignatureNormalize(secp256k1Context, nil, sig)
-	if ret != 1 || err != nil {
+	if ret != 0 || err != nil {
 		return false
 	}
 
@@ -255,7 +274,7 @@ func IsDefineHashtypeSignature(vchSig []byte) bool {
 	if len(vchSig) == 0 {
 		return false
 	}
-	nHashType := vchSig[len(vchSig)-1] & (^byte(SigHashAnyoneCanpay))
+	nHashType := vchSig[len(vchSig)-1] & (^byte(SigHashAnyoneCanpay | SigHashForkID))
 	if nHashType < SigHashAll || nHashType > SigHashSingle {
 		return false
 	}
@@ -48,6 +48,24 @@ func TestCheckSignatureEncoding(t *testing.T) {
 	}
 }
 
+func TestIsLowDERSignature(t *testing.T) {
+	if ok, err := IsLowDERSignature(validSig); ok || err == nil {
+		t.Error("the S value of the test signature is high")
+	}
+
+	// The same signature with S replaced by N - S, which needs no padding.
+	lowSig := append([]byte{0x30, 0x45}, validSig[2:37]...)
+	lowSig = append(lowSig, 0x02, 0x20,
+		0x7b, 0xf8, 0x6d, 0x43, 0xe0, 0xba, 0x9f, 0x9d,
+		0x7e, 0x60, 0xea, 0x2c, 0xc1, 0x18, 0xfa, 0xa1,
+		0xc2, 0xf8, 0xee, 0xcb, 0xbd, 0x5c, 0xd3, 0xdb,
+		0x96, 0xf8, 0x90, 0xdb, 0x0c, 0x86, 0xc9, 0xf9,
+		0x01)
+	if ok, err := IsLowDERSignature(lowSig); !ok || err != nil {
+		t.Error("the S value of the normalized signature is low, ", err)
+	}
+}
+
 func TestParseSignature(t *testing.T) {
 	sig := validSig[:len(validSig)-1]
 	signature, err := ParseDERSignature(sig)
@@ -29,6 +29,43 @@ func PrivateKeyFromBytes(privateKeyBytes []byte) *PrivateKey {
 	return &privateKey
 }
 
+// NewPrivateKey returns the private key of a 32 bytes secret, which must be
+// a valid secp256k1 scalar.
+func NewPrivateKey(privateKeyBytes []byte, compressed bool) (*PrivateKey, error) {
+	if len(privateKeyBytes) != PrivateKeyBytesLen {
+		return nil, errors.New("Wrong number of bytes a private key , not 32")
+	}
+	if ret, err := secp256k1.EcSeckeyVerify(secp256k1Context, privateKeyBytes); ret != 1 {
+		return nil, errors.Errorf("invalid private key: %v", err)
+	}
+	bytes := make([]byte, PrivateKeyBytesLen)
+	copy(bytes, privateKeyBytes)
+	privateKey := PrivateKey{version: DumpedPrivateKeyVersion, bytes: bytes, compressed: compressed}
+	return &privateKey, nil
+}
+
+// Bytes returns a copy of the 32 bytes secret of the key.
+func (privateKey *PrivateKey) Bytes() []byte {
+	bytes := make([]byte, len(privateKey.bytes))
+	copy(bytes, privateKey.bytes)
+	return bytes
+}
+
+// IsCompressed returns whether the public key of the key is compressed.
+func (privateKey *PrivateKey) IsCompressed() bool {
+	return privateKey.compressed
+}
+
+// TweakAdd returns the key whose secret is the one of privateKey plus tweak.
+func (privateKey *PrivateKey) TweakAdd(tweak []byte) (*PrivateKey, error) {
+	bytes := privateKey.Bytes()
+	if ret, err := secp256k1.EcPrivkeyTweakAdd(secp256k1Context, bytes, tweak); ret != 1 {
+		return nil, errors.Errorf("invalid private key tweak: %v", err)
+	}
+	tweaked := PrivateKey{version: privateKey.version, bytes: bytes, compressed: privateKey.compressed}
+	return &tweaked, nil
+}
+
 func (privateKey *PrivateKey) PubKey() *PublicKey {
 	_, secp256k1PublicKey, err := secp256k1.EcPubkeyCreate(secp256k1Context, privateKey.bytes)
 	if err != nil {
@@ -56,6 +56,20 @@ func (publicKey *PublicKey) SerializeCompressed() []byte {
 	return serializedComp
 }
 
+// TweakAdd returns the key whose point is the one of publicKey plus tweak
+// times the generator.
+func (publicKey *PublicKey) TweakAdd(tweak []byte) (*PublicKey, error) {
+	_, pubKey, err := secp256k1.EcPubkeyParse(secp256k1Context, publicKey.SerializeCompressed())
+	if err != nil {
+		return nil, err
+	}
+	if ret, err := secp256k1.EcPubkeyTweakAdd(secp256k1Context, pubKey, tweak); ret != 1 {
+		return nil, errors.Errorf("invalid public key tweak: %v", err)
+	}
+	tweaked := PublicKey{SecpPubKey: pubKey, Compressed: publicKey.Compressed}
+	return &tweaked, nil
+}
+
 func (publicKey *PublicKey) IsEqual(otherPublicKey *PublicKey) bool {
 	publicKeyBytes := publicKey.SerializeUncompressed()
 	otherBytes := otherPublicKey.SerializeUncompressed()
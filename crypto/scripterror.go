@@ -75,6 +75,16 @@ const (
 	/* misc */
 
 	ScriptErrNonCompressedPubKey
+
+	/* native introspection */
+
+	ScriptErrContextNotPresent
+	ScriptErrInvalidTxInputIndex
+	ScriptErrInvalidTxOutputIndex
+
+	/* 64-bit integers */
+
+	ScriptErrNumberOverflow
 )
 
 func ScriptErrorString(scriptError ScriptError) string {
@@ -157,6 +167,14 @@ func ScriptErrorString(scriptError ScriptError) string {
 		return "Witness provided for non-witness script"
 	case ScriptErrWitnessPubKeyType:
 		return "Using non-compressed keys in segWit"
+	case ScriptErrContextNotPresent:
+		return "Script execution context is not present"
+	case ScriptErrInvalidTxInputIndex:
+		return "Specified transaction input index is out of range"
+	case ScriptErrInvalidTxOutputIndex:
+		return "Specified transaction output index is out of range"
+	case ScriptErrNumberOverflow:
+		return "Script number arithmetic result is outside of the range [-2^63 + 1, 2^63 - 1]"
 	case ScriptErrUnknownError:
 	case ScriptErrErrorCount:
 	default:
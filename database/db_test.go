@@ -113,6 +113,18 @@ func TestDBWrapperBatch(t *testing.T) {
 		if dbw.Exists(key3) {
 			t.Fatalf("shouldn't read out key 'k' value")
 		}
+
+		// Short values share no buffer with the keys.
+		batch = NewBatchWrapper(dbw)
+		batch.Write([]byte("key1"), []byte("value1"))
+		batch.Write([]byte("key2"), []byte("value2"))
+		dbw.WriteBatch(batch, false)
+		for _, k := range []string{"key1", "key2"} {
+			res, err := dbw.Read([]byte(k))
+			if err != nil || string(res) != "value"+k[3:] {
+				t.Fatalf("should read back key %s value, got %q: %v", k, res, err)
+			}
+		}
 	}
 }
 
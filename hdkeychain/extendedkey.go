@@ -0,0 +1,345 @@
+// Package hdkeychain implements the BIP32 hierarchical deterministic keys:
+// extended keys are derived from a seed, then from each other, and
+// serialized in base58 with the HD version bytes of a network.
+package hdkeychain
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utils/base58"
+	"github.com/pkg/errors"
+)
+
+const (
+	// HardenedKeyStart is the index of the first hardened child key.
+	HardenedKeyStart = 0x80000000
+
+	// MinSeedBytes and MaxSeedBytes bound the length of a master seed.
+	MinSeedBytes = 16
+	MaxSeedBytes = 64
+
+	// RecommendedSeedLen is the seed length in bytes GenerateSeed is
+	// usually called with.
+	RecommendedSeedLen = 32
+
+	// serializedKeyLen is the length of a serialized extended key: version,
+	// depth, parent fingerprint, child index, chain code and key.
+	serializedKeyLen = 4 + 1 + 4 + 4 + 32 + 33
+)
+
+var (
+	ErrInvalidSeedLen     = errors.New("seed length must be between 128 and 512 bits")
+	ErrUnusableSeed       = errors.New("unusable seed")
+	ErrInvalidChild       = errors.New("the extended key at this index is invalid")
+	ErrDeriveHardFromPub  = errors.New("cannot derive a hardened key from a public key")
+	ErrNotPrivExtKey      = errors.New("unable to create private keys from a public extended key")
+	ErrMaxDepthExceeded   = errors.New("cannot derive a key with more than 255 indices in its path")
+	ErrInvalidKeyLen      = errors.New("the provided serialized extended key length is invalid")
+	ErrBadChecksum        = errors.New("bad extended key checksum")
+	ErrUnknownHDKeyID     = errors.New("unknown hd extended key version")
+	ErrInvalidPrivateKey  = errors.New("invalid private key of an extended key")
+	ErrInvalidPublicKey   = errors.New("invalid public key of an extended key")
+	ErrWrongNetworkPrefix = errors.New("extended key is not of the expected network")
+)
+
+// masterKey is the HMAC key of the master key derivation.
+var masterKey = []byte("Bitcoin seed")
+
+// ExtendedKey is a private or public BIP32 extended key.
+type ExtendedKey struct {
+	version   [4]byte
+	key       []byte // 32 bytes secret or 33 bytes compressed public key
+	pubKey    []byte // compressed public key, computed on demand
+	chainCode []byte
+	parentFP  [4]byte
+	depth     uint8
+	childNum  uint32
+	isPrivate bool
+}
+
+func newExtendedKey(version [4]byte, key, chainCode []byte, parentFP [4]byte, depth uint8,
+	childNum uint32, isPrivate bool) *ExtendedKey {
+	return &ExtendedKey{
+		version:   version,
+		key:       key,
+		chainCode: chainCode,
+		parentFP:  parentFP,
+		depth:     depth,
+		childNum:  childNum,
+		isPrivate: isPrivate,
+	}
+}
+
+// NewMaster returns the master private key of seed, to be serialized with
+// the HD version bytes of params.
+func NewMaster(seed []byte, params *msg.BitcoinParams) (*ExtendedKey, error) {
+	if len(seed) < MinSeedBytes || len(seed) > MaxSeedBytes {
+		return nil, ErrInvalidSeedLen
+	}
+	mac := hmac.New(sha512.New, masterKey)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	secret, chainCode := sum[:32], sum[32:]
+	if _, err := crypto.NewPrivateKey(secret, true); err != nil {
+		return nil, ErrUnusableSeed
+	}
+	return newExtendedKey(params.HDPrivateKeyID, secret, chainCode, [4]byte{}, 0, 0, true), nil
+}
+
+// IsPrivate returns whether the key can derive private keys.
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.isPrivate
+}
+
+// Depth returns the number of derivations from the master key.
+func (k *ExtendedKey) Depth() uint8 {
+	return k.depth
+}
+
+// ChildIndex returns the index the key was derived at.
+func (k *ExtendedKey) ChildIndex() uint32 {
+	return k.childNum
+}
+
+// ParentFingerprint returns the fingerprint of the parent key, zero for the
+// master key.
+func (k *ExtendedKey) ParentFingerprint() [4]byte {
+	return k.parentFP
+}
+
+// ChainCode returns a copy of the chain code of the key.
+func (k *ExtendedKey) ChainCode() []byte {
+	return append([]byte{}, k.chainCode...)
+}
+
+// Version returns the HD version bytes the key is serialized with.
+func (k *ExtendedKey) Version() [4]byte {
+	return k.version
+}
+
+// pubKeyBytes returns the compressed public key of the key.
+func (k *ExtendedKey) pubKeyBytes() []byte {
+	if !k.isPrivate {
+		return k.key
+	}
+	if k.pubKey == nil {
+		key, _ := crypto.NewPrivateKey(k.key, true)
+		k.pubKey = key.PubKey().ToBytes()
+	}
+	return k.pubKey
+}
+
+// Fingerprint returns the first 4 bytes of the hash160 of the public key,
+// which identifies the key in the serialization of its children.
+func (k *ExtendedKey) Fingerprint() [4]byte {
+	var fp [4]byte
+	copy(fp[:], utils.Hash160(k.pubKeyBytes()))
+	return fp
+}
+
+// Child returns the child key at index i, hardened if i is at least
+// HardenedKeyStart. ErrInvalidChild is returned for the rare indexes which
+// have no valid key: callers should skip to the next index.
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	if k.depth == 255 {
+		return nil, ErrMaxDepthExceeded
+	}
+	isHardened := i >= HardenedKeyStart
+	if isHardened && !k.isPrivate {
+		return nil, ErrDeriveHardFromPub
+	}
+
+	// Hardened children commit to the secret, others to the public key only.
+	data := make([]byte, 0, 37)
+	if isHardened {
+		data = append(append(data, 0), k.key...)
+	} else {
+		data = append(data, k.pubKeyBytes()...)
+	}
+	var index [4]byte
+	binary.BigEndian.PutUint32(index[:], i)
+	data = append(data, index[:]...)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+	tweak, chainCode := sum[:32], sum[32:]
+
+	var childKey []byte
+	if k.isPrivate {
+		key, err := crypto.NewPrivateKey(k.key, true)
+		if err != nil {
+			return nil, ErrInvalidPrivateKey
+		}
+		child, err := key.TweakAdd(tweak)
+		if err != nil {
+			return nil, ErrInvalidChild
+		}
+		childKey = child.Bytes()
+	} else {
+		pubKey, err := crypto.ParsePubKey(k.key)
+		if err != nil {
+			return nil, ErrInvalidPublicKey
+		}
+		pubKey.Compressed = true
+		child, err := pubKey.TweakAdd(tweak)
+		if err != nil {
+			return nil, ErrInvalidChild
+		}
+		childKey = child.ToBytes()
+	}
+	return newExtendedKey(k.version, childKey, chainCode, k.Fingerprint(), k.depth+1, i, k.isPrivate), nil
+}
+
+// Derive returns the key at path, relative to k.
+func (k *ExtendedKey) Derive(path []uint32) (*ExtendedKey, error) {
+	key := k
+	for _, i := range path {
+		child, err := key.Child(i)
+		if err != nil {
+			return nil, err
+		}
+		key = child
+	}
+	return key, nil
+}
+
+// Neuter returns the public extended key of k, serialized with the HD public
+// version bytes matching the private ones of k.
+func (k *ExtendedKey) Neuter() (*ExtendedKey, error) {
+	if !k.isPrivate {
+		return k, nil
+	}
+	id, err := msg.HDPrivateKeyToPublicKeyID(k.version[:])
+	if err != nil {
+		return nil, err
+	}
+	var version [4]byte
+	copy(version[:], id)
+	return newExtendedKey(version, k.pubKeyBytes(), k.chainCode, k.parentFP, k.depth, k.childNum, false), nil
+}
+
+// ECPrivKey returns the private key of a private extended key, with a
+// compressed public key.
+func (k *ExtendedKey) ECPrivKey() (*crypto.PrivateKey, error) {
+	if !k.isPrivate {
+		return nil, ErrNotPrivExtKey
+	}
+	return crypto.NewPrivateKey(k.key, true)
+}
+
+// ECPubKey returns the compressed public key of the extended key.
+func (k *ExtendedKey) ECPubKey() (*crypto.PublicKey, error) {
+	pubKey, err := crypto.ParsePubKey(k.pubKeyBytes())
+	if err != nil {
+		return nil, err
+	}
+	pubKey.Compressed = true
+	return pubKey, nil
+}
+
+// Address returns the P2PKH address of the key on the network of params.
+func (k *ExtendedKey) Address(params *msg.BitcoinParams) (*core.Address, error) {
+	return core.AddressFromHash160(utils.Hash160(k.pubKeyBytes()), params.PubKeyHashAddressID)
+}
+
+// String returns the base58 serialization of the key, such as xprv... or
+// xpub... on mainnet.
+func (k *ExtendedKey) String() string {
+	serialized := make([]byte, 0, serializedKeyLen+4)
+	serialized = append(serialized, k.version[:]...)
+	serialized = append(serialized, k.depth)
+	serialized = append(serialized, k.parentFP[:]...)
+	var index [4]byte
+	binary.BigEndian.PutUint32(index[:], k.childNum)
+	serialized = append(serialized, index[:]...)
+	serialized = append(serialized, k.chainCode...)
+	if k.isPrivate {
+		serialized = append(append(serialized, 0), k.key...)
+	} else {
+		serialized = append(serialized, k.key...)
+	}
+	checkSum := crypto.DoubleSha256Bytes(serialized)
+	return base58.Encode(append(serialized, checkSum[:4]...))
+}
+
+// NewKeyFromString decodes a base58 extended key of any registered network.
+func NewKeyFromString(key string) (*ExtendedKey, error) {
+	decoded := base58.Decode(key)
+	if len(decoded) != serializedKeyLen+4 {
+		return nil, ErrInvalidKeyLen
+	}
+	payload, checkSum := decoded[:serializedKeyLen], decoded[serializedKeyLen:]
+	if expected := crypto.DoubleSha256Bytes(payload); !bytes.Equal(expected[:4], checkSum) {
+		return nil, ErrBadChecksum
+	}
+
+	var version, parentFP [4]byte
+	copy(version[:], payload[:4])
+	depth := payload[4]
+	copy(parentFP[:], payload[5:9])
+	childNum := binary.BigEndian.Uint32(payload[9:13])
+	chainCode := append([]byte{}, payload[13:45]...)
+	keyData := payload[45:78]
+
+	isPrivate := keyData[0] == 0
+	if isPrivate {
+		if _, err := msg.HDPrivateKeyToPublicKeyID(version[:]); err != nil {
+			return nil, ErrUnknownHDKeyID
+		}
+		keyData = append([]byte{}, keyData[1:]...)
+		if _, err := crypto.NewPrivateKey(keyData, true); err != nil {
+			return nil, ErrInvalidPrivateKey
+		}
+	} else {
+		if !isHDPublicKeyID(version) {
+			return nil, ErrUnknownHDKeyID
+		}
+		if !crypto.IsCompressedPubKey(keyData) {
+			return nil, ErrInvalidPublicKey
+		}
+		keyData = append([]byte{}, keyData...)
+		if _, err := crypto.ParsePubKey(keyData); err != nil {
+			return nil, ErrInvalidPublicKey
+		}
+	}
+	if depth == 0 && (parentFP != [4]byte{} || childNum != 0) {
+		return nil, errors.New("master extended key with a parent")
+	}
+	return newExtendedKey(version, keyData, chainCode, parentFP, depth, childNum, isPrivate), nil
+}
+
+// NewKeyFromStringForNet decodes a base58 extended key, which must be of the
+// network of params.
+func NewKeyFromStringForNet(key string, params *msg.BitcoinParams) (*ExtendedKey, error) {
+	extendedKey, err := NewKeyFromString(key)
+	if err != nil {
+		return nil, err
+	}
+	if !extendedKey.IsForNet(params) {
+		return nil, ErrWrongNetworkPrefix
+	}
+	return extendedKey, nil
+}
+
+// IsForNet returns whether the key is serialized with the HD version bytes
+// of params.
+func (k *ExtendedKey) IsForNet(params *msg.BitcoinParams) bool {
+	return k.version == params.HDPrivateKeyID || k.version == params.HDPublicKeyID
+}
+
+func isHDPublicKeyID(version [4]byte) bool {
+	for _, id := range msg.HDPrivateToPublicKeyIDs {
+		if bytes.Equal(id, version[:]) {
+			return true
+		}
+	}
+	return false
+}
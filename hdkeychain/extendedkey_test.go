@@ -0,0 +1,107 @@
+package hdkeychain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcboost/copernicus/net/msg"
+)
+
+// TestBIP32Vector checks the first test vector of BIP32.
+func TestBIP32Vector(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	tests := []struct {
+		path string
+		priv string
+		pub  string
+	}{
+		{"m",
+			"xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi",
+			"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8"},
+		{"m/0'",
+			"xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7",
+			"xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"},
+		{"m/0'/1/2'/2/1000000000",
+			"xprvA41z7zogVVwxVSgdKUHDy1SKmdb533PjDz7J6N6mV6uS3ze1ai8FHa8kmHScGpWmj4WggLyQjgPie1rFSruoUihUZREPSL39UNdE3BBDu76",
+			"xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy"},
+	}
+
+	master, err := NewMaster(seed, &msg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		key, err := master.DerivePath(test.path)
+		if err != nil {
+			t.Fatalf("%s: %s", test.path, err)
+		}
+		if key.String() != test.priv {
+			t.Errorf("%s: expected %s, got %s", test.path, test.priv, key.String())
+		}
+		pub, err := key.Neuter()
+		if err != nil || pub.String() != test.pub {
+			t.Errorf("%s: expected %s, got %s (%v)", test.path, test.pub, pub, err)
+		}
+
+		for _, str := range []string{test.priv, test.pub} {
+			decoded, err := NewKeyFromStringForNet(str, &msg.MainNetParams)
+			if err != nil || decoded.String() != str {
+				t.Errorf("%s: failed to roundtrip %s: %v", test.path, str, err)
+			}
+		}
+	}
+
+	// Public derivation of normal children matches the private one.
+	account, _ := master.DerivePath("m/0'")
+	xpub, _ := account.Neuter()
+	fromPub, err := xpub.DerivePath("1/5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromPriv, _ := account.DerivePath("m/1/5")
+	fromPrivPub, _ := fromPriv.Neuter()
+	if fromPub.String() != fromPrivPub.String() {
+		t.Errorf("public derivation mismatch: %s != %s", fromPub, fromPrivPub)
+	}
+	addr, _ := fromPub.Address(&msg.MainNetParams)
+	privAddr, _ := fromPriv.Address(&msg.MainNetParams)
+	if addr.String() != privAddr.String() {
+		t.Errorf("address mismatch: %s != %s", addr, privAddr)
+	}
+	if _, err := xpub.Child(HardenedKeyStart); err != ErrDeriveHardFromPub {
+		t.Errorf("hardened derivation from a public key should fail, got %v", err)
+	}
+	if _, err := NewKeyFromStringForNet(tests[0].pub, &msg.TestNet3Params); err != ErrWrongNetworkPrefix {
+		t.Errorf("a mainnet key should be refused on testnet, got %v", err)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		indexes []uint32
+		ok      bool
+	}{
+		{"m/44'/145'/0'/0/5", []uint32{HardenedKeyStart + 44, HardenedKeyStart + 145, HardenedKeyStart, 0, 5}, true},
+		{"m", []uint32{}, true},
+		{"0/1h", []uint32{0, HardenedKeyStart + 1}, true},
+		{"m/", nil, false},
+		{"m/2147483648", nil, false},
+		{"m/x", nil, false},
+	}
+	for _, test := range tests {
+		indexes, err := ParsePath(test.path)
+		if (err == nil) != test.ok || len(indexes) != len(test.indexes) {
+			t.Errorf("%s: expected %v, got %v (%v)", test.path, test.indexes, indexes, err)
+			continue
+		}
+		for i := range indexes {
+			if indexes[i] != test.indexes[i] {
+				t.Errorf("%s: expected %v, got %v", test.path, test.indexes, indexes)
+			}
+		}
+		if test.ok && test.path[0] == 'm' && FormatPath(indexes) != test.path {
+			t.Errorf("%s: formatted as %s", test.path, FormatPath(indexes))
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package hdkeychain
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GenerateSeed returns a random seed of length bytes, to make a master key of.
+func GenerateSeed(length uint8) ([]byte, error) {
+	if length < MinSeedBytes || length > MaxSeedBytes {
+		return nil, ErrInvalidSeedLen
+	}
+	seed := make([]byte, length)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	return seed, nil
+}
+
+// ParsePath parses a derivation path such as m/44'/145'/0'/0/5 into child
+// indexes. Hardened indexes are marked with ' or h. The leading m is
+// optional, so that paths relative to a key such as 0/5 parse too.
+func ParsePath(path string) ([]uint32, error) {
+	path = strings.TrimSpace(path)
+	elements := strings.Split(path, "/")
+	if elements[0] == "m" || elements[0] == "M" {
+		elements = elements[1:]
+	}
+	indexes := make([]uint32, 0, len(elements))
+	for _, element := range elements {
+		if element == "" {
+			return nil, errors.Errorf("invalid derivation path %s: empty index", path)
+		}
+		hardened := strings.HasSuffix(element, "'") || strings.HasSuffix(element, "h") ||
+			strings.HasSuffix(element, "H")
+		if hardened {
+			element = element[:len(element)-1]
+		}
+		index, err := strconv.ParseUint(element, 10, 32)
+		if err != nil || index >= HardenedKeyStart {
+			return nil, errors.Errorf("invalid derivation path %s: bad index %s", path, element)
+		}
+		if hardened {
+			index += HardenedKeyStart
+		}
+		indexes = append(indexes, uint32(index))
+	}
+	return indexes, nil
+}
+
+// FormatPath returns the derivation path of indexes, as ParsePath reads it.
+func FormatPath(indexes []uint32) string {
+	elements := make([]string, 0, len(indexes)+1)
+	elements = append(elements, "m")
+	for _, index := range indexes {
+		if index >= HardenedKeyStart {
+			elements = append(elements, fmt.Sprintf("%d'", index-HardenedKeyStart))
+		} else {
+			elements = append(elements, strconv.FormatUint(uint64(index), 10))
+		}
+	}
+	return strings.Join(elements, "/")
+}
+
+// DerivePath returns the key at the textual path, relative to k.
+func (k *ExtendedKey) DerivePath(path string) (*ExtendedKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return k.Derive(indexes)
+}
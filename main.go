@@ -4,11 +4,16 @@ package main
 // or you will get an error log output.
 import (
 	"fmt"
+	"github.com/btcboost/copernicus/blockchain"
 	"github.com/btcboost/copernicus/conf"
+	"github.com/btcboost/copernicus/consensus"
+	"github.com/btcboost/copernicus/core"
 	"github.com/btcboost/copernicus/database"
 	"github.com/btcboost/copernicus/net/msg"
 	"github.com/btcboost/copernicus/net/p2p"
+	"github.com/btcboost/copernicus/rpc"
 	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/wallet"
 	"os"
 	"syscall"
 
@@ -29,6 +34,7 @@ func btcMain() error {
 
 func main() {
 	logs.Info("application is running")
+	utils.ParseParameters(len(os.Args)-1, os.Args[1:])
 	startBitcoin()
 	if err := btcMain(); err != nil {
 		os.Exit(1)
@@ -36,6 +42,22 @@ func main() {
 }
 
 func startBitcoin() error {
+	if err := msg.InitChainParams(); err != nil {
+		logs.Error(err.Error())
+		return err
+	}
+	core.InitScriptCaches()
+	blockchain.InitScriptCheckQueue()
+	blockchain.GCheckpointsEnabled = utils.GetBoolArg("-checkpoints", consensus.DefaultCheckPointsEnabled)
+	blockchain.GfReindex = utils.GetBoolArg("-reindex", false)
+	if err := blockchain.InitAssumeValid(msg.ActiveNetParams); err != nil {
+		logs.Error(err.Error())
+		return err
+	}
+	if err := blockchain.InitBlockSizeLimits(); err != nil {
+		logs.Error(err.Error())
+		return err
+	}
 	path := conf.AppConf.DataDir + "/peer"
 	exists := utils.PathExists(path)
 	if !exists {
@@ -67,5 +89,41 @@ func startBitcoin() error {
 
 	peerManager.Start()
 
+	if err := blockchain.StartTxIndex(msg.ActiveNetParams); err != nil {
+		fmt.Printf("unable to start the transaction index: %v \n", err)
+		return err
+	}
+
+	if !utils.GetBoolArg("-disablewallet", false) {
+		w, err := wallet.Open(conf.AppConf.DataDir+"/wallet", msg.ActiveNetParams)
+		if err != nil {
+			fmt.Printf("unable to open the wallet: %v \n", err)
+			return err
+		}
+		if w.Restored() {
+			if _, err := w.Rescan(0, -1); err != nil {
+				fmt.Printf("unable to rescan the restored wallet: %v \n", err)
+				return err
+			}
+		}
+		wallet.GWallet = w
+		blockchain.RegisterValidationInterface(w)
+		fmt.Println("Wallet Init")
+	}
+
+	rpcUser, rpcPassword := utils.GetArgString("-rpcuser", ""), utils.GetArgString("-rpcpassword", "")
+	if rpcPassword == "" {
+		if rpcUser, rpcPassword, err = rpc.GenerateAuthCookie(conf.AppConf.DataDir); err != nil {
+			fmt.Printf("unable to write the rpc auth cookie: %v \n", err)
+			return err
+		}
+	}
+	rpcServer := rpc.NewServer(fmt.Sprintf("%s:%d", conf.Cfg.RPC.Host, conf.Cfg.RPC.Port), rpcUser, rpcPassword)
+	if err := rpcServer.Start(); err != nil {
+		fmt.Printf("unable to start rpc server: %v \n", err)
+		return err
+	}
+	fmt.Println("RPC server Init")
+
 	return nil
 }
@@ -0,0 +1,37 @@
+package mempool
+
+import (
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utxo"
+)
+
+// CoinsViewMemPool is a view of the coins of a base view and of the outputs
+// of the transactions of the mempool. The caller holds the lock of the
+// mempool while using it.
+type CoinsViewMemPool struct {
+	utxo.CoinsView
+	mpool *TxMempool
+}
+
+func NewCoinsViewMemPool(base utxo.CoinsView, mpool *TxMempool) *CoinsViewMemPool {
+	return &CoinsViewMemPool{CoinsView: base, mpool: mpool}
+}
+
+func (v *CoinsViewMemPool) GetCoin(point *core.OutPoint, coin *utxo.Coin) bool {
+	// If an entry in the mempool exists, always return that one, as it's
+	// guaranteed to never conflict with the underlying cache, and it cannot
+	// have pruned entries (as it contains full) transactions. First checking
+	// the underlying cache risks returning a pruned entry instead.
+	if entry, ok := v.mpool.PoolData[point.Hash]; ok {
+		if int(point.Index) >= len(entry.Tx.Outs) {
+			return false
+		}
+		*coin = utxo.DeepCopyCoin(utxo.NewCoin(entry.Tx.Outs[point.Index], MEMPOOL_HEIGHT, false))
+		return true
+	}
+	return v.CoinsView.GetCoin(point, coin) && !coin.IsSpent()
+}
+
+func (v *CoinsViewMemPool) HaveCoin(point *core.OutPoint) bool {
+	return v.GetCoin(point, utxo.NewEmptyCoin())
+}
@@ -0,0 +1,34 @@
+package mempool
+
+import (
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+)
+
+func TestCoinsViewMemPool(t *testing.T) {
+	base := &utxo.CoinsViewCache{Base: utxo.CoinsViewDummy{}, CacheCoins: make(utxo.CacheCoins)}
+	confirmed := core.NewOutPoint(utils.Hash{1}, 0)
+	base.AddCoin(confirmed, *utxo.NewCoin(core.NewTxOut(10, []byte{0x51}), 7, false), false)
+
+	pool := NewTxMempool()
+	tx := core.NewTx()
+	tx.AddTxIn(core.NewTxIn(confirmed, []byte{0x51}))
+	tx.AddTxOut(core.NewTxOut(9, []byte{0x52}))
+	pool.PoolData[tx.TxHash()] = &TxEntry{Tx: tx}
+
+	view := NewCoinsViewMemPool(base, pool)
+	coin := utxo.NewEmptyCoin()
+	if !view.GetCoin(confirmed, coin) || coin.GetHeight() != 7 || coin.TxOut.Value != 10 {
+		t.Errorf("the coins of the base view should be found")
+	}
+	if !view.GetCoin(core.NewOutPoint(tx.TxHash(), 0), coin) || coin.GetHeight() != MEMPOOL_HEIGHT ||
+		coin.TxOut.Value != 9 {
+		t.Errorf("the outputs of the mempool should be found at the mempool height")
+	}
+	if view.HaveCoin(core.NewOutPoint(tx.TxHash(), 1)) || view.HaveCoin(core.NewOutPoint(utils.Hash{2}, 0)) {
+		t.Errorf("missing outputs should not be found")
+	}
+}
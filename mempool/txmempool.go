@@ -11,6 +11,7 @@ import (
 
 	"github.com/astaxie/beego/logs"
 	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/policy"
 	"github.com/btcboost/copernicus/utils"
 	"github.com/btcboost/copernicus/utxo"
 	"github.com/google/btree"
@@ -58,6 +59,8 @@ type TxMempool struct {
 	totalTxSize uint64
 	//transactionsUpdated mempool update transaction total number when create mempool late.
 	transactionsUpdated uint64
+	// FeeEstimator, if set, stops tracking the transactions leaving the mempool.
+	FeeEstimator *policy.FeeEstimator
 }
 
 func (m *TxMempool) GetCacheUsage() int64 {
@@ -194,7 +197,7 @@ func (m *TxMempool) Check(coins *utxo.CoinsViewCache, bestHeight int) {
 		} else {
 			var state core.ValidationState
 			fCheckResult := entry.Tx.IsCoinBase() ||
-				coins.CheckTxInputs(entry.Tx, &state, bestHeight)
+				coins.CheckTxInputs(entry.Tx, &state, bestHeight, true)
 			if !fCheckResult {
 				panic("the txentry check failed with utxo set...")
 			}
@@ -214,7 +217,7 @@ func (m *TxMempool) Check(coins *utxo.CoinsViewCache, bestHeight int) {
 			}
 		} else {
 			fCheckResult := entry.Tx.IsCoinBase() ||
-				coins.CheckTxInputs(entry.Tx, nil, bestHeight)
+				coins.CheckTxInputs(entry.Tx, nil, bestHeight, true)
 			if !fCheckResult {
 				panic("")
 			}
@@ -390,6 +393,24 @@ func (m *TxMempool) Expire(time int64) int {
 	return len(stage)
 }
 
+// GetSpender returns the transaction of the mempool spending outpoint with
+// the index of its input, or nil if there is none.
+func (m *TxMempool) GetSpender(outpoint *core.OutPoint) (*core.Tx, int) {
+	m.RLock()
+	defer m.RUnlock()
+
+	entry, ok := m.NextTx[*outpoint]
+	if !ok {
+		return nil, -1
+	}
+	for i, txin := range entry.Tx.Ins {
+		if txin.PreviousOutPoint != nil && *txin.PreviousOutPoint == *outpoint {
+			return entry.Tx, i
+		}
+	}
+	return nil, -1
+}
+
 func (m *TxMempool) FindTx(hash utils.Hash) *core.Tx {
 	m.RLock()
 	m.RUnlock()
@@ -672,6 +693,9 @@ func (m *TxMempool) delTxentry(removeEntry *TxEntry, reason PoolRemovalReason) {
 	delete(m.PoolData, removeEntry.Tx.Hash)
 	m.timeSortData.Delete(removeEntry)
 	m.TxByAncestorFeeRateSort.Delete(EntryAncestorFeeRateSort(*removeEntry))
+	if m.FeeEstimator != nil {
+		m.FeeEstimator.RemoveTransaction(removeEntry.Tx.Hash)
+	}
 }
 
 func (m *TxMempool) UpdateTransactionsFromBlock(ele interface{}) {
@@ -1,11 +1,14 @@
 package mining
 
 import (
+	"bytes"
 	"fmt"
 	"math"
+	"sort"
 
 	"github.com/astaxie/beego/logs"
 	"github.com/btcboost/copernicus/blockchain"
+	"github.com/btcboost/copernicus/conf"
 	"github.com/btcboost/copernicus/consensus"
 	"github.com/btcboost/copernicus/core"
 	"github.com/btcboost/copernicus/log"
@@ -117,7 +120,7 @@ func computeMaxGeneratedBlockSize() uint64 {
 	maxGeneratedBlockSize := uint64(utils.GetArg("-blockmaxsize", int64(policy.DefaultMaxGeneratedBlockSize)))
 
 	// Limit size to between 1K and MaxBlockSize-1K for sanity:
-	csize := policy.DefaultMaxBlockSize - 1000
+	csize := conf.GlobalValueInstance.GetMaxBlockSize() - 1000
 	if csize < maxGeneratedBlockSize {
 		maxGeneratedBlockSize = csize
 	}
@@ -215,7 +218,7 @@ func (ba *BlockAssembler) addPackageTxs() int {
 		// This transaction will make it in; reset the failed counter.
 		consecutiveFailed = 0
 		addset := make(map[utils.Hash]mempool.TxEntry)
-		for add := range ancestors {
+		for _, add := range sortForBlock(ancestors) {
 			ba.addToBlock(add)
 			addset[add.Tx.Hash] = *add
 		}
@@ -263,6 +266,9 @@ func (ba *BlockAssembler) CreateNewBlock() *BlockTemplate {
 	}
 
 	descendantsUpdated := ba.addPackageTxs()
+	if indexPrev != nil && blockchain.IsRuleActive(ba.chainParams, consensus.RuleCanonicalTxOrder, indexPrev) {
+		ba.bt.sortByTxID()
+	}
 
 	time1 := utils.GetMockTimeInMicros()
 
@@ -314,6 +320,51 @@ func (ba *BlockAssembler) CreateNewBlock() *BlockTemplate {
 	return ba.bt
 }
 
+// sortForBlock orders a package so that every transaction comes after its
+// ancestors, which have fewer ancestors than it.
+func sortForBlock(entrySet map[*mempool.TxEntry]struct{}) []*mempool.TxEntry {
+	entries := make([]*mempool.TxEntry, 0, len(entrySet))
+	for entry := range entrySet {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].SumTxCountWithAncestors < entries[j].SumTxCountWithAncestors
+	})
+	return entries
+}
+
+// sortByTxID puts the transactions after the coinbase in canonical order,
+// keeping their fees and sigops counts in step.
+func (bt *BlockTemplate) sortByTxID() {
+	if len(bt.Block.Txs) <= 2 {
+		return
+	}
+	order := make([]int, len(bt.Block.Txs)-1)
+	for i := range order {
+		order[i] = i + 1
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a := bt.Block.Txs[order[i]].TxHash()
+		b := bt.Block.Txs[order[j]].TxHash()
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+
+	txs := make([]*core.Tx, 1, len(bt.Block.Txs))
+	txs[0] = bt.Block.Txs[0]
+	fees := make([]utils.Amount, 1, len(bt.TxFees))
+	fees[0] = bt.TxFees[0]
+	sigOps := make([]int, 1, len(bt.TxSigOpsCount))
+	sigOps[0] = bt.TxSigOpsCount[0]
+	for _, i := range order {
+		txs = append(txs, bt.Block.Txs[i])
+		fees = append(fees, bt.TxFees[i])
+		sigOps = append(sigOps, bt.TxSigOpsCount[i])
+	}
+	bt.Block.Txs = txs
+	bt.TxFees = fees
+	bt.TxSigOpsCount = sigOps
+}
+
 func (ba *BlockAssembler) onlyUnconfirmed(entrySet map[*mempool.TxEntry]struct{}) {
 	for entry := range entrySet {
 		if _, ok := ba.inBlock[entry.Tx.Hash]; ok {
@@ -1,6 +1,7 @@
 package mining
 
 import (
+	"bytes"
 	"math"
 	"testing"
 
@@ -181,3 +182,37 @@ func TestCreateNewBlockByFee(t *testing.T) {
 //		t.Error("error sort by tx feerate")
 //	}
 //}
+
+func TestSortByTxID(t *testing.T) {
+	bt := newBlockTemplate()
+	bt.Block.Txs = append(bt.Block.Txs, core.NewTx())
+	bt.TxFees = append(bt.TxFees, -10)
+	bt.TxSigOpsCount = append(bt.TxSigOpsCount, -1)
+	for i, entry := range createTx() {
+		bt.Block.Txs = append(bt.Block.Txs, entry.Tx)
+		bt.TxFees = append(bt.TxFees, utils.Amount(i))
+		bt.TxSigOpsCount = append(bt.TxSigOpsCount, i)
+	}
+	coinbase := bt.Block.Txs[0]
+	fees := make(map[utils.Hash]utils.Amount)
+	for i, tx := range bt.Block.Txs[1:] {
+		fees[tx.Hash] = bt.TxFees[i+1]
+	}
+
+	bt.sortByTxID()
+
+	if bt.Block.Txs[0] != coinbase || bt.TxFees[0] != -10 {
+		t.Error("the coinbase should stay first")
+	}
+	for i := 2; i < len(bt.Block.Txs); i++ {
+		prev, cur := bt.Block.Txs[i-1].Hash, bt.Block.Txs[i].Hash
+		if bytes.Compare(prev[:], cur[:]) >= 0 {
+			t.Errorf("transactions %d and %d are not in txid order", i-1, i)
+		}
+	}
+	for i, tx := range bt.Block.Txs[1:] {
+		if fees[tx.Hash] != bt.TxFees[i+1] || bt.TxSigOpsCount[i+1] != int(bt.TxFees[i+1]) {
+			t.Errorf("fee and sigops of %s did not follow the transaction", tx.Hash.ToString())
+		}
+	}
+}
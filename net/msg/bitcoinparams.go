@@ -6,6 +6,7 @@ import (
 
 	"github.com/btcboost/copernicus/consensus"
 	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
 	"github.com/btcboost/copernicus/utils"
 	"github.com/pkg/errors"
 )
@@ -22,6 +23,8 @@ var (
 	regressingPowLimit = new(big.Int).Sub(new(big.Int).Lsh(bigOne, 255), bigOne)
 	testNet3PowLimit   = new(big.Int).Sub(new(big.Int).Lsh(bigOne, 255), bigOne)
 	simNetPowlimit     = new(big.Int).Sub(new(big.Int).Lsh(bigOne, 225), bigOne)
+
+	mainMinimumChainWork, _ = new(big.Int).SetString("000000000000000000000000000000000000000000796b6d5908f8db26c3cf44", 16)
 )
 
 type ChainTxData struct {
@@ -62,6 +65,7 @@ type BitcoinParams struct {
 	RelayNonStdTxs      bool
 	PubKeyHashAddressID byte
 	ScriptHashAddressID byte
+	CashAddrPrefix      string
 	PrivatekeyID        byte
 	HDPrivateKeyID      [4]byte
 	HDPublicKeyID       [4]byte
@@ -91,11 +95,45 @@ var MainNetParams = BitcoinParams{
 			consensus.DeploymentTestDummy: {Bit: 28, StartTime: 1199145601, Timeout: 1230767999},
 			consensus.DeploymentCSV:       {Bit: 0, StartTime: 1462060800, Timeout: 1493596800},
 		},
-		FPowNoRetargeting:          false,
-		CashHardForkActivationTime: 1510600000,
-		UAHFHeight:                 478559,
-		TargetTimespan:             60 * 60 * 24 * 14,
-		TargetTimePerBlock:         60 * 10,
+		FPowNoRetargeting: false,
+		Upgrades: []consensus.Upgrade{
+			{
+				Name: consensus.UpgradeUAHF, Activation: consensus.ActivationHeight, Height: 478559,
+				ScriptFlags: crypto.ScriptVerifyStrictenc | crypto.ScriptEnableSigHashForkID,
+				Rules:       consensus.RuleAntiReplay,
+			},
+			{
+				Name: consensus.UpgradeDAA, Activation: consensus.ActivationMTP, Time: 1510600000,
+				ScriptFlags: crypto.ScriptVerifyLows | crypto.ScriptVerifyNullFail,
+				Rules:       consensus.RuleCashDAA,
+			},
+			{
+				Name: consensus.UpgradeMagneticAnomaly, Activation: consensus.ActivationMTP, Time: 1542300000,
+				Rules: consensus.RuleCanonicalTxOrder,
+			},
+			{
+				Name: consensus.UpgradeAxion, Activation: consensus.ActivationMTP, Time: 1605441600,
+				Rules: consensus.RuleASERT,
+			},
+			{
+				Name: consensus.UpgradeUpgrade8, Activation: consensus.ActivationMTP, Time: 1652616000,
+				ScriptFlags: crypto.ScriptEnableNativeIntrospection | crypto.ScriptEnable64BitIntegers,
+			},
+			{
+				Name: consensus.UpgradeUpgrade9, Activation: consensus.ActivationMTP, Time: 1684152000,
+				ScriptFlags: crypto.ScriptEnableTokens,
+			},
+		},
+		ASERTHalfLife: 2 * 24 * 60 * 60,
+		ASERTAnchor: &consensus.ASERTAnchor{
+			Height:        661647,
+			Bits:          0x1804dafe,
+			PrevBlockTime: 1605447844,
+		},
+		MinimumChainWork:   *mainMinimumChainWork,
+		DefaultAssumeValid: *utils.HashFromString("000000000000000004694d6c74b532faf99fc072181f870bfb4a6c9930f7440c"),
+		TargetTimespan:     60 * 60 * 24 * 14,
+		TargetTimePerBlock: 60 * 10,
 	},
 
 	Name:        "mainnet",
@@ -138,6 +176,10 @@ var MainNetParams = BitcoinParams{
 		{343185, utils.HashFromString("0000000000000000072b8bf361d01a6ba7d445dd024203fafc78768ed4368554")},
 		{352940, utils.HashFromString("000000000000000010755df42dba556bb72be6a32f3ce0b6941ce4430152c9ff")},
 		{382320, utils.HashFromString("00000000000000000a8dc6ed5b133d0eb2fd6af56203e4159789b092defd8ab2")},
+		// UAHF fork block.
+		{478559, utils.HashFromString("000000000000000000651ef99cb9fcbe0dadde1d424bd9f15ff20136191a5eec")},
+		// Nov 15, 2018 hard fork.
+		{556767, utils.HashFromString("0000000000000000004626ff6e3b936941d341c5932ece4357eeccac44e6d56c")},
 	},
 	MineBlocksOnDemands: false,
 	// Enforce current block version once majority of the network has
@@ -153,6 +195,7 @@ var MainNetParams = BitcoinParams{
 	RelayNonStdTxs:      false,
 	PubKeyHashAddressID: 0x00, // starts with 1
 	ScriptHashAddressID: 0x05, // starts with 3
+	CashAddrPrefix:      "bitcoincash",
 	PrivatekeyID:        0x80, // starts with 5 (uncompressed) or K (compressed)
 	// BIP32 hierarchical deterministic extended key magics
 	HDPrivateKeyID: [4]byte{0x04, 0x88, 0xad, 0xe4}, // starts with xprv
@@ -162,12 +205,41 @@ var MainNetParams = BitcoinParams{
 	HDCoinType: 0,
 }
 
+// testUpgrades returns the upgrade table of the local test networks, where
+// every upgrade but axion is enforced from the genesis block.
+func testUpgrades(axionTime int64) []consensus.Upgrade {
+	upgrades := make([]consensus.Upgrade, len(MainNetParams.Upgrades))
+	copy(upgrades, MainNetParams.Upgrades)
+	for i := range upgrades {
+		upgrades[i].Height, upgrades[i].Time = 0, 0
+		if upgrades[i].Name == consensus.UpgradeAxion {
+			upgrades[i].Time = axionTime
+		}
+	}
+	return upgrades
+}
+
+// testNet3Upgrades returns the upgrade table of testnet3, which forked at its
+// own height and followed the main network for the upgrades activated by time.
+func testNet3Upgrades() []consensus.Upgrade {
+	upgrades := make([]consensus.Upgrade, len(MainNetParams.Upgrades))
+	copy(upgrades, MainNetParams.Upgrades)
+	for i := range upgrades {
+		if upgrades[i].Name == consensus.UpgradeUAHF {
+			upgrades[i].Height = 1155876
+		}
+	}
+	return upgrades
+}
+
 var RegressionNetParams = BitcoinParams{
 	Param: consensus.Param{
 		GenesisHash:        &RegressionTestGenesisHash,
 		PowLimit:           regressingPowLimit,
 		TargetTimespan:     60 * 60 * 24 * 14,
 		TargetTimePerBlock: 60 * 10,
+		Upgrades:           testUpgrades(1605441600),
+		ASERTHalfLife:      2 * 24 * 60 * 60,
 	},
 
 	Name:         "regtest",
@@ -199,6 +271,7 @@ var RegressionNetParams = BitcoinParams{
 	RelayNonStdTxs:      true,
 	PubKeyHashAddressID: 0x6f, // starts with m or n
 	ScriptHashAddressID: 0xc4, // starts with 2
+	CashAddrPrefix:      "bchreg",
 	PrivatekeyID:        0xef, // starts with 9 (uncompressed) or c (compressed)
 	// BIP32 hierarchical deterministic extended key magics
 	HDPrivateKeyID: [4]byte{0x04, 0x35, 0x83, 0x94}, // starts with xprv
@@ -214,6 +287,13 @@ var TestNet3Params = BitcoinParams{
 		PowLimit:           testNet3PowLimit,
 		TargetTimespan:     60 * 60 * 24 * 14,
 		TargetTimePerBlock: 60 * 10,
+		Upgrades:           testNet3Upgrades(),
+		ASERTHalfLife:      60 * 60,
+		ASERTAnchor: &consensus.ASERTAnchor{
+			Height:        1421481,
+			Bits:          0x1d00ffff,
+			PrevBlockTime: 1605445400,
+		},
 	},
 
 	Name:        "testnet3",
@@ -249,6 +329,7 @@ var TestNet3Params = BitcoinParams{
 	RelayNonStdTxs:      true,
 	PubKeyHashAddressID: 0x6f, // starts with 1
 	ScriptHashAddressID: 0xc4, // starts with 3
+	CashAddrPrefix:      "bchtest",
 	PrivatekeyID:        0xef, // starts with 5 (uncompressed) or K (compressed)
 	// BIP32 hierarchical deterministic extended key magics
 	HDPrivateKeyID: [4]byte{0x04, 0x35, 0x83, 0x94}, // starts with xprv
@@ -264,6 +345,8 @@ var SimNetParams = BitcoinParams{
 		PowLimit:           simNetPowlimit,
 		TargetTimespan:     60 * 60 * 24 * 14,
 		TargetTimePerBlock: 60 * 10,
+		Upgrades:           testUpgrades(0),
+		ASERTHalfLife:      2 * 24 * 60 * 60,
 	},
 
 	Name:         "simnet",
@@ -294,6 +377,7 @@ var SimNetParams = BitcoinParams{
 	RelayNonStdTxs:      true,
 	PubKeyHashAddressID: 0x3f, // starts with 1
 	ScriptHashAddressID: 0x7b, // starts with 3
+	CashAddrPrefix:      "bchsim",
 	PrivatekeyID:        0x64, // starts with 5 (uncompressed) or K (compressed)
 	// BIP32 hierarchical deterministic extended key magics
 	HDPrivateKeyID: [4]byte{0x04, 0x20, 0xb9, 0x00}, // starts with xprv
@@ -305,6 +389,7 @@ var SimNetParams = BitcoinParams{
 
 var (
 	RegisteredNets          = make(map[utils.BitcoinNet]struct{})
+	registeredParams        = make(map[string]*BitcoinParams)
 	PubKeyHashAddressIDs    = make(map[byte]struct{})
 	ScriptHashAddressIDs    = make(map[byte]struct{})
 	HDPrivateToPublicKeyIDs = make(map[[4]byte][]byte)
@@ -322,12 +407,23 @@ func Register(bitcoinParams *BitcoinParams) error {
 	if _, ok := RegisteredNets[bitcoinParams.BitcoinNet]; ok {
 		return errors.New("duplicate bitcoin network")
 	}
+	if _, ok := registeredParams[bitcoinParams.Name]; ok {
+		return errors.New("duplicate bitcoin network name")
+	}
 	RegisteredNets[bitcoinParams.BitcoinNet] = struct{}{}
+	registeredParams[bitcoinParams.Name] = bitcoinParams
 	PubKeyHashAddressIDs[bitcoinParams.PubKeyHashAddressID] = struct{}{}
 	ScriptHashAddressIDs[bitcoinParams.ScriptHashAddressID] = struct{}{}
 	HDPrivateToPublicKeyIDs[bitcoinParams.HDPrivateKeyID] = bitcoinParams.HDPublicKeyID[:]
 	return nil
 }
+
+// LookupParams returns the registered parameters of the chain called name.
+func LookupParams(name string) (*BitcoinParams, bool) {
+	params, ok := registeredParams[name]
+	return params, ok
+}
+
 func IsPublicKeyHashAddressID(id byte) bool {
 	_, ok := PubKeyHashAddressIDs[id]
 	return ok
@@ -354,14 +450,4 @@ func mustRegister(bp *BitcoinParams) {
 	if err != nil {
 		panic("failed to register network :" + err.Error())
 	}
-	work, ok := big.NewInt(0).SetString("000000000000000000000000000000000000000000796b6d5908f8db26c3cf44", 16)
-	if !ok {
-		panic("error")
-	}
-	bp.MinimumChainWork = *work
-	work, ok = big.NewInt(0).SetString("000000000000000004694d6c74b532faf99fc072181f870bfb4a6c9930f7440c", 16)
-	if !ok {
-		panic("err")
-	}
-	bp.DefaultAssumeValid = *work
 }
@@ -0,0 +1,303 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/btcboost/copernicus/consensus"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultChainParamsBase is the network a -chainparams file starts from when
+// it does not name one.
+const DefaultChainParamsBase = "regtest"
+
+// ChainParamsFile is the content of a -chainparams file, in JSON or YAML. The
+// parameters of the Base network are used for every field left out.
+//
+// The difficulty adjustment is selected by the activation of the daa (cw-144)
+// and axion (ASERT) upgrades, unless PowNoRetargeting keeps the difficulty of
+// the genesis block.
+type ChainParamsFile struct {
+	Name  string `json:"name" yaml:"name"`
+	Base  string `json:"base" yaml:"base"`
+	Magic uint32 `json:"magic" yaml:"magic"`
+	Port  string `json:"port" yaml:"port"`
+
+	Genesis *GenesisParams `json:"genesis" yaml:"genesis"`
+
+	PowLimit            string                 `json:"powlimit" yaml:"powlimit"`
+	PowNoRetargeting    *bool                  `json:"pownoretargeting" yaml:"pownoretargeting"`
+	ReduceMinDifficulty *bool                  `json:"reducemindifficulty" yaml:"reducemindifficulty"`
+	TargetSpacing       int64                  `json:"targetspacing" yaml:"targetspacing"`
+	TargetTimespan      int64                  `json:"targettimespan" yaml:"targettimespan"`
+	ASERTHalfLife       int64                  `json:"aserthalflife" yaml:"aserthalflife"`
+	ASERTAnchor         *consensus.ASERTAnchor `json:"asertanchor" yaml:"asertanchor"`
+
+	// Upgrades maps upgrade names to their activation height or median time
+	// past, depending on how the base network activates them.
+	Upgrades map[string]int64 `json:"upgrades" yaml:"upgrades"`
+
+	PubKeyHashAddressID *byte  `json:"pubkeyhashaddressid" yaml:"pubkeyhashaddressid"`
+	ScriptHashAddressID *byte  `json:"scripthashaddressid" yaml:"scripthashaddressid"`
+	CashAddrPrefix      string `json:"cashaddrprefix" yaml:"cashaddrprefix"`
+	PrivateKeyID        *byte  `json:"privatekeyid" yaml:"privatekeyid"`
+	HDPrivateKeyID      string `json:"hdprivatekeyid" yaml:"hdprivatekeyid"`
+	HDPublicKeyID       string `json:"hdpublickeyid" yaml:"hdpublickeyid"`
+
+	DNSSeeds    []string          `json:"dnsseeds" yaml:"dnsseeds"`
+	Checkpoints []CheckpointParam `json:"checkpoints" yaml:"checkpoints"`
+}
+
+// GenesisParams overrides the genesis block of the base network. Hash, when
+// set, must match the hash of the resulting header.
+type GenesisParams struct {
+	Version    *int32  `json:"version" yaml:"version"`
+	Time       *uint32 `json:"time" yaml:"time"`
+	Bits       *uint32 `json:"bits" yaml:"bits"`
+	Nonce      *uint32 `json:"nonce" yaml:"nonce"`
+	MerkleRoot string  `json:"merkleroot" yaml:"merkleroot"`
+	Coinbase   string  `json:"coinbase" yaml:"coinbase"`
+	Hash       string  `json:"hash" yaml:"hash"`
+}
+
+// CheckpointParam is a checkpoint of a -chainparams file.
+type CheckpointParam struct {
+	Height int32  `json:"height" yaml:"height"`
+	Hash   string `json:"hash" yaml:"hash"`
+}
+
+// LoadChainParamsFile reads a -chainparams file, YAML when its extension is
+// .yml or .yaml and JSON otherwise, and returns the parameters it describes.
+func LoadChainParamsFile(path string) (*BitcoinParams, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file ChainParamsFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(data, &file)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse chain params %s", path)
+	}
+	return file.ToParams()
+}
+
+// ToParams returns the parameters of the base network overridden by f.
+func (f *ChainParamsFile) ToParams() (*BitcoinParams, error) {
+	if f.Name == "" {
+		return nil, errors.New("chain params need a name")
+	}
+	baseName := f.Base
+	if baseName == "" {
+		baseName = DefaultChainParamsBase
+	}
+	base, ok := registeredParams[baseName]
+	if !ok {
+		return nil, errors.Errorf("unknown base chain %s", baseName)
+	}
+
+	params := *base
+	params.Name = f.Name
+	params.Upgrades = append([]consensus.Upgrade(nil), base.Upgrades...)
+	if f.Magic != 0 {
+		params.BitcoinNet = utils.BitcoinNet(f.Magic)
+	}
+	if f.Port != "" {
+		params.DefaultPort = f.Port
+	}
+
+	if f.PowLimit != "" {
+		powLimit, ok := new(big.Int).SetString(f.PowLimit, 16)
+		if !ok || powLimit.Sign() <= 0 {
+			return nil, errors.Errorf("invalid powlimit %s", f.PowLimit)
+		}
+		params.PowLimit = powLimit
+	}
+	if f.PowNoRetargeting != nil {
+		params.FPowNoRetargeting = *f.PowNoRetargeting
+	}
+	if f.ReduceMinDifficulty != nil {
+		params.ReduceMinDifficulty = *f.ReduceMinDifficulty
+	}
+	if f.TargetSpacing != 0 {
+		params.TargetTimePerBlock = time.Duration(f.TargetSpacing)
+	}
+	if f.TargetTimespan != 0 {
+		params.TargetTimespan = time.Duration(f.TargetTimespan)
+	}
+	if f.ASERTHalfLife != 0 {
+		params.ASERTHalfLife = f.ASERTHalfLife
+	}
+	if f.ASERTAnchor != nil {
+		anchor := *f.ASERTAnchor
+		params.ASERTAnchor = &anchor
+	}
+
+	for name, activation := range f.Upgrades {
+		upgrade := params.GetUpgrade(name)
+		if upgrade == nil {
+			return nil, errors.Errorf("unknown upgrade %s", name)
+		}
+		if upgrade.Activation == consensus.ActivationHeight {
+			upgrade.Height = int(activation)
+		} else {
+			upgrade.Time = activation
+		}
+	}
+
+	if err := f.setAddressIDs(&params); err != nil {
+		return nil, err
+	}
+
+	if f.DNSSeeds != nil {
+		params.DNSSeeds = make([]utils.DNSSeed, 0, len(f.DNSSeeds))
+		for _, host := range f.DNSSeeds {
+			params.DNSSeeds = append(params.DNSSeeds, utils.DNSSeed{Host: host})
+		}
+	}
+	if f.Checkpoints != nil {
+		params.Checkpoints = make([]*core.Checkpoint, 0, len(f.Checkpoints))
+		for _, checkpoint := range f.Checkpoints {
+			hash, err := utils.GetHashFromStr(checkpoint.Hash)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid checkpoint hash %s", checkpoint.Hash)
+			}
+			params.Checkpoints = append(params.Checkpoints, &core.Checkpoint{Height: checkpoint.Height, Hash: hash})
+		}
+	}
+
+	if f.Genesis != nil {
+		if err := f.Genesis.apply(&params); err != nil {
+			return nil, err
+		}
+	}
+	return &params, nil
+}
+
+func (f *ChainParamsFile) setAddressIDs(params *BitcoinParams) error {
+	if f.PubKeyHashAddressID != nil {
+		params.PubKeyHashAddressID = *f.PubKeyHashAddressID
+	}
+	if f.ScriptHashAddressID != nil {
+		params.ScriptHashAddressID = *f.ScriptHashAddressID
+	}
+	if f.CashAddrPrefix != "" {
+		params.CashAddrPrefix = f.CashAddrPrefix
+	}
+	if f.PrivateKeyID != nil {
+		params.PrivatekeyID = *f.PrivateKeyID
+	}
+	for _, key := range []struct {
+		value string
+		id    *[4]byte
+	}{{f.HDPrivateKeyID, &params.HDPrivateKeyID}, {f.HDPublicKeyID, &params.HDPublicKeyID}} {
+		if key.value == "" {
+			continue
+		}
+		id, err := hex.DecodeString(key.value)
+		if err != nil || len(id) != 4 {
+			return errors.Errorf("invalid hd key id %s", key.value)
+		}
+		copy(key.id[:], id)
+	}
+	return nil
+}
+
+func (g *GenesisParams) apply(params *BitcoinParams) error {
+	block := *params.GenesisBlock.Block
+	if g.Version != nil {
+		block.BlockHeader.Version = *g.Version
+	}
+	if g.Time != nil {
+		block.BlockHeader.Time = *g.Time
+	}
+	if g.Bits != nil {
+		block.BlockHeader.Bits = *g.Bits
+	}
+	if g.Nonce != nil {
+		block.BlockHeader.Nonce = *g.Nonce
+	}
+	if g.Coinbase != "" {
+		raw, err := hex.DecodeString(g.Coinbase)
+		if err != nil {
+			return errors.Wrap(err, "invalid genesis coinbase")
+		}
+		tx, err := core.DeserializeTx(bytes.NewReader(raw))
+		if err != nil {
+			return errors.Wrap(err, "invalid genesis coinbase")
+		}
+		block.Txs = []*core.Tx{tx}
+		block.BlockHeader.MerkleRoot = tx.TxHash()
+	}
+	if g.MerkleRoot != "" {
+		merkleRoot, err := utils.GetHashFromStr(g.MerkleRoot)
+		if err != nil {
+			return errors.Wrapf(err, "invalid genesis merkle root %s", g.MerkleRoot)
+		}
+		if g.Coinbase != "" && !merkleRoot.IsEqual(&block.BlockHeader.MerkleRoot) {
+			return errors.Errorf("genesis merkle root %s does not match the coinbase", g.MerkleRoot)
+		}
+		block.BlockHeader.MerkleRoot = *merkleRoot
+	}
+
+	hash, err := block.BlockHeader.GetHash()
+	if err != nil {
+		return err
+	}
+	if g.Hash != "" {
+		expected, err := utils.GetHashFromStr(g.Hash)
+		if err != nil {
+			return errors.Wrapf(err, "invalid genesis hash %s", g.Hash)
+		}
+		if !expected.IsEqual(&hash) {
+			return errors.Errorf("genesis hash %s does not match the header, which hashes to %s",
+				g.Hash, hash.ToString())
+		}
+	}
+
+	params.GenesisBlock = &BlockMessage{Block: &block}
+	params.GenesisHash = &hash
+	params.PowLimitBits = block.BlockHeader.Bits
+	return nil
+}
+
+// InitChainParams registers the network of -chainparams if given, and makes
+// the network named by -chain the active one. Without -chain the network of
+// -chainparams, or else mainnet, is used.
+func InitChainParams() error {
+	name := MainNetParams.Name
+	if path := utils.GetArgString("-chainparams", ""); path != "" {
+		params, err := LoadChainParamsFile(path)
+		if err != nil {
+			return err
+		}
+		if err := Register(params); err != nil {
+			return errors.Wrapf(err, "failed to register chain %s", params.Name)
+		}
+		name = params.Name
+	}
+	name = utils.GetArgString("-chain", name)
+
+	params, ok := registeredParams[name]
+	if !ok {
+		return errors.Errorf("unknown chain %s", name)
+	}
+	ActiveNetParams = params
+	return nil
+}
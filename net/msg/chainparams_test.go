@@ -0,0 +1,156 @@
+package msg
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcboost/copernicus/consensus"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utils/cashaddr"
+)
+
+func TestLoadChainParamsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chainparams")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	devnet := `
+name: devnet
+magic: 0xdab5bffa
+port: "19444"
+genesis:
+  time: 1700000000
+  nonce: 2
+powlimit: 7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff
+pownoretargeting: true
+upgrades:
+  axion: 1700000000
+pubkeyhashaddressid: 0x1c
+hdprivatekeyid: 0a0b0c0d
+dnsseeds: [seed.devnet.example]
+`
+	path := filepath.Join(dir, "devnet.yaml")
+	if err := ioutil.WriteFile(path, []byte(devnet), 0644); err != nil {
+		t.Fatal(err)
+	}
+	params, err := LoadChainParamsFile(path)
+	if err != nil {
+		t.Fatalf("failed to load %s: %s", path, err)
+	}
+	if params.Name != "devnet" || params.BitcoinNet != 0xdab5bffa || params.DefaultPort != "19444" {
+		t.Errorf("unexpected network %s %x %s", params.Name, params.BitcoinNet, params.DefaultPort)
+	}
+	if params.GenesisBlock.Block.BlockHeader.Time != 1700000000 || params.GenesisHash.IsEqual(RegressionNetParams.GenesisHash) {
+		t.Errorf("the genesis block should be rebuilt from the file")
+	}
+	hash, _ := params.GenesisBlock.Block.BlockHeader.GetHash()
+	if !hash.IsEqual(params.GenesisHash) {
+		t.Errorf("genesis hash %s does not match the header", params.GenesisHash.ToString())
+	}
+	if params.GetUpgrade(consensus.UpgradeAxion).Time != 1700000000 ||
+		RegressionNetParams.GetUpgrade(consensus.UpgradeAxion).Time == 1700000000 {
+		t.Errorf("upgrades should be overridden on a copy of the base network")
+	}
+	if !params.FPowNoRetargeting || params.PubKeyHashAddressID != 0x1c ||
+		params.HDPrivateKeyID != [4]byte{0x0a, 0x0b, 0x0c, 0x0d} || len(params.DNSSeeds) != 1 {
+		t.Errorf("unexpected parameters %+v", params)
+	}
+
+	invalid := []string{
+		`{"base":"regtest"}`,
+		`{"name":"x","base":"nosuchchain"}`,
+		`{"name":"x","upgrades":{"nosuchupgrade":1}}`,
+		`{"name":"x","genesis":{"hash":"` + RegressionNetParams.GenesisHash.ToString() + `","nonce":7}}`,
+		`{"name":"x","nosuchfield":1}`,
+	}
+	for i, content := range invalid {
+		path := filepath.Join(dir, "invalid.json")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadChainParamsFile(path); err == nil {
+			t.Errorf("#%d: expected %s to be rejected", i, content)
+		}
+	}
+}
+
+func TestInitChainParams(t *testing.T) {
+	defer func() {
+		ActiveNetParams = &MainNetParams
+		utils.ParseParameters(0, nil)
+	}()
+
+	utils.ParseParameters(1, []string{"-chain=regtest"})
+	if err := InitChainParams(); err != nil || ActiveNetParams != &RegressionNetParams {
+		t.Errorf("-chain=regtest should select regtest: %v", err)
+	}
+	utils.ParseParameters(1, []string{"-chain=nosuchchain"})
+	if err := InitChainParams(); err == nil {
+		t.Errorf("unknown chains should be rejected")
+	}
+}
+
+func TestTestNetUpgrades(t *testing.T) {
+	testnet3 := []struct {
+		name         string
+		height, time int64
+	}{
+		{consensus.UpgradeUAHF, 1155876, 0},
+		{consensus.UpgradeDAA, 0, 1510600000},
+		{consensus.UpgradeMagneticAnomaly, 0, 1542300000},
+		{consensus.UpgradeAxion, 0, 1605441600},
+		{consensus.UpgradeUpgrade8, 0, 1652616000},
+		{consensus.UpgradeUpgrade9, 0, 1684152000},
+	}
+	for _, test := range testnet3 {
+		upgrade := TestNet3Params.GetUpgrade(test.name)
+		if int64(upgrade.Height) != test.height || upgrade.Time != test.time {
+			t.Errorf("testnet3 %s: expected height %d time %d, got %d %d",
+				test.name, test.height, test.time, upgrade.Height, upgrade.Time)
+		}
+	}
+
+	// The local test networks enforce the upgrades from genesis.
+	for _, params := range []*BitcoinParams{&RegressionNetParams, &SimNetParams} {
+		for _, upgrade := range params.Upgrades {
+			if upgrade.Name != consensus.UpgradeAxion && (upgrade.Height != 0 || upgrade.Time != 0) {
+				t.Errorf("%s %s: expected to be active from genesis", params.Name, upgrade.Name)
+			}
+		}
+	}
+}
+
+func TestCashAddrPrefix(t *testing.T) {
+	keyID, _ := hex.DecodeString("76a04053bda0a88bda5177b86a15c3b29f559873")
+	networks := []*BitcoinParams{&MainNetParams, &TestNet3Params, &RegressionNetParams, &SimNetParams}
+	tests := []struct {
+		prefix  string
+		address string
+	}{
+		{"bitcoincash", "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"},
+		{"bchtest", "bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvqcw003ap"},
+		{"bchreg", ""},
+		{"bchsim", ""},
+	}
+	for i, test := range tests {
+		params := networks[i]
+		if params.CashAddrPrefix != test.prefix {
+			t.Errorf("%s: expected prefix %s, got %s", params.Name, test.prefix, params.CashAddrPrefix)
+			continue
+		}
+		address, err := cashaddr.Encode(params.CashAddrPrefix, cashaddr.PubKeyType, keyID)
+		if err != nil || (test.address != "" && address != test.address) {
+			t.Errorf("%s: unexpected address %s (%v)", params.Name, address, err)
+		}
+		for j, other := range networks {
+			if _, _, err := cashaddr.Decode(address, other.CashAddrPrefix); (err == nil) != (i == j) {
+				t.Errorf("%s: decoding %s gave %v", other.Name, address, err)
+			}
+		}
+	}
+}
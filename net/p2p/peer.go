@@ -13,6 +13,8 @@ import (
 	"time"
 
 	"github.com/astaxie/beego/logs"
+	"github.com/btcboost/copernicus/conf"
+	"github.com/btcboost/copernicus/consensus"
 	"github.com/btcboost/copernicus/container"
 	"github.com/btcboost/copernicus/log"
 	"github.com/btcboost/copernicus/net/msg"
@@ -159,6 +161,13 @@ func (p *Peer) LastReceived() uint64 {
 	return atomic.LoadUint64(&p.bytesReceived)
 }
 
+// getSubVersionEB formats the excessive block size in MB, floored to the first
+// decimal, as advertised in the user agent: 8000000 gives "8.0".
+func getSubVersionEB(maxBlockSize uint64) string {
+	tenths := maxBlockSize / (consensus.OneMegabyte / 10)
+	return fmt.Sprintf("%d.%d", tenths/10, tenths%10)
+}
+
 func (p *Peer) LocalVersionMsg() (*msg.VersionMessage, error) {
 	var blockNumber int32
 	if p.Config.NewBlock != nil {
@@ -189,7 +198,9 @@ func (p *Peer) LocalVersionMsg() (*msg.VersionMessage, error) {
 	}
 	sentNoces.Add(nonce, nonce)
 	message := msg.GetNewVersionMessage(localAddress, remoteAddress, nonce, blockNumber)
-	message.AddUserAgent(p.Config.UserAgent, p.Config.UserAgentVersion)
+	comments := append([]string{"EB" + getSubVersionEB(conf.GlobalValueInstance.GetMaxBlockSize())},
+		p.Config.UserAgentComments...)
+	message.AddUserAgent(p.Config.UserAgent, p.Config.UserAgentVersion, comments...)
 	message.LocalAddress.ServicesFlag = protocol.SFNodeNetworkAsFullNode
 	message.ServiceFlag = p.Config.ServicesFlag
 	message.ProtocolVersion = p.ProtocolVersion
@@ -0,0 +1,148 @@
+package policy
+
+import (
+	"sync"
+
+	"github.com/btcboost/copernicus/container"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utils"
+)
+
+const (
+	// MaxEstimateTarget is the largest confirmation target fees are
+	// estimated for.
+	MaxEstimateTarget = 25
+
+	// DefaultDecay is how much the historical moving averages are decayed
+	// per block.
+	DefaultDecay = .998
+
+	// MinSuccessPct is the share of transactions of a feerate which must
+	// have confirmed within the target for the feerate to be estimated.
+	MinSuccessPct = .95
+
+	// SufficientFeeTxs is the average number of transactions per block a
+	// range of buckets must hold to be estimated from.
+	SufficientFeeTxs = 1
+
+	// MinBucketFeeRate and MaxBucketFeeRate bound the feerate buckets, in
+	// satoshis per kB, which are FeeSpacing apart.
+	MinBucketFeeRate = 10
+	MaxBucketFeeRate = 1e7
+	FeeSpacing       = 1.1
+)
+
+type trackedTx struct {
+	info    *TxStatsInfo
+	feeRate float64
+}
+
+// FeeEstimator estimates the feerate transactions need to be confirmed
+// within a number of blocks, from how long transactions of the mempool took
+// to be confirmed depending on their feerate.
+type FeeEstimator struct {
+	mtx             sync.Mutex
+	feeStats        *TxConfirmStats
+	mapMemPoolTxs   map[utils.Hash]*trackedTx
+	nBestSeenHeight uint
+}
+
+func NewFeeEstimator() *FeeEstimator {
+	buckets := container.NewVector()
+	for boundary := float64(MinBucketFeeRate); boundary <= MaxBucketFeeRate; boundary *= FeeSpacing {
+		buckets.PushBack(boundary)
+	}
+	buckets.PushBack(float64(utils.InfFeeRate))
+	return &FeeEstimator{
+		feeStats:      NewTxConfirmStats(buckets, MaxEstimateTarget, DefaultDecay),
+		mapMemPoolTxs: make(map[utils.Hash]*trackedTx),
+	}
+}
+
+// ProcessTransaction tracks a transaction entering the mempool at height,
+// paying fee for size bytes. Only the transactions entering at the height
+// of the last block seen are tracked.
+func (e *FeeEstimator) ProcessTransaction(txid utils.Hash, height int, fee int64, size int) {
+	if size <= 0 || height < 0 {
+		return
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if _, ok := e.mapMemPoolTxs[txid]; ok {
+		return
+	}
+	if uint(height) != e.nBestSeenHeight {
+		return
+	}
+	feeRate := float64(utils.NewFeeRateWithSize(fee, int64(size)).SataoshisPerK)
+	info := NewTxStatsInfo()
+	info.BlockHeight = uint(height)
+	info.BucketIndex = e.feeStats.NewTx(info.BlockHeight, feeRate)
+	e.mapMemPoolTxs[txid] = &trackedTx{info: info, feeRate: feeRate}
+}
+
+// RemoveTransaction stops tracking a transaction which left the mempool
+// without being confirmed.
+func (e *FeeEstimator) RemoveTransaction(txid utils.Hash) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.removeTx(txid)
+}
+
+func (e *FeeEstimator) removeTx(txid utils.Hash) (*trackedTx, bool) {
+	tracked, ok := e.mapMemPoolTxs[txid]
+	if !ok {
+		return nil, false
+	}
+	e.feeStats.RemoveTx(tracked.info.BlockHeight, e.nBestSeenHeight, tracked.info.BucketIndex)
+	delete(e.mapMemPoolTxs, txid)
+	return tracked, true
+}
+
+// ProcessBlock records how long the tracked transactions of the block
+// connected at height took to be confirmed.
+func (e *FeeEstimator) ProcessBlock(height int, txs []*core.Tx) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	// A block at or below the best seen is a reorganization, whose
+	// transactions were counted when first confirmed.
+	if height < 0 || uint(height) <= e.nBestSeenHeight {
+		return
+	}
+	// The best height moves with the unconfirmed counts, so that RemoveTx
+	// finds the transactions which ClearCurrent aged out.
+	e.nBestSeenHeight = uint(height)
+	e.feeStats.ClearCurrent(uint(height))
+	for _, tx := range txs {
+		tracked, ok := e.removeTx(tx.TxHash())
+		if !ok {
+			continue
+		}
+		blocksToConfirm := height - int(tracked.info.BlockHeight)
+		if blocksToConfirm <= 0 {
+			continue
+		}
+		e.feeStats.Record(blocksToConfirm, tracked.feeRate)
+	}
+	e.feeStats.UpdateMovingAverages()
+}
+
+// EstimateFee returns the lowest feerate such that nearly all of the recent
+// transactions paying at least as much were confirmed within confTarget
+// blocks. It returns false when there is not enough data.
+func (e *FeeEstimator) EstimateFee(confTarget int) (utils.FeeRate, bool) {
+	if confTarget < 1 || confTarget > int(e.feeStats.GetMaxConfirms()) {
+		return utils.FeeRate{}, false
+	}
+	// A single block is too few to estimate a confirmation rate from.
+	if confTarget == 1 {
+		confTarget = 2
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	median := e.feeStats.EstimateMedianVal(confTarget, SufficientFeeTxs, MinSuccessPct, true, e.nBestSeenHeight)
+	if median < 0 {
+		return utils.FeeRate{}, false
+	}
+	return utils.FeeRate{SataoshisPerK: int64(median)}, true
+}
@@ -0,0 +1,86 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/btcboost/copernicus/container"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utils"
+)
+
+func newEstimatorTxs(first, count int) []*core.Tx {
+	txs := make([]*core.Tx, count)
+	for i := range txs {
+		txs[i] = core.NewTx()
+		txs[i].AddTxIn(core.NewTxIn(core.NewOutPoint(utils.Hash{}, uint32(first+i)), []byte{0x51}))
+		txs[i].AddTxOut(core.NewTxOut(1, []byte{0x51}))
+	}
+	return txs
+}
+
+func TestFeeEstimator(t *testing.T) {
+	e := NewFeeEstimator()
+	if _, ok := e.EstimateFee(2); ok {
+		t.Errorf("there should be no estimate without data")
+	}
+	e.ProcessBlock(1, nil)
+
+	// The high feerate transactions confirm in the next block, the low
+	// feerate ones eleven blocks later.
+	high, low := newEstimatorTxs(0, 600), newEstimatorTxs(600, 600)
+	for _, tx := range high {
+		e.ProcessTransaction(tx.TxHash(), 1, 8000, 1000)
+	}
+	for _, tx := range low {
+		e.ProcessTransaction(tx.TxHash(), 1, 1000, 1000)
+	}
+	// Transactions entering at another height are not tracked.
+	stale := newEstimatorTxs(1200, 1)[0]
+	e.ProcessTransaction(stale.TxHash(), 0, 1000, 1000)
+	if len(e.mapMemPoolTxs) != len(high)+len(low) {
+		t.Fatalf("expected %d tracked transactions, got %d", len(high)+len(low), len(e.mapMemPoolTxs))
+	}
+
+	e.ProcessBlock(2, high)
+	for height := 3; height < 12; height++ {
+		e.ProcessBlock(height, nil)
+	}
+	e.ProcessBlock(12, low)
+	if len(e.mapMemPoolTxs) != 0 {
+		t.Errorf("the confirmed transactions should no longer be tracked")
+	}
+
+	tests := []struct {
+		confTarget int
+		feeRate    int64
+		ok         bool
+	}{
+		{0, 0, false},
+		{1, 8000, true},
+		{2, 8000, true},
+		{10, 8000, true},
+		{11, 1000, true},
+		{MaxEstimateTarget, 1000, true},
+		{MaxEstimateTarget + 1, 0, false},
+	}
+	for _, test := range tests {
+		feeRate, ok := e.EstimateFee(test.confTarget)
+		if ok != test.ok || feeRate.SataoshisPerK != test.feeRate {
+			t.Errorf("target %d: expected %d %v, got %d %v",
+				test.confTarget, test.feeRate, test.ok, feeRate.SataoshisPerK, ok)
+		}
+	}
+
+	// A transaction leaving the mempool unconfirmed is no longer counted.
+	removed := newEstimatorTxs(1300, 1)[0]
+	e.ProcessTransaction(removed.TxHash(), 12, 1000, 1000)
+	bucket := e.mapMemPoolTxs[removed.TxHash()].info.BucketIndex
+	unconf := e.feeStats.unconfTxs.Array[12%MaxEstimateTarget].(*container.Vector)
+	if unconf.Array[bucket].(int) != 1 {
+		t.Fatalf("expected an unconfirmed transaction, got %d", unconf.Array[bucket])
+	}
+	e.RemoveTransaction(removed.TxHash())
+	if len(e.mapMemPoolTxs) != 0 || unconf.Array[bucket].(int) != 0 {
+		t.Errorf("the removed transaction should no longer be tracked")
+	}
+}
@@ -19,8 +19,6 @@ const (
 	 * mining code will create **/
 	DefaultMaxGeneratedBlockSize uint64 = 2 * OneMegaByte
 
-	DefaultMaxBlockSize = 8 * OneMegaByte
-
 	/*DefaultBlockPrioritySize default for -blockPrioritySize, maximum space for zero/low-fee transactions*/
 	DefaultBlockPrioritySize uint64 = 0
 
@@ -116,6 +114,12 @@ func IsStandardTx(tx *core.Tx, reason *string) bool {
 	nDataOut := uint(0)
 	whichType := 0
 	for _, txOut := range tx.Outs {
+		// Outputs carrying tokens are standard if their script is: Script
+		// does not include the token prefix.
+		if txOut.HasInvalidTokenPrefix() {
+			*reason = "txn-tokens-invalid-prefix"
+			return false
+		}
 		if !IsStandard(txOut.Script, &whichType) {
 			*reason = "scriptpubkey"
 			return false
@@ -159,8 +163,8 @@ func IsStandard(scriptPubKey *core.Script, whichType *int) bool {
 	}
 
 	if *whichType == core.TxMultiSig {
-		m := vSolutions.Array[0].(container.Vector).Array[0].(byte)
-		n := vSolutions.Array[0].(container.Vector).Array[0].(byte)
+		m := vSolutions.Array[0].([]byte)[0]
+		n := vSolutions.Array[vSolutions.Size()-1].([]byte)[0]
 		// Support up to x-of-3 multisig txns as standard
 		if n < 1 || n > 3 {
 			return false
@@ -3,6 +3,7 @@ package policy
 import (
 	"encoding/binary"
 	"io"
+	"sort"
 
 	"github.com/btcboost/copernicus/container"
 	"github.com/btcboost/copernicus/utils"
@@ -25,9 +26,6 @@ type TxConfirmStats struct {
 	// The upper-bound of the range for the bucket (inclusive)
 	buckets *container.Vector
 
-	// Map of bucket upper-bound to index into all vectors by bucket
-	bucketMap container.BeeMap
-
 	// For each bucket X:
 	// Count the total # of txs in each bucket
 	// Track the historical moving average of this total over blocks
@@ -87,12 +85,10 @@ func NewTxConfirmStats(defaultBuckets *container.Vector, maxConfirms uint, decay
 	txConfirmStats := TxConfirmStats{}
 	txConfirmStats.decay = decay
 	txConfirmStats.buckets = container.NewVector()
-	txConfirmStats.bucketMap = *container.NewBeeMap()
 
 	for i := 0; i < defaultBuckets.Size(); i++ {
 		bucket, _ := defaultBuckets.At(i)
 		txConfirmStats.buckets.PushBack(bucket)
-		txConfirmStats.bucketMap.Set(bucket, i)
 	}
 	txConfirmStats.confAvg = container.NewVectorWithSize(maxConfirms)
 	txConfirmStats.curBlockConf = container.NewVectorWithSize(maxConfirms)
@@ -137,6 +133,18 @@ func setValue(v *container.Vector, eleType int) {
 	}
 }
 
+// bucketIndex returns the index of the lowest bucket whose upper-bound is at
+// least val, or the last bucket when val is above them all.
+func (txConfirmStats *TxConfirmStats) bucketIndex(val float64) uint {
+	index := sort.Search(txConfirmStats.buckets.Size(), func(i int) bool {
+		return txConfirmStats.buckets.Array[i].(float64) >= val
+	})
+	if index == txConfirmStats.buckets.Size() {
+		index--
+	}
+	return uint(index)
+}
+
 //ClearCurrent Clear the state of the curBlock variables to start counting for the new block.
 func (txConfirmStats *TxConfirmStats) ClearCurrent(blockHeight uint) {
 	for j := 0; j < txConfirmStats.buckets.Size(); j++ {
@@ -151,7 +159,7 @@ func (txConfirmStats *TxConfirmStats) ClearCurrent(blockHeight uint) {
 			curBlockTmp.SetValueByIndex(j, 0)
 		}
 
-		txConfirmStats.curBlockVal.SetValueByIndex(j, 0)
+		txConfirmStats.curBlockVal.SetValueByIndex(j, 0.0)
 		txConfirmStats.curBlockTxCt.SetValueByIndex(j, 0)
 	}
 }
@@ -166,7 +174,7 @@ func (txConfirmStats *TxConfirmStats) Record(blocksToConfirm int, val float64) {
 		return
 	}
 
-	bucketindex := txConfirmStats.bucketMap.GetLowerBoundByfloat64(val).(uint)
+	bucketindex := txConfirmStats.bucketIndex(val)
 	for i := blocksToConfirm; i <= txConfirmStats.curBlockConf.Size(); i++ {
 		curBlockConfTmp := txConfirmStats.curBlockConf.Array[i-1].(*container.Vector)
 		num := curBlockConfTmp.Array[bucketindex].(int)
@@ -176,7 +184,7 @@ func (txConfirmStats *TxConfirmStats) Record(blocksToConfirm int, val float64) {
 	curTxCt := txConfirmStats.curBlockTxCt.Array[bucketindex].(int)
 	txConfirmStats.curBlockTxCt.SetValueByIndex(int(bucketindex), curTxCt+1)
 	curVal := txConfirmStats.curBlockVal.Array[bucketindex].(float64)
-	txConfirmStats.curBlockTxCt.SetValueByIndex(int(bucketindex), curVal+val)
+	txConfirmStats.curBlockVal.SetValueByIndex(int(bucketindex), curVal+val)
 }
 
 //UpdateMovingAverages Update our estimates by decaying our historical moving average and
@@ -191,9 +199,9 @@ func (txConfirmStats *TxConfirmStats) UpdateMovingAverages() {
 
 			confAvgVecTmp.SetValueByIndex(j, confAvgNum*txConfirmStats.decay+float64(curConfNum))
 		}
-		curValNum := txConfirmStats.curBlockTxCt.Array[j].(int)
+		curValNum := txConfirmStats.curBlockVal.Array[j].(float64)
 		avgNum := txConfirmStats.avg.Array[j].(float64)
-		txConfirmStats.avg.SetValueByIndex(j, avgNum*txConfirmStats.decay+float64(curValNum))
+		txConfirmStats.avg.SetValueByIndex(j, avgNum*txConfirmStats.decay+curValNum)
 
 		curTxCtNum := txConfirmStats.curBlockTxCt.Array[j].(int)
 		txCtAvgNum := txConfirmStats.txCtAvg.Array[j].(float64)
@@ -261,7 +269,7 @@ func (txConfirmStats *TxConfirmStats) EstimateMedianVal(confTarget int, sufficie
 		totalNum += txConfirmStats.txCtAvg.Array[bucket].(float64)
 
 		for confct := uint(confTarget); confct < txConfirmStats.GetMaxConfirms(); confct++ {
-			unconfTxsVecTmp := txConfirmStats.unconfTxs.Array[(nBlockHeight-confct)%bins].(*container.Vector)
+			unconfTxsVecTmp := txConfirmStats.unconfTxs.Array[(nBlockHeight+bins-confct)%bins].(*container.Vector)
 			extraNum += unconfTxsVecTmp.Array[bucket].(int)
 		}
 		extraNum += txConfirmStats.oldUnconfTxs.Array[bucket].(int)
@@ -332,7 +340,7 @@ func (txConfirmStats *TxConfirmStats) EstimateMedianVal(confTarget int, sufficie
 
 //NewTx Record a new transaction entering the mempool
 func (txConfirmStats *TxConfirmStats) NewTx(nBlockHeight uint, val float64) uint {
-	bucketIndex := txConfirmStats.bucketMap.GetLowerBoundByfloat64(val).(uint)
+	bucketIndex := txConfirmStats.bucketIndex(val)
 	blockIndex := nBlockHeight % uint(txConfirmStats.unconfTxs.Size())
 	unconfxVecTmp := txConfirmStats.unconfTxs.Array[blockIndex].(*container.Vector)
 	unconfxVecTmp.SetValueByIndex(int(bucketIndex), unconfxVecTmp.Array[bucketIndex].(int)+1)
@@ -493,7 +501,6 @@ func (txConfirmStats *TxConfirmStats) Deserialize(reader io.Reader) error {
 	txConfirmStats.avg = fileAvg
 	txConfirmStats.confAvg = fileConfAvg
 	txConfirmStats.txCtAvg = fileTxCtAvg
-	txConfirmStats.bucketMap = *container.NewBeeMap()
 
 	// Resize the current block variables which aren't stored in the data file
 	// to match the number of confirms and buckets
@@ -506,9 +513,6 @@ func (txConfirmStats *TxConfirmStats) Deserialize(reader io.Reader) error {
 	txConfirmStats.curBlockTxCt = container.NewVector()
 	txConfirmStats.curBlockVal = container.NewVector()
 	txConfirmStats.oldUnconfTxs = container.NewVector()
-	for i := 0; i < txConfirmStats.buckets.Size(); i++ {
-		txConfirmStats.bucketMap.Set(txConfirmStats.buckets.Array[i], i)
-	}
 
 	return nil
 }
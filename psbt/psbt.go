@@ -0,0 +1,462 @@
+// Package psbt implements partially signed transactions, a container passing
+// a transaction to sign between the parties of a multi-party signing flow,
+// with everything they need to sign it without access to the chain.
+//
+// The format follows BIP174 as adapted to Bitcoin Cash: each input carries
+// the output it spends, whose amount is signed with SIGHASH_FORKID.
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/pkg/errors"
+)
+
+// Magic starts every serialized partially signed transaction.
+var Magic = [5]byte{'p', 's', 'b', 't', 0xff}
+
+// Key types of the global map.
+const (
+	GlobalUnsignedTx = 0x00
+)
+
+// Key types of the input maps.
+const (
+	InUTXO           = 0x00
+	InPartialSig     = 0x02
+	InSigHashType    = 0x03
+	InRedeemScript   = 0x04
+	InBIP32          = 0x06
+	InFinalScriptSig = 0x07
+)
+
+// Key types of the output maps.
+const (
+	OutRedeemScript = 0x00
+	OutBIP32        = 0x02
+)
+
+// maxItemSize bounds the keys and values read.
+const maxItemSize = core.MaxMessagePayload
+
+var (
+	ErrInvalidMagic      = errors.New("invalid magic bytes")
+	ErrDuplicateKey      = errors.New("duplicate key")
+	ErrInvalidKey        = errors.New("invalid key")
+	ErrNoUnsignedTx      = errors.New("no unsigned transaction")
+	ErrScriptSigNotEmpty = errors.New("unsigned transaction has a non-empty scriptSig")
+)
+
+// Derivation is the BIP32 derivation of a public key: the fingerprint of the
+// master key and the path from it.
+type Derivation struct {
+	Fingerprint [4]byte
+	Path        []uint32
+}
+
+// Input holds what signers need to know about an input.
+type Input struct {
+	// UTXO is the output spent by the input.
+	UTXO *core.TxOut
+	// PartialSigs holds the signatures made so far, by serialized public key.
+	PartialSigs map[string][]byte
+	// SigHashType is the hash type signers must use, 0 if any.
+	SigHashType  uint32
+	RedeemScript *core.Script
+	// Derivations holds the BIP32 derivations of the keys involved, by
+	// serialized public key.
+	Derivations    map[string]*Derivation
+	FinalScriptSig *core.Script
+	Unknown        map[string][]byte
+}
+
+// Output holds what signers need to know about an output, such as to check
+// it is a change output.
+type Output struct {
+	RedeemScript *core.Script
+	Derivations  map[string]*Derivation
+	Unknown      map[string][]byte
+}
+
+// Packet is a partially signed transaction.
+type Packet struct {
+	// Tx is the transaction to sign, with empty scriptSigs.
+	Tx      *core.Tx
+	Inputs  []*Input
+	Outputs []*Output
+	Unknown map[string][]byte
+}
+
+func newInput() *Input {
+	return &Input{
+		PartialSigs: make(map[string][]byte),
+		Derivations: make(map[string]*Derivation),
+		Unknown:     make(map[string][]byte),
+	}
+}
+
+func newOutput() *Output {
+	return &Output{
+		Derivations: make(map[string]*Derivation),
+		Unknown:     make(map[string][]byte),
+	}
+}
+
+// writeItem writes the key made of keyType and keyData, then value.
+func writeItem(w io.Writer, keyType byte, keyData []byte, value []byte) error {
+	key := append([]byte{keyType}, keyData...)
+	if err := utils.WriteVarBytes(w, key); err != nil {
+		return err
+	}
+	return utils.WriteVarBytes(w, value)
+}
+
+// writeUnknown writes the items of unknown in key order.
+func writeUnknown(w io.Writer, unknown map[string][]byte) error {
+	for _, key := range sortedKeys(unknown) {
+		if err := utils.WriteVarBytes(w, []byte(key)); err != nil {
+			return err
+		}
+		if err := utils.WriteVarBytes(w, unknown[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeDerivations(w io.Writer, keyType byte, derivations map[string]*Derivation) error {
+	pubKeys := make([]string, 0, len(derivations))
+	for pubKey := range derivations {
+		pubKeys = append(pubKeys, pubKey)
+	}
+	sort.Strings(pubKeys)
+	for _, pubKey := range pubKeys {
+		derivation := derivations[pubKey]
+		value := make([]byte, 4, 4+4*len(derivation.Path))
+		copy(value, derivation.Fingerprint[:])
+		for _, index := range derivation.Path {
+			value = append(value, byte(index), byte(index>>8), byte(index>>16), byte(index>>24))
+		}
+		if err := writeItem(w, keyType, []byte(pubKey), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func serializeTx(tx *core.Tx) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, tx.SerializeSize()))
+	tx.Serialize(buf)
+	return buf.Bytes()
+}
+
+func serializeTxOut(txOut *core.TxOut) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, txOut.SerializeSize()))
+	txOut.Serialize(buf)
+	return buf.Bytes()
+}
+
+func (input *Input) serialize(w io.Writer) error {
+	if input.UTXO != nil {
+		if err := writeItem(w, InUTXO, nil, serializeTxOut(input.UTXO)); err != nil {
+			return err
+		}
+	}
+	for _, pubKey := range sortedKeys(input.PartialSigs) {
+		if err := writeItem(w, InPartialSig, []byte(pubKey), input.PartialSigs[pubKey]); err != nil {
+			return err
+		}
+	}
+	if input.SigHashType != 0 {
+		value := make([]byte, 4)
+		binary.LittleEndian.PutUint32(value, input.SigHashType)
+		if err := writeItem(w, InSigHashType, nil, value); err != nil {
+			return err
+		}
+	}
+	if input.RedeemScript != nil {
+		if err := writeItem(w, InRedeemScript, nil, input.RedeemScript.GetScriptByte()); err != nil {
+			return err
+		}
+	}
+	if err := writeDerivations(w, InBIP32, input.Derivations); err != nil {
+		return err
+	}
+	if input.FinalScriptSig != nil {
+		if err := writeItem(w, InFinalScriptSig, nil, input.FinalScriptSig.GetScriptByte()); err != nil {
+			return err
+		}
+	}
+	if err := writeUnknown(w, input.Unknown); err != nil {
+		return err
+	}
+	return utils.WriteVarInt(w, 0)
+}
+
+func (output *Output) serialize(w io.Writer) error {
+	if output.RedeemScript != nil {
+		if err := writeItem(w, OutRedeemScript, nil, output.RedeemScript.GetScriptByte()); err != nil {
+			return err
+		}
+	}
+	if err := writeDerivations(w, OutBIP32, output.Derivations); err != nil {
+		return err
+	}
+	if err := writeUnknown(w, output.Unknown); err != nil {
+		return err
+	}
+	return utils.WriteVarInt(w, 0)
+}
+
+// Serialize writes the packet in the BIP174 binary format.
+func (p *Packet) Serialize(w io.Writer) error {
+	if _, err := w.Write(Magic[:]); err != nil {
+		return err
+	}
+	if err := writeItem(w, GlobalUnsignedTx, nil, serializeTx(p.Tx)); err != nil {
+		return err
+	}
+	if err := writeUnknown(w, p.Unknown); err != nil {
+		return err
+	}
+	if err := utils.WriteVarInt(w, 0); err != nil {
+		return err
+	}
+	for _, input := range p.Inputs {
+		if err := input.serialize(w); err != nil {
+			return err
+		}
+	}
+	for _, output := range p.Outputs {
+		if err := output.serialize(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToBase64 returns the serialized packet in base64, as exchanged over RPC.
+func (p *Packet) ToBase64() (string, error) {
+	var buf bytes.Buffer
+	if err := p.Serialize(&buf); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// readMap calls handle for every item of the map read from r. Keys are
+// checked to be unique.
+func readMap(r io.Reader, handle func(keyType byte, keyData []byte, value []byte) error) error {
+	seen := make(map[string]struct{})
+	for {
+		key, err := utils.ReadVarBytes(r, maxItemSize, "psbt key")
+		if err != nil {
+			return err
+		}
+		if len(key) == 0 {
+			return nil
+		}
+		if _, ok := seen[string(key)]; ok {
+			return errors.Wrapf(ErrDuplicateKey, "%x", key)
+		}
+		seen[string(key)] = struct{}{}
+		value, err := utils.ReadVarBytes(r, maxItemSize, "psbt value")
+		if err != nil {
+			return err
+		}
+		if err := handle(key[0], key[1:], value); err != nil {
+			return err
+		}
+	}
+}
+
+func checkNoKeyData(keyType byte, keyData []byte) error {
+	if len(keyData) != 0 {
+		return errors.Wrapf(ErrInvalidKey, "type %#x takes no key data", keyType)
+	}
+	return nil
+}
+
+func checkPubKey(keyType byte, keyData []byte) error {
+	if !crypto.IsCompressedOrUncompressedPubKey(keyData) {
+		return errors.Wrapf(ErrInvalidKey, "type %#x needs a public key", keyType)
+	}
+	return nil
+}
+
+func parseDerivation(value []byte) (*Derivation, error) {
+	if len(value) < 4 || len(value)%4 != 0 {
+		return nil, errors.New("invalid BIP32 derivation")
+	}
+	derivation := &Derivation{Path: make([]uint32, 0, len(value)/4-1)}
+	copy(derivation.Fingerprint[:], value)
+	for i := 4; i < len(value); i += 4 {
+		derivation.Path = append(derivation.Path, binary.LittleEndian.Uint32(value[i:]))
+	}
+	return derivation, nil
+}
+
+func (input *Input) deserialize(r io.Reader) error {
+	return readMap(r, func(keyType byte, keyData []byte, value []byte) error {
+		switch keyType {
+		case InUTXO:
+			if err := checkNoKeyData(keyType, keyData); err != nil {
+				return err
+			}
+			reader := bytes.NewReader(value)
+			utxo := new(core.TxOut)
+			if err := utxo.Deserialize(reader); err != nil || reader.Len() != 0 {
+				return errors.New("invalid input utxo")
+			}
+			input.UTXO = utxo
+		case InPartialSig:
+			if err := checkPubKey(keyType, keyData); err != nil {
+				return err
+			}
+			input.PartialSigs[string(keyData)] = value
+		case InSigHashType:
+			if err := checkNoKeyData(keyType, keyData); err != nil {
+				return err
+			}
+			if len(value) != 4 {
+				return errors.New("invalid sighash type")
+			}
+			input.SigHashType = binary.LittleEndian.Uint32(value)
+		case InRedeemScript:
+			if err := checkNoKeyData(keyType, keyData); err != nil {
+				return err
+			}
+			input.RedeemScript = core.NewScriptRaw(value)
+		case InBIP32:
+			if err := checkPubKey(keyType, keyData); err != nil {
+				return err
+			}
+			derivation, err := parseDerivation(value)
+			if err != nil {
+				return err
+			}
+			input.Derivations[string(keyData)] = derivation
+		case InFinalScriptSig:
+			if err := checkNoKeyData(keyType, keyData); err != nil {
+				return err
+			}
+			input.FinalScriptSig = core.NewScriptRaw(value)
+		default:
+			input.Unknown[string(append([]byte{keyType}, keyData...))] = value
+		}
+		return nil
+	})
+}
+
+func (output *Output) deserialize(r io.Reader) error {
+	return readMap(r, func(keyType byte, keyData []byte, value []byte) error {
+		switch keyType {
+		case OutRedeemScript:
+			if err := checkNoKeyData(keyType, keyData); err != nil {
+				return err
+			}
+			output.RedeemScript = core.NewScriptRaw(value)
+		case OutBIP32:
+			if err := checkPubKey(keyType, keyData); err != nil {
+				return err
+			}
+			derivation, err := parseDerivation(value)
+			if err != nil {
+				return err
+			}
+			output.Derivations[string(keyData)] = derivation
+		default:
+			output.Unknown[string(append([]byte{keyType}, keyData...))] = value
+		}
+		return nil
+	})
+}
+
+// Deserialize reads a packet in the BIP174 binary format.
+func Deserialize(r io.Reader) (*Packet, error) {
+	var magic [5]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != Magic {
+		return nil, ErrInvalidMagic
+	}
+
+	p := &Packet{Unknown: make(map[string][]byte)}
+	err := readMap(r, func(keyType byte, keyData []byte, value []byte) error {
+		if keyType != GlobalUnsignedTx {
+			p.Unknown[string(append([]byte{keyType}, keyData...))] = value
+			return nil
+		}
+		if err := checkNoKeyData(keyType, keyData); err != nil {
+			return err
+		}
+		reader := bytes.NewReader(value)
+		tx, err := core.DeserializeTx(reader)
+		if err != nil || reader.Len() != 0 {
+			return errors.New("invalid unsigned transaction")
+		}
+		for _, txIn := range tx.Ins {
+			if txIn.Script.Size() != 0 {
+				return ErrScriptSigNotEmpty
+			}
+		}
+		p.Tx = tx
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.Tx == nil {
+		return nil, ErrNoUnsignedTx
+	}
+
+	p.Inputs = make([]*Input, len(p.Tx.Ins))
+	for i := range p.Inputs {
+		p.Inputs[i] = newInput()
+		if err := p.Inputs[i].deserialize(r); err != nil {
+			return nil, errors.Wrapf(err, "input %d", i)
+		}
+	}
+	p.Outputs = make([]*Output, len(p.Tx.Outs))
+	for i := range p.Outputs {
+		p.Outputs[i] = newOutput()
+		if err := p.Outputs[i].deserialize(r); err != nil {
+			return nil, errors.Wrapf(err, "output %d", i)
+		}
+	}
+	return p, nil
+}
+
+// FromBase64 decodes a packet serialized in base64.
+func FromBase64(str string) (*Packet, error) {
+	raw, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return nil, err
+	}
+	reader := bytes.NewReader(raw)
+	p, err := Deserialize(reader)
+	if err != nil {
+		return nil, err
+	}
+	if reader.Len() != 0 {
+		return nil, errors.New("extra data after the partially signed transaction")
+	}
+	return p, nil
+}
@@ -0,0 +1,168 @@
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/sign"
+	"github.com/btcboost/copernicus/utils"
+)
+
+func newTestKey(seed byte) *crypto.PrivateKey {
+	keyBytes := make([]byte, crypto.PrivateKeyBytesLen)
+	keyBytes[0] = 0x02
+	keyBytes[crypto.PrivateKeyBytesLen-1] = seed
+	return crypto.PrivateKeyFromBytes(keyBytes)
+}
+
+func multisigScript(keys ...*crypto.PrivateKey) *core.Script {
+	script := core.NewScriptRaw(nil)
+	script.PushInt64(int64(len(keys)))
+	for _, key := range keys {
+		script.PushData(key.PubKey().ToBytes())
+	}
+	script.PushInt64(int64(len(keys)))
+	script.PushOpCode(core.OP_CHECKMULTISIG)
+	return core.NewScriptRaw(script.GetScriptByte())
+}
+
+type derivationKeyStore struct {
+	*sign.BasicKeyStore
+	derivations map[string]*Derivation
+}
+
+func (ks *derivationKeyStore) GetDerivation(pubKey []byte) (*Derivation, bool) {
+	derivation, ok := ks.derivations[string(pubKey)]
+	return derivation, ok
+}
+
+func TestMultiPartySigning(t *testing.T) {
+	cosigners := []*crypto.PrivateKey{newTestKey(1), newTestKey(2)}
+	redeemScript := multisigScript(cosigners...)
+	payer := newTestKey(3)
+
+	// The multisig output is spent by the first input.
+	outPoints := []core.OutPoint{{Hash: utils.Hash{1}, Index: 0}, {Hash: utils.Hash{2}, Index: 3}}
+	coins := map[core.OutPoint]*core.TxOut{
+		outPoints[0]: core.NewTxOut(utils.COIN,
+			core.PayToScriptHash(utils.Hash160(redeemScript.GetScriptByte())).GetScriptByte()),
+		outPoints[1]: core.NewTxOut(2*utils.COIN,
+			core.PayToPubKeyHash(utils.Hash160(payer.PubKey().ToBytes())).GetScriptByte()),
+	}
+	tx := core.NewTx()
+	for i := range outPoints {
+		tx.AddTxIn(core.NewTxIn(&outPoints[i], nil))
+	}
+	tx.AddTxOut(core.NewTxOut(3*utils.COIN-1000, coins[*tx.Ins[0].PreviousOutPoint].Script.GetScriptByte()))
+
+	// The coordinator creates the packet and fills in what signers need.
+	p, err := New(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coordinator := &derivationKeyStore{sign.NewBasicKeyStore(), map[string]*Derivation{
+		string(cosigners[0].PubKey().ToBytes()): {Fingerprint: [4]byte{1, 2, 3, 4}, Path: []uint32{0x8000002c, 1}},
+	}}
+	coordinator.AddScript(redeemScript)
+	p.Update(coins, coordinator)
+	encoded, err := p.ToBase64()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each signer signs a copy of its own.
+	signed := make([]*Packet, 0, 3)
+	for _, key := range append(cosigners, payer) {
+		p, err := FromBase64(encoded)
+		if err != nil {
+			t.Fatalf("failed to decode %s: %s", encoded, err)
+		}
+		keyStore := sign.NewBasicKeyStore()
+		keyStore.AddKey(key)
+		if complete, err := p.Sign(keyStore, crypto.SigHashAll|crypto.SigHashForkID); err != nil || complete {
+			t.Fatalf("a single signer should not complete the transaction: %v", err)
+		}
+		signed = append(signed, p)
+	}
+	if signed[0].Finalize() {
+		t.Errorf("a partially signed transaction should not be finalized")
+	}
+
+	combined, err := Combine(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range combined.Inputs {
+		if input.UTXO == nil {
+			t.Fatalf("the utxos should be kept")
+		}
+	}
+	if derivation := combined.Inputs[0].Derivations[string(cosigners[0].PubKey().ToBytes())]; derivation == nil ||
+		derivation.Fingerprint != [4]byte{1, 2, 3, 4} || len(derivation.Path) != 2 || derivation.Path[1] != 1 {
+		t.Errorf("the derivation path should survive serialization, got %+v", derivation)
+	}
+	if !combined.Finalize() {
+		t.Fatalf("the combined signatures should be complete")
+	}
+	if len(combined.Inputs[0].PartialSigs) != 0 || combined.Inputs[0].RedeemScript != nil {
+		t.Errorf("finalized inputs should only keep their scriptSig")
+	}
+	final, err := combined.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The result is the transaction a signer holding all the keys makes.
+	keyStore := sign.NewBasicKeyStore()
+	for _, key := range append(cosigners, payer) {
+		keyStore.AddKey(key)
+	}
+	keyStore.AddScript(redeemScript)
+	if errs := sign.SignTransaction(tx, coins, keyStore, crypto.SigHashAll|crypto.SigHashForkID); len(errs) != 0 {
+		t.Fatalf("failed to sign: %v", errs[0].Err)
+	}
+	for i := range tx.Ins {
+		if !bytes.Equal(final.Ins[i].Script.GetScriptByte(), tx.Ins[i].Script.GetScriptByte()) {
+			t.Errorf("input %d: expected scriptSig %x, got %x", i,
+				tx.Ins[i].Script.GetScriptByte(), final.Ins[i].Script.GetScriptByte())
+		}
+	}
+	if hash := final.TxHash(); hash.IsEqual(&combined.Tx.Hash) {
+		t.Errorf("the extracted transaction should not keep the hash of the unsigned one")
+	}
+}
+
+func TestDeserializeErrors(t *testing.T) {
+	tx := core.NewTx()
+	tx.AddTxIn(core.NewTxIn(&core.OutPoint{Hash: utils.Hash{1}}, nil))
+	p, _ := New(tx)
+	p.Inputs[0].SigHashType = crypto.SigHashAll | crypto.SigHashForkID
+	var buf bytes.Buffer
+	if err := p.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	if _, err := Deserialize(bytes.NewReader(valid)); err != nil {
+		t.Fatalf("failed to deserialize: %s", err)
+	}
+
+	badMagic := append([]byte{}, valid...)
+	badMagic[0] = 'x'
+	// The sighash item of the input is repeated before the separator.
+	item := []byte{1, InSigHashType, 4, crypto.SigHashAll | crypto.SigHashForkID, 0, 0, 0}
+	duplicate := append(append(append([]byte{}, valid[:len(valid)-1]...), item...), 0)
+	signedTx := tx.Copy()
+	signedTx.Ins[0].Script = core.NewScriptRaw([]byte{core.OP_0})
+	if _, err := New(signedTx); err != ErrScriptSigNotEmpty {
+		t.Errorf("transactions with scriptSigs should be refused, got %v", err)
+	}
+
+	for name, raw := range map[string][]byte{"magic": badMagic, "duplicate": duplicate, "truncated": valid[:len(valid)-1]} {
+		if _, err := FromBase64(base64.StdEncoding.EncodeToString(raw)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
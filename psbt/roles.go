@@ -0,0 +1,318 @@
+package psbt
+
+import (
+	"bytes"
+
+	"github.com/btcboost/copernicus/container"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/sign"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrMissingUTXO     = errors.New("input has no utxo")
+	ErrSigHashMismatch = errors.New("sighash type does not match the one of the input")
+	ErrTxMismatch      = errors.New("partially signed transactions of different transactions")
+	ErrNotFinalized    = errors.New("partially signed transaction is not finalized")
+	ErrInputOutOfRange = errors.New("input index out of range")
+)
+
+// DerivationStore is implemented by key stores which know the BIP32
+// derivation of their keys.
+type DerivationStore interface {
+	GetDerivation(pubKey []byte) (*Derivation, bool)
+}
+
+// New returns the packet of tx, whose scriptSigs must be empty.
+func New(tx *core.Tx) (*Packet, error) {
+	for _, txIn := range tx.Ins {
+		if txIn.Script.Size() != 0 {
+			return nil, ErrScriptSigNotEmpty
+		}
+	}
+	p := &Packet{
+		Tx:      tx.Copy(),
+		Inputs:  make([]*Input, len(tx.Ins)),
+		Outputs: make([]*Output, len(tx.Outs)),
+		Unknown: make(map[string][]byte),
+	}
+	for i := range p.Inputs {
+		p.Inputs[i] = newInput()
+	}
+	for i := range p.Outputs {
+		p.Outputs[i] = newOutput()
+	}
+	return p, nil
+}
+
+// inputKeyStore adds the redeem script of an input to a key store, which may
+// be nil.
+type inputKeyStore struct {
+	keyStore     sign.KeyStore
+	redeemScript *core.Script
+}
+
+func (ks *inputKeyStore) GetKey(keyID []byte) (*crypto.PrivateKey, bool) {
+	if ks.keyStore == nil {
+		return nil, false
+	}
+	return ks.keyStore.GetKey(keyID)
+}
+
+func (ks *inputKeyStore) GetScript(scriptID []byte) (*core.Script, bool) {
+	if ks.redeemScript != nil && bytes.Equal(utils.Hash160(ks.redeemScript.GetScriptByte()), scriptID) {
+		return ks.redeemScript, true
+	}
+	if ks.keyStore == nil {
+		return nil, false
+	}
+	return ks.keyStore.GetScript(scriptID)
+}
+
+// solve returns the script to satisfy for scriptPubKey, its redeem script
+// for P2SH, and the public keys the script may need a signature of.
+func solve(scriptPubKey *core.Script, keyStore sign.KeyStore) (*core.Script, [][]byte) {
+	script := scriptPubKey
+	var scriptType int
+	solutions := container.NewVector()
+	if !core.Solver(script, &scriptType, solutions) {
+		return nil, nil
+	}
+	if scriptType == core.TxScriptHash {
+		redeemScript, ok := keyStore.GetScript(solutions.Array[0].([]byte))
+		if !ok {
+			return nil, nil
+		}
+		script = redeemScript
+		if !core.Solver(script, &scriptType, solutions) {
+			return script, nil
+		}
+	}
+
+	var pubKeys [][]byte
+	switch scriptType {
+	case core.TxPubKey:
+		pubKeys = append(pubKeys, solutions.Array[0].([]byte))
+	case core.TxPubKeyHash:
+		if key, ok := keyStore.GetKey(solutions.Array[0].([]byte)); ok {
+			pubKeys = append(pubKeys, key.PubKey().ToBytes())
+		}
+	case core.TxMultiSig:
+		for _, pubKey := range solutions.Array[1 : solutions.Size()-1] {
+			pubKeys = append(pubKeys, pubKey.([]byte))
+		}
+	}
+	return script, pubKeys
+}
+
+// addDerivations records the derivations keyStore knows of pubKeys.
+func addDerivations(derivations map[string]*Derivation, pubKeys [][]byte, keyStore sign.KeyStore) {
+	derivationStore, ok := keyStore.(DerivationStore)
+	if !ok {
+		return
+	}
+	for _, pubKey := range pubKeys {
+		if derivation, ok := derivationStore.GetDerivation(pubKey); ok {
+			derivations[string(pubKey)] = derivation
+		}
+	}
+}
+
+// Update completes the packet with the spent outputs found in coins and the
+// redeem scripts and key derivations of keyStore, which may be nil.
+func (p *Packet) Update(coins map[core.OutPoint]*core.TxOut, keyStore sign.KeyStore) {
+	for i, input := range p.Inputs {
+		if input.FinalScriptSig != nil {
+			continue
+		}
+		if input.UTXO == nil {
+			if utxo, ok := coins[*p.Tx.Ins[i].PreviousOutPoint]; ok {
+				input.UTXO = utxo
+			}
+		}
+		if input.UTXO == nil {
+			continue
+		}
+		script, pubKeys := solve(input.UTXO.Script, &inputKeyStore{keyStore, input.RedeemScript})
+		if script != nil && script != input.UTXO.Script {
+			input.RedeemScript = script
+		}
+		addDerivations(input.Derivations, pubKeys, keyStore)
+	}
+
+	if keyStore == nil {
+		return
+	}
+	for i, output := range p.Outputs {
+		script, pubKeys := solve(p.Tx.Outs[i].Script, &inputKeyStore{keyStore, output.RedeemScript})
+		if script != nil && script != p.Tx.Outs[i].Script {
+			output.RedeemScript = script
+		}
+		addDerivations(output.Derivations, pubKeys, keyStore)
+	}
+}
+
+// SignInput adds the signatures keyStore can make for input i, with hashType,
+// to its partial signatures. It returns whether the input can be finalized.
+func (p *Packet) SignInput(i int, keyStore sign.KeyStore, hashType uint32) (bool, error) {
+	if i < 0 || i >= len(p.Inputs) {
+		return false, ErrInputOutOfRange
+	}
+	input := p.Inputs[i]
+	if input.FinalScriptSig != nil {
+		return true, nil
+	}
+	if input.UTXO == nil {
+		return false, ErrMissingUTXO
+	}
+	if input.SigHashType != 0 && input.SigHashType != hashType {
+		return false, ErrSigHashMismatch
+	}
+	if hashType&0x1f == crypto.SigHashSingle && i >= len(p.Tx.Outs) {
+		return false, sign.ErrNotSigned
+	}
+
+	inputKeys := &inputKeyStore{keyStore, input.RedeemScript}
+	if input.RedeemScript == nil && input.UTXO.Script.IsPayToScriptHash() {
+		input.RedeemScript, _ = solve(input.UTXO.Script, inputKeys)
+	}
+	_, complete := sign.ProduceScriptSig(p.Tx, i, input.UTXO, inputKeys, input.PartialSigs, hashType, nil)
+	return complete, nil
+}
+
+// Sign signs every input keyStore has keys for. It returns whether all the
+// inputs can be finalized.
+func (p *Packet) Sign(keyStore sign.KeyStore, hashType uint32) (bool, error) {
+	complete := true
+	for i, input := range p.Inputs {
+		if input.UTXO == nil {
+			complete = false
+			continue
+		}
+		inputComplete, err := p.SignInput(i, keyStore, hashType)
+		if err != nil {
+			return false, errors.Wrapf(err, "input %d", i)
+		}
+		complete = complete && inputComplete
+	}
+	return complete, nil
+}
+
+func mergeItems(dst map[string][]byte, src map[string][]byte) {
+	for key, value := range src {
+		if _, ok := dst[key]; !ok {
+			dst[key] = value
+		}
+	}
+}
+
+func mergeDerivations(dst map[string]*Derivation, src map[string]*Derivation) {
+	for key, value := range src {
+		if _, ok := dst[key]; !ok {
+			dst[key] = value
+		}
+	}
+}
+
+// Combine merges packets of the same transaction, such as those returned by
+// each cosigner, into a new packet.
+func Combine(packets []*Packet) (*Packet, error) {
+	if len(packets) == 0 {
+		return nil, errors.New("no partially signed transaction to combine")
+	}
+	combined, err := packets[0].Copy()
+	if err != nil {
+		return nil, err
+	}
+	txHash := combined.Tx.TxHash()
+	for _, p := range packets[1:] {
+		if hash := p.Tx.TxHash(); !hash.IsEqual(&txHash) {
+			return nil, ErrTxMismatch
+		}
+		mergeItems(combined.Unknown, p.Unknown)
+		for i, input := range p.Inputs {
+			dst := combined.Inputs[i]
+			if dst.UTXO == nil {
+				dst.UTXO = input.UTXO
+			}
+			if dst.SigHashType == 0 {
+				dst.SigHashType = input.SigHashType
+			}
+			if dst.RedeemScript == nil {
+				dst.RedeemScript = input.RedeemScript
+			}
+			if dst.FinalScriptSig == nil {
+				dst.FinalScriptSig = input.FinalScriptSig
+			}
+			mergeItems(dst.PartialSigs, input.PartialSigs)
+			mergeDerivations(dst.Derivations, input.Derivations)
+			mergeItems(dst.Unknown, input.Unknown)
+		}
+		for i, output := range p.Outputs {
+			dst := combined.Outputs[i]
+			if dst.RedeemScript == nil {
+				dst.RedeemScript = output.RedeemScript
+			}
+			mergeDerivations(dst.Derivations, output.Derivations)
+			mergeItems(dst.Unknown, output.Unknown)
+		}
+	}
+	return combined, nil
+}
+
+// Copy returns a deep copy of the packet.
+func (p *Packet) Copy() (*Packet, error) {
+	var buf bytes.Buffer
+	if err := p.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return Deserialize(&buf)
+}
+
+// Finalize builds the scriptSig of every input whose partial signatures are
+// complete, dropping the data only signers need. It returns whether all the
+// inputs are finalized.
+func (p *Packet) Finalize() bool {
+	cache := core.NewPrecomputedTransactionData(p.Tx)
+	for i, input := range p.Inputs {
+		if input.FinalScriptSig != nil || input.UTXO == nil {
+			continue
+		}
+		scriptSig, complete := sign.ProduceScriptSig(p.Tx, i, input.UTXO, &inputKeyStore{nil, input.RedeemScript},
+			input.PartialSigs, input.SigHashType, cache)
+		if !complete {
+			continue
+		}
+		input.FinalScriptSig = scriptSig
+		input.PartialSigs = make(map[string][]byte)
+		input.SigHashType = 0
+		input.RedeemScript = nil
+		input.Derivations = make(map[string]*Derivation)
+	}
+	return p.IsComplete()
+}
+
+// IsComplete returns whether every input is finalized.
+func (p *Packet) IsComplete() bool {
+	for _, input := range p.Inputs {
+		if input.FinalScriptSig == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract returns the signed transaction of a finalized packet.
+func (p *Packet) Extract() (*core.Tx, error) {
+	if !p.IsComplete() {
+		return nil, ErrNotFinalized
+	}
+	tx := p.Tx.Copy()
+	for i, input := range p.Inputs {
+		tx.Ins[i].Script = core.NewScriptRaw(input.FinalScriptSig.GetScriptByte())
+	}
+	tx.Hash = utils.Hash{}
+	return tx, nil
+}
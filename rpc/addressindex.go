@@ -0,0 +1,240 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/btcboost/copernicus/blockchain"
+	"github.com/btcboost/copernicus/utils"
+)
+
+var addressIndexHandlers = map[string]commandHandler{
+	"getaddresstxids":   handleGetAddressTxIDs,
+	"getaddressbalance": handleGetAddressBalance,
+	"getaddressutxos":   handleGetAddressUtxos,
+	"getaddressdeltas":  handleGetAddressDeltas,
+}
+
+func init() {
+	registerHandlers(addressIndexHandlers)
+}
+
+// addressRequest is the param of the address index commands: an address,
+// or an object listing addresses with a range of heights and a page of
+// results.
+type addressRequest struct {
+	Addresses []string `json:"addresses"`
+	Start     int      `json:"start"`
+	End       int      `json:"end"`
+	Offset    int      `json:"offset"`
+	Limit     int      `json:"limit"`
+
+	scriptHashes []utils.Hash
+}
+
+// parseAddressRequest decodes the address request at index 0 of params.
+func parseAddressRequest(params []json.RawMessage) (*addressRequest, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	request := new(addressRequest)
+	var address string
+	if err := json.Unmarshal(params[0], &address); err == nil {
+		request.Addresses = []string{address}
+	} else if err := parseParam(params, 0, request, "an address or an object"); err != nil {
+		return nil, err
+	}
+	if len(request.Addresses) == 0 {
+		return nil, NewRPCError(RPCInvalidParameter, "No addresses")
+	}
+	if request.Start < 0 || request.End < 0 || (request.End > 0 && request.End < request.Start) {
+		return nil, NewRPCError(RPCInvalidParameter, "Invalid start or end height")
+	}
+	if request.Offset < 0 || request.Limit < 0 {
+		return nil, NewRPCError(RPCInvalidParameter, "Invalid offset or limit")
+	}
+	for _, address := range request.Addresses {
+		script, err := addressScript(address)
+		if err != nil {
+			return nil, err
+		}
+		request.scriptHashes = append(request.scriptHashes, blockchain.ScriptHash(script.GetScriptByte()))
+	}
+	return request, nil
+}
+
+// page returns the bounds of the requested page of n results.
+func (request *addressRequest) page(n int) (int, int) {
+	if request.Offset >= n {
+		return n, n
+	}
+	if request.Limit == 0 || request.Offset+request.Limit > n {
+		return request.Offset, n
+	}
+	return request.Offset, request.Offset + request.Limit
+}
+
+// addressIndexError converts an error of the address index.
+func addressIndexError(err error) error {
+	if err == blockchain.ErrAddressIndexDisabled {
+		return NewRPCError(RPCMiscError, "Address index not enabled. Use -addressindex")
+	}
+	return NewRPCError(RPCDatabaseError, err.Error())
+}
+
+// addressDelta is a balance change of one of the requested addresses.
+type addressDelta struct {
+	*blockchain.AddressDelta
+	address string
+}
+
+// addressDeltas returns the balance changes of the requested addresses in
+// the requested blocks, by height and position in the block.
+func addressDeltas(request *addressRequest) ([]addressDelta, error) {
+	var deltas []addressDelta
+	for i := range request.scriptHashes {
+		found, err := blockchain.GetAddressDeltas(&request.scriptHashes[i], request.Start, request.End)
+		if err != nil {
+			return nil, addressIndexError(err)
+		}
+		for _, delta := range found {
+			deltas = append(deltas, addressDelta{delta, request.Addresses[i]})
+		}
+	}
+	sort.SliceStable(deltas, func(i, j int) bool {
+		if deltas[i].Height != deltas[j].Height {
+			return deltas[i].Height < deltas[j].Height
+		}
+		return deltas[i].TxPos < deltas[j].TxPos
+	})
+	return deltas, nil
+}
+
+// handleGetAddressTxIDs implements the getaddresstxids command: it returns
+// the transactions funding or spending from the addresses, by height.
+func handleGetAddressTxIDs(s *Server, params []json.RawMessage) (interface{}, error) {
+	request, err := parseAddressRequest(params)
+	if err != nil {
+		return nil, err
+	}
+	deltas, err := addressDeltas(request)
+	if err != nil {
+		return nil, err
+	}
+	txids := make([]string, 0, len(deltas))
+	seen := make(map[utils.Hash]bool)
+	for _, delta := range deltas {
+		if !seen[delta.TxID] {
+			seen[delta.TxID] = true
+			txids = append(txids, delta.TxID.ToString())
+		}
+	}
+	lo, hi := request.page(len(txids))
+	return txids[lo:hi], nil
+}
+
+// AddressDeltaResult is a balance change listed by getaddressdeltas.
+type AddressDeltaResult struct {
+	Satoshis   int64  `json:"satoshis"`
+	TxID       string `json:"txid"`
+	Index      uint32 `json:"index"`
+	BlockIndex uint32 `json:"blockindex"`
+	Height     int    `json:"height"`
+	Address    string `json:"address"`
+}
+
+// handleGetAddressDeltas implements the getaddressdeltas command: it lists
+// the outputs paying to the addresses and the inputs spending them.
+func handleGetAddressDeltas(s *Server, params []json.RawMessage) (interface{}, error) {
+	request, err := parseAddressRequest(params)
+	if err != nil {
+		return nil, err
+	}
+	deltas, err := addressDeltas(request)
+	if err != nil {
+		return nil, err
+	}
+	lo, hi := request.page(len(deltas))
+	results := make([]AddressDeltaResult, 0, hi-lo)
+	for _, delta := range deltas[lo:hi] {
+		results = append(results, AddressDeltaResult{
+			Satoshis:   delta.Value,
+			TxID:       delta.TxID.ToString(),
+			Index:      delta.Index,
+			BlockIndex: delta.TxPos,
+			Height:     delta.Height,
+			Address:    delta.address,
+		})
+	}
+	return results, nil
+}
+
+// AddressBalanceResult is the result of getaddressbalance.
+type AddressBalanceResult struct {
+	Balance  int64 `json:"balance"`
+	Received int64 `json:"received"`
+}
+
+// handleGetAddressBalance implements the getaddressbalance command: it
+// returns the balance of the addresses and the total they received.
+func handleGetAddressBalance(s *Server, params []json.RawMessage) (interface{}, error) {
+	request, err := parseAddressRequest(params)
+	if err != nil {
+		return nil, err
+	}
+	request.Start, request.End = 0, 0
+	deltas, err := addressDeltas(request)
+	if err != nil {
+		return nil, err
+	}
+	result := new(AddressBalanceResult)
+	for _, delta := range deltas {
+		result.Balance += delta.Value
+		if delta.Value > 0 {
+			result.Received += delta.Value
+		}
+	}
+	return result, nil
+}
+
+// AddressUtxoResult is an unspent output listed by getaddressutxos.
+type AddressUtxoResult struct {
+	Address     string `json:"address"`
+	TxID        string `json:"txid"`
+	OutputIndex uint32 `json:"outputIndex"`
+	Script      string `json:"script"`
+	Satoshis    int64  `json:"satoshis"`
+	Height      int    `json:"height"`
+}
+
+// handleGetAddressUtxos implements the getaddressutxos command: it lists the
+// unspent outputs paying to the addresses, by height.
+func handleGetAddressUtxos(s *Server, params []json.RawMessage) (interface{}, error) {
+	request, err := parseAddressRequest(params)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]AddressUtxoResult, 0)
+	for i := range request.scriptHashes {
+		unspents, err := blockchain.GetAddressUnspent(&request.scriptHashes[i])
+		if err != nil {
+			return nil, addressIndexError(err)
+		}
+		for _, unspent := range unspents {
+			results = append(results, AddressUtxoResult{
+				Address:     request.Addresses[i],
+				TxID:        unspent.OutPoint.Hash.ToString(),
+				OutputIndex: unspent.OutPoint.Index,
+				Script:      hex.EncodeToString(unspent.Script),
+				Satoshis:    unspent.Value,
+				Height:      unspent.Height,
+			})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Height < results[j].Height
+	})
+	lo, hi := request.page(len(results))
+	return results[lo:hi], nil
+}
@@ -0,0 +1,29 @@
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAddressIndexParams(t *testing.T) {
+	tests := []struct {
+		param string
+		code  int
+	}{
+		{`"notanaddress"`, RPCInvalidAddressOrKey},
+		{`{"addresses": []}`, RPCInvalidParameter},
+		{`{"addresses": ["bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"], "start": 5, "end": 2}`,
+			RPCInvalidParameter},
+		{`{"addresses": ["bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"], "limit": -1}`,
+			RPCInvalidParameter},
+		{`7`, RPCTypeError},
+	}
+	for _, method := range []string{"getaddresstxids", "getaddressbalance", "getaddressutxos", "getaddressdeltas"} {
+		for _, test := range tests {
+			_, rpcErr := NewServer("", "", "").Execute(method, []json.RawMessage{json.RawMessage(test.param)})
+			if rpcErr == nil || rpcErr.Code != test.code {
+				t.Errorf("%s %s: expected error %d, got %v", method, test.param, test.code, rpcErr)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+)
+
+const (
+	// cookieAuthUser is the user name of the cookie authentication.
+	cookieAuthUser = "__cookie__"
+	// cookieAuthFile is the file of the data directory holding the cookie.
+	cookieAuthFile = ".cookie"
+)
+
+// GenerateAuthCookie makes a random password for the cookie user and writes
+// both to the cookie file of dataDir, where the local clients read them when
+// no rpcpassword is configured.
+func GenerateAuthCookie(dataDir string) (user string, password string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	password = hex.EncodeToString(buf)
+	cookie := cookieAuthUser + ":" + password
+	if err = ioutil.WriteFile(filepath.Join(dataDir, cookieAuthFile), []byte(cookie), 0600); err != nil {
+		return "", "", err
+	}
+	return cookieAuthUser, password, nil
+}
+
+// checkAuth reports whether r carries the basic authorization of the server.
+// A server without a password accepts no request.
+func (s *Server) checkAuth(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok || s.password == "" {
+		return false
+	}
+	// Compare the digests so the time taken does not leak the lengths.
+	gotUser, gotPassword := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(password))
+	wantUser, wantPassword := sha256.Sum256([]byte(s.user)), sha256.Sum256([]byte(s.password))
+	return subtle.ConstantTimeCompare(gotUser[:], wantUser[:])&
+		subtle.ConstantTimeCompare(gotPassword[:], wantPassword[:]) == 1
+}
+
+// isJSONRequest reports whether r declares a JSON body. Browsers post the
+// other types across origins without a preflight, so only JSON is served.
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
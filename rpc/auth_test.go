@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTPAuth(t *testing.T) {
+	tests := []struct {
+		password    string
+		user, pass  string
+		contentType string
+		status      int
+	}{
+		{"password", "user", "password", "application/json", http.StatusOK},
+		{"password", "user", "password", "application/json; charset=utf-8", http.StatusOK},
+		{"password", "user", "wrong", "application/json", http.StatusUnauthorized},
+		{"password", "other", "password", "application/json", http.StatusUnauthorized},
+		{"password", "", "", "application/json", http.StatusUnauthorized},
+		{"", "user", "", "application/json", http.StatusUnauthorized},
+		{"password", "user", "password", "text/plain", http.StatusUnsupportedMediaType},
+		{"password", "user", "password", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"password", "user", "password", "", http.StatusUnsupportedMediaType},
+	}
+
+	for i, test := range tests {
+		s := NewServer("", "user", test.password)
+		request := httptest.NewRequest(http.MethodPost, "/",
+			strings.NewReader(`{"method":"getblockchaininfo","params":[],"id":1}`))
+		if test.user != "" || test.pass != "" {
+			request.SetBasicAuth(test.user, test.pass)
+		}
+		if test.contentType != "" {
+			request.Header.Set("Content-Type", test.contentType)
+		}
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("#%d: expected status %d, got %d", i, test.status, recorder.Code)
+		}
+	}
+}
+
+func TestGenerateAuthCookie(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "rpcauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	user, password, err := GenerateAuthCookie(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := ioutil.ReadFile(filepath.Join(dataDir, cookieAuthFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != cookieAuthUser || len(password) != 64 || string(cookie) != user+":"+password {
+		t.Errorf("unexpected cookie %q for %s:%s", cookie, user, password)
+	}
+	if _, again, _ := GenerateAuthCookie(dataDir); again == password {
+		t.Errorf("the cookie password should be random")
+	}
+}
@@ -0,0 +1,164 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/btcboost/copernicus/blockchain"
+	"github.com/btcboost/copernicus/consensus"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/net/msg"
+)
+
+var blockchainHandlers = map[string]commandHandler{
+	"getblockchaininfo": handleGetBlockChainInfo,
+	"invalidateblock":   handleInvalidateBlock,
+	"preciousblock":     handlePreciousBlock,
+	"finalizeblock":     handleFinalizeBlock,
+	"parkblock":         handleParkBlock,
+	"unparkblock":       handleUnparkBlock,
+}
+
+func init() {
+	registerHandlers(blockchainHandlers)
+}
+
+// lookupBlockIndex returns the index of the block whose hash is param 0.
+func lookupBlockIndex(params []json.RawMessage) (*core.BlockIndex, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	hash, err := parseHashParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	index, ok := blockchain.GChainState.MapBlockIndex.Data[*hash]
+	if !ok {
+		return nil, NewRPCError(RPCInvalidAddressOrKey, "Block not found")
+	}
+	return index, nil
+}
+
+// applyBlockCommand runs command on the block of params and activates the
+// resulting best chain.
+func applyBlockCommand(params []json.RawMessage,
+	command func(*msg.BitcoinParams, *core.ValidationState, *core.BlockIndex) bool) (interface{}, error) {
+	index, err := lookupBlockIndex(params)
+	if err != nil {
+		return nil, err
+	}
+
+	state := core.ValidationState{}
+	if command(msg.ActiveNetParams, &state, index) && state.IsValid() {
+		blockchain.ActivateBestChain(msg.ActiveNetParams, &state, nil)
+	}
+	if !state.IsValid() {
+		return nil, NewRPCError(RPCDatabaseError, state.GetRejectReason())
+	}
+	return nil, nil
+}
+
+// handleInvalidateBlock implements the invalidateblock command: the block and
+// its descendants are permanently marked invalid.
+func handleInvalidateBlock(s *Server, params []json.RawMessage) (interface{}, error) {
+	return applyBlockCommand(params, blockchain.InvalidateBlock)
+}
+
+// handlePreciousBlock implements the preciousblock command: the block is
+// treated as if it was received before others with the same work.
+func handlePreciousBlock(s *Server, params []json.RawMessage) (interface{}, error) {
+	index, err := lookupBlockIndex(params)
+	if err != nil {
+		return nil, err
+	}
+	state := core.ValidationState{}
+	blockchain.PreciousBlock(msg.ActiveNetParams, &state, index)
+	if !state.IsValid() {
+		return nil, NewRPCError(RPCDatabaseError, state.GetRejectReason())
+	}
+	return nil, nil
+}
+
+// handleFinalizeBlock implements the finalizeblock command: the block becomes
+// final and any chain conflicting with it is invalidated.
+func handleFinalizeBlock(s *Server, params []json.RawMessage) (interface{}, error) {
+	return applyBlockCommand(params, blockchain.FinalizeBlockAndInvalidate)
+}
+
+// handleParkBlock implements the parkblock command: the block and its
+// descendants are held out of the active chain until unparked.
+func handleParkBlock(s *Server, params []json.RawMessage) (interface{}, error) {
+	return applyBlockCommand(params, blockchain.ParkBlock)
+}
+
+// handleUnparkBlock implements the unparkblock command, reverting parkblock.
+func handleUnparkBlock(s *Server, params []json.RawMessage) (interface{}, error) {
+	return applyBlockCommand(params,
+		func(params *msg.BitcoinParams, state *core.ValidationState, index *core.BlockIndex) bool {
+			blockchain.UnparkBlock(index)
+			return true
+		})
+}
+
+// UpgradeInfo describes one entry of the upgrade table in getblockchaininfo.
+type UpgradeInfo struct {
+	Name       string `json:"name"`
+	Activation string `json:"activation"`
+	Height     int    `json:"height"`
+	Time       int64  `json:"time,omitempty"`
+	Active     bool   `json:"active"`
+}
+
+// BlockChainInfo is the result of getblockchaininfo.
+type BlockChainInfo struct {
+	Chain          string        `json:"chain"`
+	Blocks         int           `json:"blocks"`
+	Headers        int           `json:"headers"`
+	BestBlockHash  string        `json:"bestblockhash"`
+	MedianTime     int64         `json:"mediantime"`
+	ChainWork      string        `json:"chainwork"`
+	FinalizedBlock string        `json:"finalizedblockhash,omitempty"`
+	Upgrades       []UpgradeInfo `json:"upgrades"`
+}
+
+// handleGetBlockChainInfo implements the getblockchaininfo command, which
+// reports the state of the active chain and of the network upgrades for the
+// next block.
+func handleGetBlockChainInfo(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 0); err != nil {
+		return nil, err
+	}
+	chainParams := msg.ActiveNetParams
+	tip := blockchain.GChainState.ChainActive.Tip()
+	info := &BlockChainInfo{
+		Chain:    chainParams.Name,
+		Blocks:   blockchain.GChainState.ChainActive.Height(),
+		Headers:  -1,
+		Upgrades: make([]UpgradeInfo, 0, len(chainParams.Upgrades)),
+	}
+	if blockchain.GIndexBestHeader != nil {
+		info.Headers = blockchain.GIndexBestHeader.Height
+	}
+	if tip != nil {
+		info.BestBlockHash = tip.GetBlockHash().ToString()
+		info.MedianTime = tip.GetMedianTimePast()
+		info.ChainWork = fmt.Sprintf("%064x", &tip.ChainWork)
+	}
+	if finalized := blockchain.GetFinalizedBlock(); finalized != nil {
+		info.FinalizedBlock = finalized.GetBlockHash().ToString()
+	}
+	for _, upgrade := range chainParams.Upgrades {
+		upgradeInfo := UpgradeInfo{
+			Name:       upgrade.Name,
+			Activation: upgrade.Activation.String(),
+			Active:     blockchain.IsUpgradeActive(chainParams, upgrade.Name, tip),
+		}
+		if upgrade.Activation == consensus.ActivationHeight {
+			upgradeInfo.Height = upgrade.Height
+		} else {
+			upgradeInfo.Time = upgrade.Time
+		}
+		info.Upgrades = append(info.Upgrades, upgradeInfo)
+	}
+	return info, nil
+}
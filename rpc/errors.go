@@ -0,0 +1,111 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/btcboost/copernicus/utils"
+)
+
+// RPC error codes, the same as bitcoind's.
+const (
+	// Standard JSON-RPC 2.0 errors
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	RPCParseError     = -32700
+
+	// General application defined errors
+	RPCMiscError            = -1
+	RPCTypeError            = -3
+	RPCInvalidAddressOrKey  = -5
+	RPCOutOfMemory          = -7
+	RPCInvalidParameter     = -8
+	RPCDatabaseError        = -20
+	RPCDeserializationError = -22
+	RPCVerifyError          = -25
+	RPCVerifyRejected       = -26
+	RPCInWarmup             = -28
+
+	// Wallet errors
+	RPCWalletError               = -4
+	RPCWalletInsufficientFunds   = -6
+	RPCWalletUnlockNeeded        = -13
+	RPCWalletPassphraseIncorrect = -14
+	RPCWalletWrongEncState       = -15
+	RPCWalletEncryptionFailed    = -16
+)
+
+// RPCError is the error member of a response.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewRPCError returns an RPCError with code and message.
+func NewRPCError(code int, message string) *RPCError {
+	return &RPCError{Code: code, Message: message}
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+func (e *RPCError) httpStatus() int {
+	switch e.Code {
+	case RPCInvalidRequest:
+		return http.StatusBadRequest
+	case RPCMethodNotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// checkParamCount fails unless params has between min and max entries.
+func checkParamCount(params []json.RawMessage, min, max int) error {
+	if len(params) < min || len(params) > max {
+		return NewRPCError(RPCInvalidParams, fmt.Sprintf("expected %d to %d params, got %d", min, max, len(params)))
+	}
+	return nil
+}
+
+// parseStringParam decodes the string param at index i.
+func parseStringParam(params []json.RawMessage, i int) (string, error) {
+	var str string
+	if err := json.Unmarshal(params[i], &str); err != nil {
+		return "", NewRPCError(RPCTypeError, fmt.Sprintf("param %d must be a string", i))
+	}
+	return str, nil
+}
+
+// parseHashParam decodes the hex hash param at index i.
+func parseHashParam(params []json.RawMessage, i int) (*utils.Hash, error) {
+	str, err := parseStringParam(params, i)
+	if err != nil {
+		return nil, err
+	}
+	if len(str) != 64 {
+		return nil, NewRPCError(RPCInvalidParameter, fmt.Sprintf("param %d must be of length 64 (not %d)", i, len(str)))
+	}
+	hash, err := utils.GetHashFromStr(str)
+	if err != nil {
+		return nil, NewRPCError(RPCInvalidParameter, fmt.Sprintf("param %d must be hexadecimal string", i))
+	}
+	return hash, nil
+}
+
+// parseParam decodes the param at index i into v, which is described by kind
+// in the error.
+func parseParam(params []json.RawMessage, i int, v interface{}, kind string) error {
+	if err := json.Unmarshal(params[i], v); err != nil {
+		return NewRPCError(RPCTypeError, fmt.Sprintf("param %d must be %s", i, kind))
+	}
+	return nil
+}
+
+// isNullParam returns whether the optional param at index i is absent.
+func isNullParam(params []json.RawMessage, i int) bool {
+	return i >= len(params) || string(params[i]) == "null"
+}
@@ -0,0 +1,370 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/psbt"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utils/cashaddr"
+)
+
+var psbtHandlers = map[string]commandHandler{
+	"createpsbt":      handleCreatePSBT,
+	"converttopsbt":   handleConvertToPSBT,
+	"utxoupdatepsbt":  handleUTXOUpdatePSBT,
+	"signpsbtwithkey": handleSignPSBTWithKey,
+	"combinepsbt":     handleCombinePSBT,
+	"finalizepsbt":    handleFinalizePSBT,
+}
+
+func init() {
+	registerHandlers(psbtHandlers)
+}
+
+// PSBTInput is an input of the createpsbt command.
+type PSBTInput struct {
+	TxID     string  `json:"txid"`
+	Vout     uint32  `json:"vout"`
+	Sequence *uint32 `json:"sequence,omitempty"`
+}
+
+// SignPSBTResult is the result of the signpsbtwithkey command.
+type SignPSBTResult struct {
+	PSBT     string `json:"psbt"`
+	Complete bool   `json:"complete"`
+}
+
+// FinalizePSBTResult is the result of the finalizepsbt command. Hex is only
+// set when the transaction is complete and extracted.
+type FinalizePSBTResult struct {
+	PSBT     string `json:"psbt,omitempty"`
+	Hex      string `json:"hex,omitempty"`
+	Complete bool   `json:"complete"`
+}
+
+// parsePSBTParam decodes the base64 packet param at index i.
+func parsePSBTParam(params []json.RawMessage, i int) (*psbt.Packet, error) {
+	str, err := parseStringParam(params, i)
+	if err != nil {
+		return nil, err
+	}
+	p, err := psbt.FromBase64(str)
+	if err != nil {
+		return nil, NewRPCError(RPCDeserializationError, fmt.Sprintf("TX decode failed %s", err))
+	}
+	return p, nil
+}
+
+// encodePSBT returns the packet in base64.
+func encodePSBT(p *psbt.Packet) (string, error) {
+	str, err := p.ToBase64()
+	if err != nil {
+		return "", NewRPCError(RPCInternalError, err.Error())
+	}
+	return str, nil
+}
+
+// decodeAddress returns the hash a legacy or CashAddr P2PKH or P2SH
+// address pays to, and whether it is a P2SH address.
+func decodeAddress(str string) ([]byte, bool, error) {
+	invalid := NewRPCError(RPCInvalidAddressOrKey, "Invalid Bitcoin address: "+str)
+	if addrType, hash, err := cashaddr.Decode(str, msg.ActiveNetParams.CashAddrPrefix); err == nil {
+		if len(hash) != core.Hash160BytesLength {
+			return nil, false, invalid
+		}
+		switch addrType {
+		case cashaddr.PubKeyType:
+			return hash, false, nil
+		case cashaddr.ScriptType:
+			return hash, true, nil
+		}
+		return nil, false, invalid
+	}
+	addr, err := core.AddressFromString(str)
+	if err != nil {
+		return nil, false, invalid
+	}
+	switch addr.Version() {
+	case msg.ActiveNetParams.PubKeyHashAddressID:
+		return addr.Hash160(), false, nil
+	case msg.ActiveNetParams.ScriptHashAddressID:
+		return addr.Hash160(), true, nil
+	}
+	return nil, false, invalid
+}
+
+// addressScript returns the scriptPubKey paying to a P2PKH or P2SH address
+// of the active network.
+func addressScript(str string) (*core.Script, error) {
+	hash, isScript, err := decodeAddress(str)
+	if err != nil {
+		return nil, err
+	}
+	if isScript {
+		return core.PayToScriptHash(hash), nil
+	}
+	return core.PayToPubKeyHash(hash), nil
+}
+
+// parseOutputs decodes the outputs param of createpsbt, an object or an array
+// of objects mapping addresses to amounts, or "data" to hex data. The order
+// of the outputs is kept.
+func parseOutputs(raw json.RawMessage) ([]*core.TxOut, error) {
+	invalid := NewRPCError(RPCTypeError, "outputs must be an object or an array of objects")
+	var objects []json.RawMessage
+	if err := json.Unmarshal(raw, &objects); err != nil {
+		objects = []json.RawMessage{raw}
+	}
+
+	var outs []*core.TxOut
+	seen := make(map[string]bool)
+	for _, object := range objects {
+		decoder := json.NewDecoder(bytes.NewReader(object))
+		if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+			return nil, invalid
+		}
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, invalid
+			}
+			key := token.(string)
+			if seen[key] {
+				return nil, NewRPCError(RPCInvalidParameter, "Invalid parameter, duplicated key: "+key)
+			}
+			seen[key] = true
+
+			if key == "data" {
+				var data string
+				if err := decoder.Decode(&data); err != nil {
+					return nil, NewRPCError(RPCTypeError, "data must be a hexadecimal string")
+				}
+				raw, err := hex.DecodeString(data)
+				if err != nil {
+					return nil, NewRPCError(RPCTypeError, "data must be a hexadecimal string")
+				}
+				script := core.NewScriptRaw(nil)
+				script.PushOpCode(core.OP_RETURN)
+				script.PushData(raw)
+				outs = append(outs, core.NewTxOut(0, script.GetScriptByte()))
+				continue
+			}
+
+			script, err := addressScript(key)
+			if err != nil {
+				return nil, err
+			}
+			var value float64
+			if err := decoder.Decode(&value); err != nil {
+				return nil, NewRPCError(RPCTypeError, "Amount is not a number")
+			}
+			amount, err := utils.NewAmount(value)
+			if err != nil || amount <= 0 {
+				return nil, NewRPCError(RPCTypeError, "Invalid amount")
+			}
+			outs = append(outs, core.NewTxOut(int64(amount), script.GetScriptByte()))
+		}
+	}
+	return outs, nil
+}
+
+// handleCreatePSBT implements the createpsbt command: inputs, outputs and an
+// optional locktime make an unsigned transaction.
+func handleCreatePSBT(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 2, 3); err != nil {
+		return nil, err
+	}
+	var inputs []PSBTInput
+	if err := parseParam(params, 0, &inputs, "an array of inputs"); err != nil {
+		return nil, err
+	}
+	outs, err := parseOutputs(params[1])
+	if err != nil {
+		return nil, err
+	}
+
+	tx := core.NewTx()
+	if !isNullParam(params, 2) {
+		if err := parseParam(params, 2, &tx.LockTime, "a locktime"); err != nil {
+			return nil, err
+		}
+	}
+	for _, input := range inputs {
+		hash, err := utils.GetHashFromStr(input.TxID)
+		if err != nil || len(input.TxID) != 64 {
+			return nil, NewRPCError(RPCInvalidParameter, "txid must be hexadecimal string")
+		}
+		txIn := core.NewTxIn(&core.OutPoint{Hash: *hash, Index: input.Vout}, nil)
+		if input.Sequence != nil {
+			txIn.Sequence = *input.Sequence
+		} else if tx.LockTime != 0 {
+			txIn.Sequence = core.MaxTxInSequenceNum - 1
+		}
+		tx.AddTxIn(txIn)
+	}
+	for _, out := range outs {
+		tx.AddTxOut(out)
+	}
+
+	p, err := psbt.New(tx)
+	if err != nil {
+		return nil, NewRPCError(RPCInternalError, err.Error())
+	}
+	return encodePSBT(p)
+}
+
+// handleConvertToPSBT implements the converttopsbt command. The scriptSigs of
+// the transaction are dropped if permitsigdata is set, refused otherwise.
+func handleConvertToPSBT(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 2); err != nil {
+		return nil, err
+	}
+	str, err := parseStringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := decodeTxHex(str)
+	if err != nil {
+		return nil, err
+	}
+	permitSigData := false
+	if !isNullParam(params, 1) {
+		if err := parseParam(params, 1, &permitSigData, "a boolean"); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, txIn := range tx.Ins {
+		if txIn.Script.Size() == 0 {
+			continue
+		}
+		if !permitSigData {
+			return nil, NewRPCError(RPCDeserializationError,
+				"Inputs must not have scriptSigs, set permitsigdata to true to drop them")
+		}
+		txIn.Script = core.NewScriptRaw(nil)
+	}
+	tx.Hash = utils.Hash{}
+	p, err := psbt.New(tx)
+	if err != nil {
+		return nil, NewRPCError(RPCInternalError, err.Error())
+	}
+	return encodePSBT(p)
+}
+
+// handleUTXOUpdatePSBT implements the utxoupdatepsbt command: the outputs the
+// packet spends are looked up in the mempool and the UTXO set.
+func handleUTXOUpdatePSBT(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	p, err := parsePSBTParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	coins := make(map[core.OutPoint]*core.TxOut)
+	for _, txIn := range p.Tx.Ins {
+		if coin := lookupChainCoin(txIn.PreviousOutPoint); coin != nil {
+			coins[*txIn.PreviousOutPoint] = coin
+		}
+	}
+	p.Update(coins, nil)
+	return encodePSBT(p)
+}
+
+// handleSignPSBTWithKey implements the signpsbtwithkey command: the packet is
+// signed with the given private keys only.
+func handleSignPSBTWithKey(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 2, 3); err != nil {
+		return nil, err
+	}
+	p, err := parsePSBTParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	keyStore, err := parseKeysParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	hashType, err := parseSigHashParam(params, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Update(nil, keyStore)
+	complete, err := p.Sign(keyStore, hashType)
+	if err != nil {
+		return nil, NewRPCError(RPCInvalidParameter, err.Error())
+	}
+	result := &SignPSBTResult{Complete: complete}
+	if result.PSBT, err = encodePSBT(p); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// handleCombinePSBT implements the combinepsbt command.
+func handleCombinePSBT(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	var encoded []string
+	if err := parseParam(params, 0, &encoded, "an array of base64 strings"); err != nil {
+		return nil, err
+	}
+	if len(encoded) == 0 {
+		return nil, NewRPCError(RPCInvalidParameter, "Parameter 'txs' cannot be empty")
+	}
+	packets := make([]*psbt.Packet, 0, len(encoded))
+	for _, str := range encoded {
+		p, err := psbt.FromBase64(str)
+		if err != nil {
+			return nil, NewRPCError(RPCDeserializationError, fmt.Sprintf("TX decode failed %s", err))
+		}
+		packets = append(packets, p)
+	}
+	combined, err := psbt.Combine(packets)
+	if err != nil {
+		return nil, NewRPCError(RPCInvalidParameter, err.Error())
+	}
+	return encodePSBT(combined)
+}
+
+// handleFinalizePSBT implements the finalizepsbt command. A complete
+// transaction is returned in hex unless extract is false.
+func handleFinalizePSBT(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 2); err != nil {
+		return nil, err
+	}
+	p, err := parsePSBTParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	extract := true
+	if !isNullParam(params, 1) {
+		if err := parseParam(params, 1, &extract, "a boolean"); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &FinalizePSBTResult{Complete: p.Finalize()}
+	if result.Complete && extract {
+		tx, err := p.Extract()
+		if err != nil {
+			return nil, NewRPCError(RPCInternalError, err.Error())
+		}
+		if result.Hex, err = encodeTxHex(tx); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	if result.PSBT, err = encodePSBT(p); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,94 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/psbt"
+	"github.com/btcboost/copernicus/utils"
+)
+
+func TestCreatePSBT(t *testing.T) {
+	keyID := bytes.Repeat([]byte{1}, 20)
+	addr, _ := core.Hash160ToAddressStr(keyID, msg.ActiveNetParams.PubKeyHashAddressID)
+	params := []json.RawMessage{
+		json.RawMessage(fmt.Sprintf(`[{"txid":"%s","vout":2}]`, (&utils.Hash{1}).ToString())),
+		json.RawMessage(fmt.Sprintf(`[{"data":"abcd"},{"%s":0.5}]`, addr)),
+		json.RawMessage(`100`),
+	}
+	result, rpcErr := NewServer("", "", "").Execute("createpsbt", params)
+	if rpcErr != nil {
+		t.Fatalf("createpsbt failed: %v", rpcErr)
+	}
+	p, err := psbt.FromBase64(result.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Tx.LockTime != 100 || p.Tx.Ins[0].PreviousOutPoint.Index != 2 ||
+		p.Tx.Ins[0].Sequence != core.MaxTxInSequenceNum-1 {
+		t.Errorf("unexpected inputs or locktime")
+	}
+	if len(p.Tx.Outs) != 2 || p.Tx.Outs[0].Value != 0 || p.Tx.Outs[1].Value != utils.COIN/2 ||
+		!bytes.Equal(p.Tx.Outs[1].Script.GetScriptByte(), core.PayToPubKeyHash(keyID).GetScriptByte()) {
+		t.Errorf("the outputs should be kept in order")
+	}
+
+	params[1] = json.RawMessage(`{"notanaddress":1}`)
+	if _, rpcErr := NewServer("", "", "").Execute("createpsbt", params); rpcErr == nil || rpcErr.Code != RPCInvalidAddressOrKey {
+		t.Errorf("invalid addresses should be refused, got %v", rpcErr)
+	}
+}
+
+func TestSignAndFinalizePSBT(t *testing.T) {
+	keyBytes := make([]byte, crypto.PrivateKeyBytesLen)
+	keyBytes[31] = 9
+	key := crypto.PrivateKeyFromBytes(keyBytes)
+	scriptPubKey := core.PayToPubKeyHash(utils.Hash160(key.PubKey().ToBytes()))
+
+	tx := core.NewTx()
+	tx.AddTxIn(core.NewTxIn(&core.OutPoint{Hash: utils.Hash{3}}, nil))
+	tx.AddTxOut(core.NewTxOut(utils.COIN-1000, scriptPubKey.GetScriptByte()))
+	txHex, _ := encodeTxHex(tx)
+
+	result, rpcErr := NewServer("", "", "").Execute("converttopsbt", []json.RawMessage{json.RawMessage(`"` + txHex + `"`)})
+	if rpcErr != nil {
+		t.Fatalf("converttopsbt failed: %v", rpcErr)
+	}
+	p, _ := psbt.FromBase64(result.(string))
+	p.Inputs[0].UTXO = core.NewTxOut(utils.COIN, scriptPubKey.GetScriptByte())
+	encoded, _ := p.ToBase64()
+
+	result, rpcErr = NewServer("", "", "").Execute("signpsbtwithkey", []json.RawMessage{
+		json.RawMessage(`"` + encoded + `"`),
+		json.RawMessage(`["` + key.ToString() + `"]`),
+	})
+	if rpcErr != nil {
+		t.Fatalf("signpsbtwithkey failed: %v", rpcErr)
+	}
+	signed := result.(*SignPSBTResult)
+	if !signed.Complete {
+		t.Fatalf("the packet should be complete")
+	}
+
+	result, rpcErr = NewServer("", "", "").Execute("finalizepsbt", []json.RawMessage{json.RawMessage(`"` + signed.PSBT + `"`)})
+	if rpcErr != nil {
+		t.Fatalf("finalizepsbt failed: %v", rpcErr)
+	}
+	final := result.(*FinalizePSBTResult)
+	finalTx, err := decodeTxHex(final.Hex)
+	if !final.Complete || final.PSBT != "" || err != nil || finalTx.Ins[0].Script.Size() == 0 {
+		t.Errorf("unexpected finalized transaction %+v", final)
+	}
+
+	result, rpcErr = NewServer("", "", "").Execute("combinepsbt", []json.RawMessage{
+		json.RawMessage(`["` + encoded + `","` + signed.PSBT + `"]`),
+	})
+	if rpcErr != nil || result.(string) != signed.PSBT {
+		t.Errorf("combining with the unsigned packet should change nothing, got %v", rpcErr)
+	}
+}
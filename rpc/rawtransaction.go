@@ -0,0 +1,357 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/btcboost/copernicus/blockchain"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/sign"
+	"github.com/btcboost/copernicus/utils"
+)
+
+var rawTransactionHandlers = map[string]commandHandler{
+	"getrawtransaction":         handleGetRawTransaction,
+	"signrawtransactionwithkey": handleSignRawTransactionWithKey,
+}
+
+func init() {
+	registerHandlers(rawTransactionHandlers)
+}
+
+// decodeTxHex decodes a serialized transaction.
+func decodeTxHex(str string) (*core.Tx, error) {
+	raw, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, NewRPCError(RPCDeserializationError, "TX decode failed")
+	}
+	reader := bytes.NewReader(raw)
+	tx, err := core.DeserializeTx(reader)
+	if err != nil || reader.Len() != 0 {
+		return nil, NewRPCError(RPCDeserializationError, "TX decode failed")
+	}
+	return tx, nil
+}
+
+// encodeTxHex returns the serialized transaction in hex.
+func encodeTxHex(tx *core.Tx) (string, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, tx.SerializeSize()))
+	if err := tx.Serialize(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeScriptHex decodes a hex script, naming it field in errors.
+func decodeScriptHex(str string, field string) (*core.Script, error) {
+	raw, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, NewRPCError(RPCDeserializationError, field+" must be hexadecimal string")
+	}
+	return core.NewScriptRaw(raw), nil
+}
+
+// PrevTx describes an output spent by a transaction to sign, which may not be
+// in the UTXO set yet.
+type PrevTx struct {
+	TxID         string   `json:"txid"`
+	Vout         uint32   `json:"vout"`
+	ScriptPubKey string   `json:"scriptPubKey"`
+	RedeemScript string   `json:"redeemScript,omitempty"`
+	Amount       *float64 `json:"amount"`
+}
+
+// SignRawTransactionError describes an input which could not be signed.
+type SignRawTransactionError struct {
+	TxID      string `json:"txid"`
+	Vout      uint32 `json:"vout"`
+	ScriptSig string `json:"scriptSig"`
+	Sequence  uint32 `json:"sequence"`
+	Error     string `json:"error"`
+}
+
+// SignRawTransactionResult is the result of the signrawtransaction commands.
+type SignRawTransactionResult struct {
+	Hex      string                    `json:"hex"`
+	Complete bool                      `json:"complete"`
+	Errors   []SignRawTransactionError `json:"errors,omitempty"`
+}
+
+// lookupCoins returns the outputs spent by tx: those of prevTxs first, then
+// those of the mempool and of the UTXO set. Redeem scripts of prevTxs are
+// added to keyStore.
+func lookupCoins(tx *core.Tx, prevTxs []PrevTx, keyStore *sign.BasicKeyStore) (map[core.OutPoint]*core.TxOut, error) {
+	coins := make(map[core.OutPoint]*core.TxOut)
+	for _, prevTx := range prevTxs {
+		hash, err := utils.GetHashFromStr(prevTx.TxID)
+		if err != nil || len(prevTx.TxID) != 64 {
+			return nil, NewRPCError(RPCInvalidParameter, "txid must be hexadecimal string")
+		}
+		script, err := decodeScriptHex(prevTx.ScriptPubKey, "scriptPubKey")
+		if err != nil {
+			return nil, err
+		}
+		if prevTx.Amount == nil {
+			return nil, NewRPCError(RPCInvalidParameter, "Missing amount")
+		}
+		amount, err := utils.NewAmount(*prevTx.Amount)
+		if err != nil || amount < 0 {
+			return nil, NewRPCError(RPCTypeError, "Invalid amount")
+		}
+		outPoint := core.OutPoint{Hash: *hash, Index: prevTx.Vout}
+		coins[outPoint] = core.NewTxOut(int64(amount), script.GetScriptByte())
+
+		if prevTx.RedeemScript != "" {
+			if !script.IsPayToScriptHash() {
+				return nil, NewRPCError(RPCInvalidParameter, "redeemScript given for a non P2SH output")
+			}
+			redeemScript, err := decodeScriptHex(prevTx.RedeemScript, "redeemScript")
+			if err != nil {
+				return nil, err
+			}
+			keyStore.AddScript(redeemScript)
+		}
+	}
+
+	for _, txIn := range tx.Ins {
+		if _, ok := coins[*txIn.PreviousOutPoint]; ok {
+			continue
+		}
+		if coin := lookupChainCoin(txIn.PreviousOutPoint); coin != nil {
+			coins[*txIn.PreviousOutPoint] = coin
+		}
+	}
+	return coins, nil
+}
+
+// lookupChainCoin returns the unspent output of outPoint in the mempool or in
+// the UTXO set, nil if there is none.
+func lookupChainCoin(outPoint *core.OutPoint) *core.TxOut {
+	if coin := blockchain.GMemPool.GetCoin(outPoint); coin != nil {
+		return coin.TxOut
+	}
+	if blockchain.GCoinsTip != nil {
+		if coin := blockchain.GCoinsTip.AccessCoin(outPoint); !coin.IsSpent() {
+			return coin.TxOut
+		}
+	}
+	return nil
+}
+
+// parseKeysParam returns a key store holding the WIF private keys of the
+// param at index i.
+func parseKeysParam(params []json.RawMessage, i int) (*sign.BasicKeyStore, error) {
+	var privateKeys []string
+	if err := parseParam(params, i, &privateKeys, "an array of private keys"); err != nil {
+		return nil, err
+	}
+	keyStore := sign.NewBasicKeyStore()
+	for _, encoded := range privateKeys {
+		key, err := crypto.DecodePrivateKey(encoded)
+		if err != nil || !keyStore.AddKey(key) {
+			return nil, NewRPCError(RPCInvalidAddressOrKey, "Invalid private key")
+		}
+	}
+	return keyStore, nil
+}
+
+// parseSigHashParam returns the hash type named by the optional param at
+// index i, ALL|FORKID by default. Hash types without FORKID are refused.
+func parseSigHashParam(params []json.RawMessage, i int) (uint32, error) {
+	if isNullParam(params, i) {
+		return crypto.SigHashAll | crypto.SigHashForkID, nil
+	}
+	name, err := parseStringParam(params, i)
+	if err != nil {
+		return 0, err
+	}
+	hashType, err := sign.ParseSigHashType(name)
+	if err != nil {
+		return 0, NewRPCError(RPCInvalidParameter, err.Error())
+	}
+	if hashType&crypto.SigHashForkID == 0 {
+		return 0, NewRPCError(RPCInvalidParameter, "Signature must use SIGHASH_FORKID")
+	}
+	return hashType, nil
+}
+
+// signRawTransaction signs the transaction of param 0 with keyStore, the
+// outputs it spends being completed by the prevtxs param at index prevTxsParam
+// and the hash type named by the param after it.
+func signRawTransaction(params []json.RawMessage, keyStore *sign.BasicKeyStore,
+	prevTxsParam int) (interface{}, error) {
+	str, err := parseStringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := decodeTxHex(str)
+	if err != nil {
+		return nil, err
+	}
+
+	var prevTxs []PrevTx
+	if !isNullParam(params, prevTxsParam) {
+		if err := parseParam(params, prevTxsParam, &prevTxs, "an array of previous outputs"); err != nil {
+			return nil, err
+		}
+	}
+	hashType, err := parseSigHashParam(params, prevTxsParam+1)
+	if err != nil {
+		return nil, err
+	}
+
+	coins, err := lookupCoins(tx, prevTxs, keyStore)
+	if err != nil {
+		return nil, err
+	}
+	inputErrors := sign.SignTransaction(tx, coins, keyStore, hashType)
+
+	result := &SignRawTransactionResult{Complete: len(inputErrors) == 0}
+	if result.Hex, err = encodeTxHex(tx); err != nil {
+		return nil, err
+	}
+	for _, inputError := range inputErrors {
+		txIn := tx.Ins[inputError.Index]
+		result.Errors = append(result.Errors, SignRawTransactionError{
+			TxID:      txIn.PreviousOutPoint.Hash.ToString(),
+			Vout:      txIn.PreviousOutPoint.Index,
+			ScriptSig: hex.EncodeToString(txIn.Script.GetScriptByte()),
+			Sequence:  txIn.Sequence,
+			Error:     inputError.Err.Error(),
+		})
+	}
+	return result, nil
+}
+
+// handleSignRawTransactionWithKey implements the signrawtransactionwithkey
+// command: the transaction is signed with the given private keys only.
+func handleSignRawTransactionWithKey(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 2, 4); err != nil {
+		return nil, err
+	}
+	keyStore, err := parseKeysParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	return signRawTransaction(params, keyStore, 2)
+}
+
+// RawTransactionResult is the verbose result of getrawtransaction.
+type RawTransactionResult struct {
+	Hex           string `json:"hex"`
+	TxID          string `json:"txid"`
+	Hash          string `json:"hash"`
+	Size          int    `json:"size"`
+	Version       int32  `json:"version"`
+	LockTime      uint32 `json:"locktime"`
+	BlockHash     string `json:"blockhash,omitempty"`
+	Confirmations int    `json:"confirmations,omitempty"`
+	Time          uint32 `json:"time,omitempty"`
+	BlockTime     uint32 `json:"blocktime,omitempty"`
+}
+
+// parseVerboseParam decodes the optional verbose param at index i, which is
+// a boolean or a number.
+func parseVerboseParam(params []json.RawMessage, i int) (bool, error) {
+	if isNullParam(params, i) {
+		return false, nil
+	}
+	var verbose bool
+	if err := json.Unmarshal(params[i], &verbose); err == nil {
+		return verbose, nil
+	}
+	var level int
+	if err := parseParam(params, i, &level, "a boolean or a number"); err != nil {
+		return false, err
+	}
+	return level != 0, nil
+}
+
+// findBlockTransaction returns the transaction txid of the block whose hash
+// is the param at index i.
+func findBlockTransaction(params []json.RawMessage, i int, txid *utils.Hash) (*core.Tx, *utils.Hash, error) {
+	hash, err := parseHashParam(params, i)
+	if err != nil {
+		return nil, nil, err
+	}
+	index, ok := blockchain.GChainState.MapBlockIndex.Data[*hash]
+	if !ok {
+		return nil, nil, NewRPCError(RPCInvalidAddressOrKey, "Block hash not found")
+	}
+	var block core.Block
+	if index.Status&core.BlockHaveData == 0 || !blockchain.ReadBlockFromDisk(&block, index, msg.ActiveNetParams) {
+		return nil, nil, NewRPCError(RPCMiscError, "Block not available")
+	}
+	for _, tx := range block.Txs {
+		if tx.TxHash() == *txid {
+			return tx, hash, nil
+		}
+	}
+	return nil, nil, NewRPCError(RPCInvalidAddressOrKey, "No such transaction found in the provided block")
+}
+
+// handleGetRawTransaction implements the getrawtransaction command: it
+// returns a transaction of the mempool or, with -txindex or a block hash, of
+// the chain.
+func handleGetRawTransaction(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 3); err != nil {
+		return nil, err
+	}
+	txid, err := parseHashParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	verbose, err := parseVerboseParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var tx *core.Tx
+	var blockHash utils.Hash
+	if !isNullParam(params, 2) {
+		var hash *utils.Hash
+		if tx, hash, err = findBlockTransaction(params, 2, txid); err != nil {
+			return nil, err
+		}
+		blockHash = *hash
+	} else {
+		var ok bool
+		if tx, blockHash, ok = blockchain.GetTransaction(msg.ActiveNetParams, txid, true); !ok {
+			if blockchain.GTxIndex {
+				return nil, NewRPCError(RPCInvalidAddressOrKey,
+					"No such mempool or blockchain transaction. Use gettransaction for wallet transactions.")
+			}
+			return nil, NewRPCError(RPCInvalidAddressOrKey, "No such mempool transaction. Use -txindex "+
+				"to enable blockchain transaction queries. Use gettransaction for wallet transactions.")
+		}
+	}
+
+	hexTx, err := encodeTxHex(tx)
+	if err != nil {
+		return nil, err
+	}
+	if !verbose {
+		return hexTx, nil
+	}
+	result := &RawTransactionResult{
+		Hex:      hexTx,
+		TxID:     txid.ToString(),
+		Hash:     txid.ToString(),
+		Size:     tx.SerializeSize(),
+		Version:  tx.Version,
+		LockTime: tx.LockTime,
+	}
+	if blockHash != (utils.Hash{}) {
+		result.BlockHash = blockHash.ToString()
+		if index, ok := blockchain.GChainState.MapBlockIndex.Data[blockHash]; ok &&
+			blockchain.GChainState.ChainActive.Contains(index) {
+			result.Confirmations = blockchain.GChainState.ChainActive.Height() - index.Height + 1
+			result.Time = index.GetBlockTime()
+			result.BlockTime = index.GetBlockTime()
+		}
+	}
+	return result, nil
+}
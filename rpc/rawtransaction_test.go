@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/utils"
+)
+
+func TestSignRawTransactionWithKey(t *testing.T) {
+	keyBytes := make([]byte, crypto.PrivateKeyBytesLen)
+	keyBytes[31] = 7
+	key := crypto.PrivateKeyFromBytes(keyBytes)
+	keyID := utils.Hash160(key.PubKey().ToBytes())
+	scriptPubKey := append(append([]byte{core.OP_DUP, core.OP_HASH160, 20}, keyID...), core.OP_EQUALVERIFY, core.OP_CHECKSIG)
+
+	prevOut := core.OutPoint{Hash: utils.Hash{1}, Index: 1}
+	tx := core.NewTx()
+	tx.AddTxIn(core.NewTxIn(&prevOut, nil))
+	tx.AddTxIn(core.NewTxIn(&core.OutPoint{Hash: utils.Hash{2}}, nil))
+	tx.AddTxOut(core.NewTxOut(utils.COIN, scriptPubKey))
+	txHex, _ := encodeTxHex(tx)
+
+	prevTxs := fmt.Sprintf(`[{"txid":"%s","vout":1,"scriptPubKey":"%s","amount":1.5}]`,
+		prevOut.Hash.ToString(), hex.EncodeToString(scriptPubKey))
+	params := []json.RawMessage{
+		json.RawMessage(`"` + txHex + `"`),
+		json.RawMessage(`["` + key.ToString() + `"]`),
+		json.RawMessage(prevTxs),
+		json.RawMessage(`"ALL|FORKID|ANYONECANPAY"`),
+	}
+	result, rpcErr := NewServer("", "", "").Execute("signrawtransactionwithkey", params)
+	if rpcErr != nil {
+		t.Fatalf("signrawtransactionwithkey failed: %v", rpcErr)
+	}
+	signed := result.(*SignRawTransactionResult)
+	if signed.Complete || len(signed.Errors) != 1 || signed.Errors[0].TxID != (&utils.Hash{2}).ToString() {
+		t.Fatalf("only the input with a known coin should be signed, got %+v", signed)
+	}
+	signedTx, err := decodeTxHex(signed.Hex)
+	if err != nil || signedTx.Ins[0].Script.Size() == 0 || signedTx.Ins[1].Script.Size() != 0 {
+		t.Errorf("unexpected signed transaction %s", signed.Hex)
+	}
+
+	params[2] = json.RawMessage(fmt.Sprintf(`[{"txid":"%s","vout":1,"scriptPubKey":"%s"}]`,
+		prevOut.Hash.ToString(), hex.EncodeToString(scriptPubKey)))
+	if _, rpcErr := NewServer("", "", "").Execute("signrawtransactionwithkey", params); rpcErr == nil ||
+		rpcErr.Code != RPCInvalidParameter || rpcErr.Message != "Missing amount" {
+		t.Errorf("previous outputs without an amount should be refused, got %v", rpcErr)
+	}
+	params[2] = json.RawMessage(prevTxs)
+
+	params[3] = json.RawMessage(`"ALL"`)
+	if _, rpcErr := NewServer("", "", "").Execute("signrawtransactionwithkey", params); rpcErr == nil ||
+		rpcErr.Code != RPCInvalidParameter {
+		t.Errorf("signatures without SIGHASH_FORKID should be refused, got %v", rpcErr)
+	}
+	params[1] = json.RawMessage(`["nosuchkey"]`)
+	if _, rpcErr := NewServer("", "", "").Execute("signrawtransactionwithkey", params); rpcErr == nil ||
+		rpcErr.Code != RPCInvalidAddressOrKey {
+		t.Errorf("invalid keys should be refused, got %v", rpcErr)
+	}
+}
+
+func TestGetRawTransactionNotFound(t *testing.T) {
+	hash := utils.Hash{7}
+	txid := `"` + hash.ToString() + `"`
+	_, rpcErr := NewServer("", "", "").Execute("getrawtransaction", []json.RawMessage{json.RawMessage(txid)})
+	if rpcErr == nil || rpcErr.Code != RPCInvalidAddressOrKey {
+		t.Fatalf("an unknown transaction should not be found, got %v", rpcErr)
+	}
+
+	_, rpcErr = NewServer("", "", "").Execute("getrawtransaction", []json.RawMessage{
+		json.RawMessage(txid), json.RawMessage(`1`), json.RawMessage(txid),
+	})
+	if rpcErr == nil || rpcErr.Message != "Block hash not found" {
+		t.Fatalf("an unknown block should be refused, got %v", rpcErr)
+	}
+
+	_, rpcErr = NewServer("", "", "").Execute("getrawtransaction", []json.RawMessage{
+		json.RawMessage(txid), json.RawMessage(`"yes"`),
+	})
+	if rpcErr == nil || rpcErr.Code != RPCTypeError {
+		t.Fatalf("a malformed verbose flag should be refused, got %v", rpcErr)
+	}
+}
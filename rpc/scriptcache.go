@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"github.com/btcboost/copernicus/core"
+)
+
+var scriptCacheHandlers = map[string]commandHandler{
+	"getscriptcacheinfo": handleGetScriptCacheInfo,
+}
+
+func init() {
+	registerHandlers(scriptCacheHandlers)
+}
+
+// ScriptCacheInfo describes the usage of one of the script caches.
+type ScriptCacheInfo struct {
+	Entries    int    `json:"entries"`
+	MaxEntries int    `json:"maxentries"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+}
+
+// ScriptCacheInfoResult is the result of getscriptcacheinfo.
+type ScriptCacheInfoResult struct {
+	Signatures ScriptCacheInfo `json:"signatures"`
+	Scripts    ScriptCacheInfo `json:"scripts"`
+}
+
+func newScriptCacheInfo(cache *core.ScriptCache) ScriptCacheInfo {
+	stats := cache.Stats()
+	return ScriptCacheInfo{
+		Entries:    stats.Entries,
+		MaxEntries: stats.MaxEntries,
+		Hits:       stats.Hits,
+		Misses:     stats.Misses,
+	}
+}
+
+// handleGetScriptCacheInfo implements the getscriptcacheinfo command, which
+// reports how full the signature cache and the script execution cache are and
+// how often they were hit, as sized by -maxsigcachesize.
+func handleGetScriptCacheInfo(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 0); err != nil {
+		return nil, err
+	}
+	return &ScriptCacheInfoResult{
+		Signatures: newScriptCacheInfo(core.GSignatureCache),
+		Scripts:    newScriptCacheInfo(core.GScriptExecutionCache),
+	}, nil
+}
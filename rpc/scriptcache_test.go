@@ -0,0 +1,29 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utils"
+)
+
+func TestGetScriptCacheInfo(t *testing.T) {
+	key := utils.GetRandHash()
+	core.GScriptExecutionCache.Add(key)
+	defer core.GScriptExecutionCache.Contains(key, true)
+	hits := core.GScriptExecutionCache.Stats().Hits
+	core.GScriptExecutionCache.Contains(key, false)
+
+	result, rpcErr := NewServer("", "", "").Execute("getscriptcacheinfo", nil)
+	if rpcErr != nil {
+		t.Fatalf("getscriptcacheinfo failed: %v", rpcErr)
+	}
+	info := result.(*ScriptCacheInfoResult)
+	if info.Scripts.Entries == 0 || info.Scripts.Hits != hits+1 ||
+		info.Scripts.MaxEntries != core.GScriptExecutionCache.Stats().MaxEntries {
+		t.Errorf("unexpected script execution cache info %+v", info.Scripts)
+	}
+	if info.Signatures.MaxEntries != core.GSignatureCache.Stats().MaxEntries {
+		t.Errorf("unexpected signature cache info %+v", info.Signatures)
+	}
+}
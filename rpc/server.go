@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/btcboost/copernicus/blockchain"
+)
+
+// maxRequestSize is the largest request body the server reads.
+const maxRequestSize = 1 << 24
+
+// commandHandler runs one RPC command with its positional params.
+type commandHandler func(s *Server, params []json.RawMessage) (interface{}, error)
+
+// rpcHandlers maps the method names to their handlers. Each file registers its
+// commands through registerHandlers from an init function.
+var rpcHandlers = make(map[string]commandHandler)
+
+func registerHandlers(handlers map[string]commandHandler) {
+	for method, handler := range handlers {
+		if _, ok := rpcHandlers[method]; ok {
+			panic(fmt.Sprintf("rpc method %s registered twice", method))
+		}
+		rpcHandlers[method] = handler
+	}
+}
+
+// Request is a JSON-RPC 1.0 request as sent by bitcoin-cli.
+type Request struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	ID     interface{}       `json:"id"`
+}
+
+// Response is the reply to a Request, exactly one of Result and Error is set.
+type Response struct {
+	Result interface{} `json:"result"`
+	Error  *RPCError   `json:"error"`
+	ID     interface{} `json:"id"`
+}
+
+// Server serves the JSON-RPC commands over HTTP.
+type Server struct {
+	addr     string
+	user     string
+	password string
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// NewServer returns a server which will listen on addr once started and serve
+// the requests authenticated as user with password.
+func NewServer(addr string, user string, password string) *Server {
+	return &Server{addr: addr, user: user, password: password}
+}
+
+// Start listens on the address of the server and serves the requests in the
+// background.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		logs.Info(fmt.Sprintf("RPC server listening on %s", listener.Addr()))
+		http.Serve(listener, s)
+	}()
+	return nil
+}
+
+// Stop closes the listener and waits for the server to exit.
+func (s *Server) Stop() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// ServeHTTP decodes a request, runs its command and writes the response.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSONRPC server handles only POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.checkAuth(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !isJSONRequest(r) {
+		http.Error(w, "JSONRPC server handles only application/json requests", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var response Response
+	var request Request
+	if err := json.Unmarshal(body, &request); err != nil {
+		response.Error = NewRPCError(RPCParseError, "Parse error")
+	} else {
+		response.ID = request.ID
+		response.Result, response.Error = s.Execute(request.Method, request.Params)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.Error != nil {
+		w.WriteHeader(response.Error.httpStatus())
+	}
+	if err := json.NewEncoder(w).Encode(&response); err != nil {
+		logs.Error(fmt.Sprintf("failed to write rpc response: %s", err))
+	}
+}
+
+// Execute runs method with params, errors which are not RPC errors are
+// reported as RPCMiscError. The commands run with the chain state locked,
+// so they do not race with validation nor with each other.
+func (s *Server) Execute(method string, params []json.RawMessage) (interface{}, *RPCError) {
+	handler, ok := rpcHandlers[method]
+	if !ok {
+		return nil, NewRPCError(RPCMethodNotFound, "Method not found")
+	}
+	result, err := func() (interface{}, error) {
+		blockchain.GChainState.Lock()
+		defer blockchain.GChainState.Unlock()
+		return handler(s, params)
+	}()
+	if err != nil {
+		if rpcErr, ok := err.(*RPCError); ok {
+			return nil, rpcErr
+		}
+		return nil, NewRPCError(RPCMiscError, err.Error())
+	}
+	return result, nil
+}
@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcboost/copernicus/blockchain"
+	"github.com/btcboost/copernicus/net/msg"
+)
+
+func TestServeHTTP(t *testing.T) {
+	s := NewServer("", "user", "password")
+	tests := []struct {
+		body   string
+		status int
+		code   int
+	}{
+		{`{"method":"nosuchmethod","params":[],"id":1}`, http.StatusNotFound, RPCMethodNotFound},
+		{`{"method":"parkblock",`, http.StatusInternalServerError, RPCParseError},
+		{`{"method":"parkblock","params":[],"id":1}`, http.StatusInternalServerError, RPCInvalidParams},
+		{`{"method":"parkblock","params":[1],"id":1}`, http.StatusInternalServerError, RPCTypeError},
+		{`{"method":"parkblock","params":["00"],"id":1}`, http.StatusInternalServerError, RPCInvalidParameter},
+		{`{"method":"unparkblock","params":["` + strings.Repeat("ab", 32) + `"],"id":1}`,
+			http.StatusInternalServerError, RPCInvalidAddressOrKey},
+	}
+
+	for i, test := range tests {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		request.SetBasicAuth("user", "password")
+		request.Header.Set("Content-Type", "application/json")
+		s.ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("#%d: expected status %d, got %d", i, test.status, recorder.Code)
+		}
+		var response Response
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("#%d: invalid response %q: %s", i, recorder.Body.String(), err)
+		}
+		if response.Error == nil || response.Error.Code != test.code {
+			t.Errorf("#%d: expected error code %d, got %v", i, test.code, response.Error)
+		}
+	}
+}
+
+func TestExecuteLocksChainState(t *testing.T) {
+	// Validation holds the chain state: the command waits for it.
+	blockchain.GChainState.Lock()
+	done := make(chan struct{})
+	go func() {
+		NewServer("", "", "").Execute("getblockchaininfo", nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("the command should wait for the chain state lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	blockchain.GChainState.Unlock()
+	<-done
+
+	// A failing handler releases it.
+	NewServer("", "", "").Execute("parkblock", nil)
+	blockchain.GChainState.Lock()
+	blockchain.GChainState.Unlock()
+}
+
+func TestGetBlockChainInfo(t *testing.T) {
+	result, rpcErr := NewServer("", "", "").Execute("getblockchaininfo", nil)
+	if rpcErr != nil {
+		t.Fatalf("getblockchaininfo failed: %v", rpcErr)
+	}
+	info := result.(*BlockChainInfo)
+	if info.Chain != msg.ActiveNetParams.Name || len(info.Upgrades) != len(msg.ActiveNetParams.Upgrades) {
+		t.Errorf("unexpected chain %s with %d upgrades", info.Chain, len(info.Upgrades))
+	}
+	for i, upgrade := range info.Upgrades {
+		if upgrade.Name != msg.ActiveNetParams.Upgrades[i].Name {
+			t.Errorf("upgrade %d: expected %s, got %s", i, msg.ActiveNetParams.Upgrades[i].Name, upgrade.Name)
+		}
+	}
+
+	// An upgrade activated at the genesis block still reports its height.
+	encoded, _ := json.Marshal(&UpgradeInfo{Name: "uahf", Activation: "height"})
+	if !strings.Contains(string(encoded), `"height":0`) {
+		t.Errorf("the height should always be reported, got %s", encoded)
+	}
+}
@@ -0,0 +1,126 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/wallet"
+)
+
+var signMessageHandlers = map[string]commandHandler{
+	"signmessage":            handleSignMessage,
+	"signmessagewithprivkey": handleSignMessageWithPrivKey,
+	"verifymessage":          handleVerifyMessage,
+}
+
+func init() {
+	registerHandlers(signMessageHandlers)
+}
+
+// messageKeyID returns the key id of the legacy or CashAddr P2PKH address
+// at index i of params.
+func messageKeyID(params []json.RawMessage, i int) ([]byte, error) {
+	address, err := parseStringParam(params, i)
+	if err != nil {
+		return nil, err
+	}
+	keyID, isScript, err := decodeAddress(address)
+	if err != nil {
+		return nil, NewRPCError(RPCTypeError, "Invalid address")
+	}
+	if isScript {
+		return nil, NewRPCError(RPCTypeError, "Address does not refer to key")
+	}
+	return keyID, nil
+}
+
+// signMessage returns the base64 compact signature of message by key.
+func signMessage(key *crypto.PrivateKey, message string) (interface{}, error) {
+	sig, err := crypto.SignCompact(key, crypto.MessageHash(message))
+	if err != nil {
+		return nil, NewRPCError(RPCInvalidAddressOrKey, "Sign failed")
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// handleSignMessage implements the signmessage command: it signs the
+// message with the key of a P2PKH address of the wallet.
+func handleSignMessage(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 2, 2); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := messageKeyID(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	message, err := parseStringParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	if w.IsLocked() {
+		return nil, walletError(wallet.ErrWalletLocked)
+	}
+	key, ok := w.GetKey(keyID)
+	if !ok {
+		return nil, NewRPCError(RPCWalletError, "Private key not available")
+	}
+	return signMessage(key, message)
+}
+
+// handleSignMessageWithPrivKey implements the signmessagewithprivkey
+// command: it signs the message with a WIF private key.
+func handleSignMessageWithPrivKey(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 2, 2); err != nil {
+		return nil, err
+	}
+	encoded, err := parseStringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	message, err := parseStringParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.DecodePrivateKey(encoded)
+	if err != nil {
+		return nil, NewRPCError(RPCInvalidAddressOrKey, "Invalid private key")
+	}
+	return signMessage(key, message)
+}
+
+// handleVerifyMessage implements the verifymessage command: it returns
+// whether the base64 signature of the message was made by the key of a
+// P2PKH address.
+func handleVerifyMessage(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 3, 3); err != nil {
+		return nil, err
+	}
+	keyID, err := messageKeyID(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := parseStringParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	message, err := parseStringParam(params, 2)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, NewRPCError(RPCTypeError, "Malformed base64 encoding")
+	}
+	pubKey, err := crypto.RecoverCompact(sig, crypto.MessageHash(message))
+	if err != nil {
+		return false, nil
+	}
+	return bytes.Equal(utils.Hash160(pubKey.ToBytes()), keyID), nil
+}
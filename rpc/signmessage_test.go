@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utils/cashaddr"
+)
+
+func TestSignMessage(t *testing.T) {
+	keyBytes := make([]byte, crypto.PrivateKeyBytesLen)
+	keyBytes[31] = 7
+	key, err := crypto.NewPrivateKey(keyBytes, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := utils.Hash160(key.PubKey().ToBytes())
+	legacy, _ := core.Hash160ToAddressStr(keyID, msg.ActiveNetParams.PubKeyHashAddressID)
+	cash, _ := cashaddr.Encode(msg.ActiveNetParams.CashAddrPrefix, cashaddr.PubKeyType, keyID)
+	script, _ := core.Hash160ToAddressStr(keyID, msg.ActiveNetParams.ScriptHashAddressID)
+
+	params := []json.RawMessage{
+		json.RawMessage(`"` + key.ToString() + `"`),
+		json.RawMessage(`"hello"`),
+	}
+	result, rpcErr := NewServer("", "", "").Execute("signmessagewithprivkey", params)
+	if rpcErr != nil {
+		t.Fatalf("signmessagewithprivkey failed: %v", rpcErr)
+	}
+	sig := result.(string)
+
+	verify := func(address, message string) (interface{}, *RPCError) {
+		return NewServer("", "", "").Execute("verifymessage", []json.RawMessage{
+			json.RawMessage(`"` + address + `"`),
+			json.RawMessage(`"` + sig + `"`),
+			json.RawMessage(`"` + message + `"`),
+		})
+	}
+	for _, address := range []string{legacy, cash} {
+		if valid, rpcErr := verify(address, "hello"); rpcErr != nil || valid != true {
+			t.Errorf("%s: the signature should verify, got %v %v", address, valid, rpcErr)
+		}
+	}
+	if valid, rpcErr := verify(legacy, "goodbye"); rpcErr != nil || valid != false {
+		t.Errorf("the signature should not verify another message, got %v %v", valid, rpcErr)
+	}
+	if _, rpcErr := verify(script, "hello"); rpcErr == nil || rpcErr.Code != RPCTypeError {
+		t.Errorf("P2SH addresses should be refused, got %v", rpcErr)
+	}
+	sig = "not base64!"
+	if _, rpcErr := verify(legacy, "hello"); rpcErr == nil || rpcErr.Code != RPCTypeError {
+		t.Errorf("malformed signatures should be refused, got %v", rpcErr)
+	}
+}
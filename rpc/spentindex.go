@@ -0,0 +1,64 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/btcboost/copernicus/blockchain"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utils"
+)
+
+var spentIndexHandlers = map[string]commandHandler{
+	"getspentinfo": handleGetSpentInfo,
+}
+
+func init() {
+	registerHandlers(spentIndexHandlers)
+}
+
+// SpentInfoResult is the result of getspentinfo.
+type SpentInfoResult struct {
+	TxID     string `json:"txid"`
+	Index    uint32 `json:"index"`
+	Height   int    `json:"height"`
+	Satoshis int64  `json:"satoshis"`
+	Script   string `json:"script"`
+}
+
+// handleGetSpentInfo implements the getspentinfo command: it returns the
+// input spending an output, with a height of -1 in the mempool, and the
+// value and script of the output.
+func handleGetSpentInfo(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	var request struct {
+		TxID  string  `json:"txid"`
+		Index *uint32 `json:"index"`
+	}
+	if err := parseParam(params, 0, &request, "an object with a txid and an index"); err != nil {
+		return nil, err
+	}
+	txid, err := utils.GetHashFromStr(request.TxID)
+	if len(request.TxID) != 64 || err != nil {
+		return nil, NewRPCError(RPCInvalidParameter, "Invalid txid")
+	}
+	if request.Index == nil {
+		return nil, NewRPCError(RPCInvalidParameter, "Invalid index")
+	}
+
+	info, err := blockchain.GetSpentInfo(core.NewOutPoint(*txid, *request.Index))
+	if err == blockchain.ErrSpentIndexDisabled {
+		return nil, NewRPCError(RPCMiscError, "Spent index not enabled. Use -spentindex")
+	} else if err != nil {
+		return nil, NewRPCError(RPCInvalidAddressOrKey, "Unable to get spent info")
+	}
+	return &SpentInfoResult{
+		TxID:     info.TxID.ToString(),
+		Index:    info.Index,
+		Height:   info.Height,
+		Satoshis: info.Value,
+		Script:   hex.EncodeToString(info.Script),
+	}, nil
+}
@@ -0,0 +1,25 @@
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGetSpentInfoParams(t *testing.T) {
+	txid := "0000000000000000000000000000000000000000000000000000000000000001"
+	tests := []struct {
+		param string
+		code  int
+	}{
+		{`{"txid": "01", "index": 0}`, RPCInvalidParameter},
+		{`{"txid": "` + txid + `"}`, RPCInvalidParameter},
+		{`"` + txid + `"`, RPCTypeError},
+		{`{"txid": "` + txid + `", "index": 0}`, RPCMiscError},
+	}
+	for _, test := range tests {
+		_, rpcErr := NewServer("", "", "").Execute("getspentinfo", []json.RawMessage{json.RawMessage(test.param)})
+		if rpcErr == nil || rpcErr.Code != test.code {
+			t.Errorf("%s: expected error %d, got %v", test.param, test.code, rpcErr)
+		}
+	}
+}
@@ -0,0 +1,591 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/btcboost/copernicus/bip39"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/wallet"
+)
+
+var walletHandlers = map[string]commandHandler{
+	"getnewaddress":          handleGetNewAddress,
+	"getbalance":             handleGetBalance,
+	"getunconfirmedbalance":  handleGetUnconfirmedBalance,
+	"listunspent":            handleListUnspent,
+	"listtransactions":       handleListTransactions,
+	"sendtoaddress":          handleSendToAddress,
+	"sendmany":               handleSendMany,
+	"encryptwallet":          handleEncryptWallet,
+	"walletpassphrase":       handleWalletPassphrase,
+	"walletlock":             handleWalletLock,
+	"walletpassphrasechange": handleWalletPassphraseChange,
+	"sethdseed":              handleSetHDSeed,
+}
+
+func init() {
+	registerHandlers(walletHandlers)
+}
+
+// UnspentResult is an output listed by the listunspent command.
+type UnspentResult struct {
+	TxID          string  `json:"txid"`
+	Vout          uint32  `json:"vout"`
+	Address       string  `json:"address,omitempty"`
+	Label         string  `json:"label,omitempty"`
+	ScriptPubKey  string  `json:"scriptPubKey"`
+	Amount        float64 `json:"amount"`
+	Confirmations int     `json:"confirmations"`
+	Spendable     bool    `json:"spendable"`
+	Safe          bool    `json:"safe"`
+}
+
+// TransactionResult is a payment listed by the listtransactions command.
+type TransactionResult struct {
+	Address       string   `json:"address,omitempty"`
+	Category      string   `json:"category"`
+	Amount        float64  `json:"amount"`
+	Label         string   `json:"label,omitempty"`
+	Vout          uint32   `json:"vout"`
+	Fee           *float64 `json:"fee,omitempty"`
+	Confirmations int      `json:"confirmations"`
+	Generated     bool     `json:"generated,omitempty"`
+	WatchOnly     bool     `json:"involvesWatchonly,omitempty"`
+	BlockHash     string   `json:"blockhash,omitempty"`
+	BlockHeight   *int     `json:"blockheight,omitempty"`
+	BlockTime     uint32   `json:"blocktime,omitempty"`
+	TxID          string   `json:"txid"`
+	Time          int64    `json:"time"`
+	TimeReceived  int64    `json:"timereceived"`
+}
+
+// activeWallet returns the wallet of the node, or an error when it is
+// disabled.
+func activeWallet() (*wallet.Wallet, error) {
+	if wallet.GWallet == nil {
+		return nil, NewRPCError(RPCMethodNotFound, "Method not found (wallet disabled)")
+	}
+	return wallet.GWallet, nil
+}
+
+// walletError converts an error of the wallet.
+func walletError(err error) error {
+	switch err {
+	case wallet.ErrInsufficientFunds:
+		return NewRPCError(RPCWalletInsufficientFunds, err.Error())
+	case wallet.ErrWalletLocked:
+		return NewRPCError(RPCWalletUnlockNeeded, err.Error())
+	case wallet.ErrWrongPassphrase:
+		return NewRPCError(RPCWalletPassphraseIncorrect, err.Error())
+	case wallet.ErrWalletEncrypted, wallet.ErrWalletNotEncrypted:
+		return NewRPCError(RPCWalletWrongEncState, err.Error())
+	}
+	return NewRPCError(RPCWalletError, err.Error())
+}
+
+// parseIntParam decodes the optional integer param at index i, def when it
+// is absent.
+func parseIntParam(params []json.RawMessage, i int, def int) (int, error) {
+	if isNullParam(params, i) {
+		return def, nil
+	}
+	var v int
+	if err := parseParam(params, i, &v, "an integer"); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// parseAmount decodes a positive amount in coins.
+func parseAmount(raw json.RawMessage) (utils.Amount, error) {
+	var value float64
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return 0, NewRPCError(RPCTypeError, "Amount is not a number")
+	}
+	amount, err := utils.NewAmount(value)
+	if err != nil || amount <= 0 {
+		return 0, NewRPCError(RPCTypeError, "Invalid amount for send")
+	}
+	return amount, nil
+}
+
+// handleGetNewAddress implements the getnewaddress command: it hands out a
+// new address, with an optional label.
+func handleGetNewAddress(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 1); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	label := ""
+	if !isNullParam(params, 0) {
+		if label, err = parseStringParam(params, 0); err != nil {
+			return nil, err
+		}
+	}
+	address, err := w.GetNewAddress(label)
+	if err != nil {
+		return nil, walletError(err)
+	}
+	return address, nil
+}
+
+// parseWatchOnlyParam decodes the optional include_watchonly param at index
+// i into the filter of the outputs to include.
+func parseWatchOnlyParam(params []json.RawMessage, i int) (wallet.IsMineFilter, error) {
+	includeWatchOnly := false
+	if !isNullParam(params, i) {
+		if err := parseParam(params, i, &includeWatchOnly, "a boolean"); err != nil {
+			return 0, err
+		}
+	}
+	if includeWatchOnly {
+		return wallet.IsMineAll, nil
+	}
+	return wallet.IsMineSpendable, nil
+}
+
+// handleGetBalance implements the getbalance command. The first param is
+// the legacy account, which must be "*" if given; the second is the
+// minimum number of confirmations, 1 by default, and the third whether to
+// include watched outputs.
+func handleGetBalance(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 3); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	if !isNullParam(params, 0) {
+		if account, err := parseStringParam(params, 0); err != nil || account != "*" {
+			return nil, NewRPCError(RPCMethodNotFound, "dummy first argument must be excluded or set to \"*\".")
+		}
+	}
+	minConf, err := parseIntParam(params, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := parseWatchOnlyParam(params, 2)
+	if err != nil {
+		return nil, err
+	}
+	return w.GetBalance(minConf, filter).ToBTC(), nil
+}
+
+// handleGetUnconfirmedBalance implements the getunconfirmedbalance command.
+func handleGetUnconfirmedBalance(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 0); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	return w.GetUnconfirmedBalance().ToBTC(), nil
+}
+
+// handleListUnspent implements the listunspent command: the unspent outputs
+// having between minconf (1) and maxconf (9999999) confirmations, paying
+// to one of the optional addresses.
+func handleListUnspent(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 3); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	minConf, err := parseIntParam(params, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	maxConf, err := parseIntParam(params, 1, 9999999)
+	if err != nil {
+		return nil, err
+	}
+	var scripts [][]byte
+	if !isNullParam(params, 2) {
+		var addresses []string
+		if err := parseParam(params, 2, &addresses, "an array of addresses"); err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, address := range addresses {
+			if seen[address] {
+				return nil, NewRPCError(RPCInvalidParameter, "Invalid parameter, duplicated address: "+address)
+			}
+			seen[address] = true
+			script, err := addressScript(address)
+			if err != nil {
+				return nil, err
+			}
+			scripts = append(scripts, script.GetScriptByte())
+		}
+	}
+
+	results := make([]*UnspentResult, 0)
+	for _, output := range w.ListUnspent(minConf, maxConf) {
+		scriptBytes := output.Coin.TxOut.Script.GetScriptByte()
+		if scripts != nil && !containsScript(scripts, scriptBytes) {
+			continue
+		}
+		results = append(results, &UnspentResult{
+			TxID:          output.OutPoint.Hash.ToString(),
+			Vout:          output.OutPoint.Index,
+			Address:       output.Address,
+			Label:         output.Label,
+			ScriptPubKey:  fmt.Sprintf("%x", scriptBytes),
+			Amount:        utils.Amount(output.Coin.TxOut.Value).ToBTC(),
+			Confirmations: output.Confirmations,
+			Spendable:     output.Spendable,
+			Safe:          output.Safe,
+		})
+	}
+	return results, nil
+}
+
+func containsScript(scripts [][]byte, script []byte) bool {
+	for _, s := range scripts {
+		if bytes.Equal(s, script) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleListTransactions implements the listtransactions command. The first
+// param is the legacy account, then come count (10), skip (0) and whether
+// to include watched outputs.
+func handleListTransactions(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 4); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	if !isNullParam(params, 0) {
+		if account, err := parseStringParam(params, 0); err != nil || account != "*" {
+			return nil, NewRPCError(RPCMethodNotFound, "dummy first argument must be excluded or set to \"*\".")
+		}
+	}
+	count, err := parseIntParam(params, 1, 10)
+	if err != nil {
+		return nil, err
+	}
+	skip, err := parseIntParam(params, 2, 0)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := parseWatchOnlyParam(params, 3)
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return nil, NewRPCError(RPCInvalidParameter, "Negative count")
+	}
+	if skip < 0 {
+		return nil, NewRPCError(RPCInvalidParameter, "Negative from")
+	}
+
+	results := make([]*TransactionResult, 0)
+	for _, entry := range w.ListTransactions(count, skip, filter) {
+		result := &TransactionResult{
+			Address:       entry.Address,
+			Category:      entry.Category,
+			Amount:        entry.Amount.ToBTC(),
+			Label:         entry.Label,
+			Vout:          entry.Vout,
+			Confirmations: entry.Confirmations,
+			Generated:     entry.Generated,
+			WatchOnly:     entry.WatchOnly,
+			TxID:          entry.TxID.ToString(),
+			Time:          entry.TimeReceived,
+			TimeReceived:  entry.TimeReceived,
+		}
+		if entry.Fee != nil {
+			fee := entry.Fee.ToBTC()
+			result.Fee = &fee
+		}
+		if entry.BlockHeight >= 0 {
+			height := entry.BlockHeight
+			result.BlockHash = entry.BlockHash.ToString()
+			result.BlockHeight = &height
+			result.BlockTime = entry.BlockTime
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// sendRecipients creates and commits a transaction paying recipients and
+// returns its txid.
+func sendRecipients(w *wallet.Wallet, recipients []wallet.Recipient) (interface{}, error) {
+	tx, _, err := w.CreateTransaction(recipients)
+	if err != nil {
+		return nil, walletError(err)
+	}
+	if err := w.CommitTransaction(tx); err != nil {
+		return nil, walletError(err)
+	}
+	txid := tx.TxHash()
+	return txid.ToString(), nil
+}
+
+// handleSendToAddress implements the sendtoaddress command: address and
+// amount, two ignored comments and whether to subtract the fee from the
+// amount.
+func handleSendToAddress(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 2, 5); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	address, err := parseStringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	script, err := addressScript(address)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := parseAmount(params[1])
+	if err != nil {
+		return nil, err
+	}
+	subtractFee := false
+	if !isNullParam(params, 4) {
+		if err := parseParam(params, 4, &subtractFee, "a boolean"); err != nil {
+			return nil, err
+		}
+	}
+	return sendRecipients(w, []wallet.Recipient{{Script: script, Amount: amount, SubtractFee: subtractFee}})
+}
+
+// handleSendMany implements the sendmany command: the legacy account, which
+// must be "", an object mapping addresses to amounts, an ignored minconf and
+// comment, and the addresses to subtract the fee from.
+func handleSendMany(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 2, 5); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	if account, err := parseStringParam(params, 0); err != nil || account != "" {
+		return nil, NewRPCError(RPCInvalidParameter, "Dummy value must be set to \"\"")
+	}
+	subtractFeeFrom := make(map[string]bool)
+	if !isNullParam(params, 4) {
+		var addresses []string
+		if err := parseParam(params, 4, &addresses, "an array of addresses"); err != nil {
+			return nil, err
+		}
+		for _, address := range addresses {
+			subtractFeeFrom[address] = true
+		}
+	}
+
+	// The recipients are decoded in order, so that the outputs are too.
+	invalid := NewRPCError(RPCTypeError, "amounts must be an object mapping addresses to amounts")
+	decoder := json.NewDecoder(bytes.NewReader(params[1]))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, invalid
+	}
+	var recipients []wallet.Recipient
+	seen := make(map[string]bool)
+	var total utils.Amount
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, invalid
+		}
+		address := token.(string)
+		if seen[address] {
+			return nil, NewRPCError(RPCInvalidParameter, "Invalid parameter, duplicated address: "+address)
+		}
+		seen[address] = true
+		script, err := addressScript(address)
+		if err != nil {
+			return nil, err
+		}
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, invalid
+		}
+		amount, err := parseAmount(raw)
+		if err != nil {
+			return nil, err
+		}
+		if total > math.MaxInt64-amount {
+			return nil, NewRPCError(RPCTypeError, "Invalid amount for send")
+		}
+		total += amount
+		recipients = append(recipients, wallet.Recipient{
+			Script:      script,
+			Amount:      amount,
+			SubtractFee: subtractFeeFrom[address],
+		})
+	}
+	if len(recipients) == 0 {
+		return nil, NewRPCError(RPCInvalidParameter, "Invalid parameter, no recipients")
+	}
+	return sendRecipients(w, recipients)
+}
+
+// maxUnlockTimeout is the longest time in seconds walletpassphrase unlocks
+// the wallet for.
+const maxUnlockTimeout = 100000000
+
+// parsePassphraseParam decodes the passphrase param at index i, which must
+// not be empty.
+func parsePassphraseParam(params []json.RawMessage, i int) (string, error) {
+	passphrase, err := parseStringParam(params, i)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", NewRPCError(RPCInvalidParameter, "passphrase can not be empty")
+	}
+	return passphrase, nil
+}
+
+// handleEncryptWallet implements the encryptwallet command: it encrypts the
+// wallet with passphrase and locks it.
+func handleEncryptWallet(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := parsePassphraseParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Encrypt(passphrase); err != nil {
+		if err == wallet.ErrWalletEncrypted {
+			return nil, walletError(err)
+		}
+		return nil, NewRPCError(RPCWalletEncryptionFailed, "Error: Failed to encrypt the wallet: "+err.Error())
+	}
+	return "wallet encrypted; the wallet is locked, unlock it with walletpassphrase to sign. " +
+		"Backups made before are not encrypted and should be replaced.", nil
+}
+
+// handleWalletPassphrase implements the walletpassphrase command: it
+// unlocks the wallet with passphrase for timeout seconds.
+func handleWalletPassphrase(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 2, 2); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := parsePassphraseParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	var timeout int64
+	if err := parseParam(params, 1, &timeout, "an integer"); err != nil {
+		return nil, err
+	}
+	if timeout < 0 {
+		return nil, NewRPCError(RPCInvalidParameter, "Timeout cannot be negative.")
+	}
+	if timeout > maxUnlockTimeout {
+		timeout = maxUnlockTimeout
+	}
+	if err := w.Unlock(passphrase, time.Duration(timeout)*time.Second); err != nil {
+		return nil, walletError(err)
+	}
+	return nil, nil
+}
+
+// handleWalletLock implements the walletlock command.
+func handleWalletLock(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 0); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Lock(); err != nil {
+		return nil, walletError(err)
+	}
+	return nil, nil
+}
+
+// handleWalletPassphraseChange implements the walletpassphrasechange
+// command: it changes the passphrase from the first param to the second,
+// and rotates the master key.
+func handleWalletPassphraseChange(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 2, 2); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	oldPassphrase, err := parsePassphraseParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	newPassphrase, err := parsePassphraseParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.ChangePassphrase(oldPassphrase, newPassphrase); err != nil {
+		return nil, walletError(err)
+	}
+	return nil, nil
+}
+
+// handleSetHDSeed implements the sethdseed command: it replaces the seed of a
+// wallet which has not used its keys with the seed of the mnemonic param, or
+// of a new random mnemonic, and rescans the chain for the coins of a given
+// mnemonic unless the second param is false.
+func handleSetHDSeed(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 2); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	mnemonic := ""
+	if !isNullParam(params, 0) {
+		if mnemonic, err = parseStringParam(params, 0); err != nil {
+			return nil, err
+		}
+	}
+	doRescan, err := parseRescanParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.SetHDSeed(mnemonic); err != nil {
+		switch err {
+		case bip39.ErrMnemonicWords, bip39.ErrUnknownWord, bip39.ErrMnemonicChecksum:
+			return nil, NewRPCError(RPCInvalidParameter, "Invalid mnemonic: "+err.Error())
+		}
+		return nil, walletError(err)
+	}
+	if mnemonic != "" && doRescan {
+		return nil, rescan(w, 0)
+	}
+	return nil, nil
+}
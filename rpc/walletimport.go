@@ -0,0 +1,330 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/hdkeychain"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/wallet"
+)
+
+var walletImportHandlers = map[string]commandHandler{
+	"importaddress":    handleImportAddress,
+	"importpubkey":     handleImportPubKey,
+	"importxpub":       handleImportXPub,
+	"rescanblockchain": handleRescanBlockchain,
+	"abortrescan":      handleAbortRescan,
+	"getwalletinfo":    handleGetWalletInfo,
+	"dumpwallet":       handleDumpWallet,
+}
+
+func init() {
+	registerHandlers(walletImportHandlers)
+}
+
+// RescanResult is the range of blocks scanned by the rescanblockchain
+// command.
+type RescanResult struct {
+	StartHeight int `json:"start_height"`
+	StopHeight  int `json:"stop_height"`
+}
+
+// ScanningResult is the progress of a rescan reported by getwalletinfo.
+type ScanningResult struct {
+	Duration int64   `json:"duration"`
+	Progress float64 `json:"progress"`
+}
+
+// WalletInfoResult is the state of the wallet returned by getwalletinfo.
+type WalletInfoResult struct {
+	Balance            float64 `json:"balance"`
+	UnconfirmedBalance float64 `json:"unconfirmed_balance"`
+	ImmatureBalance    float64 `json:"immature_balance"`
+	TxCount            int     `json:"txcount"`
+	Encrypted          bool    `json:"encrypted"`
+	Locked             bool    `json:"locked,omitempty"`
+	// Scanning is false when no rescan is running.
+	Scanning interface{} `json:"scanning"`
+}
+
+// DumpWalletResult is the file written by the dumpwallet command.
+type DumpWalletResult struct {
+	FileName string `json:"filename"`
+}
+
+// parseLabelParam decodes the optional label param at index i.
+func parseLabelParam(params []json.RawMessage, i int) (string, error) {
+	if isNullParam(params, i) {
+		return "", nil
+	}
+	return parseStringParam(params, i)
+}
+
+// parseRescanParam decodes the optional rescan param at index i, true by
+// default.
+func parseRescanParam(params []json.RawMessage, i int) (bool, error) {
+	rescan := true
+	if !isNullParam(params, i) {
+		if err := parseParam(params, i, &rescan, "a boolean"); err != nil {
+			return false, err
+		}
+	}
+	return rescan, nil
+}
+
+// rescan scans the active chain from startHeight to the tip for the
+// transactions of the scripts just imported.
+func rescan(w *wallet.Wallet, startHeight int) error {
+	if _, err := w.Rescan(startHeight, -1); err != nil {
+		return walletError(err)
+	}
+	return nil
+}
+
+// handleImportAddress implements the importaddress command: it watches an
+// address or a hex script, with an optional label, and rescans the chain
+// unless the third param is false.
+func handleImportAddress(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 3); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	str, err := parseStringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	label, err := parseLabelParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	doRescan, err := parseRescanParam(params, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	script, err := addressScript(str)
+	if err != nil {
+		raw, hexErr := hex.DecodeString(str)
+		if hexErr != nil || len(raw) == 0 {
+			return nil, NewRPCError(RPCInvalidAddressOrKey, "Invalid Bitcoin address or script")
+		}
+		script = core.NewScriptRaw(raw)
+	}
+	if err := w.ImportScript(script, label); err != nil {
+		return nil, walletError(err)
+	}
+	if doRescan {
+		return nil, rescan(w, 0)
+	}
+	return nil, nil
+}
+
+// handleImportPubKey implements the importpubkey command: it watches the
+// P2PKH and P2PK outputs of a hex public key.
+func handleImportPubKey(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 3); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	str, err := parseStringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	label, err := parseLabelParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	doRescan, err := parseRescanParam(params, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, NewRPCError(RPCInvalidAddressOrKey, "Pubkey must be a hex string")
+	}
+	if _, err := crypto.ParsePubKey(pubKey); err != nil {
+		return nil, NewRPCError(RPCInvalidAddressOrKey, "Pubkey is not a valid public key")
+	}
+	if err := w.ImportPubKey(pubKey, label); err != nil {
+		return nil, walletError(err)
+	}
+	if doRescan {
+		return nil, rescan(w, 0)
+	}
+	return nil, nil
+}
+
+// handleImportXPub implements the importxpub command: it watches the
+// addresses of an extended public key up to the gap limit, 20 by default,
+// and rescans the chain from the birthday height, 0 by default.
+func handleImportXPub(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 5); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	str, err := parseStringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	label, err := parseLabelParam(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	gapLimit, err := parseIntParam(params, 2, wallet.DefaultGapLimit)
+	if err != nil {
+		return nil, err
+	}
+	if gapLimit < 1 {
+		return nil, NewRPCError(RPCInvalidParameter, "gap_limit must be positive")
+	}
+	birthday, err := parseIntParam(params, 3, 0)
+	if err != nil {
+		return nil, err
+	}
+	if birthday < 0 {
+		return nil, NewRPCError(RPCInvalidParameter, "birthday cannot be negative")
+	}
+	doRescan, err := parseRescanParam(params, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hdkeychain.NewKeyFromStringForNet(str, msg.ActiveNetParams)
+	if err != nil || key.IsPrivate() {
+		return nil, NewRPCError(RPCInvalidAddressOrKey, "Invalid extended public key")
+	}
+	if err := w.ImportXPub(key, label, uint32(gapLimit), birthday); err != nil {
+		return nil, walletError(err)
+	}
+	if doRescan {
+		return nil, rescan(w, birthday)
+	}
+	return nil, nil
+}
+
+// handleRescanBlockchain implements the rescanblockchain command: it scans
+// the active chain from the start height, 0 by default, to the stop height,
+// the tip by default.
+func handleRescanBlockchain(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 2); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	startHeight, err := parseIntParam(params, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	stopHeight, err := parseIntParam(params, 1, -1)
+	if err != nil {
+		return nil, err
+	}
+	if startHeight < 0 || (!isNullParam(params, 1) && stopHeight < startHeight) {
+		return nil, NewRPCError(RPCInvalidParameter, "Invalid start_height or stop_height")
+	}
+	last, err := w.Rescan(startHeight, stopHeight)
+	if err != nil {
+		return nil, walletError(err)
+	}
+	return &RescanResult{StartHeight: startHeight, StopHeight: last}, nil
+}
+
+// handleAbortRescan implements the abortrescan command: it stops the
+// running rescan, and returns whether there was one.
+func handleAbortRescan(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 0); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	return w.AbortRescan(), nil
+}
+
+// handleGetWalletInfo implements the getwalletinfo command.
+func handleGetWalletInfo(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 0, 0); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	result := &WalletInfoResult{
+		Balance:            w.GetBalance(0, wallet.IsMineSpendable).ToBTC(),
+		UnconfirmedBalance: w.GetUnconfirmedBalance().ToBTC(),
+		ImmatureBalance:    w.GetImmatureBalance().ToBTC(),
+		TxCount:            w.TxCount(),
+		Encrypted:          w.IsCrypted(),
+		Locked:             w.IsLocked(),
+		Scanning:           false,
+	}
+	if progress, ok := w.Rescanning(); ok {
+		result.Scanning = &ScanningResult{
+			Duration: int64(time.Since(progress.Started) / time.Second),
+			Progress: progress.Fraction(),
+		}
+	}
+	return result, nil
+}
+
+// handleDumpWallet implements the dumpwallet command: it writes the mnemonic,
+// the seed and the keys of the wallet to a new file, for a backup on paper.
+func handleDumpWallet(s *Server, params []json.RawMessage) (interface{}, error) {
+	if err := checkParamCount(params, 1, 1); err != nil {
+		return nil, err
+	}
+	w, err := activeWallet()
+	if err != nil {
+		return nil, err
+	}
+	name, err := parseStringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(name)
+	if err != nil || name == "" {
+		return nil, NewRPCError(RPCInvalidParameter, "Invalid file name")
+	}
+	if w.IsLocked() {
+		return nil, walletError(wallet.ErrWalletLocked)
+	}
+
+	// The file is never overwritten, as it could be a previous backup.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, NewRPCError(RPCInvalidParameter, path+
+			" already exists. If you are sure this is what you want, move it out of the way first")
+	}
+	if err != nil {
+		return nil, NewRPCError(RPCInvalidParameter, "Cannot open wallet dump file")
+	}
+	err = w.Dump(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, walletError(err)
+	}
+	return &DumpWalletResult{FileName: path}, nil
+}
@@ -0,0 +1,53 @@
+package sign
+
+import (
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/utils"
+)
+
+// KeyStore gives the signer the private keys and redeem scripts it needs,
+// looked up by the hash160 of the public key or of the script.
+type KeyStore interface {
+	GetKey(keyID []byte) (*crypto.PrivateKey, bool)
+	GetScript(scriptID []byte) (*core.Script, bool)
+}
+
+// BasicKeyStore is a KeyStore holding its keys and scripts in memory.
+type BasicKeyStore struct {
+	keys    map[string]*crypto.PrivateKey
+	scripts map[string]*core.Script
+}
+
+func NewBasicKeyStore() *BasicKeyStore {
+	return &BasicKeyStore{
+		keys:    make(map[string]*crypto.PrivateKey),
+		scripts: make(map[string]*core.Script),
+	}
+}
+
+// AddKey adds key, found by the hash160 of its public key serialized as
+// compressed or not according to the key.
+func (ks *BasicKeyStore) AddKey(key *crypto.PrivateKey) bool {
+	pubKey := key.PubKey()
+	if pubKey == nil {
+		return false
+	}
+	ks.keys[string(utils.Hash160(pubKey.ToBytes()))] = key
+	return true
+}
+
+// AddScript adds the redeem script of a P2SH output.
+func (ks *BasicKeyStore) AddScript(script *core.Script) {
+	ks.scripts[string(utils.Hash160(script.GetScriptByte()))] = script
+}
+
+func (ks *BasicKeyStore) GetKey(keyID []byte) (*crypto.PrivateKey, bool) {
+	key, ok := ks.keys[string(keyID)]
+	return key, ok
+}
+
+func (ks *BasicKeyStore) GetScript(scriptID []byte) (*core.Script, bool) {
+	script, ok := ks.scripts[string(scriptID)]
+	return script, ok
+}
@@ -0,0 +1,332 @@
+package sign
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/btcboost/copernicus/container"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrMissingCoin    = errors.New("Input not found or already spent")
+	ErrNotSigned      = errors.New("Unable to sign input, invalid stack size (possibly missing key)")
+	ErrInvalidSigHash = errors.New("Invalid sighash param")
+)
+
+// ParseSigHashType parses hash type names such as "ALL|FORKID" or
+// "SINGLE|FORKID|ANYONECANPAY".
+func ParseSigHashType(name string) (uint32, error) {
+	parts := strings.Split(name, "|")
+	var hashType uint32
+	switch parts[0] {
+	case "ALL":
+		hashType = crypto.SigHashAll
+	case "NONE":
+		hashType = crypto.SigHashNone
+	case "SINGLE":
+		hashType = crypto.SigHashSingle
+	default:
+		return 0, ErrInvalidSigHash
+	}
+	for _, part := range parts[1:] {
+		var flag uint32
+		switch part {
+		case "FORKID":
+			flag = crypto.SigHashForkID
+		case "ANYONECANPAY":
+			flag = crypto.SigHashAnyoneCanpay
+		default:
+			return 0, ErrInvalidSigHash
+		}
+		if hashType&flag != 0 {
+			return 0, ErrInvalidSigHash
+		}
+		hashType |= flag
+	}
+	return hashType, nil
+}
+
+// CreateSig signs input nIn with key and returns the DER signature followed
+// by the hash type.
+func CreateSig(tx *core.Tx, nIn int, spent *core.TxOut, scriptCode *core.Script, key *crypto.PrivateKey,
+	hashType uint32, cache *core.PrecomputedTransactionData) ([]byte, error) {
+	hash, err := core.GetSignatureHash(tx, scriptCode, hashType, nIn, spent, cache, crypto.ScriptEnableSigHashForkID)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.Sign(key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	return append(sig, byte(hashType)), nil
+}
+
+// signer produces the scriptSig of one input.
+type signer struct {
+	tx       *core.Tx
+	nIn      int
+	spent    *core.TxOut
+	keyStore KeyStore
+	hashType uint32
+	cache    *core.PrecomputedTransactionData
+	// sigs holds signatures by public key, those given and those made.
+	sigs map[string][]byte
+}
+
+// checkSig returns whether sig, which ends with its hash type, is a valid
+// signature of pubKey for scriptCode.
+func (s *signer) checkSig(scriptCode *core.Script, sig []byte, pubKey []byte) bool {
+	if len(sig) == 0 {
+		return false
+	}
+	hash, err := core.GetSignatureHash(s.tx, scriptCode, uint32(sig[len(sig)-1]), s.nIn, s.spent, s.cache,
+		crypto.ScriptEnableSigHashForkID)
+	if err != nil {
+		return false
+	}
+	ok, _ := core.CheckSig(hash, sig[:len(sig)-1], pubKey)
+	return ok
+}
+
+// getSig returns a valid signature of pubKey for scriptCode, the one of sigs
+// or else one made with the key store.
+func (s *signer) getSig(scriptCode *core.Script, pubKey []byte) []byte {
+	if sig, ok := s.sigs[string(pubKey)]; ok && s.checkSig(scriptCode, sig, pubKey) {
+		return sig
+	}
+	sig, key := s.createSig(scriptCode, utils.Hash160(pubKey))
+	if sig == nil || !bytes.Equal(key.PubKey().ToBytes(), pubKey) {
+		return nil
+	}
+	return sig
+}
+
+// createSig signs scriptCode with the key of keyID, if the key store has it,
+// and records the signature in sigs.
+func (s *signer) createSig(scriptCode *core.Script, keyID []byte) ([]byte, *crypto.PrivateKey) {
+	if s.keyStore == nil {
+		return nil, nil
+	}
+	key, ok := s.keyStore.GetKey(keyID)
+	if !ok {
+		return nil, nil
+	}
+	sig, err := CreateSig(s.tx, s.nIn, s.spent, scriptCode, key, s.hashType, s.cache)
+	if err != nil {
+		return nil, nil
+	}
+	if s.sigs != nil {
+		s.sigs[string(key.PubKey().ToBytes())] = sig
+	}
+	return sig, key
+}
+
+// solve returns the pushes satisfying script, reusing the valid signatures
+// found in candidates, the pushes of existing scriptSigs, and producing the
+// missing ones from the key store. It also returns whether the pushes are
+// complete.
+func (s *signer) solve(script *core.Script, candidates [][][]byte, allowP2SH bool) ([][]byte, bool) {
+	var scriptType int
+	solutions := container.NewVector()
+	if !core.Solver(script, &scriptType, solutions) {
+		return nil, false
+	}
+
+	switch scriptType {
+	case core.TxPubKey:
+		pubKey := solutions.Array[0].([]byte)
+		for _, pushes := range candidates {
+			if len(pushes) == 1 && s.checkSig(script, pushes[0], pubKey) {
+				return pushes, true
+			}
+		}
+		if sig := s.getSig(script, pubKey); sig != nil {
+			return [][]byte{sig}, true
+		}
+
+	case core.TxPubKeyHash:
+		keyID := solutions.Array[0].([]byte)
+		for _, pushes := range candidates {
+			if len(pushes) == 2 && bytes.Equal(utils.Hash160(pushes[1]), keyID) &&
+				s.checkSig(script, pushes[0], pushes[1]) {
+				return pushes, true
+			}
+		}
+		for pubKey, sig := range s.sigs {
+			if bytes.Equal(utils.Hash160([]byte(pubKey)), keyID) && s.checkSig(script, sig, []byte(pubKey)) {
+				return [][]byte{sig, []byte(pubKey)}, true
+			}
+		}
+		if sig, key := s.createSig(script, keyID); sig != nil {
+			return [][]byte{sig, key.PubKey().ToBytes()}, true
+		}
+
+	case core.TxMultiSig:
+		required := int(solutions.Array[0].([]byte)[0])
+		pubKeys := solutions.Array[1 : solutions.Size()-1]
+		var sigs [][]byte
+		for _, pushes := range candidates {
+			if len(pushes) > 1 {
+				sigs = append(sigs, pushes[1:]...)
+			}
+		}
+		result := [][]byte{{}}
+		for _, item := range pubKeys {
+			if len(result) > required {
+				break
+			}
+			pubKey := item.([]byte)
+			found := false
+			for _, sig := range sigs {
+				if s.checkSig(script, sig, pubKey) {
+					result = append(result, sig)
+					found = true
+					break
+				}
+			}
+			if !found {
+				if sig := s.getSig(script, pubKey); sig != nil {
+					result = append(result, sig)
+				}
+			}
+		}
+		return result, len(result) == required+1
+
+	case core.TxScriptHash:
+		if !allowP2SH {
+			return nil, false
+		}
+		scriptID := solutions.Array[0].([]byte)
+		var redeemScript *core.Script
+		var inner [][][]byte
+		for _, pushes := range candidates {
+			if len(pushes) == 0 || !bytes.Equal(utils.Hash160(pushes[len(pushes)-1]), scriptID) {
+				continue
+			}
+			if redeemScript == nil {
+				redeemScript = core.NewScriptRaw(pushes[len(pushes)-1])
+			}
+			inner = append(inner, pushes[:len(pushes)-1])
+		}
+		if redeemScript == nil && s.keyStore != nil {
+			redeemScript, _ = s.keyStore.GetScript(scriptID)
+		}
+		if redeemScript == nil {
+			return nil, false
+		}
+		pushes, complete := s.solve(redeemScript, inner, false)
+		return append(pushes, redeemScript.GetScriptByte()), complete
+	}
+
+	// Keep the most complete of the existing scriptSigs.
+	var best [][]byte
+	for _, pushes := range candidates {
+		if len(pushes) > len(best) {
+			best = pushes
+		}
+	}
+	return best, false
+}
+
+// pushesOf returns the data pushed by scriptSig, or nil if it is not push
+// only.
+func pushesOf(scriptSig *core.Script) [][]byte {
+	if scriptSig == nil || scriptSig.Size() == 0 {
+		return nil
+	}
+	var pushes [][]byte
+	var opcode byte
+	var data []byte
+	for pc := 0; pc < scriptSig.Size(); {
+		if !scriptSig.GetOp(&pc, &opcode, &data) || opcode > core.OP_PUSHDATA4 {
+			return nil
+		}
+		pushes = append(pushes, data)
+	}
+	return pushes
+}
+
+// scriptOf returns the scriptSig pushing pushes.
+func scriptOf(pushes [][]byte) *core.Script {
+	script := core.NewScriptRaw(nil)
+	for _, push := range pushes {
+		script.PushData(push)
+	}
+	return core.NewScriptRaw(script.GetScriptByte())
+}
+
+func (s *signer) produce(scriptSigs ...*core.Script) (*core.Script, bool) {
+	candidates := make([][][]byte, 0, len(scriptSigs))
+	for _, scriptSig := range scriptSigs {
+		if pushes := pushesOf(scriptSig); pushes != nil {
+			candidates = append(candidates, pushes)
+		}
+	}
+	pushes, complete := s.solve(s.spent.Script, candidates, true)
+	return scriptOf(pushes), complete
+}
+
+// SignInput sets the scriptSig of input nIn of tx, which spends spent, with
+// the signatures keyStore can produce merged with those already present. It
+// returns whether the scriptSig is complete. cache may be nil.
+func SignInput(tx *core.Tx, nIn int, spent *core.TxOut, keyStore KeyStore, hashType uint32,
+	cache *core.PrecomputedTransactionData) bool {
+	s := &signer{tx: tx, nIn: nIn, spent: spent, keyStore: keyStore, hashType: hashType, cache: cache}
+	scriptSig, complete := s.produce(tx.Ins[nIn].Script)
+	tx.Ins[nIn].Script = scriptSig
+	return complete
+}
+
+// CombineSignatures merges two scriptSigs of input nIn of tx, such as the
+// partial multisig signatures of two cosigners. It returns the merged
+// scriptSig and whether it is complete.
+func CombineSignatures(tx *core.Tx, nIn int, spent *core.TxOut,
+	scriptSig1 *core.Script, scriptSig2 *core.Script) (*core.Script, bool) {
+	s := &signer{tx: tx, nIn: nIn, spent: spent}
+	return s.produce(scriptSig1, scriptSig2)
+}
+
+// ProduceScriptSig returns the scriptSig of input nIn of tx, which spends
+// spent, built from sigs, signatures indexed by serialized public key, and
+// from those keyStore can produce, which are added to sigs. It also returns
+// whether the scriptSig is complete. keyStore may be nil, cache too.
+func ProduceScriptSig(tx *core.Tx, nIn int, spent *core.TxOut, keyStore KeyStore, sigs map[string][]byte,
+	hashType uint32, cache *core.PrecomputedTransactionData) (*core.Script, bool) {
+	s := &signer{tx: tx, nIn: nIn, spent: spent, keyStore: keyStore, hashType: hashType, cache: cache, sigs: sigs}
+	return s.produce()
+}
+
+// InputError reports an input SignTransaction could not fully sign.
+type InputError struct {
+	Index int
+	Err   error
+}
+
+// SignTransaction signs every input of tx with the keys of keyStore. coins
+// holds the outputs spent by tx. The inputs which are not complete after
+// signing are returned.
+func SignTransaction(tx *core.Tx, coins map[core.OutPoint]*core.TxOut, keyStore KeyStore,
+	hashType uint32) []InputError {
+	cache := core.NewPrecomputedTransactionData(tx)
+	var inputErrors []InputError
+	for i, txIn := range tx.Ins {
+		spent, ok := coins[*txIn.PreviousOutPoint]
+		if !ok || spent.IsNull() {
+			inputErrors = append(inputErrors, InputError{Index: i, Err: ErrMissingCoin})
+			continue
+		}
+		// Without an output of the same index, SIGHASH_SINGLE signs nothing.
+		if hashType&0x1f == crypto.SigHashSingle && i >= len(tx.Outs) {
+			inputErrors = append(inputErrors, InputError{Index: i, Err: ErrNotSigned})
+			continue
+		}
+		if !SignInput(tx, i, spent, keyStore, hashType, cache) {
+			inputErrors = append(inputErrors, InputError{Index: i, Err: ErrNotSigned})
+		}
+	}
+	return inputErrors
+}
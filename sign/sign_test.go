@@ -0,0 +1,234 @@
+package sign
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/utils"
+)
+
+func newTestKey(t *testing.T, seed byte) *crypto.PrivateKey {
+	keyBytes := make([]byte, crypto.PrivateKeyBytesLen)
+	keyBytes[0] = 0x01
+	keyBytes[crypto.PrivateKeyBytesLen-1] = seed
+	key := crypto.PrivateKeyFromBytes(keyBytes)
+	if key.PubKey() == nil {
+		t.Fatalf("invalid test key %d", seed)
+	}
+	return key
+}
+
+func newScript(build func(script *core.Script)) *core.Script {
+	script := core.NewScriptRaw(nil)
+	build(script)
+	return core.NewScriptRaw(script.GetScriptByte())
+}
+
+func p2pkhScript(key *crypto.PrivateKey) *core.Script {
+	return newScript(func(script *core.Script) {
+		script.PushOpCode(core.OP_DUP)
+		script.PushOpCode(core.OP_HASH160)
+		script.PushData(utils.Hash160(key.PubKey().ToBytes()))
+		script.PushOpCode(core.OP_EQUALVERIFY)
+		script.PushOpCode(core.OP_CHECKSIG)
+	})
+}
+
+func multisigScript(required int, keys ...*crypto.PrivateKey) *core.Script {
+	return newScript(func(script *core.Script) {
+		script.PushInt64(int64(required))
+		for _, key := range keys {
+			script.PushData(key.PubKey().ToBytes())
+		}
+		script.PushInt64(int64(len(keys)))
+		script.PushOpCode(core.OP_CHECKMULTISIG)
+	})
+}
+
+func p2shScript(redeemScript *core.Script) *core.Script {
+	return newScript(func(script *core.Script) {
+		script.PushOpCode(core.OP_HASH160)
+		script.PushData(utils.Hash160(redeemScript.GetScriptByte()))
+		script.PushOpCode(core.OP_EQUAL)
+	})
+}
+
+// newSpendingTx returns a transaction spending one output of each script.
+func newSpendingTx(scripts ...*core.Script) (*core.Tx, map[core.OutPoint]*core.TxOut) {
+	tx := core.NewTx()
+	coins := make(map[core.OutPoint]*core.TxOut)
+	for i, script := range scripts {
+		outPoint := core.OutPoint{Hash: utils.Hash{byte(i + 1)}, Index: uint32(i)}
+		coins[outPoint] = core.NewTxOut(int64(i+1)*utils.COIN, script.GetScriptByte())
+		tx.AddTxIn(core.NewTxIn(&outPoint, nil))
+		tx.AddTxOut(core.NewTxOut(int64(i+1)*utils.COIN-1000, script.GetScriptByte()))
+	}
+	return tx, coins
+}
+
+func TestParseSigHashType(t *testing.T) {
+	tests := []struct {
+		name     string
+		hashType uint32
+		ok       bool
+	}{
+		{"ALL|FORKID", crypto.SigHashAll | crypto.SigHashForkID, true},
+		{"NONE|FORKID|ANYONECANPAY", crypto.SigHashNone | crypto.SigHashForkID | crypto.SigHashAnyoneCanpay, true},
+		{"SINGLE", crypto.SigHashSingle, true},
+		{"ALL|FORKID|FORKID", 0, false},
+		{"FORKID", 0, false},
+		{"ALL|", 0, false},
+	}
+	for _, test := range tests {
+		hashType, err := ParseSigHashType(test.name)
+		if (err == nil) != test.ok || hashType != test.hashType {
+			t.Errorf("%s: expected %#x, got %#x (%v)", test.name, test.hashType, hashType, err)
+		}
+	}
+}
+
+func TestSignP2PKH(t *testing.T) {
+	key := newTestKey(t, 1)
+	keyStore := NewBasicKeyStore()
+	keyStore.AddKey(key)
+
+	for _, name := range []string{"ALL", "NONE", "SINGLE"} {
+		for _, flags := range []string{"|FORKID", "|FORKID|ANYONECANPAY"} {
+			hashType, _ := ParseSigHashType(name + flags)
+			tx, coins := newSpendingTx(p2pkhScript(key), p2pkhScript(key))
+			if errs := SignTransaction(tx, coins, keyStore, hashType); len(errs) != 0 {
+				t.Fatalf("%s%s: failed to sign: %v", name, flags, errs[0].Err)
+			}
+
+			pushes := pushesOf(tx.Ins[0].Script)
+			if len(pushes) != 2 || !bytes.Equal(pushes[1], key.PubKey().ToBytes()) ||
+				uint32(pushes[0][len(pushes[0])-1]) != hashType {
+				t.Fatalf("%s%s: unexpected scriptSig %x", name, flags, tx.Ins[0].Script.GetScriptByte())
+			}
+			spent := coins[*tx.Ins[0].PreviousOutPoint]
+			hash, _ := core.SignatureHashForkID(tx, spent.Script, hashType, 0, spent, nil)
+			if ok, _ := core.CheckSig(hash, pushes[0][:len(pushes[0])-1], pushes[1]); !ok {
+				t.Errorf("%s%s: the signature does not verify", name, flags)
+			}
+
+			// Change what the hash type leaves out and check the signature
+			// still holds.
+			s := &signer{tx: tx, nIn: 0, spent: spent}
+			if name != "ALL" {
+				tx.Outs[1].Value--
+			}
+			if flags == "|FORKID|ANYONECANPAY" {
+				tx.Ins[1].Sequence--
+				tx.Ins = tx.Ins[:1]
+			}
+			s.cache = core.NewPrecomputedTransactionData(tx)
+			if !s.checkSig(spent.Script, pushes[0], pushes[1]) {
+				t.Errorf("%s%s: the signature should not commit to the changes", name, flags)
+			}
+			tx.Outs[0].Value--
+			s.cache = core.NewPrecomputedTransactionData(tx)
+			if name != "NONE" && s.checkSig(spent.Script, pushes[0], pushes[1]) {
+				t.Errorf("%s%s: the signature should commit to its output", name, flags)
+			}
+		}
+	}
+}
+
+func TestSignMissing(t *testing.T) {
+	key := newTestKey(t, 1)
+	tx, coins := newSpendingTx(p2pkhScript(key), p2pkhScript(newTestKey(t, 2)))
+	delete(coins, *tx.Ins[0].PreviousOutPoint)
+	keyStore := NewBasicKeyStore()
+	keyStore.AddKey(key)
+
+	errs := SignTransaction(tx, coins, keyStore, crypto.SigHashAll|crypto.SigHashForkID)
+	if len(errs) != 2 || errs[0].Index != 0 || errs[0].Err != ErrMissingCoin ||
+		errs[1].Index != 1 || errs[1].Err != ErrNotSigned {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestSignMultisig(t *testing.T) {
+	keys := []*crypto.PrivateKey{newTestKey(t, 1), newTestKey(t, 2), newTestKey(t, 3)}
+	redeemScript := multisigScript(2, keys...)
+	tx, coins := newSpendingTx(p2shScript(redeemScript), multisigScript(1, keys[0], keys[1]))
+	hashType := uint32(crypto.SigHashAll | crypto.SigHashForkID)
+
+	// Each cosigner signs with a single key.
+	partial := make([]*core.Tx, 2)
+	for i, key := range []*crypto.PrivateKey{keys[2], keys[0]} {
+		keyStore := NewBasicKeyStore()
+		keyStore.AddKey(key)
+		keyStore.AddScript(redeemScript)
+		partial[i] = tx.Copy()
+		errs := SignTransaction(partial[i], coins, keyStore, hashType)
+		// Only the second cosigner holds a key of the bare multisig.
+		if len(errs) != 2-i || errs[0].Index != 0 {
+			t.Fatalf("cosigner %d: the P2SH input should be partially signed only, got %v", i, errs)
+		}
+		pushes := pushesOf(partial[i].Ins[0].Script)
+		if len(pushes) != 3 || !bytes.Equal(pushes[2], redeemScript.GetScriptByte()) {
+			t.Fatalf("cosigner %d: unexpected scriptSig %x", i, partial[i].Ins[0].Script.GetScriptByte())
+		}
+	}
+
+	spent := coins[*tx.Ins[0].PreviousOutPoint]
+	merged, complete := CombineSignatures(tx, 0, spent, partial[0].Ins[0].Script, partial[1].Ins[0].Script)
+	if !complete {
+		t.Fatalf("the merged signatures should be complete")
+	}
+	pushes := pushesOf(merged)
+	first, second := pushesOf(partial[1].Ins[0].Script)[1], pushesOf(partial[0].Ins[0].Script)[1]
+	if len(pushes) != 4 || len(pushes[0]) != 0 || !bytes.Equal(pushes[1], first) || !bytes.Equal(pushes[2], second) {
+		t.Errorf("the signatures should be ordered as their keys, got %x", merged.GetScriptByte())
+	}
+
+	// Signing again keeps the existing signatures.
+	partial[0].Ins[0].Script = merged
+	if !SignInput(partial[0], 0, spent, nil, hashType, nil) ||
+		!bytes.Equal(partial[0].Ins[0].Script.GetScriptByte(), merged.GetScriptByte()) {
+		t.Errorf("a complete scriptSig should be kept")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	keys := []*crypto.PrivateKey{newTestKey(t, 1), newTestKey(t, 2), newTestKey(t, 3)}
+	redeemScript := multisigScript(2, keys...)
+	keyStore := NewBasicKeyStore()
+	keyStore.AddKey(keys[1])
+	keyStore.AddKey(keys[2])
+	keyStore.AddScript(redeemScript)
+	flags := uint32(crypto.ScriptVerifyP2SH | crypto.ScriptVerifyStrictenc | crypto.ScriptVerifyNullFail |
+		crypto.ScriptVerifyNullDummy | crypto.ScriptEnableSigHashForkID)
+
+	for _, name := range []string{"ALL|FORKID", "SINGLE|FORKID|ANYONECANPAY"} {
+		hashType, _ := ParseSigHashType(name)
+		// The bare multisig is signed by its second key only.
+		tx, coins := newSpendingTx(p2pkhScript(keys[1]), p2shScript(redeemScript), multisigScript(1, keys[0], keys[1]))
+		if errs := SignTransaction(tx, coins, keyStore, hashType); len(errs) != 0 {
+			t.Fatalf("%s: failed to sign: %v", name, errs[0].Err)
+		}
+
+		spentCoins := make([]*core.SpentCoin, len(tx.Ins))
+		for i, in := range tx.Ins {
+			spentCoins[i] = &core.SpentCoin{TxOut: coins[*in.PreviousOutPoint]}
+		}
+		for i, ctx := range core.NewScriptExecutionContexts(tx, spentCoins) {
+			scriptPubKey := core.NewScriptRaw(spentCoins[i].TxOut.Script.GetScriptByte())
+			ret, err := core.NewInterpreter().VerifyWithContext(ctx, tx.Ins[i].Script, scriptPubKey, flags)
+			if err != nil || !ret {
+				t.Errorf("%s: input %d does not verify: %v", name, i, err)
+			}
+		}
+
+		// Both hash types commit the first input to the first output.
+		tx.Outs[0].Value--
+		ctx := core.NewScriptExecutionContext(tx, 0, spentCoins)
+		scriptPubKey := core.NewScriptRaw(spentCoins[0].TxOut.Script.GetScriptByte())
+		if ret, err := core.NewInterpreter().VerifyWithContext(ctx, tx.Ins[0].Script, scriptPubKey, flags); err == nil && ret {
+			t.Errorf("%s: the signature should commit to the first output", name)
+		}
+	}
+}
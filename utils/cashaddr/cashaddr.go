@@ -0,0 +1,164 @@
+// Package cashaddr implements the CashAddr encoding of Bitcoin Cash
+// addresses: a prefix naming the network, a separator, and the base32
+// encoding of a version byte and a hash, followed by a 40 bits BCH code
+// checksum.
+package cashaddr
+
+import (
+	"errors"
+	"strings"
+)
+
+// The types of address, stored in the version byte.
+const (
+	PubKeyType = 0
+	ScriptType = 1
+)
+
+const (
+	charset     = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	separator   = ':'
+	checksumLen = 8
+)
+
+var (
+	ErrInvalidFormat = errors.New("invalid cashaddr format")
+	ErrChecksum      = errors.New("cashaddr checksum error")
+	ErrWrongPrefix   = errors.New("cashaddr prefix mismatch")
+)
+
+var charsetRev = func() [128]int8 {
+	var rev [128]int8
+	for i := range rev {
+		rev[i] = -1
+	}
+	for i, c := range charset {
+		rev[c] = int8(i)
+	}
+	return rev
+}()
+
+// sizeBits maps the length of a hash to the size bits of the version byte.
+var sizeBits = map[int]byte{20: 0, 24: 1, 28: 2, 32: 3, 40: 4, 48: 5, 56: 6, 64: 7}
+
+// polymod computes the BCH code checksum of values.
+func polymod(values []byte) uint64 {
+	generators := [5]uint64{0x98f2bc8e61, 0x79b76d99e2, 0xf33e5fb3c4, 0xae2eabe2a8, 0x1e4f43e470}
+	c := uint64(1)
+	for _, d := range values {
+		c0 := byte(c >> 35)
+		c = ((c & 0x07ffffffff) << 5) ^ uint64(d)
+		for i, generator := range generators {
+			if c0&(1<<uint(i)) != 0 {
+				c ^= generator
+			}
+		}
+	}
+	return c ^ 1
+}
+
+// expandPrefix returns the lower 5 bits of each character of prefix,
+// followed by a zero for the separator.
+func expandPrefix(prefix string) []byte {
+	expanded := make([]byte, len(prefix)+1)
+	for i := 0; i < len(prefix); i++ {
+		expanded[i] = prefix[i] & 0x1f
+	}
+	return expanded
+}
+
+// convertBits regroups data from fromBits to toBits bits per value, padding
+// the last value with zeros if pad is set.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, bool) {
+	var acc uint32
+	var bits uint
+	maxValue := uint32(1)<<toBits - 1
+	var out []byte
+	for _, value := range data {
+		if uint32(value)>>fromBits != 0 {
+			return nil, false
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxValue))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(toBits-bits)&maxValue))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxValue != 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+// Encode returns the address of type addrType paying to hash, with prefix.
+func Encode(prefix string, addrType byte, hash []byte) (string, error) {
+	size, ok := sizeBits[len(hash)]
+	if !ok || addrType > 15 {
+		return "", ErrInvalidFormat
+	}
+	payload, _ := convertBits(append([]byte{addrType<<3 | size}, hash...), 8, 5, true)
+	checksum := polymod(append(append(expandPrefix(prefix), payload...), make([]byte, checksumLen)...))
+	for i := 0; i < checksumLen; i++ {
+		payload = append(payload, byte(checksum>>(5*uint(checksumLen-1-i))&0x1f))
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	b.WriteByte(separator)
+	for _, value := range payload {
+		b.WriteByte(charset[value])
+	}
+	return b.String(), nil
+}
+
+// Decode returns the type and the hash of address, whose prefix must be
+// prefix if it has one.
+func Decode(address string, prefix string) (byte, []byte, error) {
+	lower, upper := false, false
+	for _, c := range address {
+		if c >= 'a' && c <= 'z' {
+			lower = true
+		} else if c >= 'A' && c <= 'Z' {
+			upper = true
+		}
+	}
+	if lower && upper {
+		return 0, nil, ErrInvalidFormat
+	}
+	address = strings.ToLower(address)
+	if i := strings.IndexByte(address, separator); i >= 0 {
+		if address[:i] != prefix {
+			return 0, nil, ErrWrongPrefix
+		}
+		address = address[i+1:]
+	}
+	if len(address) <= checksumLen {
+		return 0, nil, ErrInvalidFormat
+	}
+
+	values := make([]byte, len(address))
+	for i := 0; i < len(address); i++ {
+		c := address[i]
+		if c >= 128 || charsetRev[c] < 0 {
+			return 0, nil, ErrInvalidFormat
+		}
+		values[i] = byte(charsetRev[c])
+	}
+	if polymod(append(expandPrefix(prefix), values...)) != 0 {
+		return 0, nil, ErrChecksum
+	}
+	data, ok := convertBits(values[:len(values)-checksumLen], 5, 8, false)
+	if !ok || len(data) == 0 {
+		return 0, nil, ErrInvalidFormat
+	}
+	version, hash := data[0], data[1:]
+	if size, ok := sizeBits[len(hash)]; !ok || size != version&0x07 || version&0x80 != 0 {
+		return 0, nil, ErrInvalidFormat
+	}
+	return version >> 3, hash, nil
+}
@@ -0,0 +1,50 @@
+package cashaddr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestCashAddr(t *testing.T) {
+	hash, _ := hex.DecodeString("76a04053bda0a88bda5177b86a15c3b29f559873")
+	tests := []struct {
+		prefix   string
+		addrType byte
+		address  string
+	}{
+		{"bitcoincash", PubKeyType, "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"},
+		{"bitcoincash", ScriptType, "bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq"},
+		{"bchtest", PubKeyType, "bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvqcw003ap"},
+	}
+	for _, test := range tests {
+		address, err := Encode(test.prefix, test.addrType, hash)
+		if err != nil || address != test.address {
+			t.Errorf("expected %s, got %s (%v)", test.address, address, err)
+		}
+		for _, encoded := range []string{test.address, strings.ToUpper(test.address),
+			test.address[len(test.prefix)+1:]} {
+			addrType, decoded, err := Decode(encoded, test.prefix)
+			if err != nil || addrType != test.addrType || !bytes.Equal(decoded, hash) {
+				t.Errorf("%s: unexpected type %d, hash %x (%v)", encoded, addrType, decoded, err)
+			}
+		}
+	}
+
+	invalid := []struct {
+		address string
+		err     error
+	}{
+		{"bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", ErrWrongPrefix},
+		{"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6q", ErrChecksum},
+		{"bitcoincash:Qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", ErrInvalidFormat},
+		{"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdxba", ErrInvalidFormat},
+		{"bitcoincash:qqqqqq", ErrInvalidFormat},
+	}
+	for _, test := range invalid {
+		if _, _, err := Decode(test.address, "bitcoincash"); err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.address, test.err, err)
+		}
+	}
+}
@@ -92,6 +92,7 @@ func DeepCopyCoin(coin *Coin) Coin {
 	if coin.TxOut != nil {
 		dst.TxOut.Value = coin.TxOut.Value
 		dst.TxOut.SigOpCount = coin.TxOut.SigOpCount
+		dst.TxOut.TokenData = coin.TxOut.TokenData.Copy()
 		if coin.TxOut.Script != nil {
 			tmp := coin.TxOut.Script.GetScriptByte()
 			dst.TxOut.Script = core.NewScriptRaw(tmp)
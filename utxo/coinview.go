@@ -17,6 +17,16 @@ type CoinsView interface {
 	EstimateSize() uint64
 }
 
+// CoinsViewDummy is a view without any coin. A cache holding all the coins
+// it needs can be switched to it, so it no longer reads its former base.
+type CoinsViewDummy struct{}
+
+func (CoinsViewDummy) GetCoin(point *core.OutPoint, coin *Coin) bool         { return false }
+func (CoinsViewDummy) HaveCoin(point *core.OutPoint) bool                    { return false }
+func (CoinsViewDummy) GetBestBlock() utils.Hash                              { return utils.Hash{} }
+func (CoinsViewDummy) BatchWrite(coinsMap CacheCoins, hash *utils.Hash) bool { return false }
+func (CoinsViewDummy) EstimateSize() uint64                                  { return 0 }
+
 type CoinsViewCache struct {
 	Base             CoinsView
 	hashBlock        utils.Hash
@@ -186,6 +196,9 @@ func (coinsViewCache *CoinsViewCache) SpendCoin(point *core.OutPoint, coin *Coin
 		coinsViewCache.cachedCoinsUsage -= entry.Coin.DynamicMemoryUsage()
 	} else {
 		entry.Flags |= CoinEntryDirty
+		// The spent TxOut moves to coin, and queued script checks may still
+		// read it: the entry gets a new one rather than clearing it.
+		entry.Coin.TxOut = new(core.TxOut)
 		entry.Coin.Clear()
 	}
 	return true
@@ -219,6 +232,27 @@ func (coinsViewCache *CoinsViewCache) GetOutputFor(tx *core.TxIn) *core.TxOut {
 	return coin.TxOut
 }
 
+// GetSpentCoins returns the coins spent by each input of tx, in input order.
+// An entry is nil if the corresponding coin is not in the view.
+func (coinsViewCache *CoinsViewCache) GetSpentCoins(tx *core.Tx) []*core.SpentCoin {
+	spentCoins := make([]*core.SpentCoin, len(tx.Ins))
+	if tx.IsCoinBase() {
+		return spentCoins
+	}
+	for i, in := range tx.Ins {
+		coin := coinsViewCache.AccessCoin(in.PreviousOutPoint)
+		if coin.IsSpent() {
+			continue
+		}
+		spentCoins[i] = &core.SpentCoin{
+			TxOut:      coin.TxOut,
+			Height:     coin.GetHeight(),
+			IsCoinBase: coin.IsCoinBase(),
+		}
+	}
+	return spentCoins
+}
+
 func (coinsViewCache *CoinsViewCache) GetValueIn(tx *core.Tx) utils.Amount {
 	if tx.IsCoinBase() {
 		return utils.Amount(0)
@@ -139,6 +139,30 @@ func IsEqualTxOut(o1 *core.TxOut, o2 *core.TxOut) bool {
 	return false
 }
 
+// The outputs handed out before a coin is spent, to script checks and as undo
+// data, must not change when it is spent.
+func TestSpendCoinKeepsTxOut(t *testing.T) {
+	base := newCoinsViewTest()
+	point := core.OutPoint{Hash: utils.Hash{1}, Index: 0}
+	base.coinMap[point] = NewCoin(core.NewTxOut(1000, []byte{core.OP_TRUE}), 1, false)
+	view := &CoinsViewCache{Base: base, CacheCoins: make(CacheCoins)}
+
+	tx := core.NewTx()
+	tx.AddTxIn(core.NewTxIn(&point, []byte{}))
+	tx.AddTxOut(core.NewTxOut(900, []byte{core.OP_TRUE}))
+	checked := view.GetSpentCoins(tx)[0].TxOut
+	undo := view.SpendCoins(tx)
+
+	if !view.AccessCoin(&point).IsSpent() {
+		t.Fatalf("the coin should be spent")
+	}
+	for _, out := range []*core.TxOut{checked, undo[0].TxOut} {
+		if out.Value != 1000 || out.Script == nil || !bytes.Equal(out.Script.GetScriptByte(), []byte{core.OP_TRUE}) {
+			t.Errorf("the spent output should be kept, got %v", out)
+		}
+	}
+}
+
 // test whether get the expected item by OutPoint struct with a pointer
 // in it or not
 func TestGetCoinByPointerOrValue(t *testing.T) {
@@ -10,7 +10,15 @@ const (
 	DbCoins      byte = 'c'
 	DbBlockFiles byte = 'f'
 	DbTxIndex    byte = 't'
-	DbBlockIndex byte = 'b'
+	// DbTxIndexBestBlock records the last block of the transaction index.
+	DbTxIndexBestBlock byte = 'T'
+	DbBlockIndex       byte = 'b'
+	// DbAddressIndex prefixes the balance changes of each script hash, and
+	// DbAddressUnspentIndex its unspent outputs.
+	DbAddressIndex        byte = 'a'
+	DbAddressUnspentIndex byte = 'u'
+	// DbSpentIndex prefixes the inputs spending each outpoint.
+	DbSpentIndex byte = 'p'
 
 	DbBestBlock   byte = 'B'
 	DbFlag        byte = 'F'
@@ -8,7 +8,11 @@ import (
 	"strconv"
 )
 
-func (coinsViewCache *CoinsViewCache) CheckTxInputs(tx *core.Tx, state *core.ValidationState, spendHeight int) bool {
+// CheckTxInputs checks the inputs of tx against the view: that they exist,
+// are mature, cover the outputs and, when tokensEnabled, that no tokens are
+// created out of thin air.
+func (coinsViewCache *CoinsViewCache) CheckTxInputs(tx *core.Tx, state *core.ValidationState, spendHeight int,
+	tokensEnabled bool) bool {
 	// This doesn't trigger the DoS code on purpose; if it did, it would make it
 	// easier for an attacker to attempt to split the network.
 	view := coinsViewCache
@@ -61,24 +65,151 @@ func (coinsViewCache *CoinsViewCache) CheckTxInputs(tx *core.Tx, state *core.Val
 			"bad-txns-fee-outofrange", false, "")
 	}
 
-	return true
+	return view.checkTxTokens(tx, state, tokensEnabled)
 }
 
-func (coinsViewCache *CoinsViewCache) UpdateCoins(tx *core.Tx, height int) (undo []*Coin) {
-	inputs := coinsViewCache
-	// Mark inputs spent.
-	if !(tx.IsCoinBase()) {
-		undo = make([]*Coin, 0, len(tx.Ins))
-		for _, txin := range tx.Ins {
-			undo = append(undo, NewEmptyCoin())
-			isSpent := inputs.SpendCoin(txin.PreviousOutPoint, undo[len(undo)-1])
-			if !isSpent {
-				panic("the coin is spent ..")
+// tokenCategoryInputs tallies the tokens of one category spent by a transaction.
+type tokenCategoryInputs struct {
+	amount     int64
+	minting    bool
+	mutable    int
+	immutables map[string]int
+}
+
+// checkTxTokens enforces the CashTokens rules: fungible tokens are conserved
+// per category, NFTs only come from NFTs of the same category that allow it,
+// and new categories can only be created by spending the output 0 whose
+// txid becomes the category id. Until tokens are enabled a token prefix is
+// nothing but script bytes, so there is nothing to check.
+func (coinsViewCache *CoinsViewCache) checkTxTokens(tx *core.Tx, state *core.ValidationState, tokensEnabled bool) bool {
+	if !tokensEnabled {
+		return true
+	}
+	for _, out := range tx.Outs {
+		if out.HasInvalidTokenPrefix() {
+			return state.Dos(100, false, core.RejectInvalid,
+				"bad-txns-vout-invalid-token-prefix", false, "")
+		}
+	}
+
+	genesis := make(map[utils.Hash]bool)
+	inputs := make(map[utils.Hash]*tokenCategoryInputs)
+	for _, in := range tx.Ins {
+		prevout := in.PreviousOutPoint
+		if prevout.Index == 0 {
+			genesis[prevout.Hash] = true
+		}
+		tokenData := coinsViewCache.AccessCoin(prevout).TxOut.TokenData
+		if tokenData == nil {
+			continue
+		}
+		category, ok := inputs[tokenData.Category]
+		if !ok {
+			category = &tokenCategoryInputs{immutables: make(map[string]int)}
+			inputs[tokenData.Category] = category
+		}
+		if tokenData.HasAmount() {
+			if category.amount > core.MaxTokenAmount-tokenData.Amount {
+				return state.Dos(100, false, core.RejectInvalid,
+					"bad-txns-token-in-amount-outofrange", false, "")
+			}
+			category.amount += tokenData.Amount
+		}
+		if tokenData.IsMintingNFT() {
+			category.minting = true
+		} else if tokenData.IsMutableNFT() {
+			category.mutable++
+		} else if tokenData.IsImmutableNFT() {
+			category.immutables[string(tokenData.Commitment)]++
+		}
+	}
+
+	amountsOut := make(map[utils.Hash]int64)
+	nfts := make([]*core.TokenData, 0)
+	for _, out := range tx.Outs {
+		tokenData := out.TokenData
+		if tokenData == nil {
+			continue
+		}
+		if tokenData.HasAmount() {
+			if amountsOut[tokenData.Category] > core.MaxTokenAmount-tokenData.Amount {
+				return state.Dos(100, false, core.RejectInvalid,
+					"bad-txns-token-out-amount-outofrange", false, "")
 			}
+			amountsOut[tokenData.Category] += tokenData.Amount
+		}
+		if genesis[tokenData.Category] {
+			continue
+		}
+		if _, ok := inputs[tokenData.Category]; !ok {
+			return state.Dos(100, false, core.RejectInvalid,
+				"bad-txns-token-invalid-category", false, tokenData.Category.ToString())
+		}
+		if tokenData.HasNFT() {
+			nfts = append(nfts, tokenData)
 		}
 	}
 
+	for category, amount := range amountsOut {
+		if genesis[category] {
+			continue
+		}
+		if amount > inputs[category].amount {
+			return state.Dos(100, false, core.RejectInvalid,
+				"bad-txns-token-amount-exceeds-inputs", false, category.ToString())
+		}
+	}
+
+	// Immutable NFTs passed through unchanged are matched first, everything
+	// else has to be produced by a minting or mutable NFT.
+	unmatched := make([]*core.TokenData, 0, len(nfts))
+	for _, nft := range nfts {
+		category := inputs[nft.Category]
+		key := string(nft.Commitment)
+		if nft.IsImmutableNFT() && category.immutables[key] > 0 {
+			category.immutables[key]--
+			continue
+		}
+		unmatched = append(unmatched, nft)
+	}
+	for _, nft := range unmatched {
+		category := inputs[nft.Category]
+		if category.minting {
+			continue
+		}
+		if !nft.IsMintingNFT() && category.mutable > 0 {
+			category.mutable--
+			continue
+		}
+		return state.Dos(100, false, core.RejectInvalid,
+			"bad-txns-token-nft-ex-nihilo", false, nft.Category.ToString())
+	}
+
+	return true
+}
+
+func (coinsViewCache *CoinsViewCache) UpdateCoins(tx *core.Tx, height int) (undo []*Coin) {
+	// Mark inputs spent.
+	undo = coinsViewCache.SpendCoins(tx)
+
 	// Add outputs.
-	AddCoins(*inputs, *tx, height)
+	AddCoins(*coinsViewCache, *tx, height)
+	return
+}
+
+// SpendCoins marks the coins spent by the inputs of tx as spent and returns
+// them as undo data. It does nothing for a coinbase.
+func (coinsViewCache *CoinsViewCache) SpendCoins(tx *core.Tx) (undo []*Coin) {
+	if tx.IsCoinBase() {
+		return nil
+	}
+	undo = make([]*Coin, 0, len(tx.Ins))
+	for _, txin := range tx.Ins {
+		undo = append(undo, NewEmptyCoin())
+		isSpent := coinsViewCache.SpendCoin(txin.PreviousOutPoint, undo[len(undo)-1])
+		if !isSpent {
+			panic("the coin is spent ..")
+		}
+	}
 	return
 }
@@ -0,0 +1,121 @@
+package utxo
+
+import (
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utils"
+)
+
+var tokenTestScript = []byte{core.OP_DUP, core.OP_HASH160, 0x14, 0x69, 0xe1, 0x2a, 0x40, 0xd4, 0xa2, 0x21,
+	0x8d, 0x33, 0xf2, 0x08, 0xb9, 0xa0, 0x44, 0x78, 0x94, 0xdc, 0x9b, 0xea, 0x31, core.OP_EQUALVERIFY,
+	core.OP_CHECKSIG}
+
+func newTokenTestView(prevouts map[core.OutPoint]*core.TokenData) *CoinsViewCache {
+	view := &CoinsViewCache{Base: newCoinsViewTest(), CacheCoins: make(CacheCoins)}
+	for outPoint, tokenData := range prevouts {
+		out := core.NewTxOut(10000, tokenTestScript)
+		out.TokenData = tokenData
+		point := outPoint
+		view.AddCoin(&point, *NewCoin(out, 1, false), false)
+	}
+	return view
+}
+
+func newTokenTestTx(prevouts []core.OutPoint, outs []*core.TokenData) *core.Tx {
+	tx := core.NewTx()
+	for i := range prevouts {
+		tx.Ins = append(tx.Ins, &core.TxIn{PreviousOutPoint: &prevouts[i], Script: core.NewScriptRaw([]byte{})})
+	}
+	for _, tokenData := range outs {
+		out := core.NewTxOut(1000, tokenTestScript)
+		out.TokenData = tokenData
+		tx.Outs = append(tx.Outs, out)
+	}
+	return tx
+}
+
+func TestCheckTxInputsTokens(t *testing.T) {
+	genesisPoint := core.OutPoint{Hash: *utils.HashFromString("01"), Index: 0}
+	tokenPoint := core.OutPoint{Hash: *utils.HashFromString("02"), Index: 1}
+	category := genesisPoint.Hash
+	otherCategory := *utils.HashFromString("03")
+
+	fungible := func(amount int64) *core.TokenData {
+		return &core.TokenData{Category: category, Bitfield: core.TokenHasAmount, Amount: amount}
+	}
+	nft := func(capability core.TokenCapability, commitment ...byte) *core.TokenData {
+		tokenData := &core.TokenData{Category: category, Bitfield: core.TokenHasNFT | byte(capability)}
+		if len(commitment) > 0 {
+			tokenData.Bitfield |= core.TokenHasCommitmentLength
+			tokenData.Commitment = commitment
+		}
+		return tokenData
+	}
+
+	tests := []struct {
+		name          string
+		tokenInput    *core.TokenData
+		spendGenesis  bool
+		outs          []*core.TokenData
+		tokensEnabled bool
+		reason        string
+	}{
+		{"tokens before activation", nil, false, []*core.TokenData{fungible(10)}, false, ""},
+		{"genesis", nil, true, []*core.TokenData{fungible(10), nft(core.TokenCapabilityMinting)}, true, ""},
+		{"unknown category", nil, false, []*core.TokenData{fungible(10)}, true,
+			"bad-txns-token-invalid-category"},
+		{"other category", fungible(10), true,
+			[]*core.TokenData{{Category: otherCategory, Bitfield: core.TokenHasAmount, Amount: 1}}, true,
+			"bad-txns-token-invalid-category"},
+		{"fungible conserved", fungible(10), false, []*core.TokenData{fungible(4), fungible(6)}, true, ""},
+		{"fungible inflated", fungible(10), false, []*core.TokenData{fungible(4), fungible(7)}, true,
+			"bad-txns-token-amount-exceeds-inputs"},
+		{"immutable passed through", nft(core.TokenCapabilityNone, 0x01), false,
+			[]*core.TokenData{nft(core.TokenCapabilityNone, 0x01)}, true, ""},
+		{"immutable changed", nft(core.TokenCapabilityNone, 0x01), false,
+			[]*core.TokenData{nft(core.TokenCapabilityNone, 0x02)}, true, "bad-txns-token-nft-ex-nihilo"},
+		{"mutable changed", nft(core.TokenCapabilityMutable, 0x01), false,
+			[]*core.TokenData{nft(core.TokenCapabilityMutable, 0x02)}, true, ""},
+		{"mutable duplicated", nft(core.TokenCapabilityMutable, 0x01), false,
+			[]*core.TokenData{nft(core.TokenCapabilityNone, 0x02), nft(core.TokenCapabilityNone, 0x03)}, true,
+			"bad-txns-token-nft-ex-nihilo"},
+		{"mutable to minting", nft(core.TokenCapabilityMutable), false,
+			[]*core.TokenData{nft(core.TokenCapabilityMinting)}, true, "bad-txns-token-nft-ex-nihilo"},
+		{"minting", nft(core.TokenCapabilityMinting), false,
+			[]*core.TokenData{nft(core.TokenCapabilityMinting), nft(core.TokenCapabilityNone, 0x01),
+				nft(core.TokenCapabilityMutable, 0x02)}, true, ""},
+	}
+	for _, test := range tests {
+		var prevouts []core.OutPoint
+		coins := make(map[core.OutPoint]*core.TokenData)
+		if test.spendGenesis {
+			prevouts = append(prevouts, genesisPoint)
+			coins[genesisPoint] = nil
+		}
+		if test.tokenInput != nil {
+			prevouts = append(prevouts, tokenPoint)
+			coins[tokenPoint] = test.tokenInput
+		}
+		if len(prevouts) == 0 {
+			prevouts = append(prevouts, tokenPoint)
+			coins[tokenPoint] = nil
+		}
+		view := newTokenTestView(coins)
+		tx := newTokenTestTx(prevouts, test.outs)
+
+		state := core.ValidationState{}
+		ok := view.CheckTxInputs(tx, &state, 1000, test.tokensEnabled)
+		if test.reason == "" {
+			if !ok {
+				t.Errorf("%s: CheckTxInputs() failed: %s", test.name, state.GetRejectReason())
+			}
+			continue
+		}
+		if ok {
+			t.Errorf("%s: CheckTxInputs() should fail with %s", test.name, test.reason)
+		} else if state.GetRejectReason() != test.reason {
+			t.Errorf("%s: got reject reason %s, want %s", test.name, state.GetRejectReason(), test.reason)
+		}
+	}
+}
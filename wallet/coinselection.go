@@ -0,0 +1,330 @@
+package wallet
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+	"github.com/pkg/errors"
+)
+
+const (
+	// p2pkhInputSize is the size of a signed input spending a P2PKH output
+	// with a compressed key.
+	p2pkhInputSize = 148
+
+	// p2pkhOutputSize is the size of a P2PKH output.
+	p2pkhOutputSize = 34
+
+	// maxBnBTries bounds the number of steps of the branch and bound search.
+	maxBnBTries = 100000
+
+	// knapsackIterations is the number of random passes of the knapsack
+	// search.
+	knapsackIterations = 1000
+
+	// DefaultCoinSelection is the coin selection strategy used when
+	// -coinselection is not set.
+	DefaultCoinSelection = "auto"
+)
+
+// Candidate is a spendable output coin selection may pick.
+type Candidate struct {
+	OutPoint core.OutPoint
+	Coin     *utxo.Coin
+	// Order is the order the transaction of the output entered the wallet.
+	Order uint64
+	// fee is the fee of the input spending the output, and effectiveValue
+	// its value minus that fee.
+	fee            int64
+	effectiveValue int64
+}
+
+// CoinSelectionParams are the parameters of a coin selection.
+type CoinSelectionParams struct {
+	// FeeRate is the feerate the inputs are paid at.
+	FeeRate utils.FeeRate
+	// DustRelayFee decides whether the change is dust.
+	DustRelayFee utils.FeeRate
+	// ChangeScript is the script the change is paid to.
+	ChangeScript *core.Script
+	// ChangeFee is the fee of the change output, and CostOfChange that fee
+	// plus the fee of spending it later.
+	ChangeFee    int64
+	CostOfChange int64
+}
+
+// isDustChange returns whether a change output worth change would be dust.
+func (p *CoinSelectionParams) isDustChange(change int64) bool {
+	return core.NewTxOut(change, p.ChangeScript.GetScriptByte()).IsDust(p.DustRelayFee)
+}
+
+// minChange returns the smallest change which is not dust, including the
+// fee of the change output.
+func (p *CoinSelectionParams) minChange() int64 {
+	out := core.NewTxOut(0, p.ChangeScript.GetScriptByte())
+	return out.GetDustThreshold(p.DustRelayFee) + p.ChangeFee
+}
+
+// CoinSelector picks candidates whose effective value is at least target.
+// It returns false when the candidates are not enough. The returned bool
+// changeless tells that the excess is to be left to the fee rather than
+// paid as change.
+type CoinSelector func(candidates []*Candidate, target int64, params *CoinSelectionParams) (
+	selected []*Candidate, changeless bool, ok bool)
+
+// coinSelectors are the strategies -coinselection can name.
+var coinSelectors = map[string]CoinSelector{
+	"auto":         SelectCoinsAuto,
+	"bnb":          SelectCoinsBnB,
+	"knapsack":     SelectCoinsKnapsack,
+	"largestfirst": SelectCoinsLargestFirst,
+	"oldestfirst":  SelectCoinsOldestFirst,
+}
+
+// LookupCoinSelector returns the coin selection strategy named name.
+func LookupCoinSelector(name string) (CoinSelector, error) {
+	selector, ok := coinSelectors[name]
+	if !ok {
+		return nil, errors.Errorf("unknown coin selection strategy %s", name)
+	}
+	return selector, nil
+}
+
+// newCandidates returns the outputs worth spending at the feerate of params,
+// those whose value is more than the fee of their input.
+func newCandidates(outputs []*Output, params *CoinSelectionParams) []*Candidate {
+	inputFee := params.FeeRate.GetFee(p2pkhInputSize)
+	var candidates []*Candidate
+	for _, output := range outputs {
+		effectiveValue := output.Coin.TxOut.Value - inputFee
+		if effectiveValue <= 0 {
+			continue
+		}
+		candidates = append(candidates, &Candidate{
+			OutPoint:       output.OutPoint,
+			Coin:           output.Coin,
+			Order:          output.Order,
+			fee:            inputFee,
+			effectiveValue: effectiveValue,
+		})
+	}
+	return candidates
+}
+
+func effectiveValueOf(candidates []*Candidate) int64 {
+	var value int64
+	for _, candidate := range candidates {
+		value += candidate.effectiveValue
+	}
+	return value
+}
+
+// SelectCoinsAuto tries a changeless selection by branch and bound, and
+// falls back to the knapsack.
+func SelectCoinsAuto(candidates []*Candidate, target int64, params *CoinSelectionParams) ([]*Candidate, bool, bool) {
+	if selected, changeless, ok := SelectCoinsBnB(candidates, target, params); ok {
+		return selected, changeless, ok
+	}
+	return SelectCoinsKnapsack(candidates, target, params)
+}
+
+// SelectCoinsBnB searches by branch and bound the selection whose effective
+// value exceeds target by the least, by at most the cost of change, so that
+// no change output is needed.
+func SelectCoinsBnB(candidates []*Candidate, target int64, params *CoinSelectionParams) ([]*Candidate, bool, bool) {
+	sorted := make([]*Candidate, len(candidates))
+	copy(sorted, candidates)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].effectiveValue > sorted[j].effectiveValue
+	})
+	available := effectiveValueOf(sorted)
+	if available < target {
+		return nil, false, false
+	}
+
+	var best, current []*Candidate
+	bestExcess := int64(-1)
+	tries := 0
+	var search func(i int, value, remaining int64)
+	search = func(i int, value, remaining int64) {
+		if tries >= maxBnBTries || bestExcess == 0 {
+			return
+		}
+		tries++
+		if value > target+params.CostOfChange || value+remaining < target {
+			return
+		}
+		if value >= target {
+			if excess := value - target; bestExcess < 0 || excess < bestExcess {
+				best = append([]*Candidate(nil), current...)
+				bestExcess = excess
+			}
+			return
+		}
+		if i == len(sorted) {
+			return
+		}
+		candidate := sorted[i]
+		remaining -= candidate.effectiveValue
+		current = append(current, candidate)
+		search(i+1, value+candidate.effectiveValue, remaining)
+		current = current[:len(current)-1]
+		// Omitting a candidate worth the same as the previous omitted one
+		// explores the same selections again.
+		for i+1 < len(sorted) && sorted[i+1].effectiveValue == candidate.effectiveValue {
+			i++
+			remaining -= sorted[i].effectiveValue
+		}
+		search(i+1, value, remaining)
+	}
+	search(0, 0, available)
+	if bestExcess < 0 {
+		return nil, false, false
+	}
+	return best, true, true
+}
+
+// approximateBestSubset randomly searches the subset of candidates, sorted
+// by decreasing value, worth the least at or above target.
+func approximateBestSubset(candidates []*Candidate, total, target int64) ([]bool, int64) {
+	best := make([]bool, len(candidates))
+	for i := range best {
+		best[i] = true
+	}
+	bestValue := total
+	included := make([]bool, len(candidates))
+	for rep := 0; rep < knapsackIterations && bestValue != target; rep++ {
+		for i := range included {
+			included[i] = false
+		}
+		value := int64(0)
+		reachedTarget := false
+		for pass := 0; pass < 2 && !reachedTarget; pass++ {
+			for i, candidate := range candidates {
+				// The first pass picks candidates at random, the second
+				// only those left out.
+				pick := rand.Intn(2) == 0
+				if pass == 1 {
+					pick = !included[i]
+				}
+				if !pick {
+					continue
+				}
+				value += candidate.effectiveValue
+				included[i] = true
+				if value >= target {
+					reachedTarget = true
+					if value < bestValue {
+						bestValue = value
+						copy(best, included)
+					}
+					value -= candidate.effectiveValue
+					included[i] = false
+				}
+			}
+		}
+	}
+	return best, bestValue
+}
+
+// SelectCoinsKnapsack picks the single candidate matching target, or the
+// random subset of the smaller candidates worth the least above target plus
+// the smallest change which is not dust, or else the smallest candidate
+// larger than target.
+func SelectCoinsKnapsack(candidates []*Candidate, target int64, params *CoinSelectionParams) ([]*Candidate, bool, bool) {
+	minChange := params.minChange()
+	var lowestLarger *Candidate
+	var applicable []*Candidate
+	var totalLower int64
+	for _, i := range rand.Perm(len(candidates)) {
+		candidate := candidates[i]
+		switch value := candidate.effectiveValue; {
+		case value == target:
+			return []*Candidate{candidate}, false, true
+		case value < target+minChange:
+			applicable = append(applicable, candidate)
+			totalLower += value
+		case lowestLarger == nil || value < lowestLarger.effectiveValue:
+			lowestLarger = candidate
+		}
+	}
+	if totalLower == target {
+		return applicable, false, true
+	}
+	if totalLower < target {
+		if lowestLarger == nil {
+			return nil, false, false
+		}
+		return []*Candidate{lowestLarger}, false, true
+	}
+
+	sort.SliceStable(applicable, func(i, j int) bool {
+		return applicable[i].effectiveValue > applicable[j].effectiveValue
+	})
+	best, bestValue := approximateBestSubset(applicable, totalLower, target)
+	if bestValue != target && totalLower >= target+minChange {
+		best, bestValue = approximateBestSubset(applicable, totalLower, target+minChange)
+	}
+	// A larger candidate is preferred to a subset leaving dust change, or
+	// worth more.
+	if lowestLarger != nil &&
+		(bestValue != target && bestValue < target+minChange || lowestLarger.effectiveValue <= bestValue) {
+		return []*Candidate{lowestLarger}, false, true
+	}
+	var selected []*Candidate
+	for i, included := range best {
+		if included {
+			selected = append(selected, applicable[i])
+		}
+	}
+	return selected, false, true
+}
+
+// selectInOrder picks candidates in order until their effective value
+// reaches target, and then until the change is not dust if it can be.
+func selectInOrder(sorted []*Candidate, target int64, params *CoinSelectionParams) ([]*Candidate, bool, bool) {
+	var selected []*Candidate
+	var value int64
+	for _, candidate := range sorted {
+		if value >= target {
+			change := value - target - params.ChangeFee
+			if change <= 0 || !params.isDustChange(change) {
+				break
+			}
+		}
+		selected = append(selected, candidate)
+		value += candidate.effectiveValue
+	}
+	if value < target {
+		return nil, false, false
+	}
+	return selected, false, true
+}
+
+// SelectCoinsLargestFirst picks the candidates of largest value first, which
+// keeps the number of inputs low.
+func SelectCoinsLargestFirst(candidates []*Candidate, target int64, params *CoinSelectionParams) ([]*Candidate, bool, bool) {
+	sorted := make([]*Candidate, len(candidates))
+	copy(sorted, candidates)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].effectiveValue > sorted[j].effectiveValue
+	})
+	return selectInOrder(sorted, target, params)
+}
+
+// SelectCoinsOldestFirst picks the candidates confirmed first, unconfirmed
+// ones last, which consolidates old outputs.
+func SelectCoinsOldestFirst(candidates []*Candidate, target int64, params *CoinSelectionParams) ([]*Candidate, bool, bool) {
+	sorted := make([]*Candidate, len(candidates))
+	copy(sorted, candidates)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if hi, hj := sorted[i].Coin.GetHeight(), sorted[j].Coin.GetHeight(); hi != hj {
+			return hi < hj
+		}
+		return sorted[i].Order < sorted[j].Order
+	})
+	return selectInOrder(sorted, target, params)
+}
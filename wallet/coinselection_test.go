@@ -0,0 +1,109 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+)
+
+func newTestParams(feePerK int64) *CoinSelectionParams {
+	feeRate := utils.FeeRate{SataoshisPerK: feePerK}
+	params := &CoinSelectionParams{
+		FeeRate:      feeRate,
+		DustRelayFee: utils.FeeRate{SataoshisPerK: 1000},
+		ChangeScript: core.PayToPubKeyHash(make([]byte, 20)),
+		ChangeFee:    feeRate.GetFee(p2pkhOutputSize),
+	}
+	params.CostOfChange = params.ChangeFee + feeRate.GetFee(p2pkhInputSize)
+	return params
+}
+
+// newTestCandidates returns candidates worth values, the first one confirmed
+// last.
+func newTestCandidates(params *CoinSelectionParams, values ...int64) []*Candidate {
+	var outputs []*Output
+	for i, value := range values {
+		out := core.NewTxOut(value, params.ChangeScript.GetScriptByte())
+		outputs = append(outputs, &Output{
+			OutPoint: *core.NewOutPoint(utils.Hash{byte(i)}, 0),
+			Coin:     utxo.NewCoin(out, uint32(len(values)-i), false),
+			Order:    uint64(i),
+		})
+	}
+	return newCandidates(outputs, params)
+}
+
+func valueOf(selected []*Candidate) int64 {
+	var value int64
+	for _, candidate := range selected {
+		value += candidate.Coin.TxOut.Value
+	}
+	return value
+}
+
+func TestNewCandidates(t *testing.T) {
+	params := newTestParams(10000)
+	candidates := newTestCandidates(params, 1000, 100000)
+	if len(candidates) != 1 || candidates[0].effectiveValue != 100000-1480 {
+		t.Errorf("outputs worth less than the fee of their input should be left out")
+	}
+}
+
+func TestSelectCoinsBnB(t *testing.T) {
+	params := newTestParams(0)
+	candidates := newTestCandidates(params, 1000, 2000, 3000, 5000, 5000)
+	selected, changeless, ok := SelectCoinsBnB(candidates, 7000, params)
+	if !ok || !changeless || valueOf(selected) != 7000 {
+		t.Errorf("expected an exact match, got %d", valueOf(selected))
+	}
+	if _, _, ok := SelectCoinsBnB(candidates, 15500, params); ok {
+		t.Errorf("there is no selection without change")
+	}
+	if _, _, ok := SelectCoinsBnB(candidates, 17000, params); ok {
+		t.Errorf("the candidates are not enough")
+	}
+
+	// The auto strategy falls back to the knapsack.
+	selected, changeless, ok = SelectCoinsAuto(candidates, 15500, params)
+	if !ok || changeless || valueOf(selected) < 15500 {
+		t.Errorf("the knapsack should have selected enough, got %d", valueOf(selected))
+	}
+}
+
+func TestSelectCoinsKnapsack(t *testing.T) {
+	params := newTestParams(0)
+	candidates := newTestCandidates(params, 1000, 2000, 50000)
+	if selected, _, ok := SelectCoinsKnapsack(candidates, 2000, params); !ok || len(selected) != 1 ||
+		valueOf(selected) != 2000 {
+		t.Errorf("a candidate matching the target should be picked alone")
+	}
+	if selected, _, ok := SelectCoinsKnapsack(candidates, 3000, params); !ok || valueOf(selected) != 3000 {
+		t.Errorf("the smaller candidates matching the target should be picked, got %d", valueOf(selected))
+	}
+	if selected, _, ok := SelectCoinsKnapsack(candidates, 2500, params); !ok || valueOf(selected) != 50000 {
+		t.Errorf("the larger candidate should be preferred to dust change, got %d", valueOf(selected))
+	}
+	if _, _, ok := SelectCoinsKnapsack(candidates, 60000, params); ok {
+		t.Errorf("the candidates are not enough")
+	}
+}
+
+func TestSelectCoinsInOrder(t *testing.T) {
+	params := newTestParams(0)
+	candidates := newTestCandidates(params, 600, 10000, 5000)
+	// 10000 leaves dust change, so another candidate is picked.
+	selected, _, ok := SelectCoinsLargestFirst(candidates, 9800, params)
+	if !ok || len(selected) != 2 || valueOf(selected) != 15000 {
+		t.Errorf("unexpected largest first selection worth %d", valueOf(selected))
+	}
+	// The last candidate is the oldest.
+	selected, _, ok = SelectCoinsOldestFirst(candidates, 4000, params)
+	if !ok || len(selected) != 1 || valueOf(selected) != 5000 {
+		t.Errorf("unexpected oldest first selection worth %d", valueOf(selected))
+	}
+	if _, err := LookupCoinSelector("random"); err == nil {
+		t.Errorf("unknown strategies should be refused")
+	}
+}
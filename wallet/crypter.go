@@ -0,0 +1,177 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"io"
+	"time"
+
+	"github.com/btcboost/copernicus/net/protocol"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// masterKeyLen is the size of the AES-256 master key encrypting the
+	// private keys of the wallet.
+	masterKeyLen = 32
+
+	// kdfSaltLen is the size of the salt of the passphrase key derivation.
+	kdfSaltLen = 16
+
+	// kdfPBKDF2SHA512 identifies PBKDF2 with HMAC-SHA512 as the key
+	// derivation of the passphrase.
+	kdfPBKDF2SHA512 = 0
+
+	// minKDFIterations is the least number of iterations of the key
+	// derivation.
+	minKDFIterations = 25000
+
+	// DefaultKDFTime is the time in milliseconds deriving the key of a
+	// passphrase is tuned to take, set by -walletkdftime.
+	DefaultKDFTime = 100
+)
+
+var (
+	ErrWalletLocked       = errors.New("Error: Please enter the wallet passphrase with walletpassphrase first.")
+	ErrWrongPassphrase    = errors.New("Error: The wallet passphrase entered was incorrect.")
+	ErrWalletEncrypted    = errors.New("Error: running with an encrypted wallet, but encryptwallet was called.")
+	ErrWalletNotEncrypted = errors.New("Error: running with an unencrypted wallet, but a passphrase was given.")
+	ErrEmptyPassphrase    = errors.New("passphrase can not be empty")
+)
+
+// masterKey is the master key of the wallet, encrypted with the key derived
+// from the passphrase.
+type masterKey struct {
+	encryptedKey []byte
+	salt         []byte
+	method       uint32
+	iterations   uint32
+}
+
+func (m *masterKey) serialize(w io.Writer) error {
+	if err := utils.WriteVarBytes(w, m.encryptedKey); err != nil {
+		return err
+	}
+	if err := utils.WriteVarBytes(w, m.salt); err != nil {
+		return err
+	}
+	return protocol.WriteElements(w, m.method, m.iterations)
+}
+
+func (m *masterKey) deserialize(r io.Reader) (err error) {
+	if m.encryptedKey, err = utils.ReadVarBytes(r, maxRecordBytes, "encryptedKey"); err != nil {
+		return err
+	}
+	if m.salt, err = utils.ReadVarBytes(r, maxRecordBytes, "salt"); err != nil {
+		return err
+	}
+	return protocol.ReadElements(r, &m.method, &m.iterations)
+}
+
+// deriveKey derives the AES-256 key and IV encrypting the master key from
+// passphrase.
+func (m *masterKey) deriveKey(passphrase string) ([]byte, []byte, error) {
+	if m.method != kdfPBKDF2SHA512 {
+		return nil, nil, errors.Errorf("unknown key derivation method %d", m.method)
+	}
+	derived := pbkdf2.Key([]byte(passphrase), m.salt, int(m.iterations), masterKeyLen+aes.BlockSize, sha512.New)
+	return derived[:masterKeyLen], derived[masterKeyLen:], nil
+}
+
+// newMasterKey encrypts key with passphrase, with a fresh salt and the
+// number of iterations taking -walletkdftime milliseconds.
+func newMasterKey(key []byte, passphrase string) (*masterKey, error) {
+	m := &masterKey{salt: make([]byte, kdfSaltLen), method: kdfPBKDF2SHA512}
+	if _, err := rand.Read(m.salt); err != nil {
+		return nil, err
+	}
+	target := time.Duration(utils.GetArg("-walletkdftime", DefaultKDFTime)) * time.Millisecond
+	start := time.Now()
+	pbkdf2.Key([]byte(passphrase), m.salt, minKDFIterations, masterKeyLen+aes.BlockSize, sha512.New)
+	elapsed := time.Since(start)
+	m.iterations = minKDFIterations
+	if elapsed > 0 && target > elapsed {
+		m.iterations = uint32(int64(minKDFIterations) * int64(target) / int64(elapsed))
+	}
+
+	derivedKey, iv, err := m.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if m.encryptedKey, err = encryptCBC(derivedKey, iv, key); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// decrypt returns the master key, or ErrWrongPassphrase.
+func (m *masterKey) decrypt(passphrase string) ([]byte, error) {
+	derivedKey, iv, err := m.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	key, err := decryptCBC(derivedKey, iv, m.encryptedKey)
+	if err != nil || len(key) != masterKeyLen {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+// encryptCBC encrypts plaintext with AES-256-CBC and PKCS#7 padding.
+func encryptCBC(key, iv, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte(nil), plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+	return ciphertext, nil
+}
+
+// decryptCBC decrypts ciphertext encrypted by encryptCBC.
+func decryptCBC(key, iv, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid ciphertext length")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("invalid padding")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// encryptSecret encrypts secret with the master key, under a random IV
+// written before the ciphertext.
+func encryptSecret(key, secret []byte) ([]byte, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	ciphertext, err := encryptCBC(key, iv, secret)
+	if err != nil {
+		return nil, err
+	}
+	return append(iv, ciphertext...), nil
+}
+
+// decryptSecret decrypts a secret encrypted by encryptSecret.
+func decryptSecret(key, encrypted []byte) ([]byte, error) {
+	if len(encrypted) < aes.BlockSize {
+		return nil, errors.New("invalid ciphertext length")
+	}
+	return decryptCBC(key, encrypted[:aes.BlockSize], encrypted[aes.BlockSize:])
+}
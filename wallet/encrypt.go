@@ -0,0 +1,159 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/btcboost/copernicus/hdkeychain"
+)
+
+// IsCrypted returns whether the private keys of the wallet are encrypted.
+func (w *Wallet) IsCrypted() bool {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	return w.masterKey != nil
+}
+
+// IsLocked returns whether the wallet is encrypted and locked, so that it
+// can not sign.
+func (w *Wallet) IsLocked() bool {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	return w.isLocked()
+}
+
+func (w *Wallet) isLocked() bool {
+	return w.masterKey != nil && w.unlockedKey == nil
+}
+
+// lock forgets the master key and the private keys derived from it.
+func (w *Wallet) lock() {
+	for i := range w.unlockedKey {
+		w.unlockedKey[i] = 0
+	}
+	w.unlockedKey = nil
+	w.chainPrvKeys = [2]*hdkeychain.ExtendedKey{}
+	w.lockGeneration++
+	if w.lockTimer != nil {
+		w.lockTimer.Stop()
+		w.lockTimer = nil
+	}
+}
+
+// Lock locks an encrypted wallet.
+func (w *Wallet) Lock() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.masterKey == nil {
+		return ErrWalletNotEncrypted
+	}
+	w.lock()
+	return nil
+}
+
+// decryptAccount returns the master key decrypted with passphrase and the
+// HD chain with its secrets decrypted with it.
+func (w *Wallet) decryptAccount(passphrase string) ([]byte, *hdChain, error) {
+	key, err := w.masterKey.decrypt(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := w.hdChain.decrypt(key)
+	if err != nil {
+		return nil, nil, ErrWrongPassphrase
+	}
+	if _, err := hdkeychain.NewKeyFromStringForNet(string(c.accountXPrv), w.params); err != nil {
+		return nil, nil, ErrWrongPassphrase
+	}
+	return key, c, nil
+}
+
+// encryptAccount encrypts the secrets of the HD chain c under a new random
+// master key, itself encrypted with passphrase, and stores both. It returns
+// the master key.
+func (w *Wallet) encryptAccount(c *hdChain, passphrase string) ([]byte, error) {
+	key := make([]byte, masterKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	encrypted, err := c.encrypt(key)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMasterKey(key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.db.writeEncryption(m, encrypted); err != nil {
+		return nil, err
+	}
+	w.masterKey = m
+	w.hdChain = encrypted
+	return key, nil
+}
+
+// Encrypt encrypts the private keys of the wallet with passphrase, and
+// locks it. Copies of the unencrypted keys may remain in backups made
+// before.
+func (w *Wallet) Encrypt(passphrase string) error {
+	if passphrase == "" {
+		return ErrEmptyPassphrase
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.masterKey != nil {
+		return ErrWalletEncrypted
+	}
+	if _, err := w.encryptAccount(w.hdChain, passphrase); err != nil {
+		return err
+	}
+	w.lock()
+	return nil
+}
+
+// Unlock decrypts the private keys with passphrase, for timeout after which
+// the wallet is locked again.
+func (w *Wallet) Unlock(passphrase string, timeout time.Duration) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.masterKey == nil {
+		return ErrWalletNotEncrypted
+	}
+	key, _, err := w.decryptAccount(passphrase)
+	if err != nil {
+		return err
+	}
+	w.lock()
+	w.unlockedKey = key
+	generation := w.lockGeneration
+	w.lockTimer = time.AfterFunc(timeout, func() {
+		w.mtx.Lock()
+		defer w.mtx.Unlock()
+		if w.lockGeneration == generation {
+			w.lock()
+		}
+	})
+	return nil
+}
+
+// ChangePassphrase encrypts the private keys with a new master key, itself
+// encrypted with newPassphrase. The wallet is locked afterwards.
+func (w *Wallet) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if newPassphrase == "" {
+		return ErrEmptyPassphrase
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.masterKey == nil {
+		return ErrWalletNotEncrypted
+	}
+	_, c, err := w.decryptAccount(oldPassphrase)
+	if err != nil {
+		return err
+	}
+	if _, err := w.encryptAccount(c, newPassphrase); err != nil {
+		return err
+	}
+	w.lock()
+	return nil
+}
@@ -0,0 +1,97 @@
+package wallet
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+)
+
+func TestEncryptWallet(t *testing.T) {
+	utils.ParseParameters(2, []string{"-keypool=5", "-walletkdftime=1"})
+	defer utils.ParseParameters(0, nil)
+	w, path := openTestWallet(t)
+	defer os.RemoveAll(path)
+
+	script := newAddressScript(t, w, "")
+	funding := core.NewTx()
+	funding.AddTxIn(core.NewTxIn(core.NewOutPoint(utils.Hash{9}, 0), []byte{0x51}))
+	funding.AddTxOut(core.NewTxOut(utils.COIN, script.GetScriptByte()))
+	block, index := newTestBlock(1, funding)
+	w.BlockConnected(block, index)
+	keyID := script.GetScriptByte()[3:23]
+	send := []Recipient{{Script: script, Amount: utils.Amount(utils.COIN / 2)}}
+
+	if err := w.Lock(); err != ErrWalletNotEncrypted {
+		t.Errorf("expected %v, got %v", ErrWalletNotEncrypted, err)
+	}
+	if err := w.Encrypt("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if !w.IsCrypted() || !w.IsLocked() {
+		t.Fatalf("the wallet should be encrypted and locked")
+	}
+	if err := w.Encrypt("secret"); err != ErrWalletEncrypted {
+		t.Errorf("expected %v, got %v", ErrWalletEncrypted, err)
+	}
+	if _, ok := w.GetKey(keyID); ok {
+		t.Errorf("keys should not be available while locked")
+	}
+	if _, _, err := w.CreateTransaction(send); err != ErrWalletLocked {
+		t.Errorf("expected %v, got %v", ErrWalletLocked, err)
+	}
+	if _, err := w.GetNewAddress(""); err != nil {
+		t.Errorf("addresses should be handed out while locked: %v", err)
+	}
+
+	if err := w.Unlock("wrong", time.Hour); err != ErrWrongPassphrase {
+		t.Errorf("expected %v, got %v", ErrWrongPassphrase, err)
+	}
+	if err := w.Unlock("secret", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := w.CreateTransaction(send); err != nil {
+		t.Errorf("an unlocked wallet should sign: %v", err)
+	}
+	if err := w.Lock(); err != nil || !w.IsLocked() {
+		t.Errorf("the wallet should be locked")
+	}
+
+	if err := w.Unlock("secret", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !w.IsLocked() {
+		t.Errorf("the wallet should be locked after the timeout")
+	}
+
+	if err := w.ChangePassphrase("wrong", "other"); err != ErrWrongPassphrase {
+		t.Errorf("expected %v, got %v", ErrWrongPassphrase, err)
+	}
+	if err := w.ChangePassphrase("secret", "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The encryption is kept.
+	w.Close()
+	w, err := Open(path, msg.ActiveNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if !w.IsLocked() {
+		t.Fatalf("the reopened wallet should be locked")
+	}
+	if err := w.Unlock("secret", time.Hour); err != ErrWrongPassphrase {
+		t.Errorf("the old passphrase should be refused, got %v", err)
+	}
+	if err := w.Unlock("other", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.GetKey(keyID); !ok {
+		t.Errorf("keys should be available once unlocked")
+	}
+}
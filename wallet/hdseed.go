@@ -0,0 +1,253 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/btcboost/copernicus/bip39"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/hdkeychain"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utils/base58"
+	"github.com/pkg/errors"
+)
+
+// ErrSeedInUse is returned when replacing the seed of a wallet whose keys
+// are in use, as the coins they received would be lost.
+var ErrSeedInUse = errors.New("Cannot set a new HD seed while the wallet has handed out addresses or holds transactions")
+
+// normalizeMnemonic lowercases a phrase and separates its words with single
+// spaces, as they are hashed into the seed.
+func normalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+}
+
+// newHDChain returns the account of the seed of mnemonic, or of a new random
+// mnemonic when it is empty.
+func newHDChain(params *msg.BitcoinParams, mnemonic string) (*hdChain, error) {
+	if mnemonic == "" {
+		entropy, err := bip39.NewEntropy(bip39.MaxEntropyBits)
+		if err != nil {
+			return nil, err
+		}
+		if mnemonic, err = bip39.NewMnemonic(entropy); err != nil {
+			return nil, err
+		}
+	}
+	mnemonic = normalizeMnemonic(mnemonic)
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, err
+	}
+	master, err := hdkeychain.NewMaster(seed, params)
+	if err != nil {
+		return nil, err
+	}
+	account, err := master.Derive(accountPath(params))
+	if err != nil {
+		return nil, err
+	}
+	accountPub, err := account.Neuter()
+	if err != nil {
+		return nil, err
+	}
+	return &hdChain{
+		accountXPub: accountPub.String(),
+		accountXPrv: []byte(account.String()),
+		seed:        seed,
+		mnemonic:    []byte(mnemonic),
+	}, nil
+}
+
+// encrypt returns a copy of the chain with its secrets encrypted with key.
+func (c *hdChain) encrypt(key []byte) (*hdChain, error) {
+	encrypted := *c
+	var err error
+	if encrypted.accountXPrv, err = encryptSecret(key, c.accountXPrv); err != nil {
+		return nil, err
+	}
+	if encrypted.seed, err = encryptSecret(key, c.seed); err != nil {
+		return nil, err
+	}
+	if encrypted.mnemonic, err = encryptSecret(key, c.mnemonic); err != nil {
+		return nil, err
+	}
+	return &encrypted, nil
+}
+
+// decrypt returns a copy of the chain with its secrets decrypted with key.
+func (c *hdChain) decrypt(key []byte) (*hdChain, error) {
+	decrypted := *c
+	var err error
+	if decrypted.accountXPrv, err = decryptSecret(key, c.accountXPrv); err != nil {
+		return nil, err
+	}
+	if decrypted.seed, err = decryptSecret(key, c.seed); err != nil {
+		return nil, err
+	}
+	if decrypted.mnemonic, err = decryptSecret(key, c.mnemonic); err != nil {
+		return nil, err
+	}
+	return &decrypted, nil
+}
+
+// secrets returns the HD chain with its secrets in the clear. The caller
+// holds the lock.
+func (w *Wallet) secrets() (*hdChain, error) {
+	if w.masterKey == nil {
+		return w.hdChain, nil
+	}
+	if w.unlockedKey == nil {
+		return nil, ErrWalletLocked
+	}
+	return w.hdChain.decrypt(w.unlockedKey)
+}
+
+// deriveChainKeys derives the public keys of the chains of the account and
+// the keypool.
+func (w *Wallet) deriveChainKeys() error {
+	account, err := hdkeychain.NewKeyFromStringForNet(w.hdChain.accountXPub, w.params)
+	if err != nil {
+		return errors.Wrap(err, "invalid account key")
+	}
+	for chain := range w.chainKeys {
+		if w.chainKeys[chain], err = account.Child(uint32(chain)); err != nil {
+			return err
+		}
+	}
+	return w.topUpKeypool()
+}
+
+// markKeysUsed moves the next key of each chain past the keys tx pays to,
+// so that the keypool stays ahead of the keys in use, such as those found
+// rescanning a restored wallet.
+func (w *Wallet) markKeysUsed(tx *core.Tx) {
+	changed := false
+	for _, out := range tx.Outs {
+		key, ok := w.keyOf(out.Script)
+		if !ok || key.index < w.hdChain.nextIndex[key.chain] {
+			continue
+		}
+		w.hdChain.nextIndex[key.chain] = key.index + 1
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	if err := w.topUpKeypool(); err != nil {
+		logs.Error(fmt.Sprintf("wallet: failed to top up the keypool: %v", err))
+	}
+	if err := w.db.writeHDChain(w.hdChain); err != nil {
+		logs.Error(fmt.Sprintf("wallet: failed to write the HD chain: %v", err))
+	}
+}
+
+// SetHDSeed replaces the seed of a wallet which has not used its keys yet
+// with the seed of mnemonic, or of a new random mnemonic when it is empty.
+// An encrypted wallet must be unlocked.
+func (w *Wallet) SetHDSeed(mnemonic string) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.isLocked() {
+		return ErrWalletLocked
+	}
+	if len(w.txs) != 0 || w.hdChain.nextIndex != [2]uint32{} {
+		return ErrSeedInUse
+	}
+	c, err := newHDChain(w.params, mnemonic)
+	if err != nil {
+		return err
+	}
+	if w.masterKey != nil {
+		if c, err = c.encrypt(w.unlockedKey); err != nil {
+			return err
+		}
+	}
+	if err := w.db.writeHDChain(c); err != nil {
+		return err
+	}
+	w.hdChain = c
+	w.chainPrvKeys = [2]*hdkeychain.ExtendedKey{}
+	w.derived = [2]uint32{}
+	w.keys = make(map[string]*walletKey)
+	return w.deriveChainKeys()
+}
+
+// HDSeed returns the mnemonic of the wallet and the seed it makes. An
+// encrypted wallet must be unlocked.
+func (w *Wallet) HDSeed() (string, []byte, error) {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	c, err := w.secrets()
+	if err != nil {
+		return "", nil, err
+	}
+	return string(c.mnemonic), c.seed, nil
+}
+
+// Dump writes a backup of the wallet to out: its mnemonic, seed and account
+// key, followed by the private key of each address handed out, one per
+// line. An encrypted wallet must be unlocked.
+func (w *Wallet) Dump(out io.Writer) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	c, err := w.secrets()
+	if err != nil {
+		return err
+	}
+
+	path := accountPath(w.params)
+	fmt.Fprintf(out, "# Wallet dump created by copernicus\n")
+	fmt.Fprintf(out, "# * Created on %s\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(out, "# * Best block at time of backup was %d (%s)\n", w.bestHeight, w.bestHash.ToString())
+	fmt.Fprintf(out, "#\n")
+	fmt.Fprintf(out, "# The mnemonic restores the wallet with -walletmnemonic or sethdseed.\n")
+	fmt.Fprintf(out, "# mnemonic: %s\n", c.mnemonic)
+	fmt.Fprintf(out, "# seed: %s\n", hex.EncodeToString(c.seed))
+	fmt.Fprintf(out, "# extended private key of %s: %s\n", hdkeychain.FormatPath(path), c.accountXPrv)
+	fmt.Fprintf(out, "\n")
+
+	for chain := range w.chainKeys {
+		for index := uint32(0); index < w.hdChain.nextIndex[chain]; index++ {
+			child, err := w.chainKeys[chain].Child(index)
+			if err == hdkeychain.ErrInvalidChild {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			pubKey, err := child.ECPubKey()
+			if err != nil {
+				return err
+			}
+			keyID := utils.Hash160(pubKey.SerializeCompressed())
+			keyPath := append(append([]uint32{}, path...), uint32(chain), index)
+			privKey, ok := w.getKey(keyID)
+			if !ok {
+				return errors.Errorf("failed to derive the key %s", hdkeychain.FormatPath(keyPath))
+			}
+			address, err := core.Hash160ToAddressStr(keyID, w.params.PubKeyHashAddressID)
+			if err != nil {
+				return err
+			}
+			kind := "change=1"
+			if chain == externalChain {
+				kind = "label=" + url.PathEscape(w.labels[string(keyID)])
+			}
+			_, err = fmt.Fprintf(out, "%s 1970-01-01T00:00:01Z %s # addr=%s hdkeypath=%s\n",
+				base58.CheckEncode(privKey.Encode(), w.params.PrivatekeyID), kind, address,
+				hdkeychain.FormatPath(keyPath))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = fmt.Fprintf(out, "\n# End of dump\n")
+	return err
+}
@@ -0,0 +1,138 @@
+package wallet
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcboost/copernicus/bip39"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utils/base58"
+)
+
+func TestRestoreFromMnemonic(t *testing.T) {
+	utils.ParseParameters(1, []string{"-keypool=5"})
+	defer utils.ParseParameters(0, nil)
+	w, path := openTestWallet(t)
+	defer os.RemoveAll(path)
+	defer w.Close()
+
+	mnemonic, seed, err := w.HDSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(strings.Fields(mnemonic)) != 24 || !bytes.Equal(seed, bip39.NewSeed(mnemonic, "")) || w.Restored() {
+		t.Fatalf("unexpected seed %x of mnemonic %q", seed, mnemonic)
+	}
+	// The fourth address is the first to receive coins.
+	var scripts []*core.Script
+	for i := 0; i < 5; i++ {
+		scripts = append(scripts, newAddressScript(t, w, ""))
+	}
+	funding := core.NewTx()
+	funding.AddTxIn(core.NewTxIn(core.NewOutPoint(utils.Hash{9}, 0), []byte{0x51}))
+	funding.AddTxOut(core.NewTxOut(utils.COIN, scripts[3].GetScriptByte()))
+	block, index := newTestBlock(1, funding)
+
+	// The phrase is restored however it is spaced and cased.
+	utils.ParseParameters(2, []string{"-keypool=5", "-walletmnemonic= " + strings.ToUpper(mnemonic) + " "})
+	restored, restoredPath := openTestWallet(t)
+	defer os.RemoveAll(restoredPath)
+	if !restored.Restored() {
+		t.Errorf("the wallet should be restored from -walletmnemonic")
+	}
+	if restoredMnemonic, _, _ := restored.HDSeed(); restoredMnemonic != mnemonic {
+		t.Errorf("expected mnemonic %q, got %q", mnemonic, restoredMnemonic)
+	}
+	restored.BlockConnected(block, index)
+	if balance := restored.GetBalance(1, IsMineSpendable); int64(balance) != utils.COIN {
+		t.Errorf("the restored wallet should find the coins, got a balance of %d", balance)
+	}
+	if next := newAddressScript(t, restored, ""); !bytes.Equal(next.GetScriptByte(), scripts[4].GetScriptByte()) {
+		t.Errorf("the keys up to the one used should not be handed out again")
+	}
+	if err := restored.SetHDSeed(""); err != ErrSeedInUse {
+		t.Errorf("expected %v, got %v", ErrSeedInUse, err)
+	}
+
+	// An existing wallet keeps its seed.
+	restored.Close()
+	restored, err = Open(restoredPath, w.params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if restored.Restored() {
+		t.Errorf("a reopened wallet should not be restored again")
+	}
+}
+
+func TestSetHDSeed(t *testing.T) {
+	utils.ParseParameters(2, []string{"-keypool=5", "-walletkdftime=1"})
+	defer utils.ParseParameters(0, nil)
+	w, path := openTestWallet(t)
+	defer os.RemoveAll(path)
+
+	mnemonic, _, _ := w.HDSeed()
+	if err := w.SetHDSeed("abandon abandon abandon"); err != bip39.ErrMnemonicWords {
+		t.Errorf("expected %v, got %v", bip39.ErrMnemonicWords, err)
+	}
+	if err := w.SetHDSeed(""); err != nil {
+		t.Fatal(err)
+	}
+	if other, _, _ := w.HDSeed(); other == mnemonic {
+		t.Errorf("a new random mnemonic should be set")
+	}
+	if err := w.Encrypt("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetHDSeed(mnemonic); err != ErrWalletLocked {
+		t.Errorf("expected %v, got %v", ErrWalletLocked, err)
+	}
+	if err := w.Unlock("secret", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetHDSeed(mnemonic); err != nil {
+		t.Fatal(err)
+	}
+	script := newAddressScript(t, w, "savings")
+	if err := w.Dump(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The encrypted seed is kept, and backs the dump.
+	w.Close()
+	w, err := Open(path, w.params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, _, err := w.HDSeed(); err != ErrWalletLocked {
+		t.Errorf("expected %v, got %v", ErrWalletLocked, err)
+	}
+	if err := w.Dump(&bytes.Buffer{}); err != ErrWalletLocked {
+		t.Errorf("expected %v, got %v", ErrWalletLocked, err)
+	}
+	if err := w.Unlock("secret", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if restored, _, _ := w.HDSeed(); restored != mnemonic {
+		t.Errorf("expected mnemonic %q, got %q", mnemonic, restored)
+	}
+	var dump bytes.Buffer
+	if err := w.Dump(&dump); err != nil {
+		t.Fatal(err)
+	}
+	privKey, ok := w.GetKey(script.GetScriptByte()[3:23])
+	if !ok {
+		t.Fatalf("the key of the address should be available")
+	}
+	wif := base58.CheckEncode(privKey.Encode(), w.params.PrivatekeyID)
+	line := wif + " 1970-01-01T00:00:01Z label=savings # addr=" + w.extractAddress(script) + " hdkeypath=m/44'/"
+	if !strings.Contains(dump.String(), "# mnemonic: "+mnemonic+"\n") || !strings.Contains(dump.String(), "\n"+line) {
+		t.Errorf("unexpected dump\n%s", dump.String())
+	}
+}
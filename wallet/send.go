@@ -0,0 +1,230 @@
+package wallet
+
+import (
+	"github.com/btcboost/copernicus/blockchain"
+	"github.com/btcboost/copernicus/conf"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/sign"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultTxConfirmTarget is the number of blocks the fee of the sent
+	// transactions aims at, set by -txconfirmtarget.
+	DefaultTxConfirmTarget = 6
+
+	// DefaultFallbackFee is the feerate in satoshis per kB used when the fee
+	// estimator has not enough data, set by -fallbackfee.
+	DefaultFallbackFee = 20000
+
+	// DefaultMaxTxFee is the highest fee in satoshis of a sent transaction,
+	// set by -maxtxfee.
+	DefaultMaxTxFee = 10000000
+)
+
+var (
+	ErrNoRecipients      = errors.New("Transaction must have at least one recipient")
+	ErrNegativeAmount    = errors.New("Transaction amounts must not be negative")
+	ErrAmountTooSmall    = errors.New("Transaction amount too small")
+	ErrInsufficientFunds = errors.New("Insufficient funds")
+	ErrFeeTooHigh        = errors.New("Fee exceeds maximum configured by -maxtxfee")
+	ErrSigningFailed     = errors.New("Signing transaction failed")
+)
+
+// Recipient is an output of a transaction to send.
+type Recipient struct {
+	Script *core.Script
+	Amount utils.Amount
+	// SubtractFee takes the fee from the amount rather than adding it. The
+	// fee is shared among the recipients having it set.
+	SubtractFee bool
+}
+
+// FeeRate returns the feerate of the sent transactions: -paytxfee when set,
+// else the estimate for -txconfirmtarget blocks, else -fallbackfee. It is
+// not below the relay minimum.
+func FeeRate() utils.FeeRate {
+	feeRate := utils.FeeRate{SataoshisPerK: utils.GetArg("-paytxfee", 0)}
+	if feeRate.SataoshisPerK == 0 {
+		target := int(utils.GetArg("-txconfirmtarget", DefaultTxConfirmTarget))
+		estimate, ok := blockchain.GFeeEstimator.EstimateFee(target)
+		if ok {
+			feeRate = estimate
+		} else {
+			feeRate.SataoshisPerK = utils.GetArg("-fallbackfee", DefaultFallbackFee)
+		}
+	}
+	if feeRate.SataoshisPerK < blockchain.GMinRelayTxFee.SataoshisPerK {
+		feeRate = blockchain.GMinRelayTxFee
+	}
+	return feeRate
+}
+
+// CreateTransaction builds and signs a transaction paying recipients from
+// the safe outputs of the wallet, picked by the -coinselection strategy,
+// sending the change to a new key of the internal chain. It returns the
+// transaction and its fee.
+func (w *Wallet) CreateTransaction(recipients []Recipient) (*core.Tx, utils.Amount, error) {
+	selector, err := LookupCoinSelector(utils.GetArgString("-coinselection", DefaultCoinSelection))
+	if err != nil {
+		return nil, 0, err
+	}
+	return w.CreateTransactionWithSelector(recipients, selector)
+}
+
+// CreateTransactionWithSelector is CreateTransaction picking the outputs to
+// spend with selector.
+func (w *Wallet) CreateTransactionWithSelector(recipients []Recipient, selector CoinSelector) (*core.Tx,
+	utils.Amount, error) {
+	if len(recipients) == 0 {
+		return nil, 0, ErrNoRecipients
+	}
+	var total int64
+	subtractFeeCount := 0
+	for _, recipient := range recipients {
+		if recipient.Amount < 0 {
+			return nil, 0, ErrNegativeAmount
+		}
+		total += int64(recipient.Amount)
+		if recipient.SubtractFee {
+			subtractFeeCount++
+		}
+	}
+	feeRate := FeeRate()
+	maxTxFee := utils.GetArg("-maxtxfee", DefaultMaxTxFee)
+	params := &CoinSelectionParams{
+		FeeRate:      feeRate,
+		DustRelayFee: conf.GlobalValueInstance.GetDustRelayFee(),
+		// Only the size of the change script matters until a key is handed
+		// out for it.
+		ChangeScript: core.PayToPubKeyHash(make([]byte, 20)),
+		ChangeFee:    feeRate.GetFee(p2pkhOutputSize),
+	}
+	params.CostOfChange = params.ChangeFee + feeRate.GetFee(p2pkhInputSize)
+	// When the recipients pay the fee, the inputs are picked by their value.
+	if subtractFeeCount > 0 {
+		params.FeeRate = utils.FeeRate{}
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.isLocked() {
+		return nil, 0, ErrWalletLocked
+	}
+
+	var safe []*Output
+	for _, output := range w.unspent() {
+		if output.Safe && output.Spendable {
+			safe = append(safe, output)
+		}
+	}
+	candidates := newCandidates(safe, params)
+	var changeScript *core.Script
+	// fee is the fee the recipients pay, or else the fee of the transaction
+	// besides that of its inputs and change.
+	var fee int64
+	for {
+		tx := core.NewTx()
+		// Discourage fee sniping by only allowing the next block to include
+		// the transaction.
+		if w.bestHeight > 0 {
+			tx.LockTime = uint32(w.bestHeight)
+		}
+		share, first := int64(0), true
+		if subtractFeeCount > 0 {
+			share = fee / int64(subtractFeeCount)
+		}
+		for _, recipient := range recipients {
+			out := core.NewTxOut(int64(recipient.Amount), recipient.Script.GetScriptByte())
+			if recipient.SubtractFee {
+				out.Value -= share
+				// The first recipient pays the remainder of the division.
+				if first {
+					out.Value -= fee % int64(subtractFeeCount)
+					first = false
+				}
+			}
+			if out.IsDust(params.DustRelayFee) {
+				return nil, 0, ErrAmountTooSmall
+			}
+			tx.AddTxOut(out)
+		}
+
+		target := total
+		if subtractFeeCount == 0 {
+			if fee == 0 {
+				fee = feeRate.GetFee(tx.SerializeSize())
+			}
+			target += fee
+		}
+		selected, changeless, ok := selector(candidates, target, params)
+		if !ok {
+			return nil, 0, ErrInsufficientFunds
+		}
+		var value, inputsFee int64
+		for _, candidate := range selected {
+			value += candidate.Coin.TxOut.Value
+			inputsFee += candidate.fee
+		}
+		change := value - target - inputsFee - params.ChangeFee
+		if subtractFeeCount > 0 {
+			change = value - total
+		}
+		// Dust change goes to the fee instead.
+		if !changeless && change > 0 && !params.isDustChange(change) {
+			if changeScript == nil {
+				keyID, err := w.nextKey(internalChain)
+				if err != nil {
+					return nil, 0, err
+				}
+				changeScript = core.PayToPubKeyHash(keyID)
+			}
+			tx.AddTxOut(core.NewTxOut(change, changeScript.GetScriptByte()))
+		}
+
+		coins := make(map[core.OutPoint]*core.TxOut, len(selected))
+		for _, candidate := range selected {
+			outPoint := candidate.OutPoint
+			tx.AddTxIn(core.NewTxIn(&outPoint, nil))
+			tx.Ins[len(tx.Ins)-1].Sequence = core.MaxTxInSequenceNum - 1
+			coins[outPoint] = candidate.Coin.TxOut
+		}
+		if inputErrors := sign.SignTransaction(tx, coins, keyStore{w}, crypto.SigHashAll|crypto.SigHashForkID); len(inputErrors) > 0 {
+			return nil, 0, ErrSigningFailed
+		}
+
+		paid := value - tx.GetValueOut()
+		required := feeRate.GetFee(tx.SerializeSize())
+		if required > maxTxFee {
+			return nil, 0, ErrFeeTooHigh
+		}
+		if paid >= required {
+			return tx, utils.Amount(paid), nil
+		}
+		if subtractFeeCount > 0 {
+			fee = required
+		} else {
+			fee += required - paid
+		}
+	}
+}
+
+// CommitTransaction submits tx to the mempool and adds it to the wallet.
+func (w *Wallet) CommitTransaction(tx *core.Tx) error {
+	state := core.NewValidationState()
+	missingInputs := false
+	if !blockchain.AcceptToMemoryPool(w.params, blockchain.GMemPool, state, tx, false, &missingInputs, nil,
+		false, utils.Amount(utils.GetArg("-maxtxfee", DefaultMaxTxFee))) {
+		if missingInputs {
+			return errors.New("Transaction rejected: missing inputs")
+		}
+		return errors.Errorf("Transaction rejected: %s", state.FormatStateMessage())
+	}
+	// The mempool notification usually added it already.
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	_, err := w.addTx(tx, nil, nil)
+	return err
+}
@@ -0,0 +1,973 @@
+// Package wallet implements an HD wallet following the active chain and the
+// mempool. Its keys derive from a BIP44 account, addresses are handed out
+// from a pool of keys derived ahead, and the transactions paying to or
+// spending from them are kept in the wallet database.
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/btcboost/copernicus/consensus"
+	"github.com/btcboost/copernicus/container"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/crypto"
+	"github.com/btcboost/copernicus/hdkeychain"
+	"github.com/btcboost/copernicus/mempool"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultKeypoolSize is the number of keys derived ahead on each chain,
+	// set by -keypool.
+	DefaultKeypoolSize = 1000
+
+	externalChain = 0
+	internalChain = 1
+)
+
+// GWallet is the wallet of the node, nil when it is disabled.
+var GWallet *Wallet
+
+// WalletTx is a transaction paying to or spending from the wallet.
+type WalletTx struct {
+	Tx *core.Tx
+	// BlockHash, BlockHeight and BlockTime locate the block of the active
+	// chain the transaction is in. BlockHeight is -1 while it is not.
+	BlockHash    utils.Hash
+	BlockHeight  int
+	BlockTime    uint32
+	TimeReceived int64
+	// Order sorts the transactions in the order they entered the wallet.
+	Order uint64
+	// conflictHeight is the height of the block which confirmed a
+	// transaction double spending this one, -1 when there is none.
+	conflictHeight int
+}
+
+// walletKey locates a key of the wallet in the account.
+type walletKey struct {
+	pubKey []byte
+	chain  int
+	index  uint32
+}
+
+// Wallet is an HD wallet. All its methods are safe for concurrent use.
+type Wallet struct {
+	mtx    sync.RWMutex
+	db     *walletDB
+	params *msg.BitcoinParams
+
+	hdChain *hdChain
+	// restored is set when the wallet was just created from -walletmnemonic.
+	restored bool
+	// masterKey is set when the wallet is encrypted, and unlockedKey holds
+	// the decrypted master key while the wallet is unlocked.
+	masterKey   *masterKey
+	unlockedKey []byte
+	// lockGeneration is incremented by each unlock, so that the timer of a
+	// previous unlock does not lock the wallet.
+	lockGeneration uint64
+	lockTimer      *time.Timer
+	// chainKeys holds the public extended keys of the external and internal
+	// chains, chainPrvKeys the private ones.
+	chainKeys    [2]*hdkeychain.ExtendedKey
+	chainPrvKeys [2]*hdkeychain.ExtendedKey
+	// derived is, for each chain, the number of keys in keys.
+	derived     [2]uint32
+	keypoolSize uint32
+	keys        map[string]*walletKey
+	labels      map[string]string
+	// watched maps the scripts the wallet watches without their keys to
+	// their origin.
+	watched map[string]*watchedScript
+	xpubs   []*watchedXPub
+	rescan  rescanState
+
+	txs map[utils.Hash]*WalletTx
+	// spends maps the outpoints spent by wallet transactions to them.
+	spends     map[core.OutPoint][]utils.Hash
+	nextOrder  uint64
+	bestHash   utils.Hash
+	bestHeight int
+}
+
+// accountPath returns the BIP44 path of the account of the wallet.
+func accountPath(params *msg.BitcoinParams) []uint32 {
+	return []uint32{
+		44 + hdkeychain.HardenedKeyStart,
+		params.HDCoinType + hdkeychain.HardenedKeyStart,
+		hdkeychain.HardenedKeyStart,
+	}
+}
+
+// Open opens the wallet stored at path. A new wallet is created from the
+// mnemonic of -walletmnemonic, or from a new random one.
+func Open(path string, params *msg.BitcoinParams) (*Wallet, error) {
+	db, err := openWalletDB(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &Wallet{
+		db:          db,
+		params:      params,
+		keypoolSize: uint32(utils.GetArg("-keypool", DefaultKeypoolSize)),
+		keys:        make(map[string]*walletKey),
+		labels:      make(map[string]string),
+		watched:     make(map[string]*watchedScript),
+		txs:         make(map[utils.Hash]*WalletTx),
+		spends:      make(map[core.OutPoint][]utils.Hash),
+		bestHeight:  -1,
+	}
+	if w.keypoolSize < 1 {
+		w.keypoolSize = 1
+	}
+	if err := w.load(); err != nil {
+		db.close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Wallet) load() error {
+	mnemonic := utils.GetArgString("-walletmnemonic", "")
+	if !w.db.dbw.Exists([]byte{dbHDChain}) {
+		c, err := newHDChain(w.params, mnemonic)
+		if err != nil {
+			return errors.Wrap(err, "invalid -walletmnemonic")
+		}
+		if err := w.db.writeHDChain(c); err != nil {
+			return err
+		}
+		w.restored = mnemonic != ""
+		logs.Info("wallet: created a new HD wallet")
+	} else if mnemonic != "" {
+		logs.Warn("wallet: -walletmnemonic is ignored, the wallet already exists")
+	}
+	c, err := w.db.readHDChain()
+	if err != nil {
+		return errors.Wrap(err, "failed to read the HD chain")
+	}
+	w.hdChain = c
+	if w.db.dbw.Exists([]byte{dbMasterKey}) {
+		if w.masterKey, err = w.db.readMasterKey(); err != nil {
+			return errors.Wrap(err, "failed to read the master key")
+		}
+	}
+	if err := w.deriveChainKeys(); err != nil {
+		return err
+	}
+
+	err = w.db.forEach(dbLabel, func(id []byte, value []byte) error {
+		w.labels[string(id)] = string(value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.loadWatched(); err != nil {
+		return err
+	}
+	err = w.db.forEach(dbTx, func(id []byte, value []byte) error {
+		wtx, err := deserializeWalletTx(bytes.NewReader(value))
+		if err != nil {
+			return errors.Wrap(err, "failed to read a wallet transaction")
+		}
+		w.insertTx(wtx)
+		if wtx.Order >= w.nextOrder {
+			w.nextOrder = wtx.Order + 1
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if w.db.dbw.Exists([]byte{dbBestBlock}) {
+		if w.bestHash, w.bestHeight, err = w.db.readBestBlock(); err != nil {
+			return err
+		}
+	}
+	logs.Info(fmt.Sprintf("wallet: loaded %d transactions, best block height %d", len(w.txs), w.bestHeight))
+	return nil
+}
+
+// Restored returns whether the wallet was just created from the mnemonic of
+// -walletmnemonic, so that the chain should be rescanned for its coins.
+func (w *Wallet) Restored() bool {
+	return w.restored
+}
+
+// Close closes the wallet database.
+func (w *Wallet) Close() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.db.close()
+}
+
+// topUpKeypool derives the keys of each chain up to keypoolSize keys past
+// the next one to hand out.
+func (w *Wallet) topUpKeypool() error {
+	for chain := range w.chainKeys {
+		for end := w.hdChain.nextIndex[chain] + w.keypoolSize; w.derived[chain] < end; w.derived[chain]++ {
+			child, err := w.chainKeys[chain].Child(w.derived[chain])
+			if err == hdkeychain.ErrInvalidChild {
+				// The index is skipped, as BIP32 requires.
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			pubKey, err := child.ECPubKey()
+			if err != nil {
+				return err
+			}
+			pubKeyBytes := pubKey.SerializeCompressed()
+			w.keys[string(utils.Hash160(pubKeyBytes))] = &walletKey{
+				pubKey: pubKeyBytes,
+				chain:  chain,
+				index:  w.derived[chain],
+			}
+		}
+	}
+	return nil
+}
+
+// nextKey hands out the next key of chain and returns its key id.
+func (w *Wallet) nextKey(chain int) ([]byte, error) {
+	for {
+		index := w.hdChain.nextIndex[chain]
+		w.hdChain.nextIndex[chain]++
+		if err := w.topUpKeypool(); err != nil {
+			return nil, err
+		}
+		child, err := w.chainKeys[chain].Child(index)
+		if err == hdkeychain.ErrInvalidChild {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := w.db.writeHDChain(w.hdChain); err != nil {
+			return nil, err
+		}
+		pubKey, err := child.ECPubKey()
+		if err != nil {
+			return nil, err
+		}
+		return utils.Hash160(pubKey.SerializeCompressed()), nil
+	}
+}
+
+// GetNewAddress hands out a new receiving address, labelled with label.
+func (w *Wallet) GetNewAddress(label string) (string, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	keyID, err := w.nextKey(externalChain)
+	if err != nil {
+		return "", err
+	}
+	w.labels[string(keyID)] = label
+	if err := w.db.writeLabel(keyID, label); err != nil {
+		return "", err
+	}
+	return core.Hash160ToAddressStr(keyID, w.params.PubKeyHashAddressID)
+}
+
+// accountXPrv returns the serialized account private key, decrypted when
+// the wallet is encrypted.
+func (w *Wallet) accountXPrv() ([]byte, error) {
+	if w.masterKey == nil {
+		return w.hdChain.accountXPrv, nil
+	}
+	if w.unlockedKey == nil {
+		return nil, ErrWalletLocked
+	}
+	return decryptSecret(w.unlockedKey, w.hdChain.accountXPrv)
+}
+
+// getKey derives the private key of keyID. The caller holds the lock.
+func (w *Wallet) getKey(keyID []byte) (*crypto.PrivateKey, bool) {
+	key, ok := w.keys[string(keyID)]
+	if !ok {
+		return nil, false
+	}
+	if w.chainPrvKeys[key.chain] == nil {
+		accountXPrv, err := w.accountXPrv()
+		if err != nil {
+			return nil, false
+		}
+		account, err := hdkeychain.NewKeyFromStringForNet(string(accountXPrv), w.params)
+		if err != nil {
+			logs.Error(fmt.Sprintf("wallet: invalid account private key: %v", err))
+			return nil, false
+		}
+		for chain := range w.chainPrvKeys {
+			if w.chainPrvKeys[chain], err = account.Child(uint32(chain)); err != nil {
+				return nil, false
+			}
+		}
+	}
+	child, err := w.chainPrvKeys[key.chain].Child(key.index)
+	if err != nil {
+		return nil, false
+	}
+	privKey, err := child.ECPrivKey()
+	if err != nil {
+		return nil, false
+	}
+	return privKey, true
+}
+
+// keyStore gives the signer the keys of a wallet whose lock is held.
+type keyStore struct {
+	w *Wallet
+}
+
+func (ks keyStore) GetKey(keyID []byte) (*crypto.PrivateKey, bool) {
+	return ks.w.getKey(keyID)
+}
+
+func (ks keyStore) GetScript(scriptID []byte) (*core.Script, bool) {
+	return nil, false
+}
+
+// GetKey returns the private key of a wallet key, found by the hash160 of
+// its compressed public key.
+func (w *Wallet) GetKey(keyID []byte) (*crypto.PrivateKey, bool) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.getKey(keyID)
+}
+
+// GetScript returns no script, the wallet only has P2PKH and P2PK outputs.
+func (w *Wallet) GetScript(scriptID []byte) (*core.Script, bool) {
+	return nil, false
+}
+
+// extractKeyID returns the key id a P2PKH or P2PK script pays to.
+func extractKeyID(script *core.Script) ([]byte, bool) {
+	var scriptType int
+	var solutions container.Vector
+	if !core.Solver(script, &scriptType, &solutions) || solutions.Size() == 0 {
+		return nil, false
+	}
+	solution, err := solutions.At(0)
+	if err != nil {
+		return nil, false
+	}
+	data := solution.([]byte)
+	switch scriptType {
+	case core.TxPubKeyHash:
+		return data, true
+	case core.TxPubKey:
+		return utils.Hash160(data), true
+	}
+	return nil, false
+}
+
+// extractAddress returns the address a P2PKH or P2SH script pays to, or ""
+// for other scripts.
+func (w *Wallet) extractAddress(script *core.Script) string {
+	var scriptType int
+	var solutions container.Vector
+	if !core.Solver(script, &scriptType, &solutions) || solutions.Size() == 0 {
+		return ""
+	}
+	solution, _ := solutions.At(0)
+	version := w.params.PubKeyHashAddressID
+	switch scriptType {
+	case core.TxPubKeyHash:
+	case core.TxScriptHash:
+		version = w.params.ScriptHashAddressID
+	case core.TxPubKey:
+		solution = utils.Hash160(solution.([]byte))
+	default:
+		return ""
+	}
+	address, err := core.Hash160ToAddressStr(solution.([]byte), version)
+	if err != nil {
+		return ""
+	}
+	return address
+}
+
+// keyOf returns the wallet key script pays to.
+func (w *Wallet) keyOf(script *core.Script) (*walletKey, bool) {
+	keyID, ok := extractKeyID(script)
+	if !ok {
+		return nil, false
+	}
+	key, ok := w.keys[string(keyID)]
+	return key, ok
+}
+
+// IsMineFilter selects outputs of the wallet by whether it can spend them
+// or only watches them.
+type IsMineFilter int
+
+const (
+	IsMineSpendable IsMineFilter = 1 << iota
+	IsMineWatchOnly
+	IsMineAll = IsMineSpendable | IsMineWatchOnly
+)
+
+// isMine returns whether out pays to a key of the wallet, or to a watched
+// script, or 0.
+func (w *Wallet) isMine(out *core.TxOut) IsMineFilter {
+	if _, ok := w.keyOf(out.Script); ok {
+		return IsMineSpendable
+	}
+	if _, ok := w.watched[string(out.Script.GetScriptByte())]; ok {
+		return IsMineWatchOnly
+	}
+	return 0
+}
+
+// labelOf returns the label of the address script pays to.
+func (w *Wallet) labelOf(script *core.Script) string {
+	if keyID, ok := extractKeyID(script); ok {
+		if label, ok := w.labels[string(keyID)]; ok {
+			return label
+		}
+	}
+	if watched, ok := w.watched[string(script.GetScriptByte())]; ok {
+		return watched.label
+	}
+	return ""
+}
+
+// isChange returns whether out pays to a key of the internal chain.
+func (w *Wallet) isChange(out *core.TxOut) bool {
+	key, ok := w.keyOf(out.Script)
+	return ok && key.chain == internalChain
+}
+
+// prevOut returns the wallet output selected by filter spent by txIn, or
+// nil.
+func (w *Wallet) prevOut(txIn *core.TxIn, filter IsMineFilter) *core.TxOut {
+	prev, ok := w.txs[txIn.PreviousOutPoint.Hash]
+	if !ok || int(txIn.PreviousOutPoint.Index) >= len(prev.Tx.Outs) {
+		return nil
+	}
+	out := prev.Tx.Outs[txIn.PreviousOutPoint.Index]
+	if w.isMine(out)&filter == 0 {
+		return nil
+	}
+	return out
+}
+
+// debit returns the value of the wallet outputs selected by filter tx
+// spends.
+func (w *Wallet) debit(tx *core.Tx, filter IsMineFilter) int64 {
+	var debit int64
+	for _, txIn := range tx.Ins {
+		if out := w.prevOut(txIn, filter); out != nil {
+			debit += out.Value
+		}
+	}
+	return debit
+}
+
+// isFromMe returns whether all the inputs of tx spend wallet outputs
+// selected by filter.
+func (w *Wallet) isFromMe(tx *core.Tx, filter IsMineFilter) bool {
+	if tx.IsCoinBase() || len(tx.Ins) == 0 {
+		return false
+	}
+	for _, txIn := range tx.Ins {
+		if w.prevOut(txIn, filter) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Wallet) isRelevant(tx *core.Tx) bool {
+	for _, out := range tx.Outs {
+		if w.isMine(out) != 0 {
+			return true
+		}
+	}
+	if !tx.IsCoinBase() {
+		for _, txIn := range tx.Ins {
+			if w.prevOut(txIn, IsMineAll) != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// insertTx adds wtx to the in memory indexes.
+func (w *Wallet) insertTx(wtx *WalletTx) {
+	txid := wtx.Tx.TxHash()
+	w.txs[txid] = wtx
+	if wtx.Tx.IsCoinBase() {
+		return
+	}
+	for _, txIn := range wtx.Tx.Ins {
+		spenders := w.spends[*txIn.PreviousOutPoint]
+		known := false
+		for _, spender := range spenders {
+			if spender == txid {
+				known = true
+			}
+		}
+		if !known {
+			w.spends[*txIn.PreviousOutPoint] = append(spenders, txid)
+		}
+	}
+}
+
+// addTx adds tx to the wallet if it is relevant, or updates it, confirmed
+// in block at index, or unconfirmed when index is nil.
+func (w *Wallet) addTx(tx *core.Tx, block *core.Block, index *core.BlockIndex) (*WalletTx, error) {
+	txid := tx.TxHash()
+	wtx, ok := w.txs[txid]
+	if !ok {
+		if !w.isRelevant(tx) {
+			return nil, nil
+		}
+		wtx = &WalletTx{
+			Tx:             tx,
+			BlockHeight:    -1,
+			TimeReceived:   time.Now().Unix(),
+			Order:          w.nextOrder,
+			conflictHeight: -1,
+		}
+		w.nextOrder++
+		w.insertTx(wtx)
+		w.markKeysUsed(tx)
+		w.markXPubsUsed(tx)
+	} else if index == nil {
+		return wtx, nil
+	}
+	if index != nil {
+		wtx.BlockHash = *index.GetBlockHash()
+		wtx.BlockHeight = index.Height
+		wtx.BlockTime = block.BlockHeader.Time
+		wtx.conflictHeight = -1
+	}
+	return wtx, w.db.writeTx(wtx)
+}
+
+// markConflicted marks the unconfirmed transaction txid and the wallet
+// transactions spending it as conflicted by the block at height.
+func (w *Wallet) markConflicted(txid utils.Hash, height int) error {
+	wtx, ok := w.txs[txid]
+	if !ok || wtx.BlockHeight >= 0 || wtx.conflictHeight >= 0 {
+		return nil
+	}
+	wtx.conflictHeight = height
+	if err := w.db.writeTx(wtx); err != nil {
+		return err
+	}
+	for i := range wtx.Tx.Outs {
+		for _, spender := range w.spends[*core.NewOutPoint(txid, uint32(i))] {
+			if err := w.markConflicted(spender, height); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BlockConnected confirms the wallet transactions of block, and marks those
+// it double spends as conflicted.
+func (w *Wallet) BlockConnected(block *core.Block, index *core.BlockIndex) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for _, tx := range block.Txs {
+		if _, err := w.addTx(tx, block, index); err != nil {
+			logs.Error(fmt.Sprintf("wallet: failed to write a transaction: %v", err))
+		}
+	}
+	for _, tx := range block.Txs {
+		if tx.IsCoinBase() {
+			continue
+		}
+		txid := tx.TxHash()
+		for _, txIn := range tx.Ins {
+			for _, spender := range w.spends[*txIn.PreviousOutPoint] {
+				if spender == txid {
+					continue
+				}
+				if err := w.markConflicted(spender, index.Height); err != nil {
+					logs.Error(fmt.Sprintf("wallet: failed to write a transaction: %v", err))
+				}
+			}
+		}
+	}
+	w.setBestBlock(index.GetBlockHash(), index.Height)
+}
+
+// BlockDisconnected unconfirms the wallet transactions of block, and clears
+// the conflicts its transactions caused.
+func (w *Wallet) BlockDisconnected(block *core.Block, index *core.BlockIndex) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for _, wtx := range w.txs {
+		changed := false
+		if wtx.BlockHeight >= index.Height {
+			wtx.BlockHash = utils.Hash{}
+			wtx.BlockHeight = -1
+			wtx.BlockTime = 0
+			changed = true
+		}
+		if wtx.conflictHeight >= index.Height {
+			wtx.conflictHeight = -1
+			changed = true
+		}
+		if changed {
+			if err := w.db.writeTx(wtx); err != nil {
+				logs.Error(fmt.Sprintf("wallet: failed to write a transaction: %v", err))
+			}
+		}
+	}
+	if index.Prev != nil {
+		w.setBestBlock(index.Prev.GetBlockHash(), index.Prev.Height)
+	} else {
+		w.setBestBlock(&utils.Hash{}, -1)
+	}
+}
+
+// TransactionAddedToMempool adds tx to the wallet if it is relevant.
+func (w *Wallet) TransactionAddedToMempool(tx *core.Tx) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if _, err := w.addTx(tx, nil, nil); err != nil {
+		logs.Error(fmt.Sprintf("wallet: failed to write a transaction: %v", err))
+	}
+}
+
+func (w *Wallet) setBestBlock(hash *utils.Hash, height int) {
+	w.bestHash = *hash
+	w.bestHeight = height
+	if err := w.db.writeBestBlock(hash, height); err != nil {
+		logs.Error(fmt.Sprintf("wallet: failed to write the best block: %v", err))
+	}
+}
+
+// depth returns the number of confirmations of wtx, 0 when it is in the
+// mempool, or minus the number of confirmations of the conflicting
+// transaction when it is conflicted.
+func (w *Wallet) depth(wtx *WalletTx) int {
+	if wtx.BlockHeight >= 0 {
+		return w.bestHeight - wtx.BlockHeight + 1
+	}
+	if wtx.conflictHeight >= 0 {
+		return -(w.bestHeight - wtx.conflictHeight + 1)
+	}
+	return 0
+}
+
+// blocksToMaturity returns the number of blocks before the outputs of a
+// coinbase can be spent.
+func (w *Wallet) blocksToMaturity(wtx *WalletTx) int {
+	if !wtx.Tx.IsCoinBase() {
+		return 0
+	}
+	blocks := consensus.CoinbaseMaturity + 1 - w.depth(wtx)
+	if blocks < 0 {
+		return 0
+	}
+	return blocks
+}
+
+// isTrusted returns whether the outputs of wtx can be spent: it is
+// confirmed, or it is an unconfirmed transaction of ours spending trusted
+// outputs.
+func (w *Wallet) isTrusted(wtx *WalletTx) bool {
+	depth := w.depth(wtx)
+	if depth >= 1 {
+		return true
+	}
+	if depth < 0 || !w.isFromMe(wtx.Tx, IsMineSpendable) {
+		return false
+	}
+	for _, txIn := range wtx.Tx.Ins {
+		if !w.isTrusted(w.txs[txIn.PreviousOutPoint.Hash]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSpent returns whether a wallet transaction which is not conflicted
+// spends outPoint.
+func (w *Wallet) isSpent(outPoint *core.OutPoint) bool {
+	for _, spender := range w.spends[*outPoint] {
+		if wtx, ok := w.txs[spender]; ok && w.depth(wtx) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Output is an unspent output of the wallet.
+type Output struct {
+	OutPoint      core.OutPoint
+	Coin          *utxo.Coin
+	Address       string
+	Label         string
+	Confirmations int
+	// Spendable is set when the wallet has the key of the output, rather
+	// than watching it.
+	Spendable bool
+	// Safe is set when the output can be spent by the wallet: it is
+	// confirmed, or is change of a trusted transaction.
+	Safe bool
+	// Order is the order of the transaction of the output.
+	Order uint64
+}
+
+// unspent returns the unspent, mature outputs of the wallet.
+func (w *Wallet) unspent() []*Output {
+	var outputs []*Output
+	for txid, wtx := range w.txs {
+		depth := w.depth(wtx)
+		if depth < 0 || w.blocksToMaturity(wtx) > 0 {
+			continue
+		}
+		safe := w.isTrusted(wtx)
+		for i, out := range wtx.Tx.Outs {
+			mine := w.isMine(out)
+			outPoint := core.NewOutPoint(txid, uint32(i))
+			if mine == 0 || w.isSpent(outPoint) {
+				continue
+			}
+			height := uint32(mempool.MEMPOOL_HEIGHT)
+			if wtx.BlockHeight >= 0 {
+				height = uint32(wtx.BlockHeight)
+			}
+			outputs = append(outputs, &Output{
+				OutPoint:      *outPoint,
+				Coin:          utxo.NewCoin(out, height, wtx.Tx.IsCoinBase()),
+				Address:       w.extractAddress(out.Script),
+				Label:         w.labelOf(out.Script),
+				Confirmations: depth,
+				Spendable:     mine == IsMineSpendable,
+				Safe:          safe,
+				Order:         wtx.Order,
+			})
+		}
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		if outputs[i].Order != outputs[j].Order {
+			return outputs[i].Order < outputs[j].Order
+		}
+		return outputs[i].OutPoint.Index < outputs[j].OutPoint.Index
+	})
+	return outputs
+}
+
+// ListUnspent returns the unspent outputs having between minConf and
+// maxConf confirmations.
+func (w *Wallet) ListUnspent(minConf, maxConf int) []*Output {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	var outputs []*Output
+	for _, output := range w.unspent() {
+		if output.Confirmations >= minConf && output.Confirmations <= maxConf {
+			outputs = append(outputs, output)
+		}
+	}
+	return outputs
+}
+
+// GetBalance returns the value of the safe outputs selected by filter
+// having at least minConf confirmations. Unconfirmed change of the wallet is
+// counted when minConf is 0.
+func (w *Wallet) GetBalance(minConf int, filter IsMineFilter) utils.Amount {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	var balance int64
+	for _, output := range w.unspent() {
+		mine := IsMineWatchOnly
+		if output.Spendable {
+			mine = IsMineSpendable
+		}
+		if mine&filter != 0 && output.Safe && output.Confirmations >= minConf {
+			balance += output.Coin.TxOut.Value
+		}
+	}
+	return utils.Amount(balance)
+}
+
+// GetUnconfirmedBalance returns the value of the unconfirmed outputs which
+// the wallet does not trust yet.
+func (w *Wallet) GetUnconfirmedBalance() utils.Amount {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	var balance int64
+	for _, output := range w.unspent() {
+		if output.Spendable && !output.Safe && output.Confirmations == 0 {
+			balance += output.Coin.TxOut.Value
+		}
+	}
+	return utils.Amount(balance)
+}
+
+// GetImmatureBalance returns the value of the coinbase outputs which are not
+// mature yet.
+func (w *Wallet) GetImmatureBalance() utils.Amount {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	var balance int64
+	for _, wtx := range w.txs {
+		if w.depth(wtx) <= 0 || w.blocksToMaturity(wtx) == 0 {
+			continue
+		}
+		for _, out := range wtx.Tx.Outs {
+			if w.isMine(out) == IsMineSpendable {
+				balance += out.Value
+			}
+		}
+	}
+	return utils.Amount(balance)
+}
+
+// Transaction categories of the entries of ListTransactions.
+const (
+	CategorySend     = "send"
+	CategoryReceive  = "receive"
+	CategoryGenerate = "generate"
+	CategoryImmature = "immature"
+	CategoryOrphan   = "orphan"
+)
+
+// TransactionEntry is a payment made or received by a wallet transaction.
+type TransactionEntry struct {
+	Address  string
+	Label    string
+	Category string
+	// Amount is negative for payments made.
+	Amount utils.Amount
+	Vout   uint32
+	// Fee is the fee of the transaction, negative, set for payments made
+	// by a transaction all of whose inputs are ours.
+	Fee           *utils.Amount
+	Confirmations int
+	Generated     bool
+	// WatchOnly is set when the payment involves watched outputs.
+	WatchOnly    bool
+	BlockHash    utils.Hash
+	BlockHeight  int
+	BlockTime    uint32
+	TxID         utils.Hash
+	TimeReceived int64
+	order        uint64
+}
+
+// entries returns the payments of wtx involving the outputs selected by
+// filter.
+func (w *Wallet) entries(txid utils.Hash, wtx *WalletTx, filter IsMineFilter) []*TransactionEntry {
+	newEntry := func(category string, i int, out *core.TxOut) *TransactionEntry {
+		entry := &TransactionEntry{
+			Address:       w.extractAddress(out.Script),
+			Category:      category,
+			Amount:        utils.Amount(out.Value),
+			Vout:          uint32(i),
+			Confirmations: w.depth(wtx),
+			Generated:     wtx.Tx.IsCoinBase(),
+			BlockHash:     wtx.BlockHash,
+			BlockHeight:   wtx.BlockHeight,
+			BlockTime:     wtx.BlockTime,
+			TxID:          txid,
+			TimeReceived:  wtx.TimeReceived,
+			order:         wtx.Order,
+		}
+		entry.Label = w.labelOf(out.Script)
+		return entry
+	}
+
+	var entries []*TransactionEntry
+	debit := w.debit(wtx.Tx, filter)
+	watchOnlyDebit := w.debit(wtx.Tx, IsMineWatchOnly&filter) > 0
+	if debit > 0 {
+		var fee *utils.Amount
+		if w.isFromMe(wtx.Tx, filter) {
+			paid := -utils.Amount(debit - wtx.Tx.GetValueOut())
+			fee = &paid
+		}
+		for i, out := range wtx.Tx.Outs {
+			if w.isChange(out) {
+				continue
+			}
+			entry := newEntry(CategorySend, i, out)
+			entry.Amount = -entry.Amount
+			entry.Fee = fee
+			entry.WatchOnly = watchOnlyDebit
+			entries = append(entries, entry)
+		}
+	}
+	for i, out := range wtx.Tx.Outs {
+		mine := w.isMine(out) & filter
+		if mine == 0 || debit > 0 && w.isChange(out) {
+			continue
+		}
+		category := CategoryReceive
+		if wtx.Tx.IsCoinBase() {
+			switch {
+			case w.depth(wtx) < 1:
+				category = CategoryOrphan
+			case w.blocksToMaturity(wtx) > 0:
+				category = CategoryImmature
+			default:
+				category = CategoryGenerate
+			}
+		}
+		entry := newEntry(category, i, out)
+		entry.WatchOnly = mine == IsMineWatchOnly
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// ListTransactions returns the count most recent payments involving the
+// outputs selected by filter, after skipping the skip most recent ones,
+// oldest first.
+func (w *Wallet) ListTransactions(count, skip int, filter IsMineFilter) []*TransactionEntry {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	var entries []*TransactionEntry
+	for txid, wtx := range w.txs {
+		entries = append(entries, w.entries(txid, wtx, filter)...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].order != entries[j].order {
+			return entries[i].order < entries[j].order
+		}
+		return entries[i].Vout < entries[j].Vout
+	})
+	end := len(entries) - skip
+	if end < 0 {
+		end = 0
+	}
+	start := end - count
+	if start < 0 {
+		start = 0
+	}
+	return entries[start:end]
+}
+
+// TxCount returns the number of transactions of the wallet.
+func (w *Wallet) TxCount() int {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	return len(w.txs)
+}
+
+// BestBlock returns the last block the wallet processed.
+func (w *Wallet) BestBlock() (utils.Hash, int) {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	return w.bestHash, w.bestHeight
+}
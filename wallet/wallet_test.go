@@ -0,0 +1,210 @@
+package wallet
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/btcboost/copernicus/blockchain"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/mempool"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/btcboost/copernicus/utxo"
+)
+
+func openTestWallet(t *testing.T) (*Wallet, string) {
+	path, err := ioutil.TempDir("", "wallettest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path, msg.ActiveNetParams)
+	if err != nil {
+		os.RemoveAll(path)
+		t.Fatal(err)
+	}
+	return w, path
+}
+
+// newAddressScript hands out an address of w and returns its script.
+func newAddressScript(t *testing.T, w *Wallet, label string) *core.Script {
+	address, err := w.GetNewAddress(label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := core.AddressFromString(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return core.PayToPubKeyHash(addr.Hash160())
+}
+
+func newTestBlock(height int, txs ...*core.Tx) (*core.Block, *core.BlockIndex) {
+	block := &core.Block{Txs: txs}
+	block.BlockHeader.Time = uint32(1500000000 + height)
+	index := &core.BlockIndex{Height: height}
+	index.BlockHash = utils.Hash{byte(height), 1}
+	return block, index
+}
+
+func TestWalletTracksTransactions(t *testing.T) {
+	utils.ParseParameters(1, []string{"-keypool=5"})
+	defer utils.ParseParameters(0, nil)
+	w, path := openTestWallet(t)
+	defer os.RemoveAll(path)
+
+	script := newAddressScript(t, w, "savings")
+	other := core.PayToPubKeyHash(bytes.Repeat([]byte{7}, 20))
+	funding := core.NewTx()
+	funding.AddTxIn(core.NewTxIn(core.NewOutPoint(utils.Hash{9}, 0), []byte{0x51}))
+	funding.AddTxOut(core.NewTxOut(utils.COIN, script.GetScriptByte()))
+	funding.AddTxOut(core.NewTxOut(utils.COIN, other.GetScriptByte()))
+	block, index := newTestBlock(1, funding)
+	w.BlockConnected(block, index)
+
+	if balance := w.GetBalance(1, IsMineSpendable); int64(balance) != utils.COIN {
+		t.Fatalf("expected a balance of %d, got %d", utils.COIN, balance)
+	}
+	unspent := w.ListUnspent(1, 9999999)
+	if len(unspent) != 1 || unspent[0].Label != "savings" || unspent[0].Confirmations != 1 {
+		t.Fatalf("unexpected unspent outputs %+v", unspent)
+	}
+
+	tx, fee, err := w.CreateTransaction([]Recipient{{Script: other, Amount: utils.Amount(utils.COIN * 3 / 10)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feeRate := FeeRate()
+	if int64(fee) < feeRate.GetFee(tx.SerializeSize()) || len(tx.Outs) != 2 || len(tx.Ins) != 1 {
+		t.Fatalf("unexpected transaction, fee %d", fee)
+	}
+	if tx.Ins[0].Script.Size() == 0 {
+		t.Errorf("the input should be signed")
+	}
+	change := tx.Outs[1]
+	if !w.isChange(change) || change.Value != utils.COIN*7/10-int64(fee) {
+		t.Errorf("unexpected change output %v", change)
+	}
+
+	w.TransactionAddedToMempool(tx)
+	if balance := w.GetBalance(0, IsMineSpendable); int64(balance) != change.Value {
+		t.Errorf("the unconfirmed change should be trusted, got a balance of %d", balance)
+	}
+	if balance := w.GetBalance(1, IsMineSpendable); balance != 0 {
+		t.Errorf("the spent output should not be counted, got a balance of %d", balance)
+	}
+	entries := w.ListTransactions(10, 0, IsMineSpendable)
+	if len(entries) != 2 || entries[0].Category != CategoryReceive || entries[1].Category != CategorySend ||
+		int64(entries[1].Amount) != -utils.COIN*3/10 || entries[1].Fee == nil || *entries[1].Fee != -fee {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	w.BlockDisconnected(block, index)
+	if balance := w.GetBalance(0, IsMineSpendable); balance != 0 {
+		t.Errorf("change of an unconfirmed payment should not be trusted, got a balance of %d", balance)
+	}
+	if balance := w.GetUnconfirmedBalance(); int64(balance) != change.Value {
+		t.Errorf("expected an unconfirmed balance of %d, got %d", change.Value, balance)
+	}
+
+	// The transactions and the keys handed out are kept.
+	w.Close()
+	w, err = Open(path, msg.ActiveNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if len(w.txs) != 2 || w.ListTransactions(1, 0, IsMineSpendable)[0].TxID != tx.TxHash() {
+		t.Errorf("the transactions should be reloaded")
+	}
+	if next := newAddressScript(t, w, ""); bytes.Equal(next.GetScriptByte(), script.GetScriptByte()) {
+		t.Errorf("an address should not be handed out twice")
+	}
+}
+
+func TestCreateTransactionErrors(t *testing.T) {
+	w, path := openTestWallet(t)
+	defer os.RemoveAll(path)
+	defer w.Close()
+
+	other := core.PayToPubKeyHash(bytes.Repeat([]byte{7}, 20))
+	if _, _, err := w.CreateTransaction(nil); err != ErrNoRecipients {
+		t.Errorf("expected %v, got %v", ErrNoRecipients, err)
+	}
+	if _, _, err := w.CreateTransaction([]Recipient{{Script: other, Amount: utils.Amount(utils.COIN)}}); err != ErrInsufficientFunds {
+		t.Errorf("expected %v, got %v", ErrInsufficientFunds, err)
+	}
+}
+
+func TestCommitTransaction(t *testing.T) {
+	// A regtest chain, whose upgrades are all active, with a confirmation
+	// window for the version bits.
+	params := msg.RegressionNetParams
+	params.MinerConfirmationWindow = 144
+	params.RuleChangeActivationThreshold = 108
+	path, err := ioutil.TempDir("", "wallettest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+	w, err := Open(path, &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	chainState := &blockchain.GChainState
+	savedChain, savedData := chainState.ChainActive, chainState.MapBlockIndex.Data
+	savedCoinsTip, savedMemPool := blockchain.GCoinsTip, blockchain.GMemPool
+	defer func() {
+		chainState.ChainActive, chainState.MapBlockIndex.Data = savedChain, savedData
+		blockchain.GCoinsTip, blockchain.GMemPool = savedCoinsTip, savedMemPool
+	}()
+
+	// The funding block is the tip of the chain, and its outputs are coins
+	// of the tip.
+	script := newAddressScript(t, w, "")
+	funding := newFundingTx(9, utils.COIN, script)
+	block, index := newTestBlock(2, funding)
+	index.Header.Time = block.BlockHeader.Time
+	for prev := index; prev.Height > 0; prev = prev.Prev {
+		prev.Prev = &core.BlockIndex{Height: prev.Height - 1}
+		prev.Prev.Header.Time = prev.Header.Time - 600
+	}
+	w.BlockConnected(block, index)
+	chainState.ChainActive = core.Chain{}
+	chainState.ChainActive.SetTip(index)
+	chainState.MapBlockIndex.Data = map[utils.Hash]*core.BlockIndex{index.BlockHash: index}
+	blockchain.GCoinsTip = &utxo.CoinsViewCache{Base: utxo.CoinsViewDummy{}, CacheCoins: make(utxo.CacheCoins)}
+	blockchain.GCoinsTip.SetBestBlock(index.BlockHash)
+	utxo.AddCoins(*blockchain.GCoinsTip, *funding, index.Height)
+	blockchain.GMemPool = mempool.NewTxMempool()
+
+	other := core.PayToPubKeyHash(bytes.Repeat([]byte{7}, 20))
+	tx, _, err := w.CreateTransaction([]Recipient{{Script: other, Amount: utils.Amount(utils.COIN / 2)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CommitTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if !blockchain.GMemPool.Exists(tx.TxHash()) {
+		t.Errorf("the transaction should be in the mempool")
+	}
+	if _, ok := w.txs[tx.TxHash()]; !ok {
+		t.Errorf("the transaction should be in the wallet")
+	}
+
+	// Its change can be spent before it confirms.
+	spend, _, err := w.CreateTransaction([]Recipient{{Script: other, Amount: utils.Amount(utils.COIN / 4)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CommitTransaction(spend); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CommitTransaction(tx); err == nil {
+		t.Errorf("a transaction should not enter the mempool twice")
+	}
+}
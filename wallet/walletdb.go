@@ -0,0 +1,243 @@
+package wallet
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/database"
+	"github.com/btcboost/copernicus/net/protocol"
+	"github.com/btcboost/copernicus/utils"
+)
+
+// Keys of the wallet database. Records of a kind share a one byte prefix,
+// followed by their id.
+const (
+	dbHDChain   = 'h' // the HD account keys and the next key indexes
+	dbTx        = 't' // txid -> wallet transaction
+	dbBestBlock = 'B' // the last block the wallet processed
+	dbLabel     = 'l' // key id -> label of a handed out address
+	dbMasterKey = 'm' // the encrypted master key, if the wallet is encrypted
+	dbWatched   = 'w' // script -> label of a watched script
+	dbXPub      = 'x' // xpub -> gap limit, birthday and used indexes
+)
+
+// maxRecordBytes bounds the byte fields read back from the database.
+const maxRecordBytes = 1 << 24
+
+// walletDB persists the wallet in its own database.
+type walletDB struct {
+	dbw *database.DBWrapper
+}
+
+func openWalletDB(path string) (*walletDB, error) {
+	dbw, err := database.NewDBWrapper(&database.DBOption{
+		FilePath:  path,
+		CacheSize: 1 << 20,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &walletDB{dbw: dbw}, nil
+}
+
+func (db *walletDB) close() {
+	db.dbw.Close()
+}
+
+func recordKey(prefix byte, id []byte) []byte {
+	return append([]byte{prefix}, id...)
+}
+
+// forEach calls fn for each record of a kind.
+func (db *walletDB) forEach(prefix byte, fn func(id []byte, value []byte) error) error {
+	it := db.dbw.Iterator()
+	defer it.Close()
+	for it.Seek([]byte{prefix}); it.Valid(); it.Next() {
+		key := it.GetKey()
+		if len(key) == 0 || key[0] != prefix {
+			break
+		}
+		if err := fn(key[1:], it.GetVal()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hdChain holds the BIP44 account the wallet keys derive from, and for each
+// of the external and internal (change) chains the index of the next key to
+// hand out. The account private key, the seed and the mnemonic it derives
+// from are encrypted when the wallet is.
+type hdChain struct {
+	accountXPub string
+	accountXPrv []byte
+	nextIndex   [2]uint32
+	seed        []byte
+	mnemonic    []byte
+}
+
+func (c *hdChain) serialize(w io.Writer) error {
+	if err := utils.WriteVarString(w, c.accountXPub); err != nil {
+		return err
+	}
+	if err := utils.WriteVarBytes(w, c.accountXPrv); err != nil {
+		return err
+	}
+	if err := protocol.WriteElements(w, c.nextIndex[0], c.nextIndex[1]); err != nil {
+		return err
+	}
+	if err := utils.WriteVarBytes(w, c.seed); err != nil {
+		return err
+	}
+	return utils.WriteVarBytes(w, c.mnemonic)
+}
+
+func (c *hdChain) deserialize(r io.Reader) (err error) {
+	if c.accountXPub, err = utils.ReadVarString(r); err != nil {
+		return err
+	}
+	if c.accountXPrv, err = utils.ReadVarBytes(r, maxRecordBytes, "accountXPrv"); err != nil {
+		return err
+	}
+	if err := protocol.ReadElements(r, &c.nextIndex[0], &c.nextIndex[1]); err != nil {
+		return err
+	}
+	if c.seed, err = utils.ReadVarBytes(r, maxRecordBytes, "seed"); err != nil {
+		return err
+	}
+	c.mnemonic, err = utils.ReadVarBytes(r, maxRecordBytes, "mnemonic")
+	return err
+}
+
+func (db *walletDB) writeHDChain(c *hdChain) error {
+	var buf bytes.Buffer
+	if err := c.serialize(&buf); err != nil {
+		return err
+	}
+	return db.dbw.Write([]byte{dbHDChain}, buf.Bytes(), true)
+}
+
+func (db *walletDB) readHDChain() (*hdChain, error) {
+	value, err := db.dbw.Read([]byte{dbHDChain})
+	if err != nil {
+		return nil, err
+	}
+	c := new(hdChain)
+	if err := c.deserialize(bytes.NewReader(value)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (db *walletDB) readMasterKey() (*masterKey, error) {
+	value, err := db.dbw.Read([]byte{dbMasterKey})
+	if err != nil {
+		return nil, err
+	}
+	m := new(masterKey)
+	if err := m.deserialize(bytes.NewReader(value)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeEncryption atomically writes the master key and the HD chain whose
+// account private key it encrypts.
+func (db *walletDB) writeEncryption(m *masterKey, c *hdChain) error {
+	var keyBuf, chainBuf bytes.Buffer
+	if err := m.serialize(&keyBuf); err != nil {
+		return err
+	}
+	if err := c.serialize(&chainBuf); err != nil {
+		return err
+	}
+	bw := database.NewBatchWrapper(db.dbw)
+	bw.Write([]byte{dbMasterKey}, keyBuf.Bytes())
+	bw.Write([]byte{dbHDChain}, chainBuf.Bytes())
+	return db.dbw.WriteBatch(bw, true)
+}
+
+func (db *walletDB) writeLabel(keyID []byte, label string) error {
+	return db.dbw.Write(recordKey(dbLabel, keyID), []byte(label), false)
+}
+
+func (db *walletDB) writeWatched(script []byte, label string) error {
+	return db.dbw.Write(recordKey(dbWatched, script), []byte(label), false)
+}
+
+func (x *watchedXPub) serialize(w io.Writer) error {
+	if err := protocol.WriteElements(w, x.gapLimit, uint32(x.birthday), x.used[0], x.used[1]); err != nil {
+		return err
+	}
+	return utils.WriteVarString(w, x.label)
+}
+
+func (x *watchedXPub) deserialize(r io.Reader) (err error) {
+	var birthday uint32
+	if err := protocol.ReadElements(r, &x.gapLimit, &birthday, &x.used[0], &x.used[1]); err != nil {
+		return err
+	}
+	x.birthday = int(int32(birthday))
+	x.label, err = utils.ReadVarString(r)
+	return err
+}
+
+func (db *walletDB) writeXPub(x *watchedXPub) error {
+	var buf bytes.Buffer
+	if err := x.serialize(&buf); err != nil {
+		return err
+	}
+	return db.dbw.Write(recordKey(dbXPub, []byte(x.key.String())), buf.Bytes(), false)
+}
+
+func (db *walletDB) writeTx(wtx *WalletTx) error {
+	var buf bytes.Buffer
+	if err := wtx.serialize(&buf); err != nil {
+		return err
+	}
+	txid := wtx.Tx.TxHash()
+	return db.dbw.Write(recordKey(dbTx, txid[:]), buf.Bytes(), false)
+}
+
+func (db *walletDB) writeBestBlock(hash *utils.Hash, height int) error {
+	var buf bytes.Buffer
+	if err := protocol.WriteElements(&buf, hash, uint32(height)); err != nil {
+		return err
+	}
+	return db.dbw.Write([]byte{dbBestBlock}, buf.Bytes(), true)
+}
+
+func (db *walletDB) readBestBlock() (utils.Hash, int, error) {
+	var hash utils.Hash
+	var height uint32
+	value, err := db.dbw.Read([]byte{dbBestBlock})
+	if err != nil {
+		return hash, -1, err
+	}
+	err = protocol.ReadElements(bytes.NewReader(value), &hash, &height)
+	return hash, int(int32(height)), err
+}
+
+// serialize writes the transaction followed by its wallet metadata.
+func (wtx *WalletTx) serialize(w io.Writer) error {
+	if err := wtx.Tx.Serialize(w); err != nil {
+		return err
+	}
+	return protocol.WriteElements(w, &wtx.BlockHash, uint32(wtx.BlockHeight), wtx.BlockTime,
+		wtx.TimeReceived, wtx.Order, uint32(wtx.conflictHeight))
+}
+
+func deserializeWalletTx(r io.Reader) (*WalletTx, error) {
+	tx, err := core.DeserializeTx(r)
+	if err != nil {
+		return nil, err
+	}
+	wtx := &WalletTx{Tx: tx}
+	var blockHeight, conflictHeight uint32
+	err = protocol.ReadElements(r, &wtx.BlockHash, &blockHeight, &wtx.BlockTime,
+		&wtx.TimeReceived, &wtx.Order, &conflictHeight)
+	wtx.BlockHeight = int(int32(blockHeight))
+	wtx.conflictHeight = int(int32(conflictHeight))
+	return wtx, err
+}
@@ -0,0 +1,289 @@
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/btcboost/copernicus/blockchain"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/hdkeychain"
+	"github.com/btcboost/copernicus/utils"
+	"github.com/pkg/errors"
+)
+
+// DefaultGapLimit is the number of unused addresses watched past the last
+// used one of each chain of an imported xpub.
+const DefaultGapLimit = 20
+
+var (
+	ErrAlreadyMine      = errors.New("The wallet already contains the private key for this address or script")
+	ErrRescanInProgress = errors.New("Wallet is currently rescanning. Abort existing rescan or wait.")
+	ErrRescanAborted    = errors.New("Rescan aborted by user.")
+)
+
+// watchedScript is a script the wallet watches. Those of an xpub are
+// located by their chain and index.
+type watchedScript struct {
+	label string
+	xpub  *watchedXPub
+	chain int
+	index uint32
+}
+
+// watchedXPub is an imported extended public key, whose external and
+// internal chains are watched up to gapLimit addresses past the last used
+// one. Blocks before birthday have no transaction of it.
+type watchedXPub struct {
+	key       *hdkeychain.ExtendedKey
+	chainKeys [2]*hdkeychain.ExtendedKey
+	label     string
+	gapLimit  uint32
+	birthday  int
+	// used is, for each chain, the index past the last used address, and
+	// derived the number of addresses watched.
+	used    [2]uint32
+	derived [2]uint32
+}
+
+// watch adds script to the watched scripts.
+func (w *Wallet) watch(script *core.Script, watched *watchedScript) {
+	w.watched[string(script.GetScriptByte())] = watched
+}
+
+// loadWatched reads the watched scripts and xpubs.
+func (w *Wallet) loadWatched() error {
+	err := w.db.forEach(dbWatched, func(id []byte, value []byte) error {
+		w.watch(core.NewScriptRaw(id), &watchedScript{label: string(value)})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return w.db.forEach(dbXPub, func(id []byte, value []byte) error {
+		key, err := hdkeychain.NewKeyFromStringForNet(string(id), w.params)
+		if err != nil {
+			return errors.Wrap(err, "invalid watched xpub")
+		}
+		x := &watchedXPub{key: key}
+		if err := x.deserialize(bytes.NewReader(value)); err != nil {
+			return err
+		}
+		return w.addXPub(x)
+	})
+}
+
+// deriveXPub watches the addresses of x up to the gap limit.
+func (w *Wallet) deriveXPub(x *watchedXPub) error {
+	for chain := range x.chainKeys {
+		for end := x.used[chain] + x.gapLimit; x.derived[chain] < end; x.derived[chain]++ {
+			child, err := x.chainKeys[chain].Child(x.derived[chain])
+			if err == hdkeychain.ErrInvalidChild {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			pubKey, err := child.ECPubKey()
+			if err != nil {
+				return err
+			}
+			script := core.PayToPubKeyHash(utils.Hash160(pubKey.SerializeCompressed()))
+			w.watch(script, &watchedScript{label: x.label, xpub: x, chain: chain, index: x.derived[chain]})
+		}
+	}
+	return nil
+}
+
+func (w *Wallet) addXPub(x *watchedXPub) error {
+	for chain := range x.chainKeys {
+		var err error
+		if x.chainKeys[chain], err = x.key.Child(uint32(chain)); err != nil {
+			return err
+		}
+	}
+	w.xpubs = append(w.xpubs, x)
+	return w.deriveXPub(x)
+}
+
+// markXPubsUsed moves the gap of the xpubs tx pays to.
+func (w *Wallet) markXPubsUsed(tx *core.Tx) {
+	for _, out := range tx.Outs {
+		watched, ok := w.watched[string(out.Script.GetScriptByte())]
+		if !ok || watched.xpub == nil || watched.index < watched.xpub.used[watched.chain] {
+			continue
+		}
+		x := watched.xpub
+		x.used[watched.chain] = watched.index + 1
+		if err := w.deriveXPub(x); err != nil {
+			logs.Error(fmt.Sprintf("wallet: failed to derive the addresses of an xpub: %v", err))
+		}
+		if err := w.db.writeXPub(x); err != nil {
+			logs.Error(fmt.Sprintf("wallet: failed to write an xpub: %v", err))
+		}
+	}
+}
+
+// ImportScript watches script, labelled with label.
+func (w *Wallet) ImportScript(script *core.Script, label string) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.importScript(script, label)
+}
+
+func (w *Wallet) importScript(script *core.Script, label string) error {
+	if _, ok := w.keyOf(script); ok {
+		return ErrAlreadyMine
+	}
+	if err := w.db.writeWatched(script.GetScriptByte(), label); err != nil {
+		return err
+	}
+	w.watch(script, &watchedScript{label: label})
+	return nil
+}
+
+// ImportPubKey watches the P2PKH and P2PK scripts of the serialized public
+// key pubKey.
+func (w *Wallet) ImportPubKey(pubKey []byte, label string) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.importScript(core.PayToPubKeyHash(utils.Hash160(pubKey)), label); err != nil {
+		return err
+	}
+	return w.importScript(core.PayToPubKey(pubKey), label)
+}
+
+// ImportXPub watches the P2PKH addresses of the external and internal
+// chains of the extended public key, gapLimit past the last used one of
+// each. Blocks before birthday are assumed to have no transaction of it.
+func (w *Wallet) ImportXPub(key *hdkeychain.ExtendedKey, label string, gapLimit uint32, birthday int) error {
+	if key.IsPrivate() {
+		return errors.New("Expected an extended public key")
+	}
+	if gapLimit < 1 {
+		return errors.New("The gap limit must be positive")
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	for _, x := range w.xpubs {
+		if x.key.String() == key.String() {
+			return errors.New("The xpub is already imported")
+		}
+	}
+	x := &watchedXPub{key: key, label: label, gapLimit: gapLimit, birthday: birthday}
+	if err := w.db.writeXPub(x); err != nil {
+		return err
+	}
+	return w.addXPub(x)
+}
+
+// rescanState tracks the rescan running, if any.
+type rescanState struct {
+	running int32
+	abort   int32
+	// The progress is guarded by the lock of the wallet.
+	progress RescanProgress
+}
+
+// RescanProgress reports the progress of a rescan.
+type RescanProgress struct {
+	StartHeight int
+	StopHeight  int
+	// Height is the last height scanned.
+	Height  int
+	Started time.Time
+}
+
+// Fraction returns the share of the blocks scanned.
+func (p RescanProgress) Fraction() float64 {
+	if p.StopHeight <= p.StartHeight {
+		return 1
+	}
+	return float64(p.Height-p.StartHeight+1) / float64(p.StopHeight-p.StartHeight+1)
+}
+
+// Rescanning returns the progress of the running rescan, or false when
+// there is none.
+func (w *Wallet) Rescanning() (RescanProgress, bool) {
+	if atomic.LoadInt32(&w.rescan.running) == 0 {
+		return RescanProgress{}, false
+	}
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	return w.rescan.progress, true
+}
+
+// AbortRescan asks the running rescan to stop, and returns false when there
+// is none.
+func (w *Wallet) AbortRescan() bool {
+	if atomic.LoadInt32(&w.rescan.running) == 0 {
+		return false
+	}
+	atomic.StoreInt32(&w.rescan.abort, 1)
+	return true
+}
+
+// Rescan reads the blocks of the active chain from startHeight to
+// stopHeight, or to the tip when stopHeight is negative, and adds their
+// transactions involving the wallet. It returns the last height scanned.
+func (w *Wallet) Rescan(startHeight, stopHeight int) (int, error) {
+	if !atomic.CompareAndSwapInt32(&w.rescan.running, 0, 1) {
+		return -1, ErrRescanInProgress
+	}
+	defer atomic.StoreInt32(&w.rescan.running, 0)
+	atomic.StoreInt32(&w.rescan.abort, 0)
+
+	chain := &blockchain.GChainState.ChainActive
+	if stopHeight < 0 || stopHeight > chain.Height() {
+		stopHeight = chain.Height()
+	}
+	if startHeight < 0 {
+		startHeight = 0
+	}
+	w.mtx.Lock()
+	w.rescan.progress = RescanProgress{
+		StartHeight: startHeight,
+		StopHeight:  stopHeight,
+		Height:      startHeight - 1,
+		Started:     time.Now(),
+	}
+	w.mtx.Unlock()
+	logs.Info(fmt.Sprintf("wallet: rescanning from height %d to %d", startHeight, stopHeight))
+
+	lastLog := time.Now()
+	for height := startHeight; height <= stopHeight; height++ {
+		if atomic.LoadInt32(&w.rescan.abort) != 0 {
+			logs.Info(fmt.Sprintf("wallet: rescan aborted at height %d", height-1))
+			return height - 1, ErrRescanAborted
+		}
+		index := chain.GetSpecIndex(height)
+		if index == nil {
+			return height - 1, nil
+		}
+		var block core.Block
+		if !blockchain.ReadBlockFromDisk(&block, index, w.params) {
+			return height - 1, errors.Errorf("Failed to read block %d from disk", height)
+		}
+
+		w.mtx.Lock()
+		for _, tx := range block.Txs {
+			if _, err := w.addTx(tx, &block, index); err != nil {
+				w.mtx.Unlock()
+				return height - 1, err
+			}
+		}
+		w.rescan.progress.Height = height
+		progress := w.rescan.progress
+		w.mtx.Unlock()
+
+		if time.Since(lastLog) > 10*time.Second {
+			logs.Info(fmt.Sprintf("wallet: rescanning, at height %d of %d (%.1f%%)", height, stopHeight,
+				100*progress.Fraction()))
+			lastLog = time.Now()
+		}
+	}
+	logs.Info(fmt.Sprintf("wallet: rescan finished at height %d", stopHeight))
+	return stopHeight, nil
+}
@@ -0,0 +1,231 @@
+package wallet
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/btcboost/copernicus/blockchain"
+	"github.com/btcboost/copernicus/core"
+	"github.com/btcboost/copernicus/hdkeychain"
+	"github.com/btcboost/copernicus/net/msg"
+	"github.com/btcboost/copernicus/utils"
+)
+
+// newFundingTx returns a transaction paying value to each script.
+func newFundingTx(seed byte, value int64, scripts ...*core.Script) *core.Tx {
+	tx := core.NewTx()
+	tx.AddTxIn(core.NewTxIn(core.NewOutPoint(utils.Hash{seed}, 0), []byte{0x51}))
+	for _, script := range scripts {
+		tx.AddTxOut(core.NewTxOut(value, script.GetScriptByte()))
+	}
+	return tx
+}
+
+// xpubScript returns the P2PKH script of the child index of the chain of
+// key.
+func xpubScript(t *testing.T, key *hdkeychain.ExtendedKey, chain, index uint32) *core.Script {
+	chainKey, err := key.Child(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := chainKey.Child(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := child.ECPubKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return core.PayToPubKeyHash(utils.Hash160(pubKey.SerializeCompressed()))
+}
+
+func TestImportScript(t *testing.T) {
+	utils.ParseParameters(1, []string{"-keypool=5"})
+	defer utils.ParseParameters(0, nil)
+	w, path := openTestWallet(t)
+	defer os.RemoveAll(path)
+
+	if err := w.ImportScript(newAddressScript(t, w, ""), ""); err != ErrAlreadyMine {
+		t.Errorf("expected %v, got %v", ErrAlreadyMine, err)
+	}
+	watched := core.PayToPubKeyHash(bytes.Repeat([]byte{7}, 20))
+	if err := w.ImportScript(watched, "cold"); err != nil {
+		t.Fatal(err)
+	}
+	block, index := newTestBlock(1, newFundingTx(9, utils.COIN, watched))
+	w.BlockConnected(block, index)
+
+	if balance := w.GetBalance(1, IsMineSpendable); balance != 0 {
+		t.Errorf("watched outputs should not be spendable, got a balance of %d", balance)
+	}
+	if balance := w.GetBalance(1, IsMineAll); int64(balance) != utils.COIN {
+		t.Errorf("expected a watch-only balance of %d, got %d", utils.COIN, balance)
+	}
+	if _, _, err := w.CreateTransaction([]Recipient{{Script: watched, Amount: 1000}}); err != ErrInsufficientFunds {
+		t.Errorf("watched outputs should not be spent, got %v", err)
+	}
+	if entries := w.ListTransactions(10, 0, IsMineSpendable); len(entries) != 0 {
+		t.Errorf("watch-only transactions should be left out, got %d", len(entries))
+	}
+	entries := w.ListTransactions(10, 0, IsMineAll)
+	if len(entries) != 1 || !entries[0].WatchOnly || entries[0].Label != "cold" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	// The watched scripts are kept.
+	w.Close()
+	w, err := Open(path, msg.ActiveNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if balance := w.GetBalance(1, IsMineAll); int64(balance) != utils.COIN {
+		t.Errorf("expected a watch-only balance of %d after reopening, got %d", utils.COIN, balance)
+	}
+}
+
+func TestImportXPubGapLimit(t *testing.T) {
+	utils.ParseParameters(1, []string{"-keypool=5"})
+	defer utils.ParseParameters(0, nil)
+	w, path := openTestWallet(t)
+	defer os.RemoveAll(path)
+
+	master, err := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), msg.ActiveNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xpub, err := master.Neuter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ImportXPub(master, "", 3, 0); err == nil {
+		t.Errorf("extended private keys should be refused")
+	}
+	if err := w.ImportXPub(xpub, "xpub", 3, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past the gap, the address is not watched until the ones before it
+	// are used.
+	block, index := newTestBlock(1, newFundingTx(1, utils.COIN, xpubScript(t, xpub, 0, 4)))
+	w.BlockConnected(block, index)
+	if w.TxCount() != 0 {
+		t.Fatalf("addresses past the gap limit should not be watched")
+	}
+	block, index = newTestBlock(2, newFundingTx(2, utils.COIN, xpubScript(t, xpub, 0, 2)))
+	w.BlockConnected(block, index)
+	block, index = newTestBlock(3, newFundingTx(3, utils.COIN, xpubScript(t, xpub, 0, 4),
+		xpubScript(t, xpub, 1, 0)))
+	w.BlockConnected(block, index)
+	if balance := w.GetBalance(1, IsMineAll); int64(balance) != 3*utils.COIN {
+		t.Errorf("expected a watch-only balance of %d, got %d", 3*utils.COIN, balance)
+	}
+
+	// The used indexes are kept.
+	w.Close()
+	w, err = Open(path, msg.ActiveNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, ok := w.watched[string(xpubScript(t, xpub, 0, 7).GetScriptByte())]; !ok {
+		t.Errorf("the gap should follow the last used address after reopening")
+	}
+	if err := w.ImportXPub(xpub, "", 3, 0); err == nil {
+		t.Errorf("an xpub should not be imported twice")
+	}
+}
+
+// writeTestChain mines a block on the active chain for each transaction,
+// writes it to its own block file under dir and makes it the tip.
+func writeTestChain(t *testing.T, dir string, param *msg.BitcoinParams, txs ...*core.Tx) {
+	utils.AppRoot = dir
+	blockchain.GChainState.ChainActive = core.Chain{}
+	var prev *core.BlockIndex
+	for height, tx := range txs {
+		block := core.NewBlock()
+		block.BlockHeader.Version = 1
+		block.BlockHeader.Time = uint32(1296688602 + 600*height)
+		block.BlockHeader.Bits = blockchain.BigToCompact(param.PowLimit)
+		if prev != nil {
+			block.BlockHeader.HashPrevBlock = prev.BlockHash
+		}
+		block.Txs = []*core.Tx{tx}
+		pow := blockchain.Pow{}
+		for {
+			hash, _ := block.BlockHeader.GetHash()
+			if pow.CheckProofOfWork(&hash, block.BlockHeader.Bits, param) {
+				break
+			}
+			block.BlockHeader.Nonce++
+		}
+
+		pos := core.DiskBlockPos{File: height}
+		if !blockchain.WriteBlockToDisk(block, &pos, param.BitcoinNet) {
+			t.Fatalf("failed to write block %d", height)
+		}
+		index := core.NewBlockIndex(&block.BlockHeader)
+		index.Prev = prev
+		index.Height = height
+		index.File = pos.File
+		index.DataPos = pos.Pos
+		index.Status = core.BlockValidTransactions | core.BlockHaveData
+		index.BlockHash, _ = block.BlockHeader.GetHash()
+		prev = index
+	}
+	blockchain.GChainState.ChainActive.SetTip(prev)
+}
+
+func TestRescan(t *testing.T) {
+	utils.ParseParameters(1, []string{"-keypool=5"})
+	defer utils.ParseParameters(0, nil)
+	w, path := openTestWallet(t)
+	defer os.RemoveAll(path)
+	defer w.Close()
+
+	dir, err := ioutil.TempDir("", "rescantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	savedRoot, savedChain := utils.AppRoot, blockchain.GChainState.ChainActive
+	defer func() {
+		utils.AppRoot, blockchain.GChainState.ChainActive = savedRoot, savedChain
+	}()
+
+	watched := core.PayToPubKeyHash(bytes.Repeat([]byte{7}, 20))
+	other := core.PayToPubKeyHash(bytes.Repeat([]byte{8}, 20))
+	w.params = &msg.RegressionNetParams
+	writeTestChain(t, dir, w.params,
+		newFundingTx(1, utils.COIN, watched),
+		newFundingTx(2, utils.COIN, other),
+		newFundingTx(3, utils.COIN, newAddressScript(t, w, "")),
+		newFundingTx(4, utils.COIN, watched))
+
+	// The import does not rescan, so the past payments are unknown.
+	if err := w.ImportScript(watched, "cold"); err != nil {
+		t.Fatal(err)
+	}
+	if w.TxCount() != 0 {
+		t.Fatalf("expected no transaction before the rescan, got %d", w.TxCount())
+	}
+
+	height, err := w.Rescan(2, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 3 || w.TxCount() != 2 {
+		t.Errorf("the rescan from height 2 should end at 3 with 2 transactions, got %d with %d", height, w.TxCount())
+	}
+	if height, err = w.Rescan(0, 1); err != nil || height != 1 {
+		t.Fatalf("the rescan should stop at height 1, got %d: %v", height, err)
+	}
+	if w.TxCount() != 3 {
+		t.Errorf("expected 3 transactions after rescanning the whole chain, got %d", w.TxCount())
+	}
+	if _, ok := w.Rescanning(); ok {
+		t.Errorf("no rescan should be running anymore")
+	}
+}